@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,9 +13,14 @@ import (
 	"time"
 
 	"connect-companion/bot"
+	"connect-companion/certs"
+	"connect-companion/cluster"
 	"connect-companion/config"
 	"connect-companion/database"
+	"connect-companion/health"
 	"connect-companion/logger"
+	"connect-companion/metrics"
+	"connect-companion/scheduler"
 
 	"github.com/gin-gonic/gin"
 )
@@ -27,13 +34,38 @@ var (
 )
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 
 	cnf.RunInDebug = *debug
 	cnf.FilesDir = *filesDir
-	config.GetConfig(*configFile, cnf)
+	if err := config.GetConfig(*configFile, cnf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	logger.InitLogger(*debug)
+
+	name, args := subcommand(flag.Args())
+	if name == "" {
+		name = "serve"
+	}
+
+	run, ok := commands[name]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(args); err == errUsage {
+		flag.Usage()
+		os.Exit(2)
+	} else if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func serve(args []string) error {
 	logger.Info("Application starting...")
 
 	if *debug {
@@ -42,60 +74,158 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	db := database.Connect(cnf.Database)
+	db, err := database.Connect(cnf.Database)
+	if err != nil {
+		return fmt.Errorf("database: %v", err)
+	}
 
 	app := gin.Default()
 	app.Use(config.Inject(cnf), database.Inject("db", db))
 
-	bot.Configure(cnf)
-	bot.InitHooks(app, cnf.Line)
+	health.Init(app)
+	metrics.Init(app)
+	health.Add("redis", func() error {
+		return db.Ping().Err()
+	})
+
+	if err := bot.Validate(cnf); err != nil {
+		return err
+	}
+	bot.InitRouting(db)
+
+	node := cluster.New(db, cnf.Cluster.LeaderTtl)
+	node.Join()
+	go node.Run()
+	bot.SetLeader(node.IsLeader)
+
+	bot.InitHooks(app, bot.Lines())
+	go bot.ReconcileHooks()
+	bot.InitAdmin(app, cnf.Admin)
+	bot.InitHealth()
+
+	go bot.HandoverQuestions(db)
+	go bot.CleanupGenerated(db)
+
+	jobs := scheduler.New(db)
+	jobs.OnlyWhen(node.IsLeader)
+	bot.InitJobs(db, jobs)
+	go jobs.Run()
+
+	tlsConfig, redirect, err := certs.Configure(cnf.Server)
+	if err != nil {
+		return fmt.Errorf("tls: %v", err)
+	}
 
 	srv := &http.Server{
-		Addr:    cnf.Server.Listen,
-		Handler: app,
+		Addr:      cnf.Server.Listen,
+		Handler:   app,
+		TLSConfig: tlsConfig,
+	}
+
+	// Слушаем порт до уведомления systemd о готовности
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return fmt.Errorf("listen: %v", err)
 	}
 
+	var redirectSrv *http.Server
+	var redirectListener net.Listener
+	if redirect != nil && cnf.Server.TLS.RedirectListen != "" {
+		redirectSrv = &http.Server{
+			Addr:    cnf.Server.TLS.RedirectListen,
+			Handler: redirect,
+		}
+
+		if redirectListener, err = net.Listen("tcp", redirectSrv.Addr); err != nil {
+			_ = listener.Close()
+			return fmt.Errorf("listen: %v", err)
+		}
+	}
+
+	// Ошибки серверов останавливают приложение так же, как сигнал
+	failed := make(chan error, 2)
+
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Listen: %s\n", err)
+		var err error
+		if tlsConfig != nil {
+			err = srv.ServeTLS(listener, "", "")
+		} else {
+			err = srv.Serve(listener)
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			failed <- fmt.Errorf("listen: %v", err)
 		}
 	}()
 
+	if redirectSrv != nil {
+		go func() {
+			if err := redirectSrv.Serve(redirectListener); err != nil && err != http.ErrServerClosed {
+				failed <- fmt.Errorf("listen: %v", err)
+			}
+		}()
+	}
+
 	logger.Info("Application started")
 
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT)
+	if err := health.Notify("READY=1"); err != nil {
+		logger.Warning("Error while notify systemd", err)
+	}
+	go health.Watchdog()
 
-	quit := make(chan int)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		for {
-			sig := <-signals
+	var runErr error
+	for runErr == nil {
+		select {
+		case runErr = <-failed:
+		case sig := <-signals:
 			switch sig {
 			// kill -SIGHUP XXXX
 			// kill -SIGINT XXXX or Ctrl+c
-			case syscall.SIGHUP, syscall.SIGINT:
+			// kill XXXX, systemctl stop
+			case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM:
 				logger.Info("Catch OS signal! Exiting...")
+				return shutdown(node, jobs, srv, redirectSrv)
+			default:
+				logger.Warning("Unknown signal")
+			}
+		}
+	}
 
-				bot.DestroyHooks(cnf.Line)
+	logger.Warning("Server failed, exiting...", runErr)
+	_ = shutdown(node, jobs, srv, redirectSrv)
 
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-				defer cancel()
+	return runErr
+}
 
-				if err := srv.Shutdown(ctx); err != nil {
-					log.Fatal("App forced to shutdown:", err)
-				}
+// shutdown stops the background work and waits for requests in progress
+func shutdown(node *cluster.Node, jobs *scheduler.Scheduler, srv, redirectSrv *http.Server) error {
+	_ = health.Notify("STOPPING=1")
 
-				logger.Info("Application stopped correctly!")
+	// Если не удалось узнать о других экземплярах, хуки оставляем
+	others, err := node.Others()
+	if err != nil {
+		logger.Warning("Error while count instances", err)
+	}
+	bot.DestroyHooks(bot.Lines(), err == nil && others == 0)
 
-				quit <- 0
-			default:
-				logger.Warning("Unknown signal")
-			}
-		}
-	}()
+	jobs.Stop()
+	node.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if redirectSrv != nil {
+		_ = redirectSrv.Shutdown(ctx)
+	}
+
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("app forced to shutdown: %v", err)
+	}
 
-	code := <-quit
+	logger.Info("Application stopped correctly!")
 
-	os.Exit(code)
+	return nil
 }
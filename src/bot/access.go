@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"errors"
+
+	"connect-companion/bot/access"
+	"connect-companion/database"
+	"connect-companion/logger"
+)
+
+var (
+	groupSource access.Source = access.Merge()
+)
+
+// configureAccess takes groups of the config, groups of the directory profile are added by permits
+func configureAccess() error {
+	c := cnf.Access
+
+	if c.ProfileField != "" && !cnf.Directory.Enabled() {
+		return errors.New("access: profile_field needs the directory")
+	}
+
+	groupSource = access.Merge(access.Static(c.Groups))
+
+	return nil
+}
+
+// permits reports whether the user of the message may see the item with the rule
+func permits(msg *message, rule access.Rule) bool {
+	if rule.Public() {
+		return true
+	}
+
+	subject := access.Subject{UserId: msg.UserId}
+
+	profile := profileOf(msg)
+	if profile != nil {
+		subject.Department = profile.Department
+	}
+
+	groups, err := groupSource.Groups(msg.UserId)
+	if err != nil {
+		logger.Warning("Error while get groups of user", msg.UserId, err)
+	}
+
+	// Профиль уже получен для сообщения, не спрашиваем справочник еще раз
+	if field := cnf.Access.ProfileField; field != "" {
+		groups = append(groups, access.SplitGroups(profile.Field(field))...)
+	}
+	subject.Groups = groups
+
+	return rule.Allows(subject)
+}
+
+// permitsFile reports whether the user may get the file, files out of the menu are open to everyone
+func permitsFile(msg *message, file string) bool {
+	doc := tenantOf(msg.LineId).documentByFile(file)
+
+	return doc == nil || permits(msg, doc.Access)
+}
+
+// refuse answers the request of the menu item which the user may not see
+func refuse(msg *message, chatState *database.Chat, choice string) (database.ChatState, error) {
+	logger.Info("User", msg.UserId, "asked for", choice, "without access")
+
+	_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_FORBIDDEN), mainKeyboard(msg, chatState))
+
+	return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
+}
@@ -0,0 +1,138 @@
+package access
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// Rule lists who may see the menu item, the item is open to everyone without conditions.
+	// One matching condition is enough.
+	Rule struct {
+		Users       []uuid.UUID `yaml:"users"`
+		Groups      []string    `yaml:"groups"`
+		Departments []string    `yaml:"departments"`
+	}
+
+	// Config of groups, they are taken from the config and from the employee directory
+	Config struct {
+		// Users of groups by group name
+		Groups map[string][]uuid.UUID `yaml:"groups"`
+		// Field of the directory profile with groups of the user separated by commas
+		// or semicolons, e.g. groups or memberOf. Groups are not read from the directory without it.
+		ProfileField string `yaml:"profile_field"`
+	}
+
+	// Subject is the user asking for the item
+	Subject struct {
+		UserId     uuid.UUID
+		Department string
+		Groups     []string
+	}
+
+	// Source returns groups of the user
+	Source interface {
+		Groups(userId uuid.UUID) ([]string, error)
+	}
+
+	SourceFunc func(userId uuid.UUID) ([]string, error)
+
+	// Static groups of the config
+	Static map[string][]uuid.UUID
+
+	sources []Source
+)
+
+func (r Rule) Public() bool {
+	return len(r.Users) == 0 && len(r.Groups) == 0 && len(r.Departments) == 0
+}
+
+// Allows reports whether the subject may see the item, names are compared case-insensitively
+func (r Rule) Allows(s Subject) bool {
+	if r.Public() {
+		return true
+	}
+
+	for _, id := range r.Users {
+		if id == s.UserId {
+			return true
+		}
+	}
+
+	if s.Department != "" && containsFold(r.Departments, s.Department) {
+		return true
+	}
+
+	for _, g := range s.Groups {
+		if containsFold(r.Groups, g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f SourceFunc) Groups(userId uuid.UUID) ([]string, error) {
+	return f(userId)
+}
+
+func (s Static) Groups(userId uuid.UUID) ([]string, error) {
+	var groups []string
+
+	for name, users := range s {
+		for _, id := range users {
+			if id == userId {
+				groups = append(groups, name)
+				break
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+// Merge joins groups of all sources, failed sources are skipped and the first error is returned
+func Merge(list ...Source) Source {
+	return sources(list)
+}
+
+func (list sources) Groups(userId uuid.UUID) ([]string, error) {
+	var (
+		groups   []string
+		firstErr error
+	)
+
+	for _, s := range list {
+		g, err := s.Groups(userId)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		groups = append(groups, g...)
+	}
+
+	return groups, firstErr
+}
+
+// SplitGroups parses the list of groups of the directory field
+func SplitGroups(value string) []string {
+	var groups []string
+
+	for _, g := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups
+}
+
+func containsFold(list []string, s string) bool {
+	for i := range list {
+		if strings.EqualFold(strings.TrimSpace(list[i]), s) {
+			return true
+		}
+	}
+
+	return false
+}
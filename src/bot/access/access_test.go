@@ -0,0 +1,90 @@
+package access
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+var (
+	user  = uuid.MustParse("5a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d")
+	other = uuid.MustParse("7c3d4e5f-6a7b-4c8d-9e0f-1a2b3c4d5e6f")
+)
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		subject Subject
+		want    bool
+	}{
+		{"public", Rule{}, Subject{}, true},
+
+		{"user", Rule{Users: []uuid.UUID{user}}, Subject{UserId: user}, true},
+		{"other user", Rule{Users: []uuid.UUID{user}}, Subject{UserId: other}, false},
+
+		{"department", Rule{Departments: []string{"Бухгалтерия"}}, Subject{Department: "бухгалтерия"}, true},
+		{"department with spaces", Rule{Departments: []string{" Дирекция "}}, Subject{Department: "Дирекция"}, true},
+		{"other department", Rule{Departments: []string{"Бухгалтерия"}}, Subject{Department: "Склад"}, false},
+		{"no department", Rule{Departments: []string{""}}, Subject{}, false},
+
+		{"group", Rule{Groups: []string{"managers"}}, Subject{Groups: []string{"staff", "Managers"}}, true},
+		{"other group", Rule{Groups: []string{"managers"}}, Subject{Groups: []string{"staff"}}, false},
+		{"no groups", Rule{Groups: []string{"managers"}}, Subject{UserId: user}, false},
+
+		{"any condition", Rule{Users: []uuid.UUID{other}, Groups: []string{"managers"}, Departments: []string{"IT"}},
+			Subject{UserId: user, Department: "IT"}, true},
+		{"no condition", Rule{Users: []uuid.UUID{other}, Groups: []string{"managers"}, Departments: []string{"IT"}},
+			Subject{UserId: user, Department: "Склад", Groups: []string{"staff"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Allows(tt.subject); got != tt.want {
+				t.Errorf("Allows = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitGroups(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"managers", []string{"managers"}},
+		{"managers, staff;it", []string{"managers", "staff", "it"}},
+		{" ; managers,, ", []string{"managers"}},
+	}
+
+	for _, tt := range tests {
+		if got := SplitGroups(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitGroups(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	failed := errors.New("directory is unavailable")
+
+	source := Merge(
+		Static{"managers": {user}, "staff": {user, other}, "it": {other}},
+		SourceFunc(func(uuid.UUID) ([]string, error) { return nil, failed }),
+		SourceFunc(func(uuid.UUID) ([]string, error) { return []string{"ldap"}, nil }),
+	)
+
+	groups, err := source.Groups(user)
+	if err != failed {
+		t.Errorf("error %v, want %v", err, failed)
+	}
+
+	// Группы доступных источников есть и при ошибке другого
+	sort.Strings(groups)
+	if want := []string{"ldap", "managers", "staff"}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("groups %q, want %q", groups, want)
+	}
+}
@@ -0,0 +1,159 @@
+package bot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"connect-companion/bot/access"
+	"connect-companion/bot/messages"
+	"connect-companion/bot/requests"
+	"connect-companion/config"
+	"connect-companion/database"
+
+	"github.com/google/uuid"
+)
+
+// messagesStub keeps messages of the bot and counts files sent to users
+type messagesStub struct {
+	mu       sync.Mutex
+	messages []requests.MessageRequest
+	files    int
+}
+
+func (s *messagesStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch strings.Trim(r.URL.Path, "/") {
+	case "v1/line/send/message":
+		var m requests.MessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&m); err == nil {
+			s.messages = append(s.messages, m)
+		}
+	case "v1/line/send/file":
+		s.files++
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *messagesStub) last() (requests.MessageRequest, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.messages) == 0 {
+		return requests.MessageRequest{}, s.files
+	}
+
+	return s.messages[len(s.messages)-1], s.files
+}
+
+func keyIds(keyboard *[][]requests.KeyboardKey) map[string]string {
+	ids := map[string]string{}
+	if keyboard == nil {
+		return ids
+	}
+
+	for _, row := range *keyboard {
+		for _, k := range row {
+			ids[k.Id] = k.Text
+		}
+	}
+
+	return ids
+}
+
+func TestMenuAccess(t *testing.T) {
+	db := testRedis(t)
+
+	dir, err := ioutil.TempDir("", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	for _, name := range []string{"vacation.txt", "salary.txt"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	connect := &messagesStub{}
+	srv := httptest.NewServer(connect)
+	t.Cleanup(srv.Close)
+
+	line, hr, other := uuid.New(), uuid.New(), uuid.New()
+	c := &config.Conf{
+		Line:     []uuid.UUID{line},
+		Connect:  config.Connect{Server: srv.URL, Login: "bot", Password: "password"},
+		FilesDir: dir,
+		Access:   access.Config{Groups: map[string][]uuid.UUID{"hr": {hr}}},
+		Documents: []config.Document{
+			{Id: "1", Title: "Отпуск", File: "vacation.txt"},
+			{Id: "2", Title: "Зарплаты сотрудников", File: "salary.txt", Access: access.Rule{Groups: []string{"hr"}}},
+		},
+	}
+	if err = Validate(c); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(userId uuid.UUID, text string) requests.MessageRequest {
+		t.Helper()
+
+		msg := newMessage(messages.Message{
+			LineId:      line,
+			UserId:      userId,
+			MessageID:   uuid.New(),
+			MessageType: messages.MESSAGE_TEXT,
+			Text:        text,
+		})
+		if err := handleLocked(db, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		m, _ := connect.last()
+		return m
+	}
+
+	// Меню каждому пользователю свое
+	menu := keyIds(send(hr, "Привет").Keyboard)
+	if _, ok := menu["2"]; !ok {
+		t.Fatalf("menu %v of the group member has no restricted document", menu)
+	}
+
+	menu = keyIds(send(other, "Привет").Keyboard)
+	if _, ok := menu["2"]; ok {
+		t.Fatalf("menu %v shows the restricted document to other users", menu)
+	}
+	if _, ok := menu["1"]; !ok {
+		t.Fatalf("menu %v has no public document", menu)
+	}
+
+	// Скрытый пункт, набранный текстом, не выдается
+	m := send(other, "Зарплаты сотрудников")
+	if _, files := connect.last(); files != 0 {
+		t.Errorf("%d files sent to the user without access", files)
+	}
+	if forbidden := defaultPhrases[PHRASE_FORBIDDEN]; m.Text != forbidden {
+		t.Errorf("answer %q, want the forbidden phrase", m.Text)
+	}
+	if _, ok := keyIds(m.Keyboard)["2"]; ok {
+		t.Error("the refusal shows the restricted document")
+	}
+	if state := getState(db, newMessage(messages.Message{LineId: line, UserId: other})).CurrentState; state != database.STATE_MAIN_MENU {
+		t.Errorf("state %d after the refusal, want the main menu", state)
+	}
+
+	// Участник группы получает файл
+	send(hr, "Зарплаты сотрудников")
+	if _, files := connect.last(); files != 1 {
+		t.Errorf("%d files sent to the group member, want one", files)
+	}
+}
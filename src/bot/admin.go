@@ -0,0 +1,33 @@
+package bot
+
+import (
+	"connect-companion/config"
+	"connect-companion/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InitAdmin registers admin API protected by basic auth. Without credentials the API is disabled.
+func InitAdmin(app *gin.Engine, admin config.Admin) {
+	if admin.Login == "" || admin.Password == "" {
+		logger.Info("Admin API disabled")
+		return
+	}
+
+	logger.Info("Init admin API...")
+
+	api := app.Group("/admin", gin.BasicAuth(gin.Accounts{admin.Login: admin.Password}))
+
+	api.GET("/csat/:dimension", CsatStats)
+	api.GET("/chats/:line/:user", ChatInfo)
+
+	api.GET("/blocklist", ListBlocks)
+	api.PUT("/blocklist/:user", PutBlock)
+	api.DELETE("/blocklist/:user", DeleteBlock)
+
+	api.GET("/generated", ListGenerated)
+	api.GET("/generated/:id", GetGenerated)
+
+	api.GET("/directory/:user", LookupProfile)
+	api.DELETE("/directory", ForgetProfiles)
+}
@@ -0,0 +1,190 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"connect-companion/database"
+	"connect-companion/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+type (
+	// Block keeps messages of the user away from the bot on all lines
+	Block struct {
+		UserId uuid.UUID  `json:"user_id"`
+		Reason string     `json:"reason,omitempty"`
+		Since  time.Time  `json:"since"`
+		Until  *time.Time `json:"until,omitempty"`
+	}
+)
+
+// isBlocked checks permanent and temporary blocks, errors of redis let messages through
+func isBlocked(db redis.UniversalClient, userId uuid.UUID) bool {
+	pipe := db.Pipeline()
+	permanent := pipe.HExists(database.KEY_BLOCKLIST, userId.String())
+	temporary := pipe.Exists(database.PREFIX_BLOCKED + userId.String())
+
+	if _, err := pipe.Exec(); err != nil {
+		logger.Warning("Error while check blocklist", err)
+		return false
+	}
+
+	return permanent.Val() || temporary.Val() > 0
+}
+
+// blockFor blocks the user until the block expires
+func blockFor(db redis.UniversalClient, userId uuid.UUID, d time.Duration, reason string) error {
+	data, err := json.Marshal(Block{UserId: userId, Reason: reason, Since: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	return db.Set(database.PREFIX_BLOCKED+userId.String(), data, d).Err()
+}
+
+// BlockUser blocks the user until it is unblocked
+func BlockUser(db redis.UniversalClient, userId uuid.UUID, reason string) error {
+	data, err := json.Marshal(Block{UserId: userId, Reason: reason, Since: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	return db.HSet(database.KEY_BLOCKLIST, userId.String(), data).Err()
+}
+
+// UnblockUser removes both permanent and temporary blocks
+func UnblockUser(db redis.UniversalClient, userId uuid.UUID) error {
+	if err := db.HDel(database.KEY_BLOCKLIST, userId.String()).Err(); err != nil {
+		return err
+	}
+
+	return db.Del(database.PREFIX_BLOCKED + userId.String()).Err()
+}
+
+// Blocks returns all blocked users, temporary blocks have Until
+func Blocks(db redis.UniversalClient) ([]Block, error) {
+	permanent, err := db.HGetAll(database.KEY_BLOCKLIST).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make([]Block, 0, len(permanent))
+	for _, data := range permanent {
+		var b Block
+		if err := json.Unmarshal([]byte(data), &b); err != nil {
+			logger.Warning("Error while decode block", err, data)
+			continue
+		}
+		blocks = append(blocks, b)
+	}
+
+	err = database.ScanKeys(db, database.PREFIX_BLOCKED+"*", func(client redis.Cmdable, keys []string) error {
+		for _, key := range keys {
+			data, err := client.Get(key).Bytes()
+			if err == redis.Nil {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			ttl, err := client.TTL(key).Result()
+			if err != nil {
+				return err
+			}
+
+			var b Block
+			if err := json.Unmarshal(data, &b); err != nil {
+				logger.Warning("Error while decode block", key, err)
+				continue
+			}
+
+			until := time.Now().Add(ttl).Truncate(time.Second)
+			b.Until = &until
+			blocks = append(blocks, b)
+		}
+
+		return nil
+	})
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Since.Before(blocks[j].Since)
+	})
+
+	return blocks, err
+}
+
+// ListBlocks returns the blocklist
+func ListBlocks(c *gin.Context) {
+	db := c.MustGet("db").(redis.UniversalClient)
+
+	blocks, err := Blocks(db)
+	if err != nil {
+		logger.Warning("Error while read blocklist", err)
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, blocks)
+}
+
+// PutBlock blocks the user permanently, the reason is taken from the optional JSON body
+func PutBlock(c *gin.Context) {
+	db := c.MustGet("db").(redis.UniversalClient)
+
+	userId, err := uuid.Parse(c.Param("user"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := BlockUser(db, userId, strings.TrimSpace(body.Reason)); err != nil {
+		logger.Warning("Error while block user", userId, err)
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("User blocked", userId, body.Reason)
+
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteBlock unblocks the user
+func DeleteBlock(c *gin.Context) {
+	db := c.MustGet("db").(redis.UniversalClient)
+
+	userId, err := uuid.Parse(c.Param("user"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	if err := UnblockUser(db, userId); err != nil {
+		logger.Warning("Error while unblock user", userId, err)
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("User unblocked", userId)
+
+	c.Status(http.StatusNoContent)
+}
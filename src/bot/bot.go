@@ -3,37 +3,102 @@ package bot
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
-	"path/filepath"
 	"strings"
 	"time"
 
+	"connect-companion/bot/forms"
 	"connect-companion/bot/messages"
 	"connect-companion/bot/requests"
+	"connect-companion/bot/routing"
 	"connect-companion/config"
 	"connect-companion/database"
 	"connect-companion/logger"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
 )
 
 const (
-	BOT_PHRASE_GREETING     = "Выберите, какая информация вас интересует:"
-	BOT_PHRASE_SORRY        = "Извините, но я вас не понимаю. Выберите, пожалуйста, один из вариантов:"
-	BOT_PHRASE_FILE_SENDING = "Сейчас пришлю соотвествующий файл, подождите."
-	BOT_PHRASE_FILE_SENDED  = "Вот, пожалуйста."
-	BOT_PHRASE_AGAIN        = "Могу ли я чем-то помочь еще?"
-	BOT_PHRASE_RETOUTING    = "Сейчас переведу, секундочку."
-	BOT_PHRASE_BYE          = "Спасибо за обращение!"
+	KEY_CLOSE      = "9"
+	KEY_SPECIALIST = "0"
+	KEY_YES        = "1"
+	KEY_NO         = "2"
+)
+
+const (
+	TOPIC_MAIN_MENU = "main_menu"
+	TOPIC_PARTING   = "parting"
+
+	// Intents are kinds of escalation known from the button or the flow, the text is not analysed
+	INTENT_SPECIALIST = "specialist"
+	INTENT_FILE       = "file"
+	INTENT_QUESTION   = "question"
 )
 
 var (
 	cnf = &config.Conf{}
+
+	// Очереди пулов специалистов общие для экземпляров кластера
+	routingDb redis.UniversalClient
 )
 
-func Configure(c *config.Conf) {
+// Validate applies the configuration and returns all mistakes in it at once
+func Validate(c *config.Conf) error {
+	cnf = c
+
+	var errs config.Errors
+
+	collect(&errs, configureCluster())
+	collect(&errs, configureOutbound())
+	collect(&errs, configureInbound())
+	collect(&errs, configureDirectory())
+	collect(&errs, configureAccess())
+	collect(&errs, configureTenants(true))
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// ConfigureClient applies only accounts of lines and limits of requests to 1C-Connect,
+// commands of the CLI use it instead of Validate, which loads phrases, schedules and forms too
+func ConfigureClient(c *config.Conf) error {
 	cnf = c
+
+	var errs config.Errors
+
+	collect(&errs, configureOutbound())
+	collect(&errs, configureTenants(false))
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// collect appends the mistake, mistakes of nested lists are flattened
+func collect(errs *config.Errors, err error) {
+	switch e := err.(type) {
+	case nil:
+	case config.Errors:
+		*errs = append(*errs, e...)
+	default:
+		*errs = append(*errs, err)
+	}
+}
+
+// InitRouting keeps turns of specialist pools in redis
+func InitRouting(db redis.UniversalClient) {
+	routingDb = db
+	for _, t := range allTenants() {
+		t.shareRouting()
+	}
 }
 
 func Receive(c *gin.Context) {
@@ -47,60 +112,123 @@ func Receive(c *gin.Context) {
 
 	logger.Debug("Receive message:", msg)
 
+	if !IsKnownLine(msg.LineId) {
+		logger.Warning("Reject message from unknown line", msg.LineId)
+
+		c.Status(http.StatusForbidden)
+		return
+	}
+
 	// Реагируем только на сообщения пользователя
 	if (msg.MessageType == messages.MESSAGE_TEXT || msg.MessageType == messages.MESSAGE_FILE) && msg.MessageAuthor != nil && msg.UserId != *msg.MessageAuthor {
 		c.Status(http.StatusOK)
 		return
 	}
 
-	cCp := c.Copy()
-	go func(cCp *gin.Context, msg messages.Message) {
-		chatState := getState(c, &msg)
+	db := c.MustGet("db").(redis.UniversalClient)
 
-		newState, err := processMessage(&msg, &chatState)
-		if err != nil {
-			logger.Warning("Error processMessage", err)
+	// Лимиты и блокировки касаются только сообщений пользователя
+	if msg.MessageType == messages.MESSAGE_TEXT || msg.MessageType == messages.MESSAGE_FILE {
+		if isBlocked(db, msg.UserId) {
+			inboundTotal.Inc("blocked")
+
+			c.Status(http.StatusOK)
+			return
 		}
 
-		err = changeState(c, &msg, &chatState, newState)
-		if err != nil {
-			logger.Warning("Error changeState", err)
+		if !admit(db, msg) {
+			c.Status(http.StatusOK)
+			return
 		}
-	}(cCp, msg)
+	}
+
+	go handle(db, newMessage(msg))
 
 	c.Status(http.StatusOK)
 }
 
-func getState(c *gin.Context, msg *messages.Message) database.Chat {
-	db := c.MustGet("db").(*redis.Client)
+// handle processes the message, the message is parked if the chat is busy
+// or 1C-Connect is unavailable
+func handle(db redis.UniversalClient, msg *message) {
+	if err := handleLocked(db, msg); err != nil {
+		logger.Warning("Park message", msg.MessageID, "of user", msg.UserId, "on line", msg.LineId, err)
+		parkMessage(msg, unavailableFor(msg.LineId))
+	}
+}
+
+// handleLocked processes the message under the lock of the chat and saves the new state,
+// the error means the message is not processed and may be repeated
+func handleLocked(db redis.UniversalClient, msg *message) error {
+	unlock, err := lockChat(db, msg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Пока предохранитель открыт, ничего не делаем, чтобы не повторять действия при повторе
+	if d := breakerOf(connectOf(msg.LineId)).RetryIn(time.Now()); d > 0 {
+		return fmt.Errorf("%w: circuit is open for %s", ErrUnavailable, d.Round(time.Second))
+	}
+
+	// Пользователь ответил раньше отложенных сообщений
+	if msg.MessageType == messages.MESSAGE_TEXT || msg.MessageType == messages.MESSAGE_FILE {
+		cancelTimeline(msg)
+	}
+
+	chatState := getState(db, msg)
+
+	newState, err := processMessage(db, msg, &chatState)
+	if isUnavailable(err) {
+		// Не сбрасываем диалог, пока 1C-Connect недоступен, сообщение обработаем позже
+		return err
+	} else if err != nil {
+		logger.Warning("Error processMessage", err)
+	}
+
+	err = changeState(db, msg, &chatState, newState)
+	if err != nil {
+		logger.Warning("Error changeState", err)
+	}
+
+	scheduleIdle(msg, newState)
 
+	return nil
+}
+
+func getState(db redis.UniversalClient, msg *message) database.Chat {
 	var chatState database.Chat
 
-	dbStateKey := database.PREFIX_STATE + msg.UserId.String() + ":" + msg.LineId.String()
+	dbStateKey := stateKey(msg)
 
 	dbStateRaw, err := db.Get(dbStateKey).Bytes()
 	if err == redis.Nil {
 		logger.Info("No state in db for " + msg.UserId.String() + ":" + msg.LineId.String())
 
-		chatState = database.Chat{
-			PreviousState: database.STATE_GREETINGS,
-			CurrentState:  database.STATE_GREETINGS,
-		}
+		chatState = database.NewChat()
 	} else if err != nil {
 		logger.Warning("Error while reading state from redis", err)
 	} else {
-		err = json.Unmarshal(dbStateRaw, &chatState)
+		var migrated bool
+
+		chatState, migrated, err = database.DecodeChat(dbStateRaw)
 		if err != nil {
-			logger.Warning("Error while decoding state", err)
+			logger.Warning("Reset corrupt state of", msg.UserId.String()+":"+msg.LineId.String(), err, string(dbStateRaw))
+
+			chatState = database.NewChat()
+		} else if migrated {
+			if _, err = database.SaveMigrated(db, dbStateKey, dbStateRaw, chatState); err != nil {
+				logger.Warning("Error while save migrated state of", msg.UserId.String()+":"+msg.LineId.String(), err)
+			}
 		}
 	}
 
 	return chatState
 }
 
-func changeState(c *gin.Context, msg *messages.Message, chatState *database.Chat, toState database.ChatState) error {
-	db := c.MustGet("db").(*redis.Client)
+func changeState(db redis.UniversalClient, msg *message, chatState *database.Chat, toState database.ChatState) error {
+	track(chatState, toState)
 
+	chatState.Version = database.CHAT_VERSION
 	chatState.PreviousState = chatState.CurrentState
 	chatState.CurrentState = toState
 
@@ -111,7 +239,7 @@ func changeState(c *gin.Context, msg *messages.Message, chatState *database.Chat
 		return err
 	}
 
-	dbStateKey := database.PREFIX_STATE + msg.UserId.String() + ":" + msg.LineId.String()
+	dbStateKey := stateKey(msg)
 
 	result, err := db.Set(dbStateKey, data, database.EXPIRE).Result()
 	logger.Debug("Write state to db result", result)
@@ -122,8 +250,10 @@ func changeState(c *gin.Context, msg *messages.Message, chatState *database.Chat
 	return nil
 }
 
-func checkErrorForSend(msg *messages.Message, err error, nextState database.ChatState) (database.ChatState, error) {
-	if err != nil {
+func checkErrorForSend(msg *message, err error, nextState database.ChatState) (database.ChatState, error) {
+	if isUnavailable(err) {
+		return nextState, err
+	} else if err != nil {
 		logger.Warning("Get error while send message to line", msg.LineId, "for user", msg.UserId, "with error", err)
 		return database.STATE_GREETINGS, err
 	}
@@ -131,12 +261,199 @@ func checkErrorForSend(msg *messages.Message, err error, nextState database.Chat
 	return nextState, nil
 }
 
-func processMessage(msg *messages.Message, chatState *database.Chat) (database.ChatState, error) {
+// rerouteTreatment appoints a specialist by routing rules. When the appoint call fails the other
+// specialists of the pool are tried, then the treatment goes to the general queue.
+func rerouteTreatment(msg *message, topic string, intent string) (content []byte, err error) {
+	router := tenantOf(msg.LineId).router
+
+	specs, rule, ok := router.Route(routing.Request{
+		LineId: msg.LineId,
+		UserId: msg.UserId,
+		Topic:  topic,
+		Intent: intent,
+		Text:   msg.Text,
+		Time:   lineTime(msg.LineId),
+
+		Profile: profileOf(msg),
+	})
+
+	if ok {
+		for _, specId := range specs {
+			logger.Debug("Route treatment by", rule, "to specialist", specId.String())
+
+			content, err = RerouteTreatmentToSpec(msg.LineId, msg.UserId, specId)
+			if err == nil {
+				router.Assigned(rule, specId, time.Now())
+				return content, nil
+			}
+
+			// Пока 1C-Connect недоступен, перебирать специалистов бесполезно
+			if isUnavailable(err) {
+				return nil, err
+			}
+
+			logger.Warning("Error while appoint specialist", specId, "for user", msg.UserId, err)
+		}
+
+		logger.Warning("No specialist of", rule, "is appointed for user", msg.UserId, "fallback to general queue")
+	}
+
+	return RerouteTreatment(msg.LineId, msg.UserId)
+}
+
+// isLineOpen reports whether specialists of the line are working now
+func isLineOpen(lineId uuid.UUID) bool {
+	sch := tenantOf(lineId).schedules.ForLine(lineId)
+
+	return sch == nil || sch.IsOpen(time.Now())
+}
+
+// lineTime returns the current time in the timezone of the line schedule
+func lineTime(lineId uuid.UUID) time.Time {
+	if sch := tenantOf(lineId).schedules.ForLine(lineId); sch != nil {
+		return time.Now().In(sch.Location())
+	}
+
+	return time.Now()
+}
+
+func mainKeyboard(msg *message, chatState *database.Chat) *[][]requests.KeyboardKey {
+	return menuKeyboard(msg, chatState, true)
+}
+
+// menuKeyboard returns the main menu, documents and forms the user may not see are hidden if restricted
+func menuKeyboard(msg *message, chatState *database.Chat, restricted bool) *[][]requests.KeyboardKey {
+	var keyboard [][]requests.KeyboardKey
+
+	t := tenantOf(msg.LineId)
+
+	for _, d := range t.conf.Documents {
+		if restricted && !permits(msg, d.Access) {
+			continue
+		}
+		keyboard = append(keyboard, []requests.KeyboardKey{key(msg, chatState, d.Id, PREFIX_DOCUMENT+d.Id)})
+	}
+
+	for _, f := range t.conf.Forms {
+		if restricted && !permits(msg, f.Access) {
+			continue
+		}
+		keyboard = append(keyboard, []requests.KeyboardKey{key(msg, chatState, PREFIX_FORM+f.Id, PREFIX_FORM+f.Id)})
+	}
+
+	if len(t.locales) > 1 {
+		keyboard = append(keyboard, []requests.KeyboardKey{key(msg, chatState, KEY_LANGUAGE, LABEL_LANGUAGE)})
+	}
+
+	keyboard = append(keyboard, []requests.KeyboardKey{key(msg, chatState, KEY_CLOSE, LABEL_CLOSE)})
+
+	if isLineOpen(msg.LineId) {
+		keyboard = append(keyboard, *specialistKeyboard(msg, chatState)...)
+	}
+
+	keyboard = withNav(msg, chatState, keyboard, database.STATE_MAIN_MENU)
+
+	return &keyboard
+}
+
+func partingKeyboard(msg *message, chatState *database.Chat) *[][]requests.KeyboardKey {
+	keyboard := [][]requests.KeyboardKey{
+		{key(msg, chatState, KEY_YES, LABEL_YES), key(msg, chatState, KEY_NO, LABEL_NO)},
+	}
+
+	if isLineOpen(msg.LineId) {
+		keyboard = append(keyboard, *specialistKeyboard(msg, chatState)...)
+	}
+
+	keyboard = withNav(msg, chatState, keyboard, database.STATE_PARTING)
+
+	return &keyboard
+}
+
+func specialistKeyboard(msg *message, chatState *database.Chat) *[][]requests.KeyboardKey {
+	return &[][]requests.KeyboardKey{
+		{key(msg, chatState, KEY_SPECIALIST, LABEL_SPECIALIST)},
+	}
+}
+
+// pickMenu matches the reply against the keyboard. Request of a specialist is recognized
+// even when the button is hidden outside of working hours.
+func pickMenu(msg *message, chatState *database.Chat, keyboard *[][]requests.KeyboardKey) string {
+	if choice := pick(keyboard, msg.Text); choice != "" {
+		return choice
+	}
+
+	return pick(specialistKeyboard(msg, chatState), msg.Text)
+}
+
+// offHours tells the user when specialists will be available and offers to leave a question
+func offHours(msg *message, chatState *database.Chat, keyboard *[][]requests.KeyboardKey, state database.ChatState) (database.ChatState, error) {
+	text := say(msg, chatState, PHRASE_OFF_HOURS)
+
+	t := tenantOf(msg.LineId)
+
+	if sch := t.schedules.ForLine(msg.LineId); sch != nil {
+		if opening := sch.NextOpening(time.Now()); !opening.IsZero() {
+			text = sayWith(msg, chatState, PHRASE_OPENING, map[string]string{
+				"opening": opening.Format("02.01.2006 в 15:04 (MST)"),
+			})
+		}
+	}
+
+	if t.conf.Schedule.CollectQuestions {
+		_, _ = SendMessage(msg.LineId, msg.UserId, text, nil)
+
+		return askQuestion(msg, chatState)
+	}
+
+	_, err := SendMessage(msg.LineId, msg.UserId, text, keyboard)
+
+	return checkErrorForSend(msg, err, state)
+}
+
+func askQuestion(msg *message, chatState *database.Chat) (database.ChatState, error) {
+	keyboard := withNav(msg, chatState, nil, database.STATE_QUESTION)
+
+	_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_ASK_QUESTION), &keyboard)
+
+	return checkErrorForSend(msg, err, database.STATE_QUESTION)
+}
+
+func sendDocument(msg *message, chatState *database.Chat, doc *config.Document) (database.ChatState, error) {
+	visit(chatState, doc.File)
+	received(chatState, doc.File)
+
+	if _, ok := done(msg, STEP_FILE_SENDING); !ok {
+		if _, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_FILE_SENDING), nil); err == nil {
+			markDone(msg, STEP_FILE_SENDING, "")
+		}
+	}
+
+	comment := say(msg, chatState, PHRASE_FILE_SENDED)
+	_, err := SendFile(msg.LineId, msg.UserId, doc.File, tenantOf(msg.LineId).documentPath(doc), &comment, nil)
+	if err != nil {
+		return checkErrorForSend(msg, err, database.STATE_PARTING)
+	}
+
+	err = later(msg, database.STATE_PARTING,
+		sendLater(pause(msg, PACE_AFTER_FILE), say(msg, chatState, PHRASE_AGAIN), partingKeyboard(msg, chatState)))
+
+	return checkErrorForSend(msg, err, database.STATE_PARTING)
+}
+
+func processMessage(db redis.UniversalClient, msg *message, chatState *database.Chat) (database.ChatState, error) {
 	switch msg.MessageType {
 	case messages.MESSAGE_TREATMENT_START_BY_USER:
 		return chatState.CurrentState, nil
+	case messages.MESSAGE_TREATMENT_CLOSE:
+		if isSurveyEvent(msg, SURVEY_EVENT_TREATMENT_CLOSE) {
+			return startSurvey(msg, chatState, SURVEY_EVENT_TREATMENT_CLOSE)
+		}
+
+		_, err := HideKeyboard(msg.LineId, msg.UserId)
+
+		return checkErrorForSend(msg, err, database.STATE_GREETINGS)
 	case messages.MESSAGE_TREATMENT_START_BY_SPEC,
-		messages.MESSAGE_TREATMENT_CLOSE,
 		messages.MESSAGE_TREATMENT_CLOSE_ACTIVE,
 		messages.MESSAGE_TREATMENT_CLOSE_DEL_LINE,
 		messages.MESSAGE_TREATMENT_CLOSE_DEL_SUBS,
@@ -145,107 +462,153 @@ func processMessage(msg *messages.Message, chatState *database.Chat) (database.C
 
 		return checkErrorForSend(msg, err, database.STATE_GREETINGS)
 	case messages.MESSAGE_TEXT:
-		keyboardMain := &[][]requests.KeyboardKey{
-			{{Id: "1", Text: "Памятка сотрудника"}},
-			{{Id: "2", Text: "Положение о персонале"}},
-			{{Id: "3", Text: "Регламент о пожеланиях"}},
-			{{Id: "9", Text: "Закрыть обращение"}},
-			{{Id: "0", Text: "Перевести на специалиста"}},
+		// Язык определяем по первому сообщению пользователя
+		if chatState.Language == "" {
+			chatState.Language = tenantOf(msg.LineId).detectLanguage(msg.Text)
 		}
-		keyboardParting := &[][]requests.KeyboardKey{
-			{{Id: "1", Text: "Да"}, {Id: "2", Text: "Нет"}},
-			{{Id: "0", Text: "Перевести на специалиста"}},
+
+		if state, ok, err := navigate(msg, chatState); ok {
+			return state, err
 		}
 
+		keyboardMain := mainKeyboard(msg, chatState)
+		keyboardParting := partingKeyboard(msg, chatState)
+
 		switch chatState.CurrentState {
 		case database.STATE_DUMMY, database.STATE_GREETINGS:
-			_, err := SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_GREETING, keyboardMain)
+			chatState.Path = nil
+			chatState.Document = ""
+			chatState.Documents = nil
+			chatState.Articles = nil
+			chatState.Found = nil
+
+			_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_GREETING), keyboardMain)
 
 			return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
 		case database.STATE_MAIN_MENU:
-			comment := BOT_PHRASE_FILE_SENDED
-			switch strings.ToLower(strings.TrimSpace(msg.Text)) {
-			case "1", "памятка сотрудника":
-				_, _ = SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_FILE_SENDING, nil)
+			choice := pickMenu(msg, chatState, keyboardMain)
 
-				filePath, _ := filepath.Abs(filepath.Join(cnf.FilesDir, "Памятка сотрудника.pdf"))
-				_, err := SendFile(msg.LineId, msg.UserId, "Памятка сотрудника.pdf", filePath, &comment, nil)
-
-				time.Sleep(3 * time.Second)
-
-				_, _ = SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_AGAIN, keyboardParting)
-
-				return checkErrorForSend(msg, err, database.STATE_PARTING)
-			case "2", "положение о персонале":
-				_, _ = SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_FILE_SENDING, nil)
+			if doc := tenantOf(msg.LineId).documentById(choice); doc != nil {
+				return sendDocument(msg, chatState, doc)
+			}
 
-				filePath, _ := filepath.Abs(filepath.Join(cnf.FilesDir, "Положение о персонале.pdf"))
-				_, err := SendFile(msg.LineId, msg.UserId, "Положение о персонале.pdf", filePath, &comment, nil)
+			if strings.HasPrefix(choice, PREFIX_FORM) {
+				if form := forms.ById(tenantOf(msg.LineId).conf.Forms, strings.TrimPrefix(choice, PREFIX_FORM)); form != nil {
+					return startForm(msg, chatState, form)
+				}
+			}
 
-				time.Sleep(3 * time.Second)
+			switch choice {
+			case KEY_LANGUAGE:
+				return chooseLanguage(msg, chatState)
+			case KEY_CLOSE:
+				visit(chatState, STEP_CLOSE)
 
-				_, _ = SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_AGAIN, keyboardParting)
+				if isSurveyEvent(msg, SURVEY_EVENT_BOT_CLOSE) {
+					return startSurvey(msg, chatState, SURVEY_EVENT_BOT_CLOSE)
+				}
 
-				return checkErrorForSend(msg, err, database.STATE_PARTING)
-			case "3", "регламент о пожеланиях":
-				_, _ = SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_FILE_SENDING, nil)
+				_, _ = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_BYE), nil)
 
-				filePath, _ := filepath.Abs(filepath.Join(cnf.FilesDir, "Регламент.pdf"))
-				_, err := SendFile(msg.LineId, msg.UserId, "Регламент.pdf", filePath, &comment, nil)
+				_, err := CloseTreatment(msg.LineId, msg.UserId)
 
-				time.Sleep(3 * time.Second)
+				return checkErrorForSend(msg, err, database.STATE_GREETINGS)
+			case KEY_SPECIALIST:
+				if !isLineOpen(msg.LineId) {
+					return offHours(msg, chatState, keyboardMain, database.STATE_MAIN_MENU)
+				}
 
-				_, _ = SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_AGAIN, keyboardParting)
+				visit(chatState, STEP_SPECIALIST)
 
-				return checkErrorForSend(msg, err, database.STATE_PARTING)
-			case "9", "закрыть обращение":
-				_, _ = SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_BYE, nil)
+				_, _ = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_RETOUTING), nil)
 
-				_, err := CloseTreatment(msg.LineId, msg.UserId)
+				_, err := rerouteTreatment(msg, TOPIC_MAIN_MENU, INTENT_SPECIALIST)
 
 				return checkErrorForSend(msg, err, database.STATE_GREETINGS)
-			case "0", "перевести на специалиста":
-				_, _ = SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_RETOUTING, nil)
+			default:
+				// Скрытый от пользователя пункт меню, набранный текстом
+				if hidden := pick(menuKeyboard(msg, chatState, false), msg.Text); hidden != "" && choice == "" {
+					return refuse(msg, chatState, hidden)
+				}
 
-				_, err := RerouteTreatment(msg.LineId, msg.UserId)
+				if state, ok, err := searchArticles(msg, chatState); ok {
+					return state, err
+				}
 
-				return checkErrorForSend(msg, err, database.STATE_GREETINGS)
-			default:
-				_, err := SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_SORRY, keyboardMain)
+				_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_SORRY), keyboardMain)
 
 				return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
 			}
 		case database.STATE_PARTING:
-			switch strings.ToLower(strings.TrimSpace(msg.Text)) {
-			case "1", "да":
-				_, err := SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_GREETING, keyboardMain)
+			switch pickMenu(msg, chatState, keyboardParting) {
+			case KEY_YES:
+				visit(chatState, STEP_AGAIN)
+
+				_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_GREETING), keyboardMain)
 
 				return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
-			case "2", "нет":
-				_, _ = SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_BYE, nil)
+			case KEY_NO:
+				visit(chatState, STEP_CLOSE)
 
-				time.Sleep(500 * time.Millisecond)
+				if isSurveyEvent(msg, SURVEY_EVENT_BOT_CLOSE) {
+					return startSurvey(msg, chatState, SURVEY_EVENT_BOT_CLOSE)
+				}
 
-				_, err := CloseTreatment(msg.LineId, msg.UserId)
+				_, _ = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_BYE), nil)
+
+				err := later(msg, database.STATE_GREETINGS, closeLater(pause(msg, PACE_BEFORE_CLOSE)))
 
 				return checkErrorForSend(msg, err, database.STATE_GREETINGS)
-			case "0", "перевести на специалиста":
-				_, _ = SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_RETOUTING, nil)
+			case KEY_SPECIALIST:
+				if !isLineOpen(msg.LineId) {
+					return offHours(msg, chatState, keyboardParting, database.STATE_PARTING)
+				}
+
+				visit(chatState, STEP_SPECIALIST)
 
-				time.Sleep(500 * time.Millisecond)
+				_, _ = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_RETOUTING), nil)
 
-				_, err := RerouteTreatment(msg.LineId, msg.UserId)
+				err := later(msg, database.STATE_GREETINGS, rerouteLater(pause(msg, PACE_BEFORE_REROUTE), msg, TOPIC_PARTING, INTENT_SPECIALIST))
 
 				return checkErrorForSend(msg, err, database.STATE_GREETINGS)
 			default:
-				_, err := SendMessage(msg.LineId, msg.UserId, BOT_PHRASE_SORRY, keyboardParting)
+				if state, ok, err := searchArticles(msg, chatState); ok {
+					return state, err
+				}
+
+				_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_SORRY), keyboardParting)
 
 				return checkErrorForSend(msg, err, database.STATE_PARTING)
 			}
+		case database.STATE_QUESTION:
+			err := saveQuestion(db, msg)
+			if err != nil {
+				logger.Warning("Error while save question", err)
+
+				_, err = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_GREETING), keyboardMain)
+
+				return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
+			}
+
+			_, err = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_QUESTION_SAVED), nil)
+
+			return checkErrorForSend(msg, err, database.STATE_GREETINGS)
+		case database.STATE_SURVEY_RATING, database.STATE_SURVEY_COMMENT:
+			return processSurvey(db, msg, chatState)
+		case database.STATE_FORM:
+			return processForm(db, msg, chatState)
+		case database.STATE_LANGUAGE:
+			return processLanguage(msg, chatState)
+		case database.STATE_ARTICLES:
+			return processArticles(msg, chatState)
 		}
 	case messages.MESSAGE_FILE:
 		_, err := HideKeyboard(msg.LineId, msg.UserId)
-		_, err = RerouteTreatment(msg.LineId, msg.UserId)
+		if !isLineOpen(msg.LineId) {
+			return offHours(msg, chatState, nil, database.STATE_GREETINGS)
+		}
+
+		_, err = rerouteTreatment(msg, "", INTENT_FILE)
 
 		return checkErrorForSend(msg, err, database.STATE_GREETINGS)
 	}
@@ -3,22 +3,41 @@ package bot
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"connect-companion/bot/requests"
+	"connect-companion/bot/throttle"
+	"connect-companion/config"
 	"connect-companion/logger"
 
 	"github.com/google/uuid"
 )
 
+const (
+	// Больше одновременных запросов к 1C-Connect считаем перегрузкой
+	MAX_INFLIGHT = 100
+
+	// Неудачный запрос к 1C-Connect делает экземпляр неготовым до удачного или на этот срок
+	CONNECT_WINDOW = 5 * time.Minute
+)
+
 var (
 	client = &http.Client{}
+
+	inflight        int64
+	lastConnectOk   int64
+	lastConnectFail int64
+	lastConnectErr  atomic.Value
 )
 
 type (
@@ -36,16 +55,16 @@ func (e *HttpError) Error() string {
 func setHook(lineId uuid.UUID) (content []byte, err error) {
 	data := requests.HookSetupRequest{
 		Id:   lineId,
-		Type: "bot",
-		Url:  cnf.Server.Host + "/connect-push/receive/",
+		Type: HOOK_TYPE,
+		Url:  hookUrl(),
 	}
 	jsonData, err := json.Marshal(data)
 
-	return invoke("POST", "/hook/", "application/json", jsonData)
+	return invoke(connectOf(lineId), lineId, "POST", "/hook/", "application/json", jsonData)
 }
 
 func deleteHook(lineId uuid.UUID) (content []byte, err error) {
-	return invoke("DELETE", "/hook/bot/"+lineId.String()+"/", "application/json", nil)
+	return invoke(connectOf(lineId), lineId, "DELETE", "/hook/bot/"+lineId.String()+"/", "application/json", nil)
 }
 
 func SendMessage(lineId uuid.UUID, userId uuid.UUID, text string, keyboard *[][]requests.KeyboardKey) (content []byte, err error) {
@@ -58,7 +77,7 @@ func SendMessage(lineId uuid.UUID, userId uuid.UUID, text string, keyboard *[][]
 
 	jsonData, err := json.Marshal(data)
 
-	return invoke("POST", "/line/send/message/", "application/json", jsonData)
+	return invoke(connectOf(lineId), lineId, "POST", "/line/send/message/", "application/json", jsonData)
 }
 
 func SendFile(lineId uuid.UUID, userId uuid.UUID, fileName string, filepath string, comment *string, keyboard *[][]requests.KeyboardKey) (content []byte, err error) {
@@ -109,7 +128,7 @@ func SendFile(lineId uuid.UUID, userId uuid.UUID, fileName string, filepath stri
 		return nil, err
 	}
 
-	return invoke("POST", "/line/send/file/", writer.FormDataContentType(), body.Bytes())
+	return invoke(connectOf(lineId), lineId, "POST", "/line/send/file/", writer.FormDataContentType(), body.Bytes())
 }
 
 func HideKeyboard(lineId uuid.UUID, userId uuid.UUID) (content []byte, err error) {
@@ -120,7 +139,7 @@ func HideKeyboard(lineId uuid.UUID, userId uuid.UUID) (content []byte, err error
 
 	jsonData, err := json.Marshal(data)
 
-	return invoke("POST", "/line/drop/keyboard/", "application/json", jsonData)
+	return invoke(connectOf(lineId), lineId, "POST", "/line/drop/keyboard/", "application/json", jsonData)
 }
 
 func CloseTreatment(lineId uuid.UUID, userId uuid.UUID) (content []byte, err error) {
@@ -131,7 +150,7 @@ func CloseTreatment(lineId uuid.UUID, userId uuid.UUID) (content []byte, err err
 
 	jsonData, err := json.Marshal(data)
 
-	return invoke("POST", "/line/drop/treatment/", "application/json", jsonData)
+	return invoke(connectOf(lineId), lineId, "POST", "/line/drop/treatment/", "application/json", jsonData)
 }
 
 func RerouteTreatment(lineId uuid.UUID, userId uuid.UUID) (content []byte, err error) {
@@ -142,7 +161,7 @@ func RerouteTreatment(lineId uuid.UUID, userId uuid.UUID) (content []byte, err e
 
 	jsonData, err := json.Marshal(data)
 
-	return invoke("POST", "/line/appoint/start/", "application/json", jsonData)
+	return invoke(connectOf(lineId), lineId, "POST", "/line/appoint/start/", "application/json", jsonData)
 }
 
 func RerouteTreatmentToSpec(lineId uuid.UUID, userId uuid.UUID, specId uuid.UUID) (content []byte, err error) {
@@ -154,35 +173,64 @@ func RerouteTreatmentToSpec(lineId uuid.UUID, userId uuid.UUID, specId uuid.UUID
 
 	jsonData, err := json.Marshal(data)
 
-	return invoke("POST", "/line/appoint/spec/", "application/json", jsonData)
+	return invoke(connectOf(lineId), lineId, "POST", "/line/appoint/spec/", "application/json", jsonData)
 }
 
-func invoke(method string, methodUrl string, contentType string, body []byte) (content []byte, err error) {
+// invoke sends the request to 1C-Connect within rate limits of the instance and the line.
+// Requests of lines are limited by lineId, uuid.Nil is limited only by the global rate.
+func invoke(connect config.Connect, lineId uuid.UUID, method string, methodUrl string, contentType string, body []byte) (content []byte, err error) {
 	methodUrl = strings.Trim(methodUrl, "/")
-	reqUrl := cnf.Connect.Server + "/v1/" + methodUrl + "/"
+	reqUrl := connect.Server + "/v1/" + methodUrl + "/"
 
-	req, err := http.NewRequest(method, reqUrl, bytes.NewBuffer(body))
-	if err != nil {
-		logger.Warning("Error while create request for", reqUrl, "with method", method, ":", err)
-	}
+	for attempt := 0; ; attempt++ {
+		breaker, err := acquire(connect, lineId)
+		if err != nil {
+			requestsTotal.Inc(methodUrl, resultOf(err))
+			return nil, err
+		}
+
+		req, err := http.NewRequest(method, reqUrl, bytes.NewReader(body))
+		if err != nil {
+			breaker.Done(time.Now(), true)
+			logger.Warning("Error while create request for", reqUrl, "with method", method, ":", err)
+			return nil, err
+		}
 
-	req.SetBasicAuth(cnf.Connect.Login, cnf.Connect.Password)
-	req.Header.Set("Content-Type", contentType)
+		req.SetBasicAuth(connect.Login, connect.Password)
+		req.Header.Set("Content-Type", contentType)
 
-	logger.Debug("---> request", req.Method, reqUrl)
+		logger.Debug("---> request", req.Method, reqUrl)
 
-	resp, err := client.Do(req)
+		atomic.AddInt64(&inflight, 1)
+		resp, err := client.Do(req)
+		atomic.AddInt64(&inflight, -1)
+
+		if err != nil {
+			breaker.Done(time.Now(), false)
+			requestsTotal.Inc(methodUrl, "error")
+			lastConnectErr.Store(err.Error())
+			atomic.StoreInt64(&lastConnectFail, time.Now().UnixNano())
+
+			return nil, err
+		}
+
+		atomic.StoreInt64(&lastConnectOk, time.Now().UnixNano())
 
-	if err != nil {
-		return nil, err
-	} else {
-		defer resp.Body.Close()
 		bodyBytes, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
 		logger.Debug("<--- request", req.Method, reqUrl, "with body", bodyBytes)
 		if err != nil {
 			logger.Warning("Error while read response body", err)
 		}
 
+		// Перегрузку и ошибки сервера считаем отказами, остальные ответы - нет
+		breaker.Done(time.Now(), resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusTooManyRequests)
+		requestsTotal.Inc(methodUrl, strconv.Itoa(resp.StatusCode))
+
+		if resp.StatusCode == http.StatusTooManyRequests && throttled(resp, lineId) && attempt == 0 {
+			continue
+		}
+
 		if resp.StatusCode != http.StatusOK {
 			return nil, &HttpError{
 				Url:     req.URL.String(),
@@ -194,3 +242,11 @@ func invoke(method string, methodUrl string, contentType string, body []byte) (c
 		return bodyBytes, nil
 	}
 }
+
+func resultOf(err error) string {
+	if errors.Is(err, throttle.ErrOpen) {
+		return "circuit_open"
+	}
+
+	return "throttled"
+}
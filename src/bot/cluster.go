@@ -0,0 +1,52 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	"connect-companion/database"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	HOOKS_DELETE_LAST   = "last"
+	HOOKS_DELETE_ALWAYS = "always"
+	HOOKS_DELETE_NEVER  = "never"
+
+	// Сообщения одного чата обрабатываются по очереди на всех экземплярах
+	CHAT_LOCK_TTL  = 30 * time.Second
+	CHAT_LOCK_WAIT = 10 * time.Second
+)
+
+// isLeader tells whether this instance manages hooks and background tasks.
+// Single instance is always the leader.
+var isLeader = func() bool { return true }
+
+// SetLeader sets how the bot learns about its leadership in the cluster
+func SetLeader(leader func() bool) {
+	isLeader = leader
+}
+
+func configureCluster() error {
+	switch cnf.Cluster.DeleteHooks {
+	case "":
+		cnf.Cluster.DeleteHooks = HOOKS_DELETE_LAST
+	case HOOKS_DELETE_LAST, HOOKS_DELETE_ALWAYS, HOOKS_DELETE_NEVER:
+	default:
+		return fmt.Errorf("cluster: unknown delete_hooks %q", cnf.Cluster.DeleteHooks)
+	}
+
+	return nil
+}
+
+// lockChat serializes processing of the chat. The lock is refreshed until unlock,
+// the chat must not be processed if it is not taken in time.
+func lockChat(db redis.UniversalClient, msg *message) (func(), error) {
+	unlock, err := database.Lock(db, database.PREFIX_LOCK+msg.UserId.String()+":"+msg.LineId.String(), CHAT_LOCK_TTL, CHAT_LOCK_WAIT)
+	if err != nil {
+		return nil, fmt.Errorf("lock chat of user %s on line %s: %v", msg.UserId, msg.LineId, err)
+	}
+
+	return unlock, nil
+}
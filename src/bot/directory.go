@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"net/http"
+
+	"connect-companion/bot/directory"
+	"connect-companion/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	// nil, если справочник сотрудников не настроен
+	employees *directory.Cache
+)
+
+func configureDirectory() (err error) {
+	employees, err = directory.New(cnf.Directory)
+
+	return err
+}
+
+// profileOf returns the employee of the directory, nil if the user is unknown. The directory
+// is asked once per message, errors are logged only, the bot works without profiles.
+func profileOf(msg *message) *directory.Profile {
+	if msg.profileLooked {
+		return msg.profile
+	}
+
+	profile, err := employees.Lookup(msg.UserId)
+	if err != nil {
+		logger.Warning("Error while look up user", msg.UserId, "in directory", err)
+	}
+
+	msg.profile, msg.profileLooked = profile, true
+
+	return profile
+}
+
+// LookupProfile returns the profile of the user from the directory
+func LookupProfile(c *gin.Context) {
+	userId, err := uuid.Parse(c.Param("user"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	if employees == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "directory is off"})
+		return
+	}
+
+	profile, err := employees.Lookup(userId)
+	if err != nil && profile == nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	if profile == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// ForgetProfiles drops cached profiles, of the user only if ?user= is set
+func ForgetProfiles(c *gin.Context) {
+	var userId *uuid.UUID
+	if user := c.Query("user"); user != "" {
+		id, err := uuid.Parse(user)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+		userId = &id
+	}
+
+	employees.Forget(userId)
+
+	logger.Info("Directory cache cleared")
+
+	c.Status(http.StatusNoContent)
+}
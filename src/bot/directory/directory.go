@@ -0,0 +1,306 @@
+package directory
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	TYPE_FILE = "file"
+	TYPE_LDAP = "ldap"
+	TYPE_HTTP = "http"
+
+	DEFAULT_TTL     = 10 * time.Minute
+	DEFAULT_TIMEOUT = 3 * time.Second
+
+	// После ошибки справочник не спрашиваем, пауза растет вдвое до максимума
+	RETRY_DELAY     = 5 * time.Second
+	MAX_RETRY_DELAY = 5 * time.Minute
+
+	FIELD_USER_ID    = "user_id"
+	FIELD_NAME       = "name"
+	FIELD_DEPARTMENT = "department"
+	FIELD_MANAGER    = "manager"
+	FIELD_LOCATION   = "location"
+)
+
+type (
+	// Profile of the employee, other fields of the directory are in Attributes
+	Profile struct {
+		UserId     uuid.UUID         `json:"user_id"`
+		Name       string            `json:"name"`
+		Department string            `json:"department"`
+		Manager    string            `json:"manager"`
+		Location   string            `json:"location"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+	}
+
+	// Provider finds the employee by Connect user id, the profile is nil for unknown users
+	Provider interface {
+		Lookup(userId uuid.UUID) (*Profile, error)
+	}
+
+	// Config of the directory, it is off without the type
+	Config struct {
+		// file (CSV or JSON), ldap or http
+		Type string `yaml:"type"`
+
+		File string `yaml:"file"`
+		Ldap Ldap   `yaml:"ldap"`
+		Http Http   `yaml:"http"`
+
+		// Profiles and unknown users are cached, 10m by default
+		Ttl time.Duration `yaml:"ttl"`
+		// Timeout of LDAP and HTTP requests, 3s by default
+		Timeout time.Duration `yaml:"timeout"`
+	}
+
+	// Cache keeps profiles for the ttl and the stale ones while the provider fails.
+	// Failures are cached too, so the provider is not asked again until the retry delay.
+	// Concurrent lookups of the same user wait for one request to the provider.
+	Cache struct {
+		provider Provider
+		ttl      time.Duration
+		now      func() time.Time
+
+		mu      sync.Mutex
+		entries map[uuid.UUID]entry
+		calls   map[uuid.UUID]*call
+		// Просроченные записи чистим не чаще раза за ttl
+		swept time.Time
+	}
+
+	// call is the request to the provider in progress, done is closed when it is over
+	call struct {
+		done    chan struct{}
+		profile *Profile
+		err     error
+	}
+
+	entry struct {
+		profile *Profile
+		expires time.Time
+
+		// Ошибка справочника и число неудач подряд
+		err      error
+		failures int
+	}
+)
+
+// Field returns the field of the profile by name, attributes included
+func (p *Profile) Field(name string) string {
+	if p == nil {
+		return ""
+	}
+
+	switch name {
+	case FIELD_USER_ID:
+		return p.UserId.String()
+	case FIELD_NAME:
+		return p.Name
+	case FIELD_DEPARTMENT:
+		return p.Department
+	case FIELD_MANAGER:
+		return p.Manager
+	case FIELD_LOCATION:
+		return p.Location
+	}
+
+	return p.Attributes[name]
+}
+
+// set fills the field by name, unknown names go to attributes
+func (p *Profile) set(name string, value string) error {
+	value = strings.TrimSpace(value)
+
+	switch name {
+	case FIELD_USER_ID:
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return fmt.Errorf("invalid user id %q", value)
+		}
+		p.UserId = id
+	case FIELD_NAME:
+		p.Name = value
+	case FIELD_DEPARTMENT:
+		p.Department = value
+	case FIELD_MANAGER:
+		p.Manager = value
+	case FIELD_LOCATION:
+		p.Location = value
+	default:
+		if value == "" {
+			return nil
+		}
+		if p.Attributes == nil {
+			p.Attributes = make(map[string]string)
+		}
+		p.Attributes[name] = value
+	}
+
+	return nil
+}
+
+func (c Config) Enabled() bool {
+	return c.Type != ""
+}
+
+// New returns the cached provider of the configuration, nil if the directory is off
+func New(c Config) (*Cache, error) {
+	if c.Timeout <= 0 {
+		c.Timeout = DEFAULT_TIMEOUT
+	}
+
+	if c.Ttl <= 0 {
+		c.Ttl = DEFAULT_TTL
+	}
+
+	var (
+		p   Provider
+		err error
+	)
+
+	switch c.Type {
+	case "":
+		return nil, nil
+	case TYPE_FILE:
+		if c.File == "" {
+			return nil, errors.New("directory: file is required")
+		}
+		p, err = newFileProvider(c.File)
+	case TYPE_LDAP:
+		p, err = newLdapProvider(c.Ldap, c.Timeout)
+	case TYPE_HTTP:
+		p, err = newHttpProvider(c.Http, c.Timeout)
+	default:
+		return nil, fmt.Errorf("directory: unknown type %q", c.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("directory: %v", err)
+	}
+
+	return NewCache(p, c.Ttl), nil
+}
+
+func NewCache(p Provider, ttl time.Duration) *Cache {
+	return &Cache{
+		provider: p,
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  make(map[uuid.UUID]entry),
+		calls:    make(map[uuid.UUID]*call),
+	}
+}
+
+// Lookup returns the cached profile. When the provider fails, the error comes
+// with the stale profile if there is one, both are repeated until the retry delay passes.
+func (c *Cache) Lookup(userId uuid.UUID) (*Profile, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	now := c.now()
+
+	c.mu.Lock()
+	e, ok := c.entries[userId]
+	if ok && now.Before(e.expires) {
+		c.mu.Unlock()
+		return e.profile, e.err
+	}
+
+	// Пачка сообщений пользователя не превращается в пачку запросов к справочнику
+	if cl, found := c.calls[userId]; found {
+		c.mu.Unlock()
+		<-cl.done
+		return cl.profile, cl.err
+	}
+
+	cl := &call{done: make(chan struct{})}
+	c.calls[userId] = cl
+	c.mu.Unlock()
+
+	cl.profile, cl.err = c.fetch(userId, e, ok, now)
+
+	c.mu.Lock()
+	delete(c.calls, userId)
+	c.mu.Unlock()
+	close(cl.done)
+
+	return cl.profile, cl.err
+}
+
+// fetch asks the provider and caches the answer, e is the cached entry if ok
+func (c *Cache) fetch(userId uuid.UUID, e entry, ok bool, now time.Time) (*Profile, error) {
+	profile, err := c.provider.Lookup(userId)
+	if err != nil {
+		// Устаревший профиль лучше, чем никакой, продлеваем его до следующей попытки
+		if ok {
+			profile = e.profile
+		}
+
+		failures := e.failures + 1
+		c.store(userId, entry{profile: profile, err: err, failures: failures, expires: now.Add(retryDelay(failures))}, now)
+
+		return profile, err
+	}
+
+	c.store(userId, entry{profile: profile, expires: now.Add(c.ttl)}, now)
+
+	return profile, nil
+}
+
+func (c *Cache) store(userId uuid.UUID, e entry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[userId] = e
+
+	// Просроченные записи чистим по ходу, чтобы кэш не рос бесконечно
+	if now.Sub(c.swept) < c.ttl {
+		return
+	}
+	c.swept = now
+
+	for id, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+}
+
+// retryDelay doubles the pause after every failure in a row up to the maximum
+func retryDelay(failures int) time.Duration {
+	d := RETRY_DELAY
+	for i := 1; i < failures && d < MAX_RETRY_DELAY; i++ {
+		d *= 2
+	}
+
+	if d > MAX_RETRY_DELAY {
+		return MAX_RETRY_DELAY
+	}
+
+	return d
+}
+
+// Forget drops the cached profile, all profiles if userId is nil
+func (c *Cache) Forget(userId *uuid.UUID) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if userId == nil {
+		c.entries = make(map[uuid.UUID]entry)
+		return
+	}
+
+	delete(c.entries, *userId)
+}
@@ -0,0 +1,211 @@
+package directory
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type testProvider struct {
+	profiles map[uuid.UUID]*Profile
+	err      error
+	calls    int
+}
+
+func (p *testProvider) Lookup(userId uuid.UUID) (*Profile, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	return p.profiles[userId], nil
+}
+
+func TestCache(t *testing.T) {
+	user, unknown := uuid.New(), uuid.New()
+	p := &testProvider{profiles: map[uuid.UUID]*Profile{user: {UserId: user, Name: "Иванов"}}}
+
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	c := NewCache(p, time.Minute)
+	c.now = func() time.Time { return now }
+
+	lookup := func(userId uuid.UUID, wantName string, wantErr bool, wantCalls int) {
+		t.Helper()
+
+		profile, err := c.Lookup(userId)
+		if (err != nil) != wantErr {
+			t.Errorf("error %v, want error %v", err, wantErr)
+		}
+
+		name := ""
+		if profile != nil {
+			name = profile.Name
+		}
+		if name != wantName {
+			t.Errorf("profile %q, want %q", name, wantName)
+		}
+
+		if p.calls != wantCalls {
+			t.Errorf("%d calls of the provider, want %d", p.calls, wantCalls)
+		}
+	}
+
+	lookup(user, "Иванов", false, 1)
+	lookup(user, "Иванов", false, 1)
+
+	// Неизвестный пользователь тоже кэшируется
+	lookup(unknown, "", false, 2)
+	lookup(unknown, "", false, 2)
+
+	now = now.Add(time.Minute)
+	p.err = errors.New("directory is down")
+
+	// Устаревший профиль отдается вместе с ошибкой и продлевается до повтора
+	lookup(user, "Иванов", true, 3)
+	lookup(user, "Иванов", true, 3)
+
+	now = now.Add(RETRY_DELAY)
+	lookup(user, "Иванов", true, 4)
+
+	// Пауза растет после каждой неудачи
+	now = now.Add(RETRY_DELAY)
+	lookup(user, "Иванов", true, 4)
+	now = now.Add(RETRY_DELAY)
+	lookup(user, "Иванов", true, 5)
+
+	// Неудача без профиля тоже кэшируется
+	lookup(uuid.New(), "", true, 6)
+
+	now = now.Add(MAX_RETRY_DELAY)
+	p.err = nil
+	p.profiles[user] = &Profile{UserId: user, Name: "Петров"}
+	lookup(user, "Петров", false, 7)
+	lookup(user, "Петров", false, 7)
+
+	c.Forget(&user)
+	lookup(user, "Петров", false, 8)
+
+	c.Forget(nil)
+	lookup(user, "Петров", false, 9)
+
+	var off *Cache
+	if profile, err := off.Lookup(user); profile != nil || err != nil {
+		t.Error("directory which is off finds the user")
+	}
+	off.Forget(nil)
+}
+
+// slowProvider answers after release is closed
+type slowProvider struct {
+	release chan struct{}
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *slowProvider) Lookup(userId uuid.UUID) (*Profile, error) {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+
+	<-p.release
+
+	return &Profile{UserId: userId, Name: "Иванов"}, nil
+}
+
+func TestCacheConcurrentLookup(t *testing.T) {
+	p := &slowProvider{release: make(chan struct{})}
+	c := NewCache(p, time.Minute)
+
+	user := uuid.New()
+
+	var wg sync.WaitGroup
+	names := make([]string, 10)
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			if profile, err := c.Lookup(user); err == nil && profile != nil {
+				names[i] = profile.Name
+			}
+		}(i)
+	}
+
+	// Все запросы успевают встать в очередь к первому
+	time.Sleep(50 * time.Millisecond)
+	close(p.release)
+	wg.Wait()
+
+	if p.calls != 1 {
+		t.Errorf("%d calls of the provider for one user, want 1", p.calls)
+	}
+
+	for i, name := range names {
+		if name != "Иванов" {
+			t.Errorf("lookup %d got %q", i, name)
+		}
+	}
+
+	if len(c.calls) != 0 {
+		t.Errorf("%d calls are left in progress", len(c.calls))
+	}
+}
+
+func TestCacheSweep(t *testing.T) {
+	p := &testProvider{}
+
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	c := NewCache(p, time.Minute)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, _ = c.Lookup(uuid.New())
+	}
+
+	// Пока ttl не прошел, записи не перебираются
+	now = now.Add(30 * time.Second)
+	_, _ = c.Lookup(uuid.New())
+	if len(c.entries) != 4 {
+		t.Fatalf("%d entries, want 4", len(c.entries))
+	}
+
+	// Просроченные записи удаляются, сколько бы их ни было
+	now = now.Add(2 * time.Minute)
+	_, _ = c.Lookup(uuid.New())
+	if len(c.entries) != 1 {
+		t.Errorf("%d entries after the sweep, want the new one", len(c.entries))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  RETRY_DELAY,
+		2:  2 * RETRY_DELAY,
+		3:  4 * RETRY_DELAY,
+		10: MAX_RETRY_DELAY,
+		99: MAX_RETRY_DELAY,
+	}
+
+	for failures, want := range tests {
+		if got := retryDelay(failures); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	p := &Profile{Name: "Иванов", Department: "Бухгалтерия", Attributes: map[string]string{"phone": "123"}}
+
+	if p.Field(FIELD_NAME) != "Иванов" || p.Field(FIELD_DEPARTMENT) != "Бухгалтерия" || p.Field("phone") != "123" || p.Field("email") != "" {
+		t.Errorf("fields of %+v", p)
+	}
+
+	var unknown *Profile
+	if unknown.Field(FIELD_NAME) != "" {
+		t.Error("field of the unknown user")
+	}
+}
@@ -0,0 +1,135 @@
+package directory
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Файл перечитываем, если он изменился, но не чаще
+	FILE_CHECK = 10 * time.Second
+)
+
+type (
+	// fileProvider reads the CSV file with the header or the JSON array of profiles
+	fileProvider struct {
+		file string
+
+		mu       sync.Mutex
+		profiles map[uuid.UUID]*Profile
+		modified time.Time
+		checked  time.Time
+	}
+)
+
+func newFileProvider(file string) (*fileProvider, error) {
+	p := &fileProvider{file: file}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.load(fi.ModTime()); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *fileProvider) Lookup(userId uuid.UUID) (*Profile, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.checked) >= FILE_CHECK {
+		p.checked = time.Now()
+
+		// Ошибки нового файла не мешают отвечать по старому
+		if fi, err := os.Stat(p.file); err == nil && !fi.ModTime().Equal(p.modified) {
+			if err = p.load(fi.ModTime()); err != nil {
+				return p.profiles[userId], err
+			}
+		}
+	}
+
+	return p.profiles[userId], nil
+}
+
+func (p *fileProvider) load(modified time.Time) error {
+	data, err := ioutil.ReadFile(p.file)
+	if err != nil {
+		return err
+	}
+
+	var profiles []*Profile
+	if strings.EqualFold(filepath.Ext(p.file), ".json") {
+		err = json.Unmarshal(data, &profiles)
+	} else {
+		profiles, err = parseCsv(data)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %v", p.file, err)
+	}
+
+	byUser := make(map[uuid.UUID]*Profile, len(profiles))
+	for i, profile := range profiles {
+		if profile.UserId == uuid.Nil {
+			return fmt.Errorf("%s: profile #%d: user_id is required", p.file, i+1)
+		}
+		byUser[profile.UserId] = profile
+	}
+
+	p.profiles = byUser
+	p.modified = modified
+
+	return nil
+}
+
+// parseCsv reads profiles by columns of the header, comma or semicolon separated
+func parseCsv(data []byte) ([]*Profile, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	r := csv.NewReader(bytes.NewReader(data))
+	if header := strings.SplitN(string(data), "\n", 2)[0]; strings.Count(header, ";") > strings.Count(header, ",") {
+		r.Comma = ';'
+	}
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("header is required")
+	}
+
+	header := records[0]
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	profiles := make([]*Profile, 0, len(records)-1)
+	for n, record := range records[1:] {
+		profile := &Profile{}
+		for i, value := range record {
+			if err := profile.set(header[i], value); err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+2, err)
+			}
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
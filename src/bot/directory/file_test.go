@@ -0,0 +1,160 @@
+package directory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	testUser  = "1c3d5e7f-9a0b-4c2d-8e4f-6a8b0c2d4e6f"
+	testOther = "5a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+)
+
+func testFile(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestParseCsv(t *testing.T) {
+	tests := map[string]string{
+		"semicolon": "\xef\xbb\xbfuser_id;name;Department;phone\n" +
+			testUser + ";Иванов, Иван;Бухгалтерия;123\n",
+		"comma": "user_id,name,department,phone\n" +
+			testUser + ",\"Иванов, Иван\",Бухгалтерия,123\n",
+		"comma with semicolons in values": "user_id,name,department,phone\r\n" +
+			testUser + ",\"Иванов, Иван\",Бухгалтерия,123;456\r\n",
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			profiles, err := parseCsv([]byte(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(profiles) != 1 {
+				t.Fatalf("%d profiles, want 1", len(profiles))
+			}
+
+			p := profiles[0]
+			if p.UserId.String() != testUser || p.Name != "Иванов, Иван" || p.Department != "Бухгалтерия" || p.Field("phone") == "" {
+				t.Errorf("profile %+v", p)
+			}
+		})
+	}
+
+	errors := map[string]string{
+		"empty":           "",
+		"invalid user id": "user_id;name\nnot-uuid;Иванов\n",
+		"columns":         "user_id;name\n" + testUser + ";Иванов;лишнее\n",
+	}
+
+	for name, data := range errors {
+		if _, err := parseCsv([]byte(data)); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	user, other := uuid.MustParse(testUser), uuid.MustParse(testOther)
+
+	csv := testFile(t, "employees.csv", "user_id;name;location\n"+testUser+";Иванов;Москва\n")
+	p, err := newFileProvider(csv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if profile, err := p.Lookup(user); err != nil || profile == nil || profile.Location != "Москва" {
+		t.Errorf("csv profile %+v, %v", profile, err)
+	}
+	if profile, err := p.Lookup(other); err != nil || profile != nil {
+		t.Errorf("unknown user %+v, %v", profile, err)
+	}
+
+	json := testFile(t, "employees.JSON", `[
+		{"user_id": "`+testUser+`", "name": "Иванов", "attributes": {"phone": "123"}},
+		{"user_id": "`+testOther+`", "name": "Петров"}
+	]`)
+	p, err = newFileProvider(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if profile, _ := p.Lookup(user); profile == nil || profile.Field("phone") != "123" {
+		t.Errorf("json profile %+v", profile)
+	}
+	if profile, _ := p.Lookup(other); profile == nil || profile.Name != "Петров" {
+		t.Errorf("json profile %+v", profile)
+	}
+
+	// Измененный файл перечитывается, ошибки нового файла не мешают старому
+	modified := time.Now().Add(time.Minute)
+	if err := ioutil.WriteFile(json, []byte(`[{"name": "Без id"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(json, modified, modified)
+	p.checked = time.Time{}
+
+	if profile, err := p.Lookup(other); err == nil || profile == nil || profile.Name != "Петров" {
+		t.Errorf("broken file: %+v, %v", profile, err)
+	}
+
+	if err := ioutil.WriteFile(json, []byte(`[{"user_id": "`+testOther+`", "name": "Сидоров"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modified = modified.Add(time.Minute)
+	_ = os.Chtimes(json, modified, modified)
+	p.checked = time.Time{}
+
+	if profile, err := p.Lookup(other); err != nil || profile == nil || profile.Name != "Сидоров" {
+		t.Errorf("reloaded file: %+v, %v", profile, err)
+	}
+	if profile, _ := p.Lookup(user); profile != nil {
+		t.Errorf("removed profile %+v", profile)
+	}
+
+	if _, err := newFileProvider(testFile(t, "employees.json", `[{"name": "Без id"}]`)); err == nil {
+		t.Error("profile without user_id is loaded")
+	}
+	if _, err := newFileProvider(filepath.Join(filepath.Dir(csv), "missing.csv")); err == nil {
+		t.Error("missing file is loaded")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if c, err := New(Config{}); c != nil || err != nil {
+		t.Errorf("directory without type: %v, %v", c, err)
+	}
+
+	if _, err := New(Config{Type: TYPE_FILE}); err == nil {
+		t.Error("file directory without file")
+	}
+
+	if _, err := New(Config{Type: "sql"}); err == nil {
+		t.Error("unknown type")
+	}
+
+	c, err := New(Config{Type: TYPE_FILE, File: testFile(t, "employees.csv", "user_id,name\n"+testUser+",Иванов\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ttl != DEFAULT_TTL {
+		t.Errorf("ttl %s, want %s", c.ttl, DEFAULT_TTL)
+	}
+}
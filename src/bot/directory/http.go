@@ -0,0 +1,80 @@
+package directory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	Http struct {
+		// {user} is replaced with the Connect user id, e.g. https://hr.example.com/api/employees/{user}
+		Url string `yaml:"url"`
+		// e.g. Authorization: Bearer <token>
+		Headers map[string]string `yaml:"headers"`
+	}
+
+	// httpProvider expects the profile in JSON and 404 for unknown users
+	httpProvider struct {
+		c      Http
+		client *http.Client
+	}
+)
+
+func newHttpProvider(c Http, timeout time.Duration) (*httpProvider, error) {
+	u, err := url.Parse(strings.ReplaceAll(c.Url, "{user}", uuid.Nil.String()))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", c.Url)
+	}
+
+	if !strings.Contains(c.Url, "{user}") {
+		return nil, errors.New("http url has no {user}")
+	}
+
+	return &httpProvider{c: c, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (p *httpProvider) Lookup(userId uuid.UUID) (*Profile, error) {
+	req, err := http.NewRequest("GET", strings.ReplaceAll(p.c.Url, "{user}", userId.String()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	for name, value := range p.c.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, fmt.Errorf("directory %s responded with code %d: %s", req.URL.Host, resp.StatusCode, body)
+	}
+
+	profile := &Profile{}
+	if err = json.Unmarshal(body, profile); err != nil {
+		return nil, err
+	}
+	profile.UserId = userId
+
+	return profile, nil
+}
@@ -0,0 +1,70 @@
+package directory
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestHttpLookup(t *testing.T) {
+	user := uuid.MustParse(testUser)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/employees/" + testUser:
+			_, _ = w.Write([]byte(`{"user_id":"` + testOther + `","name":"Иванов","department":"Бухгалтерия"}`))
+		case "/employees/" + testOther:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	p, err := newHttpProvider(Http{
+		Url:     srv.URL + "/employees/{user}",
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Пользователь подставлен в адрес, а идентификатор ответа не подменяет его
+	profile, err := p.Lookup(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile == nil || profile.UserId != user || profile.Name != "Иванов" || profile.Department != "Бухгалтерия" {
+		t.Errorf("profile %+v", profile)
+	}
+
+	if profile, err = p.Lookup(uuid.MustParse(testOther)); err != nil || profile != nil {
+		t.Errorf("unknown user: profile %+v, error %v", profile, err)
+	}
+
+	if profile, err = p.Lookup(uuid.New()); err == nil || profile != nil {
+		t.Errorf("failed request: profile %+v, error %v", profile, err)
+	}
+}
+
+func TestNewHttpProvider(t *testing.T) {
+	tests := map[string]bool{
+		"https://hr.example.com/api/employees/{user}": true,
+		"https://hr.example.com/api/employees":        false,
+		"hr.example.com/{user}":                       false,
+	}
+
+	for url, ok := range tests {
+		if _, err := newHttpProvider(Http{Url: url}, time.Second); (err == nil) != ok {
+			t.Errorf("%s: error %v", url, err)
+		}
+	}
+}
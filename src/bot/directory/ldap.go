@@ -0,0 +1,185 @@
+package directory
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	DEFAULT_LDAP_FILTER = "(employeeNumber={user})"
+)
+
+var (
+	// Атрибуты по умолчанию есть и в OpenLDAP, и в Active Directory
+	defaultLdapAttributes = map[string]string{
+		FIELD_NAME:       "displayName",
+		FIELD_DEPARTMENT: "department",
+		FIELD_MANAGER:    "manager",
+		FIELD_LOCATION:   "l",
+	}
+)
+
+type (
+	Ldap struct {
+		// ldap://host:389 or ldaps://host:636
+		Url      string `yaml:"url"`
+		StartTLS bool   `yaml:"start_tls"`
+		// Root certificate of the server if it is not trusted by the system
+		CaFile             string `yaml:"ca_file"`
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+
+		// Anonymous search without the bind dn
+		BindDn           string `yaml:"bind_dn"`
+		BindPassword     string `yaml:"bind_password"`
+		BindPasswordFile string `yaml:"bind_password_file"`
+
+		BaseDn string `yaml:"base_dn"`
+		// {user} is replaced with the Connect user id, (employeeNumber={user}) by default
+		Filter string `yaml:"filter"`
+
+		// LDAP attributes of profile fields, e.g. name: cn. Other keys become attributes of the profile.
+		// displayName, department, manager and l by default.
+		Attributes map[string]string `yaml:"attributes"`
+	}
+
+	ldapProvider struct {
+		c       Ldap
+		tls     *tls.Config
+		timeout time.Duration
+	}
+)
+
+func newLdapProvider(c Ldap, timeout time.Duration) (*ldapProvider, error) {
+	if c.Url == "" || c.BaseDn == "" {
+		return nil, errors.New("ldap url and base_dn are required")
+	}
+
+	if c.Filter == "" {
+		c.Filter = DEFAULT_LDAP_FILTER
+	}
+
+	if !strings.Contains(c.Filter, "{user}") {
+		return nil, fmt.Errorf("ldap filter %q has no {user}", c.Filter)
+	}
+
+	attributes := make(map[string]string, len(defaultLdapAttributes)+len(c.Attributes))
+	for field, attr := range defaultLdapAttributes {
+		attributes[field] = attr
+	}
+	for field, attr := range c.Attributes {
+		attributes[field] = attr
+	}
+	c.Attributes = attributes
+
+	p := &ldapProvider{
+		c:       c,
+		timeout: timeout,
+		tls:     &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify},
+	}
+
+	if c.CaFile != "" {
+		pem, err := ioutil.ReadFile(c.CaFile)
+		if err != nil {
+			return nil, err
+		}
+
+		p.tls.RootCAs = x509.NewCertPool()
+		if !p.tls.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates", c.CaFile)
+		}
+	}
+
+	return p, nil
+}
+
+// Lookup connects for every request, the cache makes them rare
+func (p *ldapProvider) Lookup(userId uuid.UUID) (*Profile, error) {
+	conn, err := ldap.DialURL(p.c.Url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: p.timeout}),
+		ldap.DialWithTLSConfig(p.tls))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetTimeout(p.timeout)
+
+	if p.c.StartTLS {
+		if err = conn.StartTLS(p.tls); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.c.BindDn != "" {
+		if err = conn.Bind(p.c.BindDn, p.c.BindPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	attrs := make([]string, 0, len(p.c.Attributes))
+	for _, attr := range p.c.Attributes {
+		attrs = append(attrs, attr)
+	}
+
+	filter := userFilter(p.c.Filter, userId.String())
+
+	res, err := conn.Search(ldap.NewSearchRequest(p.c.BaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(p.timeout/time.Second), false, filter, attrs, nil))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+
+	switch len(res.Entries) {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("ldap: user %s matches several entries", userId)
+	}
+
+	entry := res.Entries[0]
+	profile := &Profile{UserId: userId}
+
+	for field, attr := range p.c.Attributes {
+		// Несколько значений, например групп из memberOf, перечисляем через точку с запятой
+		values := entry.GetAttributeValues(attr)
+		for i := range values {
+			values[i] = shortName(values[i])
+		}
+
+		if field != FIELD_USER_ID {
+			_ = profile.set(field, strings.Join(values, "; "))
+		}
+	}
+
+	return profile, nil
+}
+
+// userFilter puts the user into the filter, special characters of the value are escaped
+func userFilter(filter string, user string) string {
+	return strings.ReplaceAll(filter, "{user}", ldap.EscapeFilter(user))
+}
+
+// shortName returns the name of the entry by its DN, e.g. of the manager or the group,
+// other values are returned as is
+func shortName(value string) string {
+	if !strings.Contains(value, "=") {
+		return value
+	}
+
+	dn, err := ldap.ParseDN(value)
+	if err != nil || len(dn.RDNs) < 2 || len(dn.RDNs[0].Attributes) == 0 {
+		return value
+	}
+
+	return dn.RDNs[0].Attributes[0].Value
+}
@@ -0,0 +1,232 @@
+package directory
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	ldapBindRequest  = 0
+	ldapBindResponse = 1
+	ldapUnbind       = 2
+	ldapSearch       = 3
+	ldapSearchEntry  = 4
+	ldapSearchDone   = 5
+)
+
+// ldapStub answers bind and search requests of the provider with entries of the filter
+type ldapStub struct {
+	listener net.Listener
+	entries  map[string]map[string][]string
+
+	mu      sync.Mutex
+	binds   []string
+	filters []string
+}
+
+func newLdapStub(t *testing.T, entries map[string]map[string][]string) *ldapStub {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no local listener:", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	s := &ldapStub{listener: l, entries: entries}
+	go s.serve()
+
+	return s
+}
+
+func (s *ldapStub) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapStub) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *ldapStub) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldapBindRequest:
+			s.mu.Lock()
+			s.binds = append(s.binds, op.Children[1].Value.(string))
+			s.mu.Unlock()
+
+			_, _ = conn.Write(ldapMessage(id, ldapResult(ldapBindResponse)).Bytes())
+		case ldapSearch:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.filters = append(s.filters, filter)
+			s.mu.Unlock()
+
+			for dn, attrs := range s.entries {
+				if filter == "(employeeNumber="+attrs["employeeNumber"][0]+")" {
+					_, _ = conn.Write(ldapMessage(id, ldapEntry(dn, attrs)).Bytes())
+				}
+			}
+			_, _ = conn.Write(ldapMessage(id, ldapResult(ldapSearchDone)).Bytes())
+		case ldapUnbind:
+			return
+		}
+	}
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "message")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "id"))
+	msg.AppendChild(op)
+
+	return msg
+}
+
+func ldapResult(tag ber.Tag) *ber.Packet {
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "result")
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, 0, "code"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matched dn"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "message"))
+
+	return res
+}
+
+func ldapEntry(dn string, attrs map[string][]string) *ber.Packet {
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapSearchEntry, nil, "entry")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "dn"))
+
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		}
+		attr.AppendChild(set)
+
+		list.AppendChild(attr)
+	}
+	entry.AppendChild(list)
+
+	return entry
+}
+
+func TestLdapLookup(t *testing.T) {
+	s := newLdapStub(t, map[string]map[string][]string{
+		"cn=Иванов,ou=staff,dc=example,dc=com": {
+			"employeeNumber": {testUser},
+			"displayName":    {"Иванов Иван"},
+			"department":     {"Бухгалтерия"},
+			"manager":        {"cn=Петров,ou=staff,dc=example,dc=com"},
+			"memberOf":       {"cn=Бухгалтеры,ou=groups,dc=example,dc=com", "cn=Все,ou=groups,dc=example,dc=com"},
+		},
+	})
+
+	p, err := newLdapProvider(Ldap{
+		Url:          s.url(),
+		BindDn:       "cn=bot,dc=example,dc=com",
+		BindPassword: "password",
+		BaseDn:       "dc=example,dc=com",
+		Attributes:   map[string]string{"groups": "memberOf"},
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := p.Lookup(uuid.MustParse(testUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if profile == nil || profile.Name != "Иванов Иван" || profile.Department != "Бухгалтерия" {
+		t.Fatalf("profile %+v", profile)
+	}
+
+	// Руководитель и группы по DN сокращаются до имени
+	if profile.Manager != "Петров" {
+		t.Errorf("manager %q, want the name", profile.Manager)
+	}
+	if groups := profile.Field("groups"); groups != "Бухгалтеры; Все" {
+		t.Errorf("groups %q", groups)
+	}
+
+	if profile, err = p.Lookup(uuid.MustParse(testOther)); err != nil || profile != nil {
+		t.Errorf("unknown user: profile %+v, error %v", profile, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.binds) != 2 || s.binds[0] != "cn=bot,dc=example,dc=com" {
+		t.Errorf("binds %v", s.binds)
+	}
+	if len(s.filters) != 2 || s.filters[0] != "(employeeNumber="+testUser+")" {
+		t.Errorf("filters %v", s.filters)
+	}
+}
+
+func TestUserFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		user   string
+		want   string
+	}{
+		{DEFAULT_LDAP_FILTER, testUser, "(employeeNumber=" + testUser + ")"},
+		{"(&(objectClass=person)(|(uid={user})(mail={user})))", "ivanov",
+			"(&(objectClass=person)(|(uid=ivanov)(mail=ivanov)))"},
+		{DEFAULT_LDAP_FILTER, "*)(uid=*", `(employeeNumber=\2a\29\28uid=\2a)`},
+		{DEFAULT_LDAP_FILTER, `a\b`, `(employeeNumber=a\5cb)`},
+	}
+
+	for _, tt := range tests {
+		if got := userFilter(tt.filter, tt.user); got != tt.want {
+			t.Errorf("userFilter(%q, %q) = %q, want %q", tt.filter, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestShortName(t *testing.T) {
+	tests := map[string]string{
+		"cn=Петров Петр,ou=staff,dc=example,dc=com":   "Петров Петр",
+		`cn=Петров\, Петр,ou=staff,dc=example,dc=com`: "Петров, Петр",
+		"uid=ivanov,dc=example":                       "ivanov",
+		// Не DN: одна часть, обычный текст или ошибка разбора
+		"cn=Петров":      "cn=Петров",
+		"Бухгалтерия":    "Бухгалтерия",
+		"a=b=c,,":        "a=b=c,,",
+		"Москва, офис 1": "Москва, офис 1",
+	}
+
+	for value, want := range tests {
+		if got := shortName(value); got != want {
+			t.Errorf("shortName(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"connect-companion/bot/directory"
+	"connect-companion/bot/messages"
+
+	"github.com/google/uuid"
+)
+
+type failingDirectory struct {
+	calls int
+}
+
+func (d *failingDirectory) Lookup(userId uuid.UUID) (*directory.Profile, error) {
+	d.calls++
+	return nil, errors.New("directory is down")
+}
+
+func TestProfileOf(t *testing.T) {
+	d := &failingDirectory{}
+
+	saved := employees
+	employees = directory.NewCache(d, time.Minute)
+	t.Cleanup(func() { employees = saved })
+
+	msg := newMessage(messages.Message{UserId: uuid.New()})
+	for i := 0; i < 3; i++ {
+		if profile := profileOf(msg); profile != nil {
+			t.Errorf("profile %+v", profile)
+		}
+	}
+
+	// Справочник спрашиваем раз на сообщение, неудачу помнит кэш
+	other := newMessage(messages.Message{UserId: msg.UserId})
+	profileOf(other)
+
+	if d.calls != 1 {
+		t.Errorf("%d lookups, want 1", d.calls)
+	}
+}
@@ -0,0 +1,129 @@
+package docgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrBusy is returned at once when too many documents wait for conversion
+	ErrBusy = errors.New("too many documents are converted")
+
+	// Конвертер тяжелый: не больше workers сразу и queue в ожидании
+	pool   *convertPool
+	poolMu sync.Mutex
+)
+
+type (
+	convertPool struct {
+		slots   chan struct{}
+		waiting int
+		queue   int
+		mu      sync.Mutex
+	}
+)
+
+// poolOf returns the pool of the config, the pool is created again when its size is changed
+func poolOf(c Config) *convertPool {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	if pool == nil || cap(pool.slots) != c.Workers || pool.queue != c.Queue {
+		pool = &convertPool{slots: make(chan struct{}, c.Workers), queue: c.Queue}
+	}
+
+	return pool
+}
+
+// acquire takes a free worker or waits for it if the queue is not full
+func (p *convertPool) acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	p.mu.Lock()
+	if p.waiting >= p.queue {
+		p.mu.Unlock()
+		return ErrBusy
+	}
+	p.waiting++
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.waiting--
+		p.mu.Unlock()
+	}()
+
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *convertPool) release() {
+	<-p.slots
+}
+
+// convert runs the converter in a temporary directory and returns the PDF it made
+func convert(c Config, ext string, content []byte) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "docgen")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "document"+ext)
+	if err = ioutil.WriteFile(file, content, 0600); err != nil {
+		return nil, err
+	}
+
+	args := make([]string, len(c.Converter))
+	for i, a := range c.Converter {
+		args[i] = strings.NewReplacer("{dir}", dir, "{file}", file).Replace(a)
+	}
+
+	// Ожидание очереди входит в timeout
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	p := poolOf(c)
+	if err = p.acquire(ctx); err != nil {
+		return nil, fmt.Errorf("converter %s: %w", args[0], err)
+	}
+	defer p.release()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "HOME="+dir)
+
+	output := new(bytes.Buffer)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("converter %s: %v: %s", args[0], err, strings.TrimSpace(output.String()))
+	}
+
+	pdf, err := ioutil.ReadFile(strings.TrimSuffix(file, ext) + EXT_PDF)
+	if err != nil {
+		return nil, fmt.Errorf("converter %s made no PDF: %s", args[0], strings.TrimSpace(output.String()))
+	}
+
+	return pdf, nil
+}
@@ -0,0 +1,71 @@
+package docgen
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConvertPool(t *testing.T) {
+	p := poolOf(Config{Workers: 1, Queue: 1})
+	if poolOf(Config{Workers: 1, Queue: 1}) != p {
+		t.Error("pool of the same size is created again")
+	}
+
+	ctx := context.Background()
+	if err := p.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	queued := make(chan error)
+	go func() {
+		queued <- p.acquire(ctx)
+	}()
+
+	// Ждем, пока второй встанет в очередь
+	for deadline := time.Now().Add(time.Second); ; {
+		p.mu.Lock()
+		waiting := p.waiting
+		p.mu.Unlock()
+
+		if waiting == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the second conversion does not wait")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := p.acquire(ctx); err != ErrBusy {
+		t.Errorf("full queue: %v, want ErrBusy", err)
+	}
+
+	p.release()
+	if err := <-queued; err != nil {
+		t.Errorf("queued conversion: %v", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := p.acquire(timeout); err != context.DeadlineExceeded {
+		t.Errorf("timeout in the queue: %v", err)
+	}
+
+	p.release()
+
+	if poolOf(Config{Workers: 2, Queue: 1}) == p {
+		t.Error("pool is not resized")
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	c := Config{}.WithDefaults()
+	if c.Workers != DEFAULT_WORKERS || c.Queue != DEFAULT_QUEUE || c.Keep != DEFAULT_KEEP || c.Dir != DEFAULT_DIR {
+		t.Errorf("defaults %+v", c)
+	}
+
+	if c = (Config{Queue: -1}).WithDefaults(); c.Queue != 0 {
+		t.Errorf("negative queue is %d, want 0", c.Queue)
+	}
+}
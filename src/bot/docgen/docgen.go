@@ -0,0 +1,236 @@
+package docgen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"connect-companion/bot/directory"
+	"connect-companion/bot/templates"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EXT_DOCX = ".docx"
+	EXT_ODT  = ".odt"
+	EXT_HTML = ".html"
+	EXT_HTM  = ".htm"
+	EXT_PDF  = ".pdf"
+
+	DEFAULT_DIR     = "./generated"
+	DEFAULT_TIMEOUT = time.Minute
+	DEFAULT_KEEP    = 365 * 24 * time.Hour
+
+	// Одновременные конвертации и очередь к ним, дальше отказываем сразу
+	DEFAULT_WORKERS = 2
+	DEFAULT_QUEUE   = 10
+
+	// Готовые документы раскладываем по месяцам
+	MONTH_DIR = "2006-01"
+)
+
+var (
+	// LibreOffice из пакета без графики, {dir} и {file} подставляются
+	DEFAULT_CONVERTER = []string{"soffice", "--headless", "--convert-to", "pdf", "--outdir", "{dir}", "{file}"}
+
+	unsafeName = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+)
+
+type (
+	// Config of the document generation
+	Config struct {
+		// Generated documents are kept here, ./generated by default
+		Dir string `yaml:"dir"`
+
+		// Command converting the filled template to PDF with {dir} and {file} placeholders,
+		// soffice --headless --convert-to pdf by default
+		Converter []string      `yaml:"converter"`
+		Timeout   time.Duration `yaml:"timeout"`
+
+		// Conversions run at once and waiting for their turn, 2 and 10 by default
+		Workers int `yaml:"workers"`
+		Queue   int `yaml:"queue"`
+
+		// Documents and their audit records are removed after keep, a year by default
+		Keep time.Duration `yaml:"keep"`
+	}
+
+	// Data of the template: {{.Values.<name>}} are answers of the form and variables of the chat
+	Data struct {
+		Values map[string]string
+		UserId string
+		LineId string
+		Now    time.Time
+		// {{.Profile.Name}}, {{.Profile.Department}} and other fields of the employee
+		Profile directory.Profile
+	}
+
+	// Output is the generated document in the storage
+	Output struct {
+		Id     string
+		Path   string
+		Name   string
+		Size   int64
+		Sha256 string
+	}
+)
+
+func (c Config) WithDefaults() Config {
+	if c.Dir == "" {
+		c.Dir = DEFAULT_DIR
+	}
+
+	if len(c.Converter) == 0 {
+		c.Converter = DEFAULT_CONVERTER
+	}
+
+	if c.Timeout <= 0 {
+		c.Timeout = DEFAULT_TIMEOUT
+	}
+
+	if c.Workers <= 0 {
+		c.Workers = DEFAULT_WORKERS
+	}
+
+	if c.Queue < 0 {
+		c.Queue = 0
+	} else if c.Queue == 0 {
+		c.Queue = DEFAULT_QUEUE
+	}
+
+	if c.Keep <= 0 {
+		c.Keep = DEFAULT_KEEP
+	}
+
+	return c
+}
+
+// Supported tells whether the template can be filled
+func Supported(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case EXT_DOCX, EXT_ODT, EXT_HTML, EXT_HTM:
+		return true
+	}
+
+	return false
+}
+
+// Check fills the template and its file name with sample data, so mistakes are found at startup
+func Check(tpl string, name string) error {
+	if !Supported(tpl) {
+		return fmt.Errorf("%s: only docx, odt and html templates are supported", tpl)
+	}
+
+	if _, err := fileName(name, tpl, sample()); err != nil {
+		return err
+	}
+
+	return fill(tpl, ioutil.Discard, sample())
+}
+
+// Generate fills the template, converts it to PDF if asked and stores the result
+func Generate(c Config, tpl string, name string, pdf bool, data *Data) (*Output, error) {
+	c = c.WithDefaults()
+
+	out := &Output{Id: uuid.New().String()}
+
+	var err error
+	if out.Name, err = fileName(name, tpl, data); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(c.Dir, data.Now.Format(MONTH_DIR))
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(tpl))
+	filled := new(bytes.Buffer)
+	if err = fill(tpl, filled, data); err != nil {
+		return nil, err
+	}
+
+	content := filled.Bytes()
+	if pdf {
+		if content, err = convert(c, ext, content); err != nil {
+			return nil, err
+		}
+		ext = EXT_PDF
+	}
+
+	out.Name += ext
+	out.Path = filepath.Join(dir, out.Id+ext)
+	out.Size = int64(len(content))
+
+	sum := sha256.Sum256(content)
+	out.Sha256 = hex.EncodeToString(sum[:])
+
+	if err = ioutil.WriteFile(out.Path, content, 0640); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func fill(tpl string, w io.Writer, data *Data) error {
+	var err error
+
+	switch strings.ToLower(filepath.Ext(tpl)) {
+	case EXT_DOCX:
+		err = fillZip(tpl, w, data, docxParts, docxParagraph, docxText)
+	case EXT_ODT:
+		err = fillZip(tpl, w, data, odtParts, odtParagraph, odtText)
+	case EXT_HTML, EXT_HTM:
+		err = fillHtml(tpl, w, data)
+	default:
+		err = fmt.Errorf("unsupported template")
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %v", tpl, err)
+	}
+
+	return nil
+}
+
+// fileName renders the name of the document for the user, the template name by default
+func fileName(name string, tpl string, data *Data) (string, error) {
+	if name == "" {
+		return strings.TrimSuffix(filepath.Base(tpl), filepath.Ext(tpl)), nil
+	}
+
+	t, err := template.New("name").Funcs(templates.Funcs()).Option("missingkey=zero").Parse(name)
+	if err != nil {
+		return "", fmt.Errorf("name %q: %v", name, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err = t.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("name %q: %v", name, err)
+	}
+
+	result := strings.TrimSpace(unsafeName.Replace(buf.String()))
+	if result == "" {
+		return strings.TrimSuffix(filepath.Base(tpl), filepath.Ext(tpl)), nil
+	}
+
+	return result, nil
+}
+
+func sample() *Data {
+	return &Data{
+		Values: map[string]string{},
+		UserId: "00000000-0000-0000-0000-000000000000",
+		LineId: "00000000-0000-0000-0000-000000000000",
+		Now:    time.Now(),
+	}
+}
@@ -0,0 +1,158 @@
+package docgen
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"html"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"connect-companion/bot/templates"
+)
+
+var (
+	// Части документа, где могут быть поля: текст, колонтитулы, сноски
+	docxParts = regexp.MustCompile(`^word/(document|header\d*|footer\d*|footnotes|endnotes)\.xml$`)
+	odtParts  = regexp.MustCompile(`^(content|styles)\.xml$`)
+
+	docxParagraph = regexp.MustCompile(`(?s)<w:p(?:\s[^>]*[^/>])?>.*?</w:p>`)
+	odtParagraph  = regexp.MustCompile(`(?s)<text:(?:p|h)(?:\s[^>]*[^/>])?>.*?</text:(?:p|h)>`)
+
+	// Текст абзаца, группа 2 - сам текст
+	docxText = regexp.MustCompile(`(<w:t(?:\s[^>]*)?>)([^<]*)(</w:t>)`)
+	odtText  = regexp.MustCompile(`(>)([^<]*)(<)`)
+)
+
+// fillZip renders every part of the office document. Word splits text of a paragraph into runs
+// as it likes, so the placeholder may be cut: the whole text of such a paragraph is rendered
+// and put into its first run, the formatting of the first run is kept.
+func fillZip(tpl string, w io.Writer, data *Data, parts *regexp.Regexp, paragraph *regexp.Regexp, text *regexp.Regexp) error {
+	z, err := zip.OpenReader(tpl)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+
+	zw := zip.NewWriter(w)
+
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			return err
+		}
+
+		if parts.MatchString(f.Name) {
+			if content, err = fillXml(content, data, paragraph, text); err != nil {
+				return err
+			}
+		}
+
+		// mimetype в ODT должен быть первым и несжатым, остальное сжимаем как было
+		header := f.FileHeader
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     header.Name,
+			Method:   header.Method,
+			Modified: header.Modified,
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err = fw.Write(content); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func fillXml(content []byte, data *Data, paragraph *regexp.Regexp, text *regexp.Regexp) ([]byte, error) {
+	var failed error
+
+	result := paragraph.ReplaceAllFunc(content, func(p []byte) []byte {
+		if failed != nil || !bytes.Contains(p, []byte("{{")) {
+			return p
+		}
+
+		var plain strings.Builder
+		for _, m := range text.FindAllSubmatch(p, -1) {
+			plain.WriteString(html.UnescapeString(string(m[2])))
+		}
+
+		if !strings.Contains(plain.String(), "{{") {
+			return p
+		}
+
+		rendered, err := render(plain.String(), data)
+		if err != nil {
+			failed = err
+			return p
+		}
+
+		first := true
+		return text.ReplaceAllFunc(p, func(t []byte) []byte {
+			m := text.FindSubmatch(t)
+			if !first {
+				return append(append([]byte{}, m[1]...), m[3]...)
+			}
+			first = false
+
+			open := m[1]
+			// Пробелы по краям значения Word иначе отбрасывает
+			if bytes.HasPrefix(open, []byte("<w:t")) && !bytes.Contains(open, []byte("xml:space")) {
+				open = []byte(`<w:t xml:space="preserve">`)
+			}
+
+			return append(append(append([]byte{}, open...), escape(rendered)...), m[3]...)
+		})
+	})
+
+	return result, failed
+}
+
+func render(text string, data *Data) (string, error) {
+	t, err := template.New("").Funcs(templates.Funcs()).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err = t.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func escape(s string) []byte {
+	buf := new(bytes.Buffer)
+	_ = xml.EscapeText(buf, []byte(s))
+
+	return buf.Bytes()
+}
+
+// fillHtml renders the page with escaping of values
+func fillHtml(tpl string, w io.Writer, data *Data) error {
+	content, err := ioutil.ReadFile(tpl)
+	if err != nil {
+		return err
+	}
+
+	t, err := htmltemplate.New("").Funcs(htmltemplate.FuncMap(templates.Funcs())).Option("missingkey=zero").Parse(string(content))
+	if err != nil {
+		return err
+	}
+
+	return t.Execute(w, data)
+}
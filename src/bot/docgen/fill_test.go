@@ -0,0 +1,164 @@
+package docgen
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testData() *Data {
+	return &Data{
+		Values: map[string]string{"fio": "Иванов & Ко", "date": "01.02.2020"},
+		Now:    time.Date(2020, 2, 1, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "docgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	return dir
+}
+
+func TestFillDocx(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		want string
+	}{
+		{
+			"placeholder split across runs",
+			`<w:p><w:r><w:rPr><w:b/></w:rPr><w:t>Сотрудник {{.Val</w:t></w:r><w:r><w:t>ues.fio}}</w:t></w:r><w:r><w:t xml:space="preserve"> работает</w:t></w:r></w:p>`,
+			`<w:p><w:r><w:rPr><w:b/></w:rPr><w:t xml:space="preserve">Сотрудник Иванов &amp; Ко работает</w:t></w:r><w:r><w:t></w:t></w:r><w:r><w:t xml:space="preserve"></w:t></w:r></w:p>`,
+		},
+		{
+			"escaped quotes of the placeholder",
+			`<w:p w:rsidR="00A1"><w:r><w:t>{{printf &quot;%s!&quot; .Values.date}}</w:t></w:r></w:p>`,
+			`<w:p w:rsidR="00A1"><w:r><w:t xml:space="preserve">01.02.2020!</w:t></w:r></w:p>`,
+		},
+		{
+			"paragraphs without placeholders are kept",
+			`<w:p><w:pPr><w:jc w:val="center"/></w:pPr><w:r><w:t>{ {не поле}}</w:t></w:r></w:p><w:p/>`,
+			`<w:p><w:pPr><w:jc w:val="center"/></w:pPr><w:r><w:t>{ {не поле}}</w:t></w:r></w:p><w:p/>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fillXml([]byte(tt.xml), testData(), docxParagraph, docxText)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("filled\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := fillXml([]byte(`<w:p><w:r><w:t>{{.Values.fio</w:t></w:r></w:p>`), testData(), docxParagraph, docxText); err == nil {
+		t.Error("broken placeholder is filled")
+	}
+}
+
+func TestFillOdt(t *testing.T) {
+	content := `<office:text><text:h text:outline-level="1">Справка</text:h>` +
+		`<text:p text:style-name="P1">Дата: <text:span text:style-name="T1">{{.Values.da</text:span>te}}</text:p></office:text>`
+
+	dir := testDir(t)
+	tpl := filepath.Join(dir, "template.odt")
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, f := range []struct {
+		name    string
+		method  uint16
+		content string
+	}{
+		{"mimetype", zip.Store, "application/vnd.oasis.opendocument.text"},
+		{"content.xml", zip.Deflate, content},
+		{"meta.xml", zip.Deflate, "<meta>{{.Values.fio}}</meta>"},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: f.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(f.content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tpl, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+	if err := fill(tpl, out, testData()); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if z.File[0].Name != "mimetype" || z.File[0].Method != zip.Store {
+		t.Errorf("mimetype is not the first stored file: %s, method %d", z.File[0].Name, z.File[0].Method)
+	}
+
+	files := map[string]string{}
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(r)
+		_ = r.Close()
+		files[f.Name] = string(data)
+	}
+
+	want := `<office:text><text:h text:outline-level="1">Справка</text:h>` +
+		`<text:p text:style-name="P1">Дата: 01.02.2020<text:span text:style-name="T1"></text:span></text:p></office:text>`
+	if files["content.xml"] != want {
+		t.Errorf("content\n%s\nwant\n%s", files["content.xml"], want)
+	}
+
+	// Метаданные не заполняются
+	if files["meta.xml"] != "<meta>{{.Values.fio}}</meta>" {
+		t.Errorf("meta %s", files["meta.xml"])
+	}
+}
+
+func TestFillHtml(t *testing.T) {
+	dir := testDir(t)
+	tpl := filepath.Join(dir, "template.html")
+
+	if err := ioutil.WriteFile(tpl, []byte(`<p title="{{.Values.fio}}">{{.Values.fio}}, {{.Values.missing}}{{.Now.Year}}</p>`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	data := testData()
+	data.Values["fio"] = `<b>"Иванов"</b>`
+
+	out := new(bytes.Buffer)
+	if err := fill(tpl, out, data); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<p title="&lt;b&gt;&#34;Иванов&#34;&lt;/b&gt;">&lt;b&gt;&#34;Иванов&#34;&lt;/b&gt;, 2020</p>`
+	if out.String() != want {
+		t.Errorf("filled %s, want %s", out.String(), want)
+	}
+
+	if err := fill(filepath.Join(dir, "template.txt"), out, data); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("text template: %v", err)
+	}
+}
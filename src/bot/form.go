@@ -0,0 +1,256 @@
+package bot
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"connect-companion/bot/forms"
+	"connect-companion/bot/requests"
+	"connect-companion/database"
+	"connect-companion/logger"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	KEY_FORM_BACK    = "back"
+	KEY_FORM_CANCEL  = "cancel"
+	KEY_FORM_CONFIRM = "confirm"
+
+	// Тексты полей в каталоге фраз: form:<форма>:<поле>:title и т. д.
+	FIELD_PHRASE_TITLE  = "title"
+	FIELD_PHRASE_PROMPT = "prompt"
+	FIELD_PHRASE_ERROR  = "error"
+	FIELD_PHRASE_CHOICE = "choice:"
+)
+
+// fieldPhrase returns the key of the field text in the catalog of phrases
+func fieldPhrase(form *forms.Form, field *forms.Field, part string) string {
+	return PREFIX_FORM + form.Id + ":" + field.Name + ":" + part
+}
+
+// formPhrases returns texts of fields to translate, choices are numbered from 1
+func formPhrases(form *forms.Form) map[string]string {
+	phrases := make(map[string]string)
+
+	for i := range form.Fields {
+		field := &form.Fields[i]
+
+		phrases[fieldPhrase(form, field, FIELD_PHRASE_TITLE)] = field.Label()
+
+		prompt := field.Prompt
+		if prompt == "" {
+			prompt = field.Label() + ":"
+		}
+		phrases[fieldPhrase(form, field, FIELD_PHRASE_PROMPT)] = prompt
+
+		if field.Error != "" {
+			phrases[fieldPhrase(form, field, FIELD_PHRASE_ERROR)] = field.Error
+		}
+
+		for j, c := range field.Choices {
+			phrases[fieldPhrase(form, field, FIELD_PHRASE_CHOICE+strconv.Itoa(j+1))] = c
+		}
+	}
+
+	return phrases
+}
+
+// choiceLabel returns the translated label of the choice, the value is kept as is in answers
+func choiceLabel(msg *message, chatState *database.Chat, form *forms.Form, field *forms.Field, value string) string {
+	for i, c := range field.Choices {
+		if c == value {
+			return say(msg, chatState, fieldPhrase(form, field, FIELD_PHRASE_CHOICE+strconv.Itoa(i+1)))
+		}
+	}
+
+	return value
+}
+
+// inputError explains the invalid input in the language of the chat
+func inputError(msg *message, chatState *database.Chat, form *forms.Form, field *forms.Field, err error) string {
+	if field.Error != "" {
+		return say(msg, chatState, fieldPhrase(form, field, FIELD_PHRASE_ERROR))
+	}
+
+	if e, ok := err.(*forms.InputError); ok {
+		return sayWith(msg, chatState, e.Key, map[string]string{"limit": e.Limit})
+	}
+
+	return err.Error()
+}
+
+func formKeyboard(msg *message, chatState *database.Chat, form *forms.Form, field *forms.Field, step int) *[][]requests.KeyboardKey {
+	var keyboard [][]requests.KeyboardKey
+
+	if field != nil && field.Type == forms.FIELD_CHOICE {
+		for _, c := range field.Choices {
+			keyboard = append(keyboard, []requests.KeyboardKey{{Id: c, Text: choiceLabel(msg, chatState, form, field, c)}})
+		}
+	}
+
+	if field == nil {
+		keyboard = append(keyboard, []requests.KeyboardKey{key(msg, chatState, KEY_FORM_CONFIRM, LABEL_CONFIRM)})
+	}
+
+	nav := []requests.KeyboardKey{key(msg, chatState, KEY_FORM_CANCEL, LABEL_CANCEL)}
+	if step > 0 {
+		nav = append([]requests.KeyboardKey{key(msg, chatState, KEY_FORM_BACK, LABEL_BACK)}, nav...)
+	}
+
+	keyboard = append(keyboard, nav)
+
+	return &keyboard
+}
+
+func startForm(msg *message, chatState *database.Chat, form *forms.Form) (database.ChatState, error) {
+	visit(chatState, PREFIX_FORM+form.Id)
+
+	chatState.Form = &database.FormState{
+		Id:      form.Id,
+		Answers: make(map[string]string),
+	}
+
+	return askField(msg, chatState, form, "")
+}
+
+// askField prompts for the current field or shows the summary when all fields are filled
+func askField(msg *message, chatState *database.Chat, form *forms.Form, prefix string) (database.ChatState, error) {
+	step := chatState.Form.Step
+
+	var text string
+	var field *forms.Field
+
+	if step < len(form.Fields) {
+		field = &form.Fields[step]
+
+		text = say(msg, chatState, fieldPhrase(form, field, FIELD_PHRASE_PROMPT))
+	} else {
+		lines := []string{say(msg, chatState, PHRASE_FORM_CONFIRM)}
+		for i := range form.Fields {
+			f := &form.Fields[i]
+
+			value := chatState.Form.Answers[f.Name]
+			if f.Type == forms.FIELD_CHOICE {
+				value = choiceLabel(msg, chatState, form, f, value)
+			}
+
+			lines = append(lines, say(msg, chatState, fieldPhrase(form, f, FIELD_PHRASE_TITLE))+": "+value)
+		}
+
+		text = strings.Join(lines, "\n")
+	}
+
+	if prefix != "" {
+		text = prefix + "\n" + text
+	}
+
+	_, err := SendMessage(msg.LineId, msg.UserId, text, formKeyboard(msg, chatState, form, field, step))
+
+	return checkErrorForSend(msg, err, database.STATE_FORM)
+}
+
+func processForm(db redis.UniversalClient, msg *message, chatState *database.Chat) (database.ChatState, error) {
+	var form *forms.Form
+	if chatState.Form != nil {
+		form = forms.ById(tenantOf(msg.LineId).conf.Forms, chatState.Form.Id)
+	}
+
+	if form == nil {
+		// Форма удалена из конфигурации, пока пользователь ее заполнял
+		chatState.Form = nil
+
+		_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_GREETING), mainKeyboard(msg, chatState))
+
+		return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
+	}
+
+	var field *forms.Field
+	if chatState.Form.Step < len(form.Fields) {
+		field = &form.Fields[chatState.Form.Step]
+	}
+
+	switch pick(formKeyboard(msg, chatState, form, nil, chatState.Form.Step), msg.Text) {
+	case KEY_FORM_CANCEL:
+		chatState.Form = nil
+
+		_, _ = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_FORM_CANCELED), nil)
+		_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_GREETING), mainKeyboard(msg, chatState))
+
+		return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
+	case KEY_FORM_BACK:
+		chatState.Form.Step--
+
+		return askField(msg, chatState, form, "")
+	case KEY_FORM_CONFIRM:
+		if field == nil {
+			return submitForm(db, msg, chatState, form)
+		}
+	}
+
+	if field == nil {
+		return askField(msg, chatState, form, say(msg, chatState, PHRASE_FORM_CHOOSE))
+	}
+
+	// Вариант можно выбрать и переведенной кнопкой
+	input := msg.Text
+	if field.Type == forms.FIELD_CHOICE {
+		if choice := pick(formKeyboard(msg, chatState, form, field, chatState.Form.Step), msg.Text); choice != "" {
+			input = choice
+		}
+	}
+
+	value, err := field.Validate(input)
+	if err != nil {
+		return askField(msg, chatState, form, inputError(msg, chatState, form, field, err))
+	}
+
+	chatState.Form.Answers[field.Name] = value
+	chatState.Form.Step++
+
+	return askField(msg, chatState, form, "")
+}
+
+func submitForm(db redis.UniversalClient, msg *message, chatState *database.Chat, form *forms.Form) (database.ChatState, error) {
+	result := &forms.Result{
+		Form:    form.Id,
+		Title:   form.Title,
+		LineId:  msg.LineId,
+		UserId:  msg.UserId,
+		Answers: form.Summary(chatState.Form.Answers),
+		Time:    time.Now(),
+	}
+
+	answers := chatState.Form.Answers
+	chatState.Form = nil
+
+	if form.Action.Type == forms.ACTION_DOCUMENT {
+		return issueDocument(db, msg, chatState, form, answers)
+	}
+
+	if _, ok := done(msg, STEP_FORM_ACTION); !ok {
+		if err := forms.Dispatch(form.Action, result); err != nil {
+			logger.Warning("Error while dispatch form", form.Id, "for user", msg.UserId, err)
+
+			_, err = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_FORM_FAILED), mainKeyboard(msg, chatState))
+
+			return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
+		}
+		markDone(msg, STEP_FORM_ACTION, "")
+	}
+
+	// Ответы формы доступны в фразах как {{.Context.<поле>}}
+	for name, value := range answers {
+		setContext(chatState, name, value)
+	}
+
+	if _, ok := done(msg, STEP_FORM_SENT); !ok {
+		if _, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_FORM_SENT), nil); err == nil {
+			markDone(msg, STEP_FORM_SENT, "")
+		}
+	}
+	_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_AGAIN), partingKeyboard(msg, chatState))
+
+	return checkErrorForSend(msg, err, database.STATE_PARTING)
+}
@@ -0,0 +1,157 @@
+package forms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"connect-companion/bot/docgen"
+)
+
+const (
+	ACTION_WEBHOOK = "webhook"
+	ACTION_FILE    = "file"
+	ACTION_EMAIL   = "email"
+	// Документ по шаблону, бот присылает его пользователю
+	ACTION_DOCUMENT = "document"
+
+	WEBHOOK_TIMEOUT = 10 * time.Second
+)
+
+type (
+	// Action receives the confirmed form. The email action is a stand-in:
+	// it writes .eml files into the directory instead of talking to SMTP.
+	Action struct {
+		Type string `yaml:"type"`
+		Url  string `yaml:"url"`
+		Path string `yaml:"path"`
+		To   string `yaml:"to"`
+		Dir  string `yaml:"dir"`
+
+		// DOCX, ODT or HTML template with {{.Values.<field>}}, the file name for the user
+		// (a Go template too) and whether the document is converted to PDF
+		Template string `yaml:"template"`
+		Name     string `yaml:"name"`
+		Pdf      bool   `yaml:"pdf"`
+	}
+)
+
+var (
+	client = &http.Client{Timeout: WEBHOOK_TIMEOUT}
+
+	fileMu sync.Mutex
+)
+
+func (a *Action) validate() error {
+	switch a.Type {
+	case ACTION_WEBHOOK:
+		u, err := url.Parse(a.Url)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid url %q", a.Url)
+		}
+	case ACTION_FILE:
+		if a.Path == "" {
+			return errors.New("path is required")
+		}
+	case ACTION_EMAIL:
+		if a.To == "" || a.Dir == "" {
+			return errors.New("to and dir are required")
+		}
+	case ACTION_DOCUMENT:
+		if a.Template == "" {
+			return errors.New("template is required")
+		}
+
+		return docgen.Check(a.Template, a.Name)
+	default:
+		return fmt.Errorf("unknown type %q", a.Type)
+	}
+
+	return nil
+}
+
+func Dispatch(a Action, r *Result) error {
+	switch a.Type {
+	case ACTION_WEBHOOK:
+		return postWebhook(a.Url, r)
+	case ACTION_FILE:
+		return appendFile(a.Path, r)
+	case ACTION_EMAIL:
+		return writeEmail(a.To, a.Dir, r)
+	default:
+		return fmt.Errorf("unknown action %q", a.Type)
+	}
+}
+
+func postWebhook(reqUrl string, r *Result) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(reqUrl, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("webhook %s responded with code %d: %s", reqUrl, resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// appendFile writes the result as a JSON line
+func appendFile(path string, r *Result) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+
+	return err
+}
+
+func writeEmail(to string, dir string, r *Result) error {
+	body := new(bytes.Buffer)
+
+	_, _ = fmt.Fprintf(body, "To: %s\r\n", to)
+	// Заголовок письма только в ASCII, название формы кодируется
+	_, _ = fmt.Fprintf(body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", r.Title))
+	_, _ = fmt.Fprintf(body, "Date: %s\r\n", r.Time.Format(time.RFC1123Z))
+	_, _ = fmt.Fprintf(body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	_, _ = fmt.Fprintf(body, "Линия: %s\r\nПользователь: %s\r\n\r\n", r.LineId, r.UserId)
+	for _, a := range r.Answers {
+		_, _ = fmt.Fprintf(body, "%s: %s\r\n", a.Title, strings.TrimSpace(a.Value))
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.eml", r.Time.Format("20060102-150405"), r.Form, r.UserId)
+
+	return ioutil.WriteFile(filepath.Join(dir, name), body.Bytes(), 0640)
+}
@@ -0,0 +1,62 @@
+package forms
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestWriteEmail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &Result{
+		Form:    "vacation",
+		Title:   "Заявление на отпуск",
+		LineId:  uuid.New(),
+		UserId:  uuid.New(),
+		Answers: []Answer{{Name: "days", Title: "Дней", Value: "14"}},
+		Time:    time.Date(2020, 3, 2, 12, 0, 0, 0, time.UTC),
+	}
+
+	if err = writeEmail("hr@example.com", dir, r); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("files %v, %v", files, err)
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	m, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := m.Header.Get("Subject")
+	for i := 0; i < len(raw); i++ {
+		if raw[i] >= 0x80 {
+			t.Fatalf("subject %q is not ASCII", raw)
+		}
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(raw)
+	if err != nil || subject != r.Title {
+		t.Errorf("subject %q, %v, want %q", subject, err, r.Title)
+	}
+}
@@ -0,0 +1,252 @@
+package forms
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FIELD_TEXT   = "text"
+	FIELD_DATE   = "date"
+	FIELD_NUMBER = "number"
+	FIELD_REGEX  = "regex"
+	FIELD_PHONE  = "phone"
+	FIELD_EMAIL  = "email"
+	FIELD_CHOICE = "choice"
+	FIELD_INN    = "inn"
+	FIELD_SNILS  = "snils"
+
+	DATE_FORMAT = "02.01.2006"
+
+	// Фразы бота об ошибках ввода
+	ERROR_EMPTY  = "form_error_empty"
+	ERROR_DATE   = "form_error_date"
+	ERROR_NUMBER = "form_error_number"
+	ERROR_MIN    = "form_error_min"
+	ERROR_MAX    = "form_error_max"
+	ERROR_FORMAT = "form_error_format"
+	ERROR_PHONE  = "form_error_phone"
+	ERROR_EMAIL  = "form_error_email"
+	ERROR_CHOICE = "form_error_choice"
+	ERROR_INN    = "form_error_inn"
+	ERROR_SNILS  = "form_error_snils"
+)
+
+type (
+	Field struct {
+		Name   string `yaml:"name"`
+		Title  string `yaml:"title"`
+		Prompt string `yaml:"prompt"`
+		Type   string `yaml:"type"`
+
+		Min     *float64 `yaml:"min"`
+		Max     *float64 `yaml:"max"`
+		Pattern string   `yaml:"pattern"`
+		Choices []string `yaml:"choices"`
+
+		// Сообщение пользователю при неверном вводе вместо фраз form_error_*
+		Error string `yaml:"error"`
+
+		re *regexp.Regexp
+	}
+
+	// InputError is the invalid input of the user, Key is the phrase of the bot explaining it
+	InputError struct {
+		Key string
+		// Min or max for errors of the range
+		Limit string
+	}
+)
+
+var (
+	dateLayouts = []string{DATE_FORMAT, "2.1.2006", "02.01.06", "2006-01-02", "02/01/2006"}
+
+	digitsOnly = regexp.MustCompile(`\D`)
+)
+
+func (f *Field) Label() string {
+	if f.Title != "" {
+		return f.Title
+	}
+
+	return f.Name
+}
+
+func (f *Field) compile() error {
+	switch f.Type {
+	case "":
+		f.Type = FIELD_TEXT
+	case FIELD_TEXT, FIELD_DATE, FIELD_NUMBER, FIELD_PHONE, FIELD_EMAIL, FIELD_INN, FIELD_SNILS:
+	case FIELD_REGEX:
+		if f.Pattern == "" {
+			return errors.New("pattern is required")
+		}
+
+		re, err := regexp.Compile(f.Pattern)
+		if err != nil {
+			return err
+		}
+		f.re = re
+	case FIELD_CHOICE:
+		if len(f.Choices) == 0 {
+			return errors.New("choices are required")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", f.Type)
+	}
+
+	if f.Min != nil && f.Max != nil && *f.Min > *f.Max {
+		return errors.New("min is greater than max")
+	}
+
+	return nil
+}
+
+func (e *InputError) Error() string {
+	if e.Limit != "" {
+		return e.Key + " " + e.Limit
+	}
+
+	return e.Key
+}
+
+func inputError(key string) error {
+	return &InputError{Key: key}
+}
+
+// Validate checks the user input and returns the normalized value or *InputError
+func (f *Field) Validate(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", inputError(ERROR_EMPTY)
+	}
+
+	switch f.Type {
+	case FIELD_DATE:
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, input); err == nil {
+				return t.Format(DATE_FORMAT), nil
+			}
+		}
+
+		return "", inputError(ERROR_DATE)
+	case FIELD_NUMBER:
+		n, err := strconv.ParseFloat(strings.Replace(input, ",", ".", 1), 64)
+		if err != nil {
+			return "", inputError(ERROR_NUMBER)
+		}
+
+		if f.Min != nil && n < *f.Min {
+			return "", &InputError{Key: ERROR_MIN, Limit: formatNumber(*f.Min)}
+		}
+
+		if f.Max != nil && n > *f.Max {
+			return "", &InputError{Key: ERROR_MAX, Limit: formatNumber(*f.Max)}
+		}
+
+		return formatNumber(n), nil
+	case FIELD_REGEX:
+		if !f.re.MatchString(input) {
+			return "", inputError(ERROR_FORMAT)
+		}
+
+		return input, nil
+	case FIELD_PHONE:
+		digits := digitsOnly.ReplaceAllString(input, "")
+		if len(digits) == 11 && digits[0] == '8' {
+			digits = "7" + digits[1:]
+		} else if len(digits) == 10 {
+			digits = "7" + digits
+		}
+
+		if len(digits) < 11 || len(digits) > 15 {
+			return "", inputError(ERROR_PHONE)
+		}
+
+		return "+" + digits, nil
+	case FIELD_EMAIL:
+		addr, err := mail.ParseAddress(input)
+		if err != nil || !strings.Contains(addr.Address, ".") {
+			return "", inputError(ERROR_EMAIL)
+		}
+
+		return addr.Address, nil
+	case FIELD_CHOICE:
+		for _, c := range f.Choices {
+			if strings.EqualFold(c, input) {
+				return c, nil
+			}
+		}
+
+		return "", inputError(ERROR_CHOICE)
+	case FIELD_INN:
+		if !validINN(input) {
+			return "", inputError(ERROR_INN)
+		}
+
+		return input, nil
+	case FIELD_SNILS:
+		digits := digitsOnly.ReplaceAllString(input, "")
+		if !validSNILS(digits) {
+			return "", inputError(ERROR_SNILS)
+		}
+
+		return fmt.Sprintf("%s-%s-%s %s", digits[0:3], digits[3:6], digits[6:9], digits[9:11]), nil
+	default:
+		return input, nil
+	}
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func checksum(digits string, weights []int) int {
+	sum := 0
+	for i, w := range weights {
+		sum += int(digits[i]-'0') * w
+	}
+
+	return sum % 11 % 10
+}
+
+func validINN(s string) bool {
+	if digitsOnly.MatchString(s) {
+		return false
+	}
+
+	switch len(s) {
+	case 10:
+		return checksum(s, []int{2, 4, 10, 3, 5, 9, 4, 6, 8}) == int(s[9]-'0')
+	case 12:
+		return checksum(s, []int{7, 2, 4, 10, 3, 5, 9, 4, 6, 8}) == int(s[10]-'0') &&
+			checksum(s, []int{3, 7, 2, 4, 10, 3, 5, 9, 4, 6, 8}) == int(s[11]-'0')
+	default:
+		return false
+	}
+}
+
+func validSNILS(s string) bool {
+	if len(s) != 11 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(s[i]-'0') * (9 - i)
+	}
+
+	control := sum % 101
+	if control == 100 {
+		control = 0
+	}
+
+	n, _ := strconv.Atoi(s[9:])
+
+	return control == n
+}
@@ -0,0 +1,145 @@
+package forms
+
+import (
+	"testing"
+)
+
+func number(n float64) *float64 {
+	return &n
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		field Field
+		input string
+		want  string
+		err   string
+	}{
+		{"text", Field{}, "  привет ", "привет", ""},
+		{"empty", Field{}, "   ", "", ERROR_EMPTY},
+
+		{"date", Field{Type: FIELD_DATE}, "01.03.2020", "01.03.2020", ""},
+		{"short date", Field{Type: FIELD_DATE}, "1.3.2020", "01.03.2020", ""},
+		{"two digit year", Field{Type: FIELD_DATE}, "01.03.20", "01.03.2020", ""},
+		{"iso date", Field{Type: FIELD_DATE}, "2020-03-01", "01.03.2020", ""},
+		{"date with slashes", Field{Type: FIELD_DATE}, "01/03/2020", "01.03.2020", ""},
+		{"no such day", Field{Type: FIELD_DATE}, "30.02.2020", "", ERROR_DATE},
+		{"not a date", Field{Type: FIELD_DATE}, "завтра", "", ERROR_DATE},
+
+		{"number", Field{Type: FIELD_NUMBER}, "14", "14", ""},
+		{"decimal comma", Field{Type: FIELD_NUMBER}, "1,5", "1.5", ""},
+		{"not a number", Field{Type: FIELD_NUMBER}, "десять", "", ERROR_NUMBER},
+		{"min", Field{Type: FIELD_NUMBER, Min: number(1)}, "1", "1", ""},
+		{"below min", Field{Type: FIELD_NUMBER, Min: number(1)}, "0", "", ERROR_MIN},
+		{"max", Field{Type: FIELD_NUMBER, Max: number(28)}, "28", "28", ""},
+		{"above max", Field{Type: FIELD_NUMBER, Max: number(28)}, "28.5", "", ERROR_MAX},
+
+		{"phone", Field{Type: FIELD_PHONE}, "+7 (900) 123-45-67", "+79001234567", ""},
+		{"phone from 8", Field{Type: FIELD_PHONE}, "8 900 123 45 67", "+79001234567", ""},
+		{"phone without code", Field{Type: FIELD_PHONE}, "9001234567", "+79001234567", ""},
+		{"foreign phone", Field{Type: FIELD_PHONE}, "+49 30 1234 5678", "+493012345678", ""},
+		{"short phone", Field{Type: FIELD_PHONE}, "123-45-67", "", ERROR_PHONE},
+		{"long phone", Field{Type: FIELD_PHONE}, "+1234567890123456", "", ERROR_PHONE},
+
+		{"email", Field{Type: FIELD_EMAIL}, "Name@Example.org", "Name@Example.org", ""},
+		{"email with name", Field{Type: FIELD_EMAIL}, "Иван <ivan@example.org>", "ivan@example.org", ""},
+		{"email without domain", Field{Type: FIELD_EMAIL}, "ivan@localhost", "", ERROR_EMAIL},
+		{"not an email", Field{Type: FIELD_EMAIL}, "ivan", "", ERROR_EMAIL},
+
+		{"choice", Field{Type: FIELD_CHOICE, Choices: []string{"Ежегодный", "За свой счет"}}, "за свой счет", "За свой счет", ""},
+		{"other choice", Field{Type: FIELD_CHOICE, Choices: []string{"Ежегодный"}}, "Учебный", "", ERROR_CHOICE},
+
+		{"regex", Field{Type: FIELD_REGEX, Pattern: `^\d{4}$`}, "1234", "1234", ""},
+		{"regex mismatch", Field{Type: FIELD_REGEX, Pattern: `^\d{4}$`}, "12345", "", ERROR_FORMAT},
+
+		{"inn of organization", Field{Type: FIELD_INN}, "7707083893", "7707083893", ""},
+		{"inn of person", Field{Type: FIELD_INN}, "500100732259", "500100732259", ""},
+		{"inn checksum", Field{Type: FIELD_INN}, "7707083894", "", ERROR_INN},
+		{"inn of person checksum", Field{Type: FIELD_INN}, "500100732258", "", ERROR_INN},
+		{"inn length", Field{Type: FIELD_INN}, "77070838", "", ERROR_INN},
+		{"inn with letters", Field{Type: FIELD_INN}, "770708389a", "", ERROR_INN},
+
+		{"snils", Field{Type: FIELD_SNILS}, "112-233-445 95", "112-233-445 95", ""},
+		{"snils digits", Field{Type: FIELD_SNILS}, "11223344595", "112-233-445 95", ""},
+		{"snils checksum", Field{Type: FIELD_SNILS}, "112-233-445 96", "", ERROR_SNILS},
+		{"snils length", Field{Type: FIELD_SNILS}, "112-233-445", "", ERROR_SNILS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.field.compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := tt.field.Validate(tt.input)
+
+			if tt.err == "" {
+				if err != nil || got != tt.want {
+					t.Errorf("Validate(%q) = %q, %v, want %q", tt.input, got, err, tt.want)
+				}
+				return
+			}
+
+			e, ok := err.(*InputError)
+			if !ok || e.Key != tt.err {
+				t.Errorf("Validate(%q) = %q, %v, want %s", tt.input, got, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRangeLimit(t *testing.T) {
+	f := Field{Type: FIELD_NUMBER, Min: number(0.5), Max: number(28)}
+
+	if _, err := f.Validate("0"); err == nil || err.(*InputError).Limit != "0.5" {
+		t.Errorf("min error %v, want limit 0.5", err)
+	}
+
+	if _, err := f.Validate("100"); err == nil || err.(*InputError).Limit != "28" {
+		t.Errorf("max error %v, want limit 28", err)
+	}
+}
+
+func TestSnilsControl(t *testing.T) {
+	tests := []struct {
+		snils string
+		want  bool
+	}{
+		{"11223344595", true},
+		// Сумма 9*1+...+1*9 = 165, 165 % 101 = 64
+		{"12345678964", true},
+		{"12345678900", false},
+		// Суммы 100 и 101 дают контрольное число 00
+		{"92000000300", true},
+		{"92000000400", true},
+		{"92000000301", false},
+	}
+
+	for _, tt := range tests {
+		if got := validSNILS(tt.snils); got != tt.want {
+			t.Errorf("validSNILS(%s) = %v, want %v", tt.snils, got, tt.want)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name  string
+		field Field
+	}{
+		{"unknown type", Field{Type: "address"}},
+		{"regex without pattern", Field{Type: FIELD_REGEX}},
+		{"invalid pattern", Field{Type: FIELD_REGEX, Pattern: "("}},
+		{"choice without choices", Field{Type: FIELD_CHOICE}},
+		{"min above max", Field{Type: FIELD_NUMBER, Min: number(10), Max: number(1)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.field.compile(); err == nil {
+				t.Error("error expected")
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package forms
+
+import (
+	"fmt"
+	"time"
+
+	"connect-companion/bot/access"
+
+	"github.com/google/uuid"
+)
+
+type (
+	Form struct {
+		Id     string  `yaml:"id"`
+		Title  string  `yaml:"title"`
+		Fields []Field `yaml:"fields"`
+		Action Action  `yaml:"action"`
+
+		// Who sees the form in the menu, everyone by default
+		Access access.Rule `yaml:"access"`
+	}
+
+	// Result is the confirmed form passed to the action
+	Result struct {
+		Form    string    `json:"form"`
+		Title   string    `json:"title"`
+		LineId  uuid.UUID `json:"line_id"`
+		UserId  uuid.UUID `json:"user_id"`
+		Answers []Answer  `json:"answers"`
+		Time    time.Time `json:"time"`
+	}
+
+	Answer struct {
+		Name  string `json:"name"`
+		Title string `json:"title"`
+		Value string `json:"value"`
+	}
+)
+
+// Validate checks form definitions at startup
+func Validate(forms []Form) error {
+	ids := make(map[string]bool)
+
+	for i := range forms {
+		f := &forms[i]
+
+		if f.Id == "" {
+			return fmt.Errorf("form #%d: id is required", i+1)
+		}
+
+		if ids[f.Id] {
+			return fmt.Errorf("form %s: duplicate id", f.Id)
+		}
+		ids[f.Id] = true
+
+		if f.Title == "" {
+			return fmt.Errorf("form %s: title is required", f.Id)
+		}
+
+		if len(f.Fields) == 0 {
+			return fmt.Errorf("form %s: no fields", f.Id)
+		}
+
+		names := make(map[string]bool)
+		for j := range f.Fields {
+			field := &f.Fields[j]
+
+			if field.Name == "" {
+				return fmt.Errorf("form %s: field #%d: name is required", f.Id, j+1)
+			}
+
+			if names[field.Name] {
+				return fmt.Errorf("form %s: duplicate field %s", f.Id, field.Name)
+			}
+			names[field.Name] = true
+
+			if err := field.compile(); err != nil {
+				return fmt.Errorf("form %s: field %s: %v", f.Id, field.Name, err)
+			}
+		}
+
+		if err := f.Action.validate(); err != nil {
+			return fmt.Errorf("form %s: action: %v", f.Id, err)
+		}
+	}
+
+	return nil
+}
+
+func ById(forms []Form, id string) *Form {
+	for i := range forms {
+		if forms[i].Id == id {
+			return &forms[i]
+		}
+	}
+
+	return nil
+}
+
+// Summary returns answers in the order of fields
+func (f *Form) Summary(answers map[string]string) []Answer {
+	result := make([]Answer, 0, len(f.Fields))
+
+	for i := range f.Fields {
+		result = append(result, Answer{
+			Name:  f.Fields[i].Name,
+			Title: f.Fields[i].Label(),
+			Value: answers[f.Fields[i].Name],
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,121 @@
+package fulltext
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	DOCX_BODY = "word/document.xml"
+)
+
+// extractDocx splits the document by page breaks: explicit ones and the ones Word saved on the last render
+func extractDocx(file string) ([]string, error) {
+	z, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	defer z.Close()
+
+	for _, f := range z.File {
+		if f.Name != DOCX_BODY {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		defer r.Close()
+
+		pages, err := docxPages(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+
+		return pages, nil
+	}
+
+	return nil, fmt.Errorf("%s: %s not found", file, DOCX_BODY)
+}
+
+func docxPages(r io.Reader) ([]string, error) {
+	var (
+		pages  []string
+		page   strings.Builder
+		inText bool
+
+		// Явный разрыв, после которого еще не было текста
+		afterBreak bool
+	)
+
+	breakPage := func() {
+		pages = append(pages, page.String())
+		page.Reset()
+	}
+
+	d := xml.NewDecoder(r)
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch el := token.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				page.WriteByte('\t')
+				afterBreak = false
+			case "lastRenderedPageBreak":
+				// Word пишет и явный разрыв, и отметку о нем в следующем абзаце,
+				// пустые страницы между явными разрывами считаются
+				if !afterBreak {
+					breakPage()
+				}
+				afterBreak = false
+			case "br", "cr":
+				kind := ""
+				for _, a := range el.Attr {
+					if a.Name.Local == "type" {
+						kind = a.Value
+					}
+				}
+
+				if kind == "page" {
+					breakPage()
+					afterBreak = true
+				} else {
+					page.WriteByte('\n')
+					afterBreak = false
+				}
+			}
+		case xml.EndElement:
+			switch el.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				page.WriteByte('\n')
+			case "tc":
+				page.WriteByte('\t')
+			}
+		case xml.CharData:
+			if inText {
+				page.Write(el)
+				afterBreak = false
+			}
+		}
+	}
+
+	pages = append(pages, page.String())
+
+	return pages, nil
+}
@@ -0,0 +1,76 @@
+package fulltext
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testDocxHead = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>`
+	testDocxTail = `</w:body></w:document>`
+)
+
+func testParagraph(text string) string {
+	return `<w:p><w:r><w:t>` + text + `</w:t></w:r></w:p>`
+}
+
+func TestDocxPages(t *testing.T) {
+	pageBreak := `<w:p><w:r><w:br w:type="page"/></w:r></w:p>`
+	rendered := `<w:p><w:r><w:lastRenderedPageBreak/><w:t>`
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			"single page",
+			testParagraph("Первая") + `<w:p><w:r><w:t>Вто</w:t></w:r><w:r><w:tab/><w:t>рая</w:t></w:r></w:p>`,
+			[]string{"Первая\nВто\tрая\n"},
+		},
+		{
+			"explicit break with its rendered mark",
+			testParagraph("Первая") + pageBreak + rendered + `Вторая</w:t></w:r></w:p>`,
+			[]string{"Первая\n", "\nВторая\n"},
+		},
+		{
+			"rendered break",
+			testParagraph("Первая") + rendered + `Вторая</w:t></w:r></w:p>`,
+			[]string{"Первая\n", "Вторая\n"},
+		},
+		{
+			"empty pages are counted",
+			testParagraph("Первая") + pageBreak + pageBreak + rendered + `Третья</w:t></w:r></w:p>`,
+			[]string{"Первая\n", "\n", "\nТретья\n"},
+		},
+		{
+			"line break",
+			`<w:p><w:r><w:t>Первая</w:t><w:br/><w:t>строка</w:t></w:r></w:p>`,
+			[]string{"Первая\nстрока\n"},
+		},
+		{
+			"empty document",
+			"",
+			[]string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := docxPages(strings.NewReader(testDocxHead + tt.body + testDocxTail))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(pages, tt.want) {
+				t.Errorf("pages %q, want %q", pages, tt.want)
+			}
+		})
+	}
+
+	if _, err := docxPages(strings.NewReader(testDocxHead + "<w:p>")); err == nil {
+		t.Error("broken xml is read")
+	}
+}
@@ -0,0 +1,58 @@
+package fulltext
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	EXT_PDF  = ".pdf"
+	EXT_DOCX = ".docx"
+)
+
+// Supported tells whether text of the file can be extracted
+func Supported(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case EXT_PDF, EXT_DOCX:
+		return true
+	}
+
+	return false
+}
+
+// Extract returns text of every page of the PDF or DOCX file
+func Extract(file string) ([]string, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case EXT_PDF:
+		return extractPdf(file)
+	case EXT_DOCX:
+		return extractDocx(file)
+	}
+
+	return nil, fmt.Errorf("%s: unsupported format", file)
+}
+
+// Load extracts pages of the files of the directory, the files which can not be read are skipped with errors
+func Load(dir string, files []string) ([]Page, []error) {
+	var (
+		pages []Page
+		errs  []error
+	)
+
+	for _, file := range files {
+		texts, err := Extract(filepath.Join(dir, file))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for i, text := range texts {
+			if strings.TrimSpace(text) != "" {
+				pages = append(pages, Page{File: file, Number: i + 1, Text: text})
+			}
+		}
+	}
+
+	return pages, errs
+}
@@ -0,0 +1,189 @@
+package fulltext
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"connect-companion/bot/kb"
+)
+
+const (
+	// Длина фрагмента в ответе, в символах
+	SNIPPET_LENGTH = 300
+)
+
+var (
+	spaces = regexp.MustCompile(`\s+`)
+)
+
+type (
+	// Page of the document, numbered from 1
+	Page struct {
+		File   string
+		Number int
+		Text   string
+	}
+
+	// Hit is the best page of the document with the fragment answering the query
+	Hit struct {
+		File    string
+		Page    int
+		Score   float64
+		Snippet string
+	}
+
+	// Index finds pages of documents by TF-IDF of word stems
+	Index struct {
+		pages  []Page
+		scorer *kb.Scorer
+	}
+)
+
+func NewIndex(pages []Page) *Index {
+	tfs := make([]map[string]float64, len(pages))
+	for i, p := range pages {
+		tf := map[string]float64{}
+		for _, t := range kb.Tokens(p.Text) {
+			tf[t]++
+		}
+		tfs[i] = tf
+	}
+
+	return &Index{pages: pages, scorer: kb.NewScorer(tfs)}
+}
+
+func (idx *Index) Len() int {
+	if idx == nil {
+		return 0
+	}
+
+	return len(idx.pages)
+}
+
+// Search returns the best page of up to limit documents scored at least minScore, the best first
+func (idx *Index) Search(query string, limit int, minScore float64) []Hit {
+	if idx.Len() == 0 {
+		return nil
+	}
+
+	terms := kb.Terms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	best := map[string]int{}
+	var hits []Hit
+
+	for i := range idx.pages {
+		score := idx.scorer.Score(i, terms)
+		if score <= 0 || score < minScore {
+			continue
+		}
+
+		p := &idx.pages[i]
+
+		// От документа берем только лучшую страницу
+		if j, ok := best[p.File]; ok {
+			if hits[j].Score >= score {
+				continue
+			}
+			hits[j] = Hit{File: p.File, Page: p.Number, Score: score}
+			continue
+		}
+
+		best[p.File] = len(hits)
+		hits = append(hits, Hit{File: p.File, Page: p.Number, Score: score})
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	for i := range hits {
+		hits[i].Snippet = idx.snippetOf(hits[i].File, hits[i].Page, terms)
+	}
+
+	return hits
+}
+
+func (idx *Index) snippetOf(file string, number int, terms map[string]bool) string {
+	for _, p := range idx.pages {
+		if p.File == file && p.Number == number {
+			return snippet(p.Text, terms)
+		}
+	}
+
+	return ""
+}
+
+// snippet returns sentences of the text having the most terms of the query
+func snippet(text string, terms map[string]bool) string {
+	sentences := splitSentences(strings.TrimSpace(spaces.ReplaceAllString(text, " ")))
+	if len(sentences) == 0 {
+		return ""
+	}
+
+	start, top := 0, -1
+	for i, s := range sentences {
+		found := map[string]bool{}
+		for _, t := range kb.Tokens(s) {
+			if terms[t] {
+				found[t] = true
+			}
+		}
+
+		if len(found) > top {
+			start, top = i, len(found)
+		}
+	}
+
+	fragment := sentences[start]
+	for i := start + 1; i < len(sentences); i++ {
+		if len([]rune(fragment))+1+len([]rune(sentences[i])) > SNIPPET_LENGTH {
+			break
+		}
+		fragment += " " + sentences[i]
+	}
+
+	if runes := []rune(fragment); len(runes) > SNIPPET_LENGTH {
+		cut := string(runes[:SNIPPET_LENGTH])
+		if i := strings.LastIndex(cut, " "); i > 0 {
+			cut = cut[:i]
+		}
+		fragment = cut + "…"
+	}
+
+	return fragment
+}
+
+// splitSentences cuts the text after the end mark followed by a space and a capital letter or a digit
+func splitSentences(text string) []string {
+	var sentences []string
+
+	runes := []rune(text)
+	from := 0
+	for i := 0; i+2 < len(runes); i++ {
+		if !strings.ContainsRune(".!?;", runes[i]) || runes[i+1] != ' ' {
+			continue
+		}
+
+		if next := runes[i+2]; !unicode.IsUpper(next) && !unicode.IsDigit(next) {
+			continue
+		}
+
+		sentences = append(sentences, string(runes[from:i+1]))
+		from = i + 2
+	}
+
+	if from < len(runes) {
+		sentences = append(sentences, string(runes[from:]))
+	}
+
+	return sentences
+}
@@ -0,0 +1,112 @@
+package fulltext
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"connect-companion/bot/kb"
+)
+
+func testTerms(query string) map[string]bool {
+	terms := map[string]bool{}
+	for _, t := range kb.Tokens(query) {
+		terms[t] = true
+	}
+
+	return terms
+}
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Первое. Второе! Третье? 4 пункт; Пятое", []string{"Первое.", "Второе!", "Третье?", "4 пункт;", "Пятое"}},
+		// Сокращения и числа не разрывают предложение
+		{"См. т. е. приложение 1.5 к договору.", []string{"См. т. е. приложение 1.5 к договору."}},
+		{"Конец.", []string{"Конец."}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		if got := splitSentences(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitSentences(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := "Общие положения.\n\nСотрудник получает   зарплату дважды в месяц. Отпуск предоставляется ежегодно. Прочее."
+
+	if got := snippet(text, testTerms("отпуск")); got != "Отпуск предоставляется ежегодно. Прочее." {
+		t.Errorf("snippet %q", got)
+	}
+
+	// Предложение с большим числом слов запроса лучше
+	if got := snippet(text, testTerms("зарплата в месяц")); !strings.HasPrefix(got, "Сотрудник получает зарплату дважды в месяц.") {
+		t.Errorf("snippet %q", got)
+	}
+
+	// Без совпадений берется начало страницы
+	if got := snippet(text, testTerms("ипотека")); !strings.HasPrefix(got, "Общие положения.") {
+		t.Errorf("snippet %q", got)
+	}
+
+	long := strings.Repeat("очень длинное предложение про отпуск ", 20)
+	got := snippet(long, testTerms("отпуск"))
+	if !strings.HasSuffix(got, "…") || utf8.RuneCountInString(got) > SNIPPET_LENGTH+1 {
+		t.Errorf("long snippet %q", got)
+	}
+	if strings.HasSuffix(strings.TrimSuffix(got, "…"), " ") {
+		t.Errorf("long snippet is not cut by words: %q", got)
+	}
+
+	if got := snippet("  ", testTerms("отпуск")); got != "" {
+		t.Errorf("snippet of the empty page %q", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	idx := NewIndex([]Page{
+		{File: "rules.pdf", Number: 1, Text: "Правила внутреннего распорядка. Рабочий день с девяти часов."},
+		{File: "rules.pdf", Number: 3, Text: "Отпуск предоставляется ежегодно. Отпуск делится на части."},
+		{File: "rules.pdf", Number: 4, Text: "Отпуск за свой счет оформляется заявлением."},
+		{File: "memo.docx", Number: 2, Text: "Памятка: о переносе отпуска сообщите руководителю. Справки выдает бухгалтерия."},
+		{File: "salary.pdf", Number: 1, Text: "Зарплата выплачивается дважды в месяц."},
+	})
+
+	hits := idx.Search("отпуск", 10, 0)
+	if len(hits) != 2 {
+		t.Fatalf("%d hits, want one of every document with the word: %+v", len(hits), hits)
+	}
+
+	// От документа только лучшая страница
+	if hits[0].File != "rules.pdf" || hits[0].Page != 3 {
+		t.Errorf("best hit %s:%d, want rules.pdf:3", hits[0].File, hits[0].Page)
+	}
+	if hits[0].Snippet != "Отпуск предоставляется ежегодно. Отпуск делится на части." {
+		t.Errorf("snippet %q", hits[0].Snippet)
+	}
+	if hits[1].File != "memo.docx" || hits[1].Page != 2 || hits[1].Score > hits[0].Score {
+		t.Errorf("second hit %+v", hits[1])
+	}
+
+	if limited := idx.Search("отпуск", 1, 0); len(limited) != 1 || limited[0].File != "rules.pdf" {
+		t.Errorf("limited hits %+v", limited)
+	}
+
+	if filtered := idx.Search("отпуск", 10, hits[1].Score+0.01); len(filtered) != 1 {
+		t.Errorf("min score keeps %d hits", len(filtered))
+	}
+
+	if hits := idx.Search("ипотека", 10, 0); hits != nil {
+		t.Errorf("hits of the unknown word %+v", hits)
+	}
+
+	var empty *Index
+	if empty.Search("отпуск", 10, 0) != nil || empty.Len() != 0 {
+		t.Error("empty index finds something")
+	}
+}
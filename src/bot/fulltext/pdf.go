@@ -0,0 +1,104 @@
+package fulltext
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	// Разрыв между символами больше этой доли кегля считаем пробелом
+	PDF_SPACE_GAP = 0.2
+	// Ширина символа, если шрифт ее не сообщает, в долях кегля
+	PDF_CHAR_WIDTH = 0.5
+)
+
+func extractPdf(file string) (pages []string, err error) {
+	// Разбор битого PDF может паниковать
+	defer func() {
+		if r := recover(); r != nil {
+			pages = nil
+			err = fmt.Errorf("%s: %v", file, r)
+		}
+	}()
+
+	f, r, err := pdf.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	defer f.Close()
+
+	pages = make([]string, r.NumPage())
+	for i := range pages {
+		p := r.Page(i + 1)
+		if p.V.IsNull() {
+			continue
+		}
+
+		pages[i] = pageText(p.Content().Text)
+	}
+
+	return pages, nil
+}
+
+// pageText joins glyphs of the page into lines, PDF has no spaces between words as a rule
+func pageText(glyphs []pdf.Text) string {
+	type line struct {
+		y      float64
+		glyphs []pdf.Text
+	}
+
+	var lines []*line
+	for _, g := range glyphs {
+		if g.S == "" {
+			continue
+		}
+
+		var l *line
+		for _, candidate := range lines {
+			if math.Abs(candidate.y-g.Y) < math.Max(g.FontSize, 1)/2 {
+				l = candidate
+				break
+			}
+		}
+
+		if l == nil {
+			l = &line{y: g.Y}
+			lines = append(lines, l)
+		}
+		l.glyphs = append(l.glyphs, g)
+	}
+
+	// Сверху вниз, слева направо
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].y > lines[j].y
+	})
+
+	var text strings.Builder
+	for _, l := range lines {
+		sort.SliceStable(l.glyphs, func(i, j int) bool {
+			return l.glyphs[i].X < l.glyphs[j].X
+		})
+
+		var end float64
+		for i, g := range l.glyphs {
+			if i > 0 && g.X-end > PDF_SPACE_GAP*g.FontSize && !strings.HasSuffix(text.String(), " ") {
+				text.WriteByte(' ')
+			}
+			text.WriteString(g.S)
+
+			w := g.W
+			if w <= 0 {
+				w = PDF_CHAR_WIDTH * g.FontSize
+			}
+			end = g.X + w
+		}
+
+		text.WriteByte('\n')
+	}
+
+	return text.String()
+}
@@ -0,0 +1,114 @@
+package fulltext
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testPdf builds the PDF with the page for every content stream, glyphs of the font are 600/1000 wide
+func testPdf(contents []string) []byte {
+	var (
+		buf     bytes.Buffer
+		offsets []int
+	)
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	kids := make([]string, len(contents))
+	for i := range contents {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(contents)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /FirstChar 32 /LastChar 126 /Widths [" +
+		strings.Repeat("600 ", 95) + "] >>")
+
+	for i, content := range contents {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] "+
+			"/Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.Bytes()
+}
+
+func TestExtractPdf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fulltext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	data := testPdf([]string{
+		// Слова без пробела между ними, только с разрывом, и вторая строка ниже
+		"BT /F1 12 Tf 72 700 Td (Annual) Tj 50 0 Td (leave) Tj ET " +
+			"BT /F1 12 Tf 72 680 Td (28 days) Tj ET",
+		// Пустая страница
+		"",
+		// Порядок вывода не совпадает с порядком на странице
+		"BT /F1 12 Tf 72 680 Td (bottom) Tj ET BT /F1 12 Tf 72 700 Td (top) Tj ET",
+	})
+	if err = ioutil.WriteFile(filepath.Join(dir, "rules.pdf"), data, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := extractPdf(filepath.Join(dir, "rules.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Annual leave\n28 days\n", "", "top\nbottom\n"}
+	if !reflect.DeepEqual(pages, want) {
+		t.Fatalf("pages %q, want %q", pages, want)
+	}
+
+	// Номера страниц сохраняются, пустые пропускаются
+	loaded, errs := Load(dir, []string{"rules.pdf"})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	var numbers []int
+	for _, p := range loaded {
+		numbers = append(numbers, p.Number)
+	}
+	if !reflect.DeepEqual(numbers, []int{1, 3}) {
+		t.Errorf("page numbers %v, want [1 3]", numbers)
+	}
+}
+
+func TestExtractPdfBroken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fulltext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	file := filepath.Join(dir, "broken.pdf")
+	if err = ioutil.WriteFile(file, []byte("%PDF-1.4\nnot a document"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = extractPdf(file); err == nil {
+		t.Error("broken PDF is extracted")
+	}
+}
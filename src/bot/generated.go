@@ -0,0 +1,421 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"connect-companion/bot/docgen"
+	"connect-companion/bot/forms"
+	"connect-companion/database"
+	"connect-companion/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+const (
+	GENERATED_PAGE     = 100
+	GENERATED_MAX_PAGE = 1000
+
+	GENERATED_CLEANUP_INTERVAL = time.Hour
+	GENERATED_CLEANUP_BATCH    = 100
+)
+
+type (
+	// Generated is the audit record of the document issued to the user
+	Generated struct {
+		Id       string    `json:"id"`
+		Form     string    `json:"form"`
+		Template string    `json:"template"`
+		Name     string    `json:"name"`
+		File     string    `json:"file"`
+		Size     int64     `json:"size"`
+		Sha256   string    `json:"sha256"`
+		LineId   uuid.UUID `json:"line_id"`
+		UserId   uuid.UUID `json:"user_id"`
+		Time     time.Time `json:"time"`
+
+		// false, если документ сформирован, но 1С-Коннект его не принял
+		Sent bool `json:"sent"`
+	}
+)
+
+// issueDocument fills the template of the form with answers and chat variables,
+// sends the document to the user and keeps the audit record. The replayed message
+// sends the document issued before instead of a new one.
+func issueDocument(db redis.UniversalClient, msg *message, chatState *database.Chat, form *forms.Form, answers map[string]string) (database.ChatState, error) {
+	values := make(map[string]string, len(chatState.Context)+len(answers))
+	for name, value := range chatState.Context {
+		values[name] = value
+	}
+	for name, value := range answers {
+		values[name] = value
+	}
+
+	if _, ok := done(msg, STEP_FILE_SENDING); !ok {
+		if _, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_FILE_SENDING), nil); err == nil {
+			markDone(msg, STEP_FILE_SENDING, "")
+		}
+	}
+
+	record, err := generateDocument(db, msg, form, values)
+	if err != nil {
+		logger.Warning("Error while generate document of form", form.Id, "for user", msg.UserId, err)
+
+		_, err = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_FORM_FAILED), mainKeyboard(msg, chatState))
+
+		return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
+	}
+
+	for name, value := range answers {
+		setContext(chatState, name, value)
+	}
+
+	if _, ok := done(msg, STEP_FILE_SENT); !ok {
+		comment := say(msg, chatState, PHRASE_FILE_SENDED)
+		if _, err = SendFile(msg.LineId, msg.UserId, record.Name, record.File, &comment, nil); err != nil {
+			return checkErrorForSend(msg, err, database.STATE_PARTING)
+		}
+		markDone(msg, STEP_FILE_SENT, "")
+
+		record.Sent = true
+		if err := saveGenerated(db, *record); err != nil {
+			logger.Warning("Error while save audit of document", record.Id, err)
+		}
+	}
+
+	err = later(msg, database.STATE_PARTING,
+		sendLater(pause(msg, PACE_AFTER_FILE), say(msg, chatState, PHRASE_AGAIN), partingKeyboard(msg, chatState)))
+
+	return checkErrorForSend(msg, err, database.STATE_PARTING)
+}
+
+// generateDocument issues the document of the form and keeps its audit record,
+// the replayed message gets the document issued before
+func generateDocument(db redis.UniversalClient, msg *message, form *forms.Form, values map[string]string) (*Generated, error) {
+	if id, ok := done(msg, STEP_DOCUMENT); ok {
+		g, err := readGenerated(db, id)
+		if err == nil {
+			return g, nil
+		}
+
+		logger.Warning("Issue again document", id, "which is gone", err)
+	}
+
+	a := form.Action
+	data := &docgen.Data{
+		Values: values,
+		UserId: msg.UserId.String(),
+		LineId: msg.LineId.String(),
+		Now:    time.Now(),
+	}
+
+	if profile := profileOf(msg); profile != nil {
+		data.Profile = *profile
+	}
+
+	out, err := docgen.Generate(tenantOf(msg.LineId).conf.Generator, a.Template, a.Name, a.Pdf, data)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &Generated{
+		Id:       out.Id,
+		Form:     form.Id,
+		Template: a.Template,
+		Name:     out.Name,
+		File:     out.Path,
+		Size:     out.Size,
+		Sha256:   out.Sha256,
+		LineId:   msg.LineId,
+		UserId:   msg.UserId,
+		Time:     data.Now,
+	}
+
+	if err := saveGenerated(db, *record); err != nil {
+		logger.Warning("Error while save audit of document", out.Id, err)
+	}
+
+	logger.Info("Document", out.Id, "of form", form.Id, "issued to user", msg.UserId)
+	markDone(msg, STEP_DOCUMENT, out.Id)
+
+	return record, nil
+}
+
+func saveGenerated(db redis.UniversalClient, g Generated) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+
+	score := float64(g.Time.UnixNano() / int64(time.Millisecond))
+
+	_, err = db.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(database.PREFIX_GENERATED+g.Id, data, 0)
+		pipe.ZAdd(database.KEY_GENERATED, &redis.Z{Score: score, Member: g.Id})
+		pipe.ZAdd(database.PREFIX_GENERATED_USER+g.UserId.String(), &redis.Z{Score: score, Member: g.Id})
+
+		return nil
+	})
+
+	return err
+}
+
+func readGenerated(db redis.UniversalClient, id string) (*Generated, error) {
+	data, err := db.Get(database.PREFIX_GENERATED + id).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var g Generated
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+// readAllGenerated returns records of the ids in order, records which are gone are skipped.
+// Ids of records which could not be decoded are returned apart.
+func readAllGenerated(db redis.UniversalClient, ids []string) ([]Generated, []string, error) {
+	if len(ids) == 0 {
+		return []Generated{}, nil, nil
+	}
+
+	cmds := make([]*redis.StringCmd, len(ids))
+	_, err := db.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.Get(database.PREFIX_GENERATED + id)
+		}
+
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, nil, err
+	}
+
+	records := make([]Generated, 0, len(ids))
+	var broken []string
+	for i, cmd := range cmds {
+		data, err := cmd.Bytes()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		var g Generated
+		if err := json.Unmarshal(data, &g); err != nil {
+			logger.Warning("Error while decode audit of document", ids[i], err)
+			broken = append(broken, ids[i])
+			continue
+		}
+
+		records = append(records, g)
+	}
+
+	return records, broken, nil
+}
+
+// GeneratedDocuments returns a page of the audit of documents and the count of all records,
+// of the user only if userId is set, the newest first
+func GeneratedDocuments(db redis.UniversalClient, userId *uuid.UUID, offset int64, limit int64) ([]Generated, int64, error) {
+	key := database.KEY_GENERATED
+	if userId != nil {
+		key = database.PREFIX_GENERATED_USER + userId.String()
+	}
+
+	total, err := db.ZCard(key).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids, err := db.ZRevRange(key, offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records, _, err := readAllGenerated(db, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return records, total, nil
+}
+
+// CleanupGenerated periodically removes documents and their audit records older than keep
+// of the generator settings
+func CleanupGenerated(db redis.UniversalClient) {
+	ticker := time.NewTicker(GENERATED_CLEANUP_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !isLeader() {
+			continue
+		}
+
+		removed, err := cleanupGenerated(db, time.Now().Add(-cnf.Generator.WithDefaults().Keep))
+		if err != nil {
+			logger.Warning("Error while remove old documents", err)
+		}
+		if removed > 0 {
+			logger.Info("Removed", removed, "old generated documents")
+		}
+	}
+}
+
+// cleanupGenerated removes documents issued before the time with their records.
+// Records which could not be decoded are left with their documents for the operator.
+func cleanupGenerated(db redis.UniversalClient, before time.Time) (int, error) {
+	max := strconv.FormatInt(before.UnixNano()/int64(time.Millisecond), 10)
+	removed := 0
+	var kept int64
+
+	for {
+		ids, err := db.ZRangeByScore(database.KEY_GENERATED, &redis.ZRangeBy{
+			Min:    "-inf",
+			Max:    "(" + max,
+			Offset: kept,
+			Count:  GENERATED_CLEANUP_BATCH,
+		}).Result()
+		if err != nil {
+			return removed, err
+		}
+
+		if len(ids) == 0 {
+			return removed, nil
+		}
+
+		records, broken, err := readAllGenerated(db, ids)
+		if err != nil {
+			return removed, err
+		}
+
+		// Без записи неизвестны ни файл, ни пользователь: оставляем оператору, идем дальше
+		if len(broken) > 0 {
+			logger.Warning("Keep old documents", broken, "with broken audit records")
+
+			kept += int64(len(broken))
+
+			skip := make(map[string]bool, len(broken))
+			for _, id := range broken {
+				skip[id] = true
+			}
+
+			left := ids[:0]
+			for _, id := range ids {
+				if !skip[id] {
+					left = append(left, id)
+				}
+			}
+			ids = left
+		}
+
+		// Сначала файлы: запись без файла отдает 410, файл без записи никто не найдет
+		for _, g := range records {
+			if err := os.Remove(g.File); err != nil && !os.IsNotExist(err) {
+				logger.Warning("Error while remove generated document", g.Id, err)
+			}
+
+			// Пустой каталог месяца больше не нужен, непустой не удаляется
+			_ = os.Remove(filepath.Dir(g.File))
+		}
+
+		if len(ids) == 0 {
+			continue
+		}
+
+		members := make([]interface{}, len(ids))
+		for i, id := range ids {
+			members[i] = id
+		}
+
+		_, err = db.TxPipelined(func(pipe redis.Pipeliner) error {
+			for _, g := range records {
+				pipe.ZRem(database.PREFIX_GENERATED_USER+g.UserId.String(), g.Id)
+			}
+			for _, id := range ids {
+				pipe.Del(database.PREFIX_GENERATED + id)
+			}
+			pipe.ZRem(database.KEY_GENERATED, members...)
+
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+
+		removed += len(records)
+	}
+}
+
+// ListGenerated returns the audit of issued documents, the newest first, by pages of ?offset= and ?limit=,
+// ?user= filters by the user
+func ListGenerated(c *gin.Context) {
+	db := c.MustGet("db").(redis.UniversalClient)
+
+	var userId *uuid.UUID
+	if user := c.Query("user"); user != "" {
+		id, err := uuid.Parse(user)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+		userId = &id
+	}
+
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(GENERATED_PAGE)), 10, 64)
+	if err != nil || limit <= 0 || limit > GENERATED_MAX_PAGE {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be from 1 to %d", GENERATED_MAX_PAGE)})
+		return
+	}
+
+	records, total, err := GeneratedDocuments(db, userId, offset, limit)
+	if err != nil {
+		logger.Warning("Error while read audit of documents", err)
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"offset":  offset,
+		"records": records,
+	})
+}
+
+// GetGenerated downloads the issued document
+func GetGenerated(c *gin.Context) {
+	db := c.MustGet("db").(redis.UniversalClient)
+
+	g, err := readGenerated(db, c.Param("id"))
+	if err == redis.Nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no document"})
+		return
+	} else if err != nil {
+		logger.Warning("Error while read audit of document", c.Param("id"), err)
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := os.Stat(g.File); err != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "file is removed"})
+		return
+	}
+
+	c.FileAttachment(g.File, g.Name)
+}
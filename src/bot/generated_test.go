@@ -0,0 +1,146 @@
+package bot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"connect-companion/database"
+
+	"github.com/google/uuid"
+)
+
+func TestGeneratedDocuments(t *testing.T) {
+	db := testRedis(t)
+
+	dir, err := ioutil.TempDir("", "generated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	user, other := uuid.New(), uuid.New()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 5; i++ {
+		g := Generated{
+			Id:     uuid.New().String(),
+			Name:   "Справка",
+			File:   filepath.Join(dir, start.AddDate(0, i, 0).Format("2006-01"), "document.pdf"),
+			UserId: user,
+			Time:   start.AddDate(0, i, 0),
+		}
+		if i%2 == 1 {
+			g.UserId = other
+		}
+
+		if err := os.MkdirAll(filepath.Dir(g.File), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(g.File, []byte("pdf"), 0640); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := saveGenerated(db, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, total, err := GeneratedDocuments(db, nil, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 5 || len(records) != 2 || !records[0].Time.Equal(start.AddDate(0, 3, 0)) || !records[1].Time.Equal(start.AddDate(0, 2, 0)) {
+		t.Errorf("second page of %d records: %+v", total, records)
+	}
+
+	records, total, err = GeneratedDocuments(db, &other, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(records) != 2 || records[0].UserId != other {
+		t.Errorf("%d records of the user: %+v", total, records)
+	}
+
+	removed, err := cleanupGenerated(db, start.AddDate(0, 2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d, want 2", removed)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "2020-01")); !os.IsNotExist(err) {
+		t.Errorf("old document is kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2020-03", "document.pdf")); err != nil {
+		t.Errorf("new document is removed: %v", err)
+	}
+
+	if _, total, _ = GeneratedDocuments(db, nil, 0, 10); total != 3 {
+		t.Errorf("%d records are left, want 3", total)
+	}
+	if _, total, _ = GeneratedDocuments(db, &other, 0, 10); total != 1 {
+		t.Errorf("%d records of the user are left, want 1", total)
+	}
+	if n, _ := db.Exists(database.PREFIX_GENERATED + records[1].Id).Result(); n != 0 {
+		t.Error("record of the removed document is kept")
+	}
+}
+
+func TestCleanupBrokenGenerated(t *testing.T) {
+	db := testRedis(t)
+
+	dir, err := ioutil.TempDir("", "generated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var ids []string
+	for i := 0; i < GENERATED_CLEANUP_BATCH+1; i++ {
+		g := Generated{
+			Id:     uuid.New().String(),
+			File:   filepath.Join(dir, uuid.New().String()+".pdf"),
+			UserId: uuid.New(),
+			Time:   start.Add(time.Duration(i) * time.Minute),
+		}
+		if err := ioutil.WriteFile(g.File, []byte("pdf"), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := saveGenerated(db, g); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, g.Id)
+	}
+
+	// Битая запись первой в очереди не должна ни теряться, ни останавливать очистку
+	if err := db.Set(database.PREFIX_GENERATED+ids[0], "{", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := cleanupGenerated(db, start.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != GENERATED_CLEANUP_BATCH {
+		t.Errorf("removed %d, want %d", removed, GENERATED_CLEANUP_BATCH)
+	}
+
+	left, _ := db.ZRange(database.KEY_GENERATED, 0, -1).Result()
+	if len(left) != 1 || left[0] != ids[0] {
+		t.Errorf("index keeps %v, want the broken record only", left)
+	}
+	if n, _ := db.Exists(database.PREFIX_GENERATED + ids[0]).Result(); n != 1 {
+		t.Error("broken record is removed")
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("%d files are left, want the document of the broken record", len(files))
+	}
+}
@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"connect-companion/health"
+)
+
+// InitHealth registers readiness checks of the bot
+func InitHealth() {
+	health.Add("config", checkConfig)
+	health.Add("hooks", checkHooks)
+	health.Add("outbound", checkOutbound)
+	health.Add("connect", checkConnect)
+}
+
+func checkConfig() error {
+	if len(defaultTenant.catalogs) == 0 {
+		return errors.New("bot is not configured")
+	}
+
+	return nil
+}
+
+func checkHooks() error {
+	if HooksReady() {
+		return nil
+	}
+
+	for _, status := range HookStatuses() {
+		if status.Error != "" {
+			return fmt.Errorf("no working hook, line %s: %s", status.LineId, status.Error)
+		}
+	}
+
+	return errors.New("no working hook")
+}
+
+func checkOutbound() error {
+	if n := atomic.LoadInt64(&inflight); n >= MAX_INFLIGHT {
+		return fmt.Errorf("%d requests to 1C-Connect in flight", n)
+	}
+
+	return checkBreakers()
+}
+
+// checkConnect fails while the last request to 1C-Connect has failed recently.
+// The instance which has sent nothing yet, e.g. just started or not the leader, is ready.
+func checkConnect() error {
+	failed := atomic.LoadInt64(&lastConnectFail)
+	if failed == 0 || failed < atomic.LoadInt64(&lastConnectOk) {
+		return nil
+	}
+
+	since := time.Since(time.Unix(0, failed))
+	if since > CONNECT_WINDOW {
+		return nil
+	}
+
+	err, _ := lastConnectErr.Load().(string)
+
+	return fmt.Errorf("1C-Connect did not answer %s ago: %s", since.Round(time.Second), err)
+}
@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func setConnect(t *testing.T, ok, failed time.Time, err string) {
+	t.Helper()
+
+	stamp := func(at time.Time) int64 {
+		if at.IsZero() {
+			return 0
+		}
+		return at.UnixNano()
+	}
+
+	atomic.StoreInt64(&lastConnectOk, stamp(ok))
+	atomic.StoreInt64(&lastConnectFail, stamp(failed))
+	lastConnectErr.Store(err)
+
+	t.Cleanup(func() {
+		atomic.StoreInt64(&lastConnectOk, 0)
+		atomic.StoreInt64(&lastConnectFail, 0)
+	})
+}
+
+func TestCheckConnect(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		ok     time.Time
+		failed time.Time
+		ready  bool
+	}{
+		{"nothing sent", time.Time{}, time.Time{}, true},
+		{"idle since success", now.Add(-time.Hour), time.Time{}, true},
+		{"recent failure", time.Time{}, now.Add(-time.Minute), false},
+		{"failure after success", now.Add(-2 * time.Minute), now.Add(-time.Minute), false},
+		{"success after failure", now.Add(-time.Minute), now.Add(-2 * time.Minute), true},
+		{"old failure", time.Time{}, now.Add(-CONNECT_WINDOW - time.Minute), true},
+	}
+
+	for _, tt := range tests {
+		setConnect(t, tt.ok, tt.failed, "connection refused")
+
+		if err := checkConnect(); (err == nil) != tt.ready {
+			t.Errorf("%s: checkConnect() = %v, want ready %v", tt.name, err, tt.ready)
+		}
+	}
+}
+
+func TestCheckOutbound(t *testing.T) {
+	atomic.StoreInt64(&inflight, MAX_INFLIGHT)
+	defer atomic.StoreInt64(&inflight, 0)
+
+	if checkOutbound() == nil {
+		t.Error("overloaded client is ready")
+	}
+
+	atomic.StoreInt64(&inflight, 0)
+	if err := checkOutbound(); err != nil {
+		t.Errorf("idle client is not ready: %v", err)
+	}
+}
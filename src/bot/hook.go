@@ -1,30 +1,91 @@
 package bot
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"connect-companion/bot/requests"
+	"connect-companion/config"
 	"connect-companion/logger"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+const (
+	HOOK_TYPE     = "bot"
+	HOOK_ENDPOINT = "/connect-push/receive/"
+
+	DEFAULT_HOOK_CHECK_INTERVAL = time.Minute
+
+	// Первая повторная попытка после ошибки, дальше интервал удваивается до check_interval
+	HOOK_RETRY_MIN = 5 * time.Second
+	HOOK_TICK      = time.Second
+)
+
+type (
+	// HookStatus is the last known state of the hook of the line
+	HookStatus struct {
+		LineId uuid.UUID `json:"line_id"`
+		Ok     bool      `json:"ok"`
+		// Hooks could not be listed on the instance which is not the leader
+		Unknown   bool      `json:"unknown,omitempty"`
+		Checked   time.Time `json:"checked"`
+		Error     string    `json:"error,omitempty"`
+		Failures  int       `json:"failures,omitempty"`
+		NextCheck time.Time `json:"next_check"`
+	}
+)
+
+var (
+	hooksMu sync.Mutex
+	hooks   = map[uuid.UUID]*HookStatus{}
+)
+
 func InitHooks(app *gin.Engine, lines []uuid.UUID) {
 	logger.Info("Init receiving endpoint...")
 
-	app.POST("/connect-push/receive/", Receive)
+	app.POST(HOOK_ENDPOINT, Receive)
+	app.GET("/health/hooks", HooksHealth)
 
 	logger.Info("Setup hooks on 1C-Connect...")
 
-	for i := range lines {
-		logger.Info("- hook for line", lines[i])
+	hooksMu.Lock()
+	hooks = make(map[uuid.UUID]*HookStatus, len(lines))
+	for _, lineId := range lines {
+		hooks[lineId] = &HookStatus{LineId: lineId}
+	}
+	hooksMu.Unlock()
 
-		_, err := setHook(lines[i])
-		if err != nil {
-			logger.Warning("Error while setup hook:", err)
+	reconcileHooks(time.Now())
+
+	for _, status := range HookStatuses() {
+		if status.Ok {
+			logger.Info("- hook for line", status.LineId)
+		} else if status.Unknown {
+			logger.Info("- hook for line", status.LineId, "is unknown:", status.Error)
+		} else {
+			logger.Warning("Error while setup hook for line", status.LineId, ":", status.Error)
 		}
 	}
 }
 
-func DestroyHooks(lines []uuid.UUID) {
+// DestroyHooks deletes hooks according to cluster.delete_hooks, last tells
+// whether no other instance is alive
+func DestroyHooks(lines []uuid.UUID, last bool) {
+	switch {
+	case cnf.Cluster.DeleteHooks == HOOKS_DELETE_NEVER:
+		return
+	case cnf.Cluster.DeleteHooks == HOOKS_DELETE_LAST && !last:
+		logger.Info("Keep hooks on 1C-Connect for other instances")
+		return
+	}
+
 	logger.Info("Destroy hooks on 1C-Connect...")
 
 	for i := range lines {
@@ -34,3 +95,250 @@ func DestroyHooks(lines []uuid.UUID) {
 		}
 	}
 }
+
+// ReconcileHooks periodically verifies hooks of all lines. The leader registers lost ones again,
+// other instances only watch them.
+func ReconcileHooks() {
+	ticker := time.NewTicker(HOOK_TICK)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		reconcileHooks(now)
+	}
+}
+
+func hookCheckInterval() time.Duration {
+	if cnf.Hooks.CheckInterval > 0 {
+		return cnf.Hooks.CheckInterval
+	}
+
+	return DEFAULT_HOOK_CHECK_INTERVAL
+}
+
+func hookUrl() string {
+	return cnf.Server.Host + HOOK_ENDPOINT
+}
+
+func reconcileHooks(now time.Time) {
+	var due []uuid.UUID
+
+	hooksMu.Lock()
+	for lineId, status := range hooks {
+		if !now.Before(status.NextCheck) {
+			due = append(due, lineId)
+		}
+	}
+	hooksMu.Unlock()
+
+	if len(due) == 0 {
+		return
+	}
+
+	type listing struct {
+		registered map[uuid.UUID]string
+		err        error
+	}
+
+	// Хуки запрашиваем один раз на каждую учетную запись 1C-Connect
+	listings := make(map[config.Connect]*listing)
+
+	leader := isLeader()
+
+	for _, lineId := range due {
+		connect := connectOf(lineId)
+
+		l, ok := listings[connect]
+		if !ok {
+			l = &listing{}
+			l.registered, l.err = registeredHooks(connect)
+			if l.err != nil {
+				logger.Debug("Could not list hooks:", l.err.Error())
+			}
+
+			listings[connect] = l
+		}
+
+		// Если список получить не удалось, лидер просто регистрирует хуки заново
+		registered, listErr := l.registered, l.err
+
+		var err error
+
+		switch {
+		case registered != nil && registered[lineId] == hookUrl():
+		case !leader && listErr != nil:
+			// Список хуков не документирован и может не поддерживаться, это не ошибка хука
+			unknownHook(lineId, now, listErr)
+			continue
+		case !leader:
+			err = errors.New("hook is not registered")
+		default:
+			if registered != nil {
+				logger.Warning("Hook for line", lineId, "is lost, register it again")
+			}
+
+			_, err = setHook(lineId)
+		}
+
+		updateHook(lineId, now, err)
+	}
+}
+
+func updateHook(lineId uuid.UUID, now time.Time, err error) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	status, ok := hooks[lineId]
+	if !ok {
+		return
+	}
+
+	status.Checked = now
+	status.Unknown = false
+
+	if err == nil {
+		status.Ok = true
+		status.Error = ""
+		status.Failures = 0
+		status.NextCheck = now.Add(hookCheckInterval())
+
+		return
+	}
+
+	if status.Ok || status.Failures == 0 {
+		logger.Warning("Hook for line", lineId, "failed:", err)
+	}
+
+	status.Ok = false
+	status.Error = err.Error()
+	status.Failures++
+
+	retry := hookCheckInterval()
+	if status.Failures <= 16 {
+		retry = HOOK_RETRY_MIN << uint(status.Failures-1)
+	}
+	if retry > hookCheckInterval() {
+		retry = hookCheckInterval()
+	}
+	status.NextCheck = now.Add(retry)
+}
+
+// unknownHook marks the hook which state could not be learned
+func unknownHook(lineId uuid.UUID, now time.Time, err error) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	status, ok := hooks[lineId]
+	if !ok {
+		return
+	}
+
+	status.Checked = now
+	status.Ok = false
+	status.Unknown = true
+	status.Error = err.Error()
+	status.Failures = 0
+	status.NextCheck = now.Add(hookCheckInterval())
+}
+
+// registeredHooks returns urls of bot hooks by line
+func registeredHooks(connect config.Connect) (map[uuid.UUID]string, error) {
+	content, err := invoke(connect, uuid.Nil, "GET", "/hook/", "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []requests.HookSetupRequest
+	if err = json.Unmarshal(content, &list); err != nil {
+		return nil, err
+	}
+
+	registered := make(map[uuid.UUID]string, len(list))
+	for _, h := range list {
+		if h.Type == HOOK_TYPE {
+			registered[h.Id] = h.Url
+		}
+	}
+
+	return registered, nil
+}
+
+// HookStatuses returns copies of hook states ordered by line
+func HookStatuses() []HookStatus {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	statuses := make([]HookStatus, 0, len(hooks))
+	for _, status := range hooks {
+		statuses = append(statuses, *status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].LineId.String() < statuses[j].LineId.String()
+	})
+
+	return statuses
+}
+
+// HooksReady tells whether at least one line receives messages. Hooks are registered
+// by the leader, so other instances are ready whatever they know about hooks.
+func HooksReady() bool {
+	if !isLeader() {
+		return true
+	}
+
+	for _, status := range HookStatuses() {
+		if status.Ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HooksHealth shows hook state of every line and fails on the leader when no line has a working hook
+func HooksHealth(c *gin.Context) {
+	code := http.StatusOK
+	if !HooksReady() {
+		code = http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, gin.H{
+		"ready": code == http.StatusOK,
+		"hooks": HookStatuses(),
+	})
+}
+
+// ListHooks returns hooks registered on 1C-Connect as is by account login@server
+func ListHooks() (map[string][]byte, error) {
+	lists := make(map[string][]byte)
+
+	for _, t := range allTenants() {
+		connect := t.conf.Connect
+
+		account := accountOf(connect)
+		if _, ok := lists[account]; ok {
+			continue
+		}
+
+		content, err := invoke(connect, uuid.Nil, "GET", "/hook/", "application/json", nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", account, err)
+		}
+
+		lists[account] = content
+	}
+
+	return lists, nil
+}
+
+func SetHook(lineId uuid.UUID) error {
+	_, err := setHook(lineId)
+
+	return err
+}
+
+func DeleteHook(lineId uuid.UUID) error {
+	_, err := deleteHook(lineId)
+
+	return err
+}
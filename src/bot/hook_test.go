@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"connect-companion/config"
+
+	"github.com/google/uuid"
+)
+
+func TestUpdateHook(t *testing.T) {
+	savedCnf, savedHooks := cnf, hooks
+	t.Cleanup(func() { cnf, hooks = savedCnf, savedHooks })
+
+	cnf = &config.Conf{Hooks: config.Hooks{CheckInterval: 30 * time.Second}}
+
+	line := uuid.New()
+	hooks = map[uuid.UUID]*HookStatus{line: {LineId: line}}
+
+	now := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	failure := errors.New("connection refused")
+
+	// Пауза после ошибки удваивается, но не больше check_interval
+	for i, want := range []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 30 * time.Second} {
+		updateHook(line, now, failure)
+
+		status := HookStatuses()[0]
+		if status.Ok || status.Failures != i+1 || status.Error != failure.Error() {
+			t.Fatalf("status %+v after failure %d", status, i+1)
+		}
+		if d := status.NextCheck.Sub(now); d != want {
+			t.Errorf("retry in %s after failure %d, want %s", d, i+1, want)
+		}
+	}
+
+	if HooksReady() {
+		t.Error("ready without working hooks")
+	}
+
+	updateHook(line, now, nil)
+
+	status := HookStatuses()[0]
+	if !status.Ok || status.Failures != 0 || status.Error != "" || status.NextCheck != now.Add(30*time.Second) {
+		t.Errorf("status %+v after success", status)
+	}
+
+	if !HooksReady() {
+		t.Error("not ready with the working hook")
+	}
+
+	// Хуки чужих линий не появляются
+	updateHook(uuid.New(), now, nil)
+	if len(HookStatuses()) != 1 {
+		t.Error("status of unknown line is added")
+	}
+}
@@ -0,0 +1,238 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"connect-companion/bot/messages"
+	"connect-companion/bot/requests"
+	"connect-companion/database"
+	"connect-companion/logger"
+	"connect-companion/scheduler"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	JOB_IDLE_REMIND = "idle_remind"
+	JOB_IDLE_CLOSE  = "idle_close"
+)
+
+var (
+	jobs *scheduler.Scheduler
+)
+
+type (
+	idlePolicy struct {
+		remindAfter time.Duration
+		closeAfter  time.Duration
+	}
+
+	idleJobData struct {
+		State database.ChatState `json:"state"`
+
+		// Уведомление о закрытии уже отправлено, повтор только закрывает обращение
+		Noticed bool `json:"noticed,omitempty"`
+	}
+)
+
+// InitJobs registers handlers of bot jobs in the scheduler
+func InitJobs(db redis.UniversalClient, s *scheduler.Scheduler) {
+	jobs = s
+
+	s.Handle(JOB_IDLE_REMIND, func(job *scheduler.Job) error {
+		return remindIdle(db, job)
+	})
+	s.Handle(JOB_IDLE_CLOSE, func(job *scheduler.Job) error {
+		return closeIdle(db, job)
+	})
+	s.Handle(JOB_TIMELINE, func(job *scheduler.Job) error {
+		return runTimeline(db, job)
+	})
+	s.Handle(JOB_MESSAGE, func(job *scheduler.Job) error {
+		return replayMessage(db, job)
+	})
+	s.Handle(JOB_INBOUND, func(job *scheduler.Job) error {
+		return flush(db, job)
+	})
+}
+
+func (t *tenant) configureInactivity() error {
+	t.idle = make(map[database.ChatState]idlePolicy)
+
+	for name, c := range t.conf.Inactivity {
+		state, ok := database.StateByName[name]
+		if !ok {
+			return fmt.Errorf("inactivity: unknown state %q", name)
+		}
+
+		if c.RemindAfter <= 0 && c.CloseAfter <= 0 {
+			return fmt.Errorf("inactivity %s: remind_after or close_after is required", name)
+		}
+
+		t.idle[state] = idlePolicy{
+			remindAfter: c.RemindAfter,
+			closeAfter:  c.CloseAfter,
+		}
+	}
+
+	// Обращение открыто, пока идет опрос, брошенный опрос его закрывает
+	closeAfter := t.conf.Survey.CloseAfter
+	if closeAfter <= 0 {
+		closeAfter = DEFAULT_SURVEY_CLOSE_AFTER
+	}
+
+	for _, state := range []database.ChatState{database.STATE_SURVEY_RATING, database.STATE_SURVEY_COMMENT} {
+		if _, ok := t.idle[state]; !ok {
+			t.idle[state] = idlePolicy{closeAfter: closeAfter}
+		}
+	}
+
+	return nil
+}
+
+func idleJobId(msg *message) string {
+	return "idle:" + msg.LineId.String() + ":" + msg.UserId.String()
+}
+
+// scheduleIdle replaces the pending idle job of the chat according to the new state
+func scheduleIdle(msg *message, state database.ChatState) {
+	if jobs == nil {
+		return
+	}
+
+	policy, ok := tenantOf(msg.LineId).idle[state]
+	if !ok {
+		if err := jobs.Cancel(idleJobId(msg)); err != nil {
+			logger.Warning("Error while cancel idle job", err)
+		}
+
+		return
+	}
+
+	kind, after := JOB_IDLE_REMIND, policy.remindAfter
+	if after <= 0 {
+		kind, after = JOB_IDLE_CLOSE, policy.closeAfter
+	}
+
+	if err := scheduleIdleJob(msg, kind, state, after); err != nil {
+		logger.Warning("Error while schedule idle job", err)
+	}
+}
+
+func scheduleIdleJob(msg *message, kind string, state database.ChatState, after time.Duration) error {
+	data, err := json.Marshal(idleJobData{State: state})
+	if err != nil {
+		return err
+	}
+
+	return jobs.Schedule(&scheduler.Job{
+		Id:     idleJobId(msg),
+		Kind:   kind,
+		At:     time.Now().Add(after),
+		LineId: msg.LineId,
+		UserId: msg.UserId,
+		Data:   data,
+	})
+}
+
+// idleJobChat returns the chat of the job if it is still in the state the job was planned for
+func idleJobChat(db redis.UniversalClient, job *scheduler.Job, data *idleJobData) (*message, *database.Chat, bool) {
+	if err := json.Unmarshal(job.Data, data); err != nil {
+		logger.Warning("Error while decoding idle job", job.Id, err)
+		return nil, nil, false
+	}
+
+	msg := newMessage(messages.Message{
+		LineId: job.LineId,
+		UserId: job.UserId,
+	})
+
+	chatState := getState(db, msg)
+	if chatState.CurrentState != data.State {
+		logger.Debug("Skip idle job", job.Id, "because chat state changed")
+		return nil, nil, false
+	}
+
+	return msg, &chatState, true
+}
+
+func remindIdle(db redis.UniversalClient, job *scheduler.Job) error {
+	unlock, err := lockChat(db, newMessage(messages.Message{LineId: job.LineId, UserId: job.UserId}))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var data idleJobData
+	msg, chatState, ok := idleJobChat(db, job, &data)
+	if !ok {
+		return nil
+	}
+
+	var keyboard *[][]requests.KeyboardKey
+	switch chatState.CurrentState {
+	case database.STATE_MAIN_MENU:
+		keyboard = mainKeyboard(msg, chatState)
+	case database.STATE_PARTING:
+		keyboard = partingKeyboard(msg, chatState)
+	case database.STATE_ARTICLES:
+		keyboard = articlesKeyboard(msg, chatState)
+	}
+
+	policy := tenantOf(msg.LineId).idle[chatState.CurrentState]
+
+	_, err = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_IDLE_REMIND), keyboard)
+	if err != nil && policy.closeAfter <= 0 {
+		// Напоминание повторит планировщик
+		return err
+	} else if err != nil {
+		// Обращение все равно закроется, иначе оно останется открытым навсегда
+		logger.Warning("Error while remind idle user", msg.UserId, "on line", msg.LineId, err)
+	}
+
+	if policy.closeAfter <= 0 {
+		return nil
+	}
+
+	return scheduleIdleJob(msg, JOB_IDLE_CLOSE, chatState.CurrentState, policy.closeAfter)
+}
+
+func closeIdle(db redis.UniversalClient, job *scheduler.Job) error {
+	unlock, err := lockChat(db, newMessage(messages.Message{LineId: job.LineId, UserId: job.UserId}))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var data idleJobData
+	msg, chatState, ok := idleJobChat(db, job, &data)
+	if !ok {
+		return nil
+	}
+
+	logger.Info("Close idle treatment of user", msg.UserId, "on line", msg.LineId)
+
+	if !data.Noticed {
+		_, err = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_IDLE_CLOSE), nil)
+		if err != nil && job.Attempt < scheduler.MAX_ATTEMPTS-1 {
+			// Планировщик повторит закрытие позже
+			return err
+		} else if err != nil {
+			logger.Warning("Close idle treatment of user", msg.UserId, "without notice", err)
+		}
+
+		// Повтор после ошибки закрытия не отправит уведомление еще раз
+		data.Noticed = true
+		if job.Data, err = json.Marshal(data); err != nil {
+			return err
+		}
+	}
+
+	if _, err = CloseTreatment(msg.LineId, msg.UserId); err != nil {
+		return err
+	}
+
+	return changeState(db, msg, chatState, database.STATE_GREETINGS)
+}
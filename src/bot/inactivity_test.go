@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"connect-companion/bot/messages"
+	"connect-companion/config"
+	"connect-companion/database"
+	"connect-companion/scheduler"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+func TestConfigureInactivity(t *testing.T) {
+	tests := []struct {
+		name       string
+		inactivity map[string]config.Idle
+		ok         bool
+	}{
+		{"remind and close", map[string]config.Idle{"main_menu": {RemindAfter: time.Minute, CloseAfter: time.Hour}}, true},
+		{"close only", map[string]config.Idle{"parting": {CloseAfter: time.Hour}}, true},
+		{"unknown state", map[string]config.Idle{"lobby": {CloseAfter: time.Hour}}, false},
+		{"no timeouts", map[string]config.Idle{"main_menu": {}}, false},
+	}
+
+	for _, tt := range tests {
+		tn := &tenant{conf: &config.Conf{Inactivity: tt.inactivity}}
+
+		if err := tn.configureInactivity(); (err == nil) != tt.ok {
+			t.Errorf("%s: error %v", tt.name, err)
+		}
+	}
+}
+
+func TestScheduleIdle(t *testing.T) {
+	db := testRedis(t)
+
+	savedTenants, savedJobs := tenants, jobs
+	t.Cleanup(func() { tenants, jobs = savedTenants, savedJobs })
+
+	tn := &tenant{conf: &config.Conf{Inactivity: map[string]config.Idle{
+		"main_menu": {RemindAfter: time.Minute, CloseAfter: time.Hour},
+		"parting":   {CloseAfter: time.Hour},
+	}}}
+	if err := tn.configureInactivity(); err != nil {
+		t.Fatal(err)
+	}
+	jobs = scheduler.New(db)
+
+	msg := newMessage(messages.Message{LineId: uuid.New(), UserId: uuid.New()})
+	tenants = map[uuid.UUID]*tenant{msg.LineId: tn}
+
+	pending := func() *scheduler.Job {
+		raw, err := db.HGet(database.KEY_JOBS_DATA, idleJobId(msg)).Result()
+		if err == redis.Nil {
+			return nil
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		var job scheduler.Job
+		if err = json.Unmarshal([]byte(raw), &job); err != nil {
+			t.Fatal(err)
+		}
+
+		return &job
+	}
+
+	// В меню сначала напоминание
+	scheduleIdle(msg, database.STATE_MAIN_MENU)
+	if job := pending(); job == nil || job.Kind != JOB_IDLE_REMIND {
+		t.Fatalf("job %+v, want the reminder", job)
+	}
+
+	// Без напоминания сразу закрытие, задание чата одно
+	scheduleIdle(msg, database.STATE_PARTING)
+	job := pending()
+	if job == nil || job.Kind != JOB_IDLE_CLOSE {
+		t.Fatalf("job %+v, want the close", job)
+	}
+	if d := time.Until(job.At); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("close in %s, want an hour", d)
+	}
+
+	// Брошенный опрос закрывает обращение
+	scheduleIdle(msg, database.STATE_SURVEY_RATING)
+	job = pending()
+	if job == nil || job.Kind != JOB_IDLE_CLOSE {
+		t.Fatalf("job %+v, want the close of the survey", job)
+	}
+	if d := time.Until(job.At); d < DEFAULT_SURVEY_CLOSE_AFTER-time.Minute || d > DEFAULT_SURVEY_CLOSE_AFTER {
+		t.Errorf("survey is closed in %s, want %s", d, DEFAULT_SURVEY_CLOSE_AFTER)
+	}
+
+	// Состояние без настроек отменяет задание
+	scheduleIdle(msg, database.STATE_GREETINGS)
+	if job := pending(); job != nil {
+		t.Errorf("job %+v is kept", job)
+	}
+}
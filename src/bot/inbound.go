@@ -0,0 +1,316 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"connect-companion/bot/messages"
+	"connect-companion/database"
+	"connect-companion/logger"
+	"connect-companion/metrics"
+	"connect-companion/scheduler"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	INBOUND_DROP     = "drop"
+	INBOUND_COALESCE = "coalesce"
+	INBOUND_NOTICE   = "notice"
+
+	DEFAULT_BLOCK_WINDOW = time.Minute
+	DEFAULT_BLOCK_FOR    = time.Hour
+
+	JOB_INBOUND = "inbound"
+
+	// Если отложенное сообщение так и не обработано, через этот срок принимаем новые как обычно
+	INBOUND_FLUSH_GRACE = time.Minute
+)
+
+var (
+	inboundTotal = metrics.NewCounter("inbound_messages_total",
+		"Messages of users by result: accepted, dropped, coalesced, noticed or blocked", "result")
+
+	// limitInbound counts the message against token buckets of the user and the line, which are
+	// kept in redis so all instances share them. Tokens are taken only by accepted messages.
+	// Returns {accepted, delay ms, block, notice}.
+	limitInbound = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[8])
+
+local function level(key, rate, burst)
+	if rate <= 0 then
+		return 0, 0
+	end
+
+	local tokens = tonumber(redis.call('HGET', key, 'tokens') or burst)
+	local last = tonumber(redis.call('HGET', key, 'last') or now)
+	if now > last then
+		tokens = math.min(burst, tokens + (now - last) * rate / 1000)
+	end
+
+	if tokens < 1 then
+		return math.ceil((1 - tokens) * 1000 / rate), tokens
+	end
+
+	return 0, tokens
+end
+
+local function take(key, rate, tokens)
+	if rate > 0 then
+		redis.call('HMSET', key, 'tokens', tostring(tokens - 1), 'last', now)
+		redis.call('PEXPIRE', key, ttl)
+	end
+end
+
+local rate, burst = tonumber(ARGV[2]), tonumber(ARGV[3])
+local lineRate, lineBurst = tonumber(ARGV[4]), tonumber(ARGV[5])
+
+local delay, tokens = level(KEYS[1], rate, burst)
+local lineDelay, lineTokens = level(KEYS[2], lineRate, lineBurst)
+if lineDelay > delay then
+	delay = lineDelay
+end
+
+if ARGV[9] == 'flush' then
+	take(KEYS[1], rate, tokens)
+	take(KEYS[2], lineRate, lineTokens)
+	redis.call('HDEL', KEYS[1], 'scheduled')
+	return {1, 0, 0, 0}
+end
+
+local scheduled = tonumber(redis.call('HGET', KEYS[1], 'scheduled') or 0) > now
+
+if delay == 0 and not scheduled then
+	take(KEYS[1], rate, tokens)
+	take(KEYS[2], lineRate, lineTokens)
+	redis.call('HDEL', KEYS[1], 'noticed')
+	return {1, 0, 0, 0}
+end
+
+local block = 0
+if delay > 0 then
+	local window = tonumber(redis.call('HGET', KEYS[1], 'window') or 0)
+	local violations = tonumber(redis.call('HGET', KEYS[1], 'violations') or 0)
+	if now - window > tonumber(ARGV[6]) then
+		window = now
+		violations = 0
+	end
+
+	violations = violations + 1
+	local blockAfter = tonumber(ARGV[7])
+	if blockAfter > 0 and violations >= blockAfter then
+		violations = 0
+		block = 1
+	end
+
+	redis.call('HMSET', KEYS[1], 'window', window, 'violations', violations)
+end
+
+local notice = 0
+if ARGV[10] == 'notice' and not redis.call('HGET', KEYS[1], 'noticed') then
+	redis.call('HSET', KEYS[1], 'noticed', 1)
+	notice = 1
+elseif ARGV[10] == 'coalesce' and not scheduled then
+	redis.call('HSET', KEYS[1], 'scheduled', now + delay + tonumber(ARGV[11]))
+end
+
+redis.call('PEXPIRE', KEYS[1], ttl)
+
+return {0, delay, block, notice}
+`)
+)
+
+type (
+	// inboundResult is the answer of limitInbound
+	inboundResult struct {
+		accepted bool
+		delay    time.Duration
+		block    bool
+		notice   bool
+	}
+)
+
+func configureInbound() error {
+	c := &cnf.Inbound
+
+	switch c.Policy {
+	case "":
+		c.Policy = INBOUND_DROP
+	case INBOUND_DROP, INBOUND_COALESCE, INBOUND_NOTICE:
+	default:
+		return fmt.Errorf("inbound: unknown policy %q", c.Policy)
+	}
+
+	if c.Rate < 0 || c.LineRate < 0 || c.BlockAfter < 0 {
+		return fmt.Errorf("inbound: rates and block_after must not be negative")
+	}
+
+	if c.BlockWindow <= 0 {
+		c.BlockWindow = DEFAULT_BLOCK_WINDOW
+	}
+
+	if c.BlockFor <= 0 {
+		c.BlockFor = DEFAULT_BLOCK_FOR
+	}
+
+	return nil
+}
+
+// inboundKeys returns keys of limits of the user and the line, the hash tag of the line
+// keeps both in one slot of redis cluster
+func inboundKeys(msg *messages.Message) []string {
+	line := database.PREFIX_INBOUND + "{" + msg.LineId.String() + "}"
+
+	return []string{line + ":" + msg.UserId.String(), line}
+}
+
+// inboundTTL is how long limits of the silent user are kept: until the bucket is full again
+// and the window of violations is over
+func inboundTTL() time.Duration {
+	c := cnf.Inbound
+	ttl := c.BlockWindow + INBOUND_FLUSH_GRACE
+
+	for _, b := range []struct {
+		rate  float64
+		burst int
+	}{{c.Rate, c.Burst}, {c.LineRate, c.LineBurst}} {
+		if b.rate <= 0 {
+			continue
+		}
+
+		if refill := time.Duration(math.Max(float64(b.burst), 1) / b.rate * float64(time.Second)); refill+INBOUND_FLUSH_GRACE > ttl {
+			ttl = refill + INBOUND_FLUSH_GRACE
+		}
+	}
+
+	return ttl
+}
+
+func limit(db redis.UniversalClient, msg *messages.Message, mode string, now time.Time) (inboundResult, error) {
+	c := cnf.Inbound
+
+	raw, err := limitInbound.Run(db, inboundKeys(msg),
+		now.UnixNano()/int64(time.Millisecond),
+		c.Rate, math.Max(float64(c.Burst), 1),
+		c.LineRate, math.Max(float64(c.LineBurst), 1),
+		c.BlockWindow.Milliseconds(), c.BlockAfter,
+		inboundTTL().Milliseconds(), mode, c.Policy,
+		INBOUND_FLUSH_GRACE.Milliseconds(),
+	).Result()
+	if err != nil {
+		return inboundResult{}, err
+	}
+
+	answer, _ := raw.([]interface{})
+	values := make([]int64, 0, len(answer))
+	for _, v := range answer {
+		if n, ok := v.(int64); ok {
+			values = append(values, n)
+		}
+	}
+
+	if len(values) != 4 {
+		return inboundResult{}, fmt.Errorf("unexpected answer of the limit %v", answer)
+	}
+
+	return inboundResult{
+		accepted: values[0] == 1,
+		delay:    time.Duration(values[1]) * time.Millisecond,
+		block:    values[2] == 1,
+		notice:   values[3] == 1,
+	}, nil
+}
+
+// admit tells whether the message is processed now. Excess messages are handled
+// by the policy and may block the user for a while. Errors of redis let messages through.
+func admit(db redis.UniversalClient, msg messages.Message) bool {
+	r, err := limit(db, &msg, "admit", time.Now())
+	if err != nil {
+		logger.Warning("Error while check inbound limits", err)
+		return true
+	}
+
+	if r.accepted {
+		inboundTotal.Inc("accepted")
+		return true
+	}
+
+	result := "dropped"
+	switch {
+	case r.notice:
+		result = "noticed"
+
+		go notice(db, newMessage(msg))
+	case cnf.Inbound.Policy == INBOUND_COALESCE:
+		result = "coalesced"
+
+		coalesce(&msg, r.delay)
+	}
+
+	inboundTotal.Inc(result)
+
+	if r.block {
+		logger.Warning("Block user", msg.UserId, "for", cnf.Inbound.BlockFor, "after too many messages")
+
+		if err := blockFor(db, msg.UserId, cnf.Inbound.BlockFor, "too many messages"); err != nil {
+			logger.Warning("Error while block user", msg.UserId, err)
+		}
+	}
+
+	return false
+}
+
+// coalesce keeps the last excess message of the user, it is answered when the limit allows.
+// The job of the chat is replaced by every new message.
+func coalesce(msg *messages.Message, after time.Duration) {
+	if jobs == nil {
+		logger.Warning("Drop message", msg.MessageID, "of user", msg.UserId, "without the scheduler")
+		return
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.Warning("Error while coalesce message", msg.MessageID, err)
+		return
+	}
+
+	err = jobs.Schedule(&scheduler.Job{
+		Id:     JOB_INBOUND + ":" + msg.LineId.String() + ":" + msg.UserId.String(),
+		Kind:   JOB_INBOUND,
+		At:     time.Now().Add(after),
+		LineId: msg.LineId,
+		UserId: msg.UserId,
+		Data:   data,
+	})
+	if err != nil {
+		logger.Warning("Error while coalesce message", msg.MessageID, err)
+	}
+}
+
+// flush processes the last coalesced message of the user
+func flush(db redis.UniversalClient, job *scheduler.Job) error {
+	var msg messages.Message
+	if err := json.Unmarshal(job.Data, &msg); err != nil {
+		logger.Warning("Error while decoding coalesced message", job.Id, err)
+		return nil
+	}
+
+	if _, err := limit(db, &msg, "flush", time.Now()); err != nil {
+		return err
+	}
+
+	handle(db, newMessage(msg))
+
+	return nil
+}
+
+func notice(db redis.UniversalClient, msg *message) {
+	chatState := getState(db, msg)
+
+	if _, err := SendMessage(msg.LineId, msg.UserId, say(msg, &chatState, PHRASE_TOO_MANY), nil); err != nil {
+		logger.Warning("Error while send notice", msg.LineId, msg.UserId, err)
+	}
+}
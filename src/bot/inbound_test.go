@@ -0,0 +1,140 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"connect-companion/bot/messages"
+	"connect-companion/config"
+
+	"github.com/google/uuid"
+)
+
+func configureInboundTest(t *testing.T, c config.Inbound) {
+	t.Helper()
+
+	cnf = &config.Conf{Inbound: c}
+	if err := configureInbound(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInboundLimits(t *testing.T) {
+	db := testRedis(t)
+	configureInboundTest(t, config.Inbound{Rate: 1, Burst: 2, LineRate: 10, LineBurst: 3})
+
+	now := time.Now()
+	line := uuid.New()
+	user := &messages.Message{LineId: line, UserId: uuid.New()}
+	other := &messages.Message{LineId: line, UserId: uuid.New()}
+
+	// Счетчики в redis: любой экземпляр видит сообщения, принятые другими
+	for i := 0; i < 2; i++ {
+		if r, err := limit(db, user, "admit", now); err != nil || !r.accepted {
+			t.Fatalf("message %d within burst: %+v, %v", i+1, r, err)
+		}
+	}
+
+	r, err := limit(db, user, "admit", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.accepted || r.delay <= 0 || r.delay > time.Second {
+		t.Errorf("message over the limit: %+v", r)
+	}
+
+	// Лимит линии общий для всех пользователей
+	if r, _ = limit(db, other, "admit", now); !r.accepted {
+		t.Fatal("message of another user is not accepted")
+	}
+	if r, _ = limit(db, other, "admit", now); r.accepted {
+		t.Error("line limit is exceeded")
+	}
+
+	// Через секунду накопился токен
+	if r, _ = limit(db, user, "admit", now.Add(time.Second)); !r.accepted {
+		t.Errorf("message after refill: %+v", r)
+	}
+}
+
+func TestInboundBlock(t *testing.T) {
+	db := testRedis(t)
+	configureInboundTest(t, config.Inbound{Rate: 1, BlockAfter: 2, BlockWindow: time.Minute})
+
+	now := time.Now()
+	msg := messages.Message{LineId: uuid.New(), UserId: uuid.New()}
+
+	if !admit(db, msg) {
+		t.Fatal("first message is not accepted")
+	}
+
+	if admit(db, msg) || isBlocked(db, msg.UserId) {
+		t.Fatal("user is blocked after the first excess message")
+	}
+
+	if admit(db, msg) || !isBlocked(db, msg.UserId) {
+		t.Error("user is not blocked after block_after excess messages")
+	}
+
+	// Нарушения старше окна не считаются
+	configureInboundTest(t, config.Inbound{Rate: 0.001, BlockAfter: 2, BlockWindow: time.Second})
+	next := &messages.Message{LineId: msg.LineId, UserId: uuid.New()}
+	_, _ = limit(db, next, "admit", now)
+	if r, _ := limit(db, next, "admit", now); r.block {
+		t.Fatal("blocked after the first excess message")
+	}
+	if r, _ := limit(db, next, "admit", now.Add(2*time.Second)); r.block {
+		t.Error("violation of the previous window is counted")
+	}
+}
+
+func TestInboundNotice(t *testing.T) {
+	db := testRedis(t)
+	configureInboundTest(t, config.Inbound{Rate: 1, Policy: INBOUND_NOTICE})
+
+	now := time.Now()
+	msg := &messages.Message{LineId: uuid.New(), UserId: uuid.New()}
+
+	_, _ = limit(db, msg, "admit", now)
+
+	if r, _ := limit(db, msg, "admit", now); !r.notice {
+		t.Error("first excess message is not noticed")
+	}
+	if r, _ := limit(db, msg, "admit", now); r.notice {
+		t.Error("user is noticed twice")
+	}
+
+	// После принятого сообщения предупреждаем снова
+	_, _ = limit(db, msg, "admit", now.Add(time.Second))
+	if r, _ := limit(db, msg, "admit", now.Add(time.Second)); !r.notice {
+		t.Error("notice is not reset by the accepted message")
+	}
+}
+
+func TestInboundCoalesce(t *testing.T) {
+	db := testRedis(t)
+	configureInboundTest(t, config.Inbound{Rate: 1, Policy: INBOUND_COALESCE})
+
+	now := time.Now()
+	msg := &messages.Message{LineId: uuid.New(), UserId: uuid.New()}
+
+	_, _ = limit(db, msg, "admit", now)
+
+	r, _ := limit(db, msg, "admit", now)
+	if r.accepted || r.delay <= 0 {
+		t.Fatalf("excess message: %+v", r)
+	}
+
+	// Пока отложенное сообщение не обработано, новые тоже откладываются
+	if r, _ = limit(db, msg, "admit", now.Add(2*time.Second)); r.accepted {
+		t.Error("message is accepted before the coalesced one")
+	}
+
+	if r, _ = limit(db, msg, "flush", now.Add(2*time.Second)); !r.accepted {
+		t.Fatal("coalesced message is not flushed")
+	}
+
+	if r, _ = limit(db, msg, "admit", now.Add(4*time.Second)); !r.accepted {
+		t.Errorf("message after the flush: %+v", r)
+	}
+}
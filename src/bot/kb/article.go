@@ -0,0 +1,171 @@
+package kb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ARTICLE_EXT = ".md"
+
+	FRONT_MATTER = "---"
+)
+
+type (
+	// Article is the Markdown file with the optional YAML front matter:
+	//
+	//	---
+	//	title: Сколько дней отпуска положено
+	//	tags: [отпуск]
+	//	synonyms: [отгул, каникулы]
+	//	documents: ["1"]
+	//	---
+	//	Text of the answer
+	Article struct {
+		// Имя файла без расширения, если не задан
+		Id    string   `yaml:"id"`
+		Title string   `yaml:"title"`
+		Tags  []string `yaml:"tags"`
+
+		// Слова, которыми пользователи называют то же самое
+		Synonyms []string `yaml:"synonyms"`
+
+		// Ids of documents sent with the article
+		Documents []string `yaml:"documents"`
+
+		Body string `yaml:"-"`
+		File string `yaml:"-"`
+	}
+)
+
+var (
+	heading  = regexp.MustCompile(`^#{1,6}\s+`)
+	emphasis = regexp.MustCompile(`(\*\*|\*|~~|` + "`" + `)(\S(?:.*?\S)?)(\*\*|\*|~~|` + "`" + `)`)
+	link     = regexp.MustCompile(`!?\[([^\]]*)\]\(([^)]*)\)`)
+	bullet   = regexp.MustCompile(`^(\s*)[-*+]\s+`)
+)
+
+// ParseArticle reads the front matter and the body of the article
+func ParseArticle(file string, data []byte) (*Article, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+
+	a := &Article{File: file}
+
+	// Front matter ограничен строками из одного «---», «----» или «---x» его не закрывают
+	if lines := strings.Split(text, "\n"); len(lines) > 1 && isDelimiter(lines[0]) {
+		end := -1
+		for i := 1; i < len(lines); i++ {
+			if isDelimiter(lines[i]) {
+				end = i
+				break
+			}
+		}
+
+		if end < 0 {
+			return nil, fmt.Errorf("%s: front matter is not closed", file)
+		}
+
+		if err := yaml.UnmarshalStrict([]byte(strings.Join(lines[1:end], "\n")), a); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+
+		text = strings.Join(lines[end+1:], "\n")
+	}
+
+	a.Body = strings.TrimSpace(text)
+
+	if a.Id == "" {
+		a.Id = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+
+	// Заголовок по умолчанию - первый заголовок Markdown
+	if a.Title == "" {
+		lines := strings.SplitN(a.Body, "\n", 2)
+		if heading.MatchString(lines[0]) {
+			a.Title = strings.TrimSpace(heading.ReplaceAllString(lines[0], ""))
+			if len(lines) > 1 {
+				a.Body = strings.TrimSpace(lines[1])
+			} else {
+				a.Body = ""
+			}
+		}
+	}
+
+	if a.Title == "" {
+		return nil, fmt.Errorf("%s: title is required", file)
+	}
+
+	if a.Body == "" {
+		return nil, fmt.Errorf("%s: body is empty", file)
+	}
+
+	return a, nil
+}
+
+func isDelimiter(line string) bool {
+	return strings.TrimRight(line, " \t") == FRONT_MATTER
+}
+
+// LoadArticles reads all *.md files of the directory
+func LoadArticles(dir string) ([]*Article, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+ARTICLE_EXT))
+	if err != nil {
+		return nil, err
+	}
+
+	articles := make([]*Article, 0, len(files))
+	ids := make(map[string]string, len(files))
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		a, err := ParseArticle(file, data)
+		if err != nil {
+			return nil, err
+		}
+
+		if other, ok := ids[a.Id]; ok {
+			return nil, fmt.Errorf("%s: id %q is used by %s", file, a.Id, other)
+		}
+		ids[a.Id] = file
+
+		articles = append(articles, a)
+	}
+
+	return articles, nil
+}
+
+// Text returns the body for the chat: markup is dropped, headings and lists are kept readable
+func (a *Article) Text() string {
+	lines := strings.Split(a.Body, "\n")
+
+	for i, line := range lines {
+		if heading.MatchString(line) {
+			line = strings.ToUpper(heading.ReplaceAllString(line, ""))
+		}
+
+		line = bullet.ReplaceAllString(line, "$1• ")
+		line = link.ReplaceAllStringFunc(line, func(s string) string {
+			m := link.FindStringSubmatch(s)
+			if m[1] == "" || m[1] == m[2] {
+				return m[2]
+			}
+			return m[1] + " (" + m[2] + ")"
+		})
+		line = emphasis.ReplaceAllString(line, "$2")
+
+		lines[i] = line
+	}
+
+	return strings.Join(lines, "\n")
+}
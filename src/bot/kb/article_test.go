@@ -0,0 +1,75 @@
+package kb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArticle(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Article
+	}{
+		{
+			"front matter",
+			"---\ntitle: Отпуск\ntags: [отпуск]\ndocuments: [\"1\"]\n---\nТекст статьи\n",
+			Article{Id: "vacation", Title: "Отпуск", Tags: []string{"отпуск"}, Documents: []string{"1"}, Body: "Текст статьи"},
+		},
+		{
+			"title of the heading",
+			"# Отпуск\n\nТекст статьи",
+			Article{Id: "vacation", Title: "Отпуск", Body: "Текст статьи"},
+		},
+		{
+			"empty front matter",
+			"---\n---\n# Отпуск\nТекст статьи",
+			Article{Id: "vacation", Title: "Отпуск", Body: "Текст статьи"},
+		},
+		{
+			"windows line breaks and bom",
+			"\xef\xbb\xbf---\r\nid: leave\r\ntitle: Отпуск\r\n---  \r\nТекст статьи",
+			Article{Id: "leave", Title: "Отпуск", Body: "Текст статьи"},
+		},
+		{
+			"longer line is not the delimiter",
+			"---\ntitle: Отпуск\n---\nТекст\n\n----\n\nпосле линии",
+			Article{Id: "vacation", Title: "Отпуск", Body: "Текст\n\n----\n\nпосле линии"},
+		},
+		{
+			"delimiter within the text",
+			"---\ntitle: \"---title\"\n---\n---foo",
+			Article{Id: "vacation", Title: "---title", Body: "---foo"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := ParseArticle("kb/vacation.md", []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.want.File = "kb/vacation.md"
+			if !reflect.DeepEqual(*a, tt.want) {
+				t.Errorf("article %+v, want %+v", *a, tt.want)
+			}
+		})
+	}
+
+	errors := map[string]string{
+		"not closed":         "---\ntitle: Отпуск\nТекст",
+		"closed by ----":     "---\ntitle: Отпуск\n----\nТекст",
+		"closed by ---foo":   "---\ntitle: Отпуск\n---foo\nТекст",
+		"unknown key":        "---\nname: Отпуск\n---\nТекст",
+		"no title":           "Текст без заголовка",
+		"empty body":         "---\ntitle: Отпуск\n---\n",
+		"heading of nothing": "# Отпуск",
+	}
+
+	for name, data := range errors {
+		if _, err := ParseArticle("vacation.md", []byte(data)); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package kb
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Вес совпадения в заголовке, тегах и синонимах относительно текста статьи
+	WEIGHT_TITLE   = 3
+	WEIGHT_TAG     = 3
+	WEIGHT_SYNONYM = 3
+	WEIGHT_BODY    = 1
+)
+
+var (
+	stopWords = map[string]bool{}
+)
+
+func init() {
+	for _, w := range strings.Fields(`и в во не что он на я с со как а то все она так его но да ты к у же вы за бы по
+		только ее мне было вот от меня еще нет о из ему теперь когда даже ну вдруг ли если уже или ни быть был него до
+		вас нибудь опять уж вам ведь там потом себя ничего ей может они тут где есть надо ней для мы тебя их чем была
+		сам чтоб без будто чего раз тоже себе под будет ж тогда кто этот того потому этого какой совсем ним здесь этом
+		один почти мой тем чтобы нее сейчас были куда зачем всех никогда можно при наконец два об другой хоть после
+		над больше тот через эти нас про всего них какая много разве три эту моя впрочем хорошо свою этой перед иногда
+		лучше чуть том нельзя такой им более всегда конечно всю между здравствуйте привет пожалуйста спасибо подскажите
+		скажите хочу хотел хотела нужно the a an and or of to in on for is are what how`) {
+		stopWords[w] = true
+	}
+}
+
+type (
+	Result struct {
+		Article *Article
+		Score   float64
+	}
+
+	// Index finds articles by TF-IDF of word stems
+	Index struct {
+		articles []*Article
+		scorer   *Scorer
+		byId     map[string]*Article
+	}
+)
+
+// Tokens splits the text into stems of words without stop words
+func Tokens(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		if stopWords[w] || len([]rune(w)) < 2 {
+			continue
+		}
+		tokens = append(tokens, Stem(w))
+	}
+
+	return tokens
+}
+
+func count(tf map[string]float64, text string, weight float64) {
+	for _, t := range Tokens(text) {
+		tf[t] += weight
+	}
+}
+
+func NewIndex(articles []*Article) *Index {
+	idx := &Index{
+		articles: articles,
+		byId:     make(map[string]*Article, len(articles)),
+	}
+
+	tfs := make([]map[string]float64, len(articles))
+
+	for i, a := range articles {
+		idx.byId[a.Id] = a
+
+		tf := map[string]float64{}
+		count(tf, a.Title, WEIGHT_TITLE)
+		count(tf, strings.Join(a.Tags, " "), WEIGHT_TAG)
+		count(tf, strings.Join(a.Synonyms, " "), WEIGHT_SYNONYM)
+		count(tf, a.Body, WEIGHT_BODY)
+
+		tfs[i] = tf
+	}
+
+	idx.scorer = NewScorer(tfs)
+
+	return idx
+}
+
+func (idx *Index) Len() int {
+	if idx == nil {
+		return 0
+	}
+
+	return len(idx.articles)
+}
+
+func (idx *Index) Article(id string) *Article {
+	if idx == nil {
+		return nil
+	}
+
+	return idx.byId[id]
+}
+
+// Search returns up to limit articles scored at least minScore, the best first
+func (idx *Index) Search(query string, limit int, minScore float64) []Result {
+	if idx.Len() == 0 {
+		return nil
+	}
+
+	terms := Terms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var results []Result
+	for i, a := range idx.articles {
+		score := idx.scorer.Score(i, terms)
+		if score > 0 && score >= minScore {
+			results = append(results, Result{Article: a, Score: score})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
@@ -0,0 +1,93 @@
+package kb
+
+import (
+	"testing"
+)
+
+func testIndex() *Index {
+	return NewIndex([]*Article{
+		{Id: "vacation", Title: "Сколько дней отпуска положено", Tags: []string{"отпуск"},
+			Body: "Ежегодный оплачиваемый отпуск составляет 28 календарных дней."},
+		{Id: "salary", Title: "Когда выплачивается зарплата", Synonyms: []string{"аванс", "получка"},
+			Body: "Зарплата выплачивается два раза в месяц. Отпускные выплачиваются за три дня до отпуска."},
+		{Id: "sick", Title: "Как оформить больничный",
+			Body: "Электронный больничный приходит автоматически, сообщите руководителю."},
+	})
+}
+
+func TestSearch(t *testing.T) {
+	idx := testIndex()
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// Заголовок и теги весят больше упоминания в тексте
+		{"отпуск", []string{"vacation", "salary"}},
+		{"Сколько дней в отпуске?", []string{"vacation", "salary"}},
+		{"когда получка", []string{"salary"}},
+		{"больничного", []string{"sick"}},
+		{"ипотека", nil},
+		{"как и что", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			results := idx.Search(tt.query, 10, 0)
+
+			if len(results) != len(tt.want) {
+				t.Fatalf("%d results, want %v", len(results), tt.want)
+			}
+
+			for i, id := range tt.want {
+				if results[i].Article.Id != id {
+					t.Errorf("result %d is %s, want %s", i, results[i].Article.Id, id)
+				}
+			}
+
+			for i := 1; i < len(results); i++ {
+				if results[i].Score > results[i-1].Score {
+					t.Errorf("results are not ordered by score: %v", results)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchScore(t *testing.T) {
+	idx := testIndex()
+
+	results := idx.Search("отпуск", 10, 0)
+	if len(results) != 2 {
+		t.Fatalf("%d results, want 2", len(results))
+	}
+
+	for _, r := range results {
+		if r.Score <= 0 || r.Score > 1 {
+			t.Errorf("score %f of %s is out of (0, 1]", r.Score, r.Article.Id)
+		}
+	}
+
+	// Порог отсекает случайное упоминание
+	if filtered := idx.Search("отпуск", 10, results[1].Score+0.01); len(filtered) != 1 || filtered[0].Article.Id != "vacation" {
+		t.Errorf("min score keeps %d results", len(filtered))
+	}
+
+	if limited := idx.Search("отпуск", 1, 0); len(limited) != 1 {
+		t.Errorf("limit keeps %d results", len(limited))
+	}
+
+	// Слово, которое есть во всех статьях, весит меньше редкого
+	common := NewIndex([]*Article{
+		{Id: "a", Title: "Справка", Body: "справка отпуск"},
+		{Id: "b", Title: "Справка", Body: "справка"},
+	})
+	if r := common.Search("справка отпуск", 10, 0); len(r) != 2 || r[0].Article.Id != "a" {
+		t.Errorf("rare word does not win: %v", r)
+	}
+
+	var empty *Index
+	if empty.Search("отпуск", 10, 0) != nil || empty.Len() != 0 || empty.Article("vacation") != nil {
+		t.Error("empty index finds something")
+	}
+}
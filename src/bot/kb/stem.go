@@ -0,0 +1,167 @@
+package kb
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Окончания русского стеммера Snowball, группы 1 допустимы только после «а» или «я»
+var (
+	perfectiveGerund1 = []string{"в", "вши", "вшись"}
+	perfectiveGerund2 = []string{"ив", "ивши", "ившись", "ыв", "ывши", "ывшись"}
+
+	adjective = []string{"ее", "ие", "ые", "ое", "ими", "ыми", "ей", "ий", "ый", "ой", "ем", "им", "ым", "ом",
+		"его", "ого", "ему", "ому", "их", "ых", "ую", "юю", "ая", "яя", "ою", "ею"}
+
+	participle1 = []string{"ем", "нн", "вш", "ющ", "щ"}
+	participle2 = []string{"ивш", "ывш", "ующ"}
+
+	reflexive = []string{"ся", "сь"}
+
+	verb1 = []string{"ла", "на", "ете", "йте", "ли", "й", "л", "ем", "н", "ло", "но", "ет", "ют", "ны", "ть", "ешь", "нно"}
+	verb2 = []string{"ила", "ыла", "ена", "ейте", "уйте", "ите", "или", "ыли", "ей", "уй", "ил", "ыл", "им", "ым", "ен",
+		"ило", "ыло", "ено", "ят", "ует", "уют", "ит", "ыт", "ены", "ить", "ыть", "ишь", "ую", "ю"}
+
+	noun = []string{"а", "ев", "ов", "ие", "ье", "е", "иями", "ями", "ами", "еи", "ии", "и", "ией", "ей", "ой", "ий", "й",
+		"иям", "ям", "ием", "ем", "ам", "ом", "о", "у", "ах", "иях", "ях", "ы", "ь", "ию", "ью", "ю", "ия", "ья", "я"}
+
+	superlative   = []string{"ейш", "ейше"}
+	derivational  = []string{"ост", "ость"}
+	russianVowels = "аеиоуыэюя"
+)
+
+func isVowel(r rune) bool {
+	return strings.ContainsRune(russianVowels, r)
+}
+
+// regions returns the start of RV and R2 of the word
+func regions(w []rune) (rv int, r2 int) {
+	rv = len(w)
+
+	for i, r := range w {
+		if isVowel(r) {
+			rv = i + 1
+			break
+		}
+	}
+
+	r2 = region(w, region(w, 0))
+
+	return rv, r2
+}
+
+// region returns the position after the first non-vowel following a vowel, starting at from
+func region(w []rune, from int) int {
+	for i := from + 1; i < len(w); i++ {
+		if !isVowel(w[i]) && isVowel(w[i-1]) {
+			return i + 1
+		}
+	}
+
+	return len(w)
+}
+
+// suffix returns the length of the longest suffix within w[start:], 0 if none matches.
+// With afterA the suffix must follow «а» or «я» inside the region.
+func suffix(w []rune, start int, list []string, afterA bool) int {
+	best := 0
+
+	for _, s := range list {
+		n := len([]rune(s))
+		if n <= best || len(w)-n < start || string(w[len(w)-n:]) != s {
+			continue
+		}
+
+		if afterA {
+			i := len(w) - n - 1
+			if i < start || (w[i] != 'а' && w[i] != 'я') {
+				continue
+			}
+		}
+
+		best = n
+	}
+
+	return best
+}
+
+// longest tries both groups and returns the longest match
+func longest(w []rune, start int, group1 []string, group2 []string) int {
+	n := suffix(w, start, group1, true)
+	if m := suffix(w, start, group2, false); m > n {
+		n = m
+	}
+
+	return n
+}
+
+// adjectival matches an adjective, optionally preceded by a participle
+func adjectival(w []rune, start int) int {
+	n := suffix(w, start, adjective, false)
+	if n == 0 {
+		return 0
+	}
+
+	if p := longest(w[:len(w)-n], start, participle1, participle2); p > 0 {
+		n += p
+	}
+
+	return n
+}
+
+// Stem returns the base of the russian word by the Snowball algorithm, other words are returned as is
+func Stem(word string) string {
+	w := []rune(strings.ReplaceAll(strings.ToLower(word), "ё", "е"))
+
+	for _, r := range w {
+		if !unicode.Is(unicode.Cyrillic, r) {
+			return string(w)
+		}
+	}
+
+	rv, r2 := regions(w)
+	if rv >= len(w) {
+		return string(w)
+	}
+
+	// Шаг 1
+	if n := longest(w, rv, perfectiveGerund1, perfectiveGerund2); n > 0 {
+		w = w[:len(w)-n]
+	} else {
+		if n := suffix(w, rv, reflexive, false); n > 0 {
+			w = w[:len(w)-n]
+		}
+
+		if n := adjectival(w, rv); n > 0 {
+			w = w[:len(w)-n]
+		} else if n := longest(w, rv, verb1, verb2); n > 0 {
+			w = w[:len(w)-n]
+		} else if n := suffix(w, rv, noun, false); n > 0 {
+			w = w[:len(w)-n]
+		}
+	}
+
+	// Шаг 2
+	if len(w) > rv && w[len(w)-1] == 'и' {
+		w = w[:len(w)-1]
+	}
+
+	// Шаг 3
+	if n := suffix(w, r2, derivational, false); n > 0 {
+		w = w[:len(w)-n]
+	}
+
+	// Шаг 4
+	if n := suffix(w, rv, []string{"нн"}, false); n > 0 {
+		w = w[:len(w)-1]
+	} else if n := suffix(w, rv, superlative, false); n > 0 {
+		w = w[:len(w)-n]
+		if suffix(w, rv, []string{"нн"}, false) > 0 {
+			w = w[:len(w)-1]
+		}
+	} else if suffix(w, rv, []string{"ь"}, false) > 0 {
+		w = w[:len(w)-1]
+	}
+
+	return string(w)
+}
@@ -0,0 +1,44 @@
+package kb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"отпуск", "отпуска", "отпуске", "отпусков", "Отпуск"}, "отпуск"},
+		{[]string{"зарплата", "зарплаты", "зарплату"}, "зарплат"},
+		{[]string{"справка", "справку", "справки"}, "справк"},
+		{[]string{"больничный", "больничного"}, "больничн"},
+		{[]string{"оформление", "оформления"}, "оформлен"},
+		{[]string{"оформить"}, "оформ"},
+		{[]string{"работать", "работающий"}, "работа"},
+		{[]string{"красивейший"}, "красив"},
+		{[]string{"Ёлка", "елки"}, "елк"},
+		// Не русские слова не меняются
+		{[]string{"vacation"}, "vacation"},
+		{[]string{"2020"}, "2020"},
+		{[]string{"на"}, "на"},
+	}
+
+	for _, tt := range tests {
+		for _, w := range tt.words {
+			if got := Stem(w); got != tt.want {
+				t.Errorf("Stem(%q) = %q, want %q", w, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	got := Tokens("Как оформить отпуск? Подскажите, пожалуйста, про ОТПУСКНЫЕ и 2020 год, я")
+	want := []string{"оформ", "отпуск", "отпускн", "2020", "год"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokens = %v, want %v", got, want)
+	}
+}
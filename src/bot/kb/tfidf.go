@@ -0,0 +1,72 @@
+package kb
+
+import (
+	"math"
+)
+
+// Scorer scores documents for the query by TF-IDF of word stems
+type Scorer struct {
+	// Веса основ слов по документам, нормированные на длину вектора документа
+	weights []map[string]float64
+}
+
+// NewScorer computes weights of documents from frequencies of their stems
+func NewScorer(tfs []map[string]float64) *Scorer {
+	s := &Scorer{weights: make([]map[string]float64, len(tfs))}
+
+	df := map[string]int{}
+	for _, tf := range tfs {
+		for t := range tf {
+			df[t]++
+		}
+	}
+
+	n := float64(len(tfs))
+
+	for i, tf := range tfs {
+		w := make(map[string]float64, len(tf))
+
+		var norm float64
+		for t, f := range tf {
+			w[t] = (1 + math.Log(f)) * math.Log(1+n/float64(df[t]))
+			norm += w[t] * w[t]
+		}
+
+		norm = math.Sqrt(norm)
+		if norm == 0 {
+			norm = 1
+		}
+		for t := range w {
+			w[t] /= norm
+		}
+
+		s.weights[i] = w
+	}
+
+	return s
+}
+
+// Terms returns the distinct stems of the query
+func Terms(query string) map[string]bool {
+	terms := map[string]bool{}
+	for _, t := range Tokens(query) {
+		terms[t] = true
+	}
+
+	return terms
+}
+
+// Score returns the score of the document i for terms of the query
+func (s *Scorer) Score(i int, terms map[string]bool) float64 {
+	var score float64
+	for t := range terms {
+		score += s.weights[i][t]
+	}
+
+	// Нормируем на длину вектора запроса, как в косинусной мере
+	if score > 0 {
+		score /= math.Sqrt(float64(len(terms)))
+	}
+
+	return score
+}
@@ -0,0 +1,29 @@
+package kb
+
+import (
+	"testing"
+)
+
+func TestScorer(t *testing.T) {
+	s := NewScorer([]map[string]float64{
+		{"отпуск": 3, "дн": 1},
+		{"отпуск": 1, "зарплат": 2},
+		{},
+	})
+
+	terms := Terms("отпуск")
+
+	if s.Score(0, terms) <= s.Score(1, terms) {
+		t.Errorf("score %f of the frequent term is not above %f", s.Score(0, terms), s.Score(1, terms))
+	}
+
+	if score := s.Score(2, terms); score != 0 {
+		t.Errorf("empty document scored %f", score)
+	}
+
+	// Векторы нормированы, оценка не больше косинуса
+	all := map[string]bool{"отпуск": true, "зарплат": true}
+	if score := s.Score(1, all); score > 1+1e-9 || score < s.Score(1, terms) {
+		t.Errorf("score %f is not a cosine", score)
+	}
+}
@@ -0,0 +1,350 @@
+package bot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"connect-companion/bot/fulltext"
+	"connect-companion/bot/kb"
+	"connect-companion/bot/requests"
+	"connect-companion/config"
+	"connect-companion/database"
+	"connect-companion/logger"
+)
+
+const (
+	PREFIX_ARTICLE = "article:"
+	PREFIX_FOUND   = "found:"
+
+	DEFAULT_KB_DIR     = "kb"
+	DEFAULT_KB_RESULTS = 3
+
+	// Длинные статьи делим на несколько сообщений
+	MAX_MESSAGE_LENGTH = 4000
+
+	STEP_SEARCH = "search"
+
+	// Какие документы ищем по тексту
+	DOCUMENTS_ALL  = "all"
+	DOCUMENTS_MENU = "menu"
+	DOCUMENTS_OFF  = "off"
+)
+
+// configureKnowledge indexes articles of the tenant, the knowledge base is off without the directory
+func (t *tenant) configureKnowledge() error {
+	c := t.conf.Knowledge
+
+	dir := c.Dir
+	if dir == "" {
+		dir = filepath.Join(t.conf.FilesDir, DEFAULT_KB_DIR)
+
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			t.kb = nil
+			return nil
+		}
+	} else if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("knowledge: %v", err)
+	}
+
+	if c.Results < 0 || c.MinScore < 0 || c.MinScore > 1 {
+		return fmt.Errorf("knowledge: results must not be negative and min_score must be from 0 to 1")
+	}
+
+	articles, err := kb.LoadArticles(dir)
+	if err != nil {
+		return fmt.Errorf("knowledge: %v", err)
+	}
+
+	for _, a := range articles {
+		for _, id := range a.Documents {
+			if t.documentById(id) == nil {
+				return fmt.Errorf("knowledge %s: unknown document %q", a.Id, id)
+			}
+		}
+	}
+
+	t.kb = kb.NewIndex(articles)
+
+	logger.Info("Knowledge base of", t.name, "has", len(articles), "articles")
+
+	return nil
+}
+
+// configureDocuments extracts text of PDF and DOCX documents for the full-text search,
+// the documents which can not be read are skipped
+func (t *tenant) configureDocuments() error {
+	c := t.conf.Knowledge
+	t.pages = nil
+
+	if c.DocumentMinScore < 0 || c.DocumentMinScore > 1 {
+		return fmt.Errorf("knowledge: document_min_score must be from 0 to 1")
+	}
+
+	dir := t.conf.FilesDir
+	if dir == "" {
+		dir = "."
+	}
+
+	var files []string
+	seen := map[string]bool{}
+
+	add := func(file string) {
+		if seen[file] || !fulltext.Supported(file) {
+			return
+		}
+		seen[file] = true
+
+		// Отсутствующие файлы меню показывает flow lint
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			files = append(files, file)
+		}
+	}
+
+	switch c.Documents {
+	case DOCUMENTS_OFF:
+		return nil
+	case "", DOCUMENTS_ALL:
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("knowledge: %v", err)
+		}
+
+		for _, e := range entries {
+			if !e.IsDir() {
+				add(e.Name())
+			}
+		}
+	case DOCUMENTS_MENU:
+	default:
+		return fmt.Errorf("knowledge: documents must be %s, %s or %s", DOCUMENTS_ALL, DOCUMENTS_MENU, DOCUMENTS_OFF)
+	}
+
+	for _, doc := range t.conf.Documents {
+		add(doc.File)
+	}
+
+	pages, errs := fulltext.Load(dir, files)
+	for _, err := range errs {
+		logger.Warning("Error while extract text of document", err)
+	}
+
+	t.pages = fulltext.NewIndex(pages)
+
+	logger.Info("Documents of", t.name, "have", len(pages), "pages with text in", len(files)-len(errs), "files")
+
+	return nil
+}
+
+func (t *tenant) searchLimit() int {
+	if t.conf.Knowledge.Results > 0 {
+		return t.conf.Knowledge.Results
+	}
+
+	return DEFAULT_KB_RESULTS
+}
+
+func articlesKeyboard(msg *message, chatState *database.Chat) *[][]requests.KeyboardKey {
+	var keyboard [][]requests.KeyboardKey
+
+	index := tenantOf(msg.LineId).kb
+
+	for _, id := range chatState.Articles {
+		if a := index.Article(id); a != nil {
+			keyboard = append(keyboard, []requests.KeyboardKey{{Id: PREFIX_ARTICLE + a.Id, Text: a.Title}})
+		}
+	}
+
+	for i, file := range chatState.Found {
+		keyboard = append(keyboard, []requests.KeyboardKey{{
+			Id:   PREFIX_FOUND + strconv.Itoa(i),
+			Text: sayWith(msg, chatState, LABEL_SEND_FOUND, map[string]string{"document": documentTitle(msg, chatState, file)}),
+		}})
+	}
+
+	keyboard = withNav(msg, chatState, keyboard, database.STATE_ARTICLES)
+
+	return &keyboard
+}
+
+// documentTitle returns the title of the document of the menu or the file name
+func documentTitle(msg *message, chatState *database.Chat, file string) string {
+	if doc := tenantOf(msg.LineId).documentByFile(file); doc != nil {
+		return say(msg, chatState, PREFIX_DOCUMENT+doc.Id)
+	}
+
+	return strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+}
+
+// searchArticles offers articles and documents matching the free text, ok is false when nothing is found.
+// The best fragment of documents is sent right away.
+func searchArticles(msg *message, chatState *database.Chat) (database.ChatState, bool, error) {
+	t := tenantOf(msg.LineId)
+
+	results := t.kb.Search(msg.Text, t.searchLimit(), t.conf.Knowledge.MinScore)
+	hits := t.pages.Search(msg.Text, t.searchLimit(), t.conf.Knowledge.DocumentMinScore)
+
+	// Закрытые документы не показываем даже фрагментом
+	allowed := hits[:0]
+	for _, h := range hits {
+		if permitsFile(msg, h.File) {
+			allowed = append(allowed, h)
+		}
+	}
+	hits = allowed
+
+	if len(results) == 0 && len(hits) == 0 {
+		return chatState.CurrentState, false, nil
+	}
+
+	visit(chatState, STEP_SEARCH)
+
+	chatState.Articles = make([]string, 0, len(results))
+	for _, r := range results {
+		chatState.Articles = append(chatState.Articles, r.Article.Id)
+	}
+
+	chatState.Found = make([]string, 0, len(hits))
+	for _, h := range hits {
+		chatState.Found = append(chatState.Found, h.File)
+	}
+
+	if len(hits) > 0 {
+		text := sayWith(msg, chatState, PHRASE_DOC_FOUND, map[string]string{
+			"document": documentTitle(msg, chatState, hits[0].File),
+			"page":     strconv.Itoa(hits[0].Page),
+			"snippet":  hits[0].Snippet,
+		})
+
+		if _, err := SendMessage(msg.LineId, msg.UserId, text, nil); err != nil {
+			state, err := checkErrorForSend(msg, err, database.STATE_ARTICLES)
+			return state, true, err
+		}
+	}
+
+	state, err := offerArticles(msg, chatState)
+
+	return state, true, err
+}
+
+func offerArticles(msg *message, chatState *database.Chat) (database.ChatState, error) {
+	phrase := PHRASE_KB_FOUND
+	if len(chatState.Articles) == 0 {
+		phrase = PHRASE_DOC_OFFER
+	}
+
+	_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, phrase), articlesKeyboard(msg, chatState))
+
+	return checkErrorForSend(msg, err, database.STATE_ARTICLES)
+}
+
+func processArticles(msg *message, chatState *database.Chat) (database.ChatState, error) {
+	choice := pick(articlesKeyboard(msg, chatState), msg.Text)
+
+	if strings.HasPrefix(choice, PREFIX_ARTICLE) {
+		if a := tenantOf(msg.LineId).kb.Article(strings.TrimPrefix(choice, PREFIX_ARTICLE)); a != nil {
+			return sendArticle(msg, chatState, a)
+		}
+	}
+
+	if strings.HasPrefix(choice, PREFIX_FOUND) {
+		i, err := strconv.Atoi(strings.TrimPrefix(choice, PREFIX_FOUND))
+		if err == nil && i >= 0 && i < len(chatState.Found) {
+			file := chatState.Found[i]
+
+			doc := tenantOf(msg.LineId).documentByFile(file)
+			if doc == nil {
+				doc = &config.Document{File: file}
+			}
+
+			chatState.Articles = nil
+			chatState.Found = nil
+
+			// Доступ мог измениться, пока пользователь выбирал
+			if !permits(msg, doc.Access) {
+				return refuse(msg, chatState, file)
+			}
+
+			return sendDocument(msg, chatState, doc)
+		}
+	}
+
+	// Пользователь уточнил вопрос
+	if state, ok, err := searchArticles(msg, chatState); ok {
+		return state, err
+	}
+
+	_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_SORRY), mainKeyboard(msg, chatState))
+
+	return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
+}
+
+// sendArticle sends the answer in one or more messages and documents of the article
+func sendArticle(msg *message, chatState *database.Chat, a *kb.Article) (database.ChatState, error) {
+	visit(chatState, PREFIX_ARTICLE+a.Id)
+	chatState.Articles = nil
+	chatState.Found = nil
+
+	t := tenantOf(msg.LineId)
+
+	for _, text := range splitMessage(a.Title + "\n\n" + a.Text()) {
+		if _, err := SendMessage(msg.LineId, msg.UserId, text, nil); err != nil {
+			return checkErrorForSend(msg, err, database.STATE_PARTING)
+		}
+	}
+
+	after := pause(msg, PACE_AFTER_FILE)
+	if len(a.Documents) == 0 {
+		after = 0
+	}
+
+	for _, id := range a.Documents {
+		doc := t.documentById(id)
+		if doc == nil || !permits(msg, doc.Access) {
+			continue
+		}
+
+		if _, err := SendFile(msg.LineId, msg.UserId, doc.File, t.documentPath(doc), nil, nil); err != nil {
+			logger.Warning("Error while send document", doc.File, "of article", a.Id, err)
+		}
+	}
+
+	err := later(msg, database.STATE_PARTING,
+		sendLater(after, say(msg, chatState, PHRASE_AGAIN), partingKeyboard(msg, chatState)))
+
+	return checkErrorForSend(msg, err, database.STATE_PARTING)
+}
+
+// splitMessage cuts the text by paragraphs, lines or words into messages not longer than the limit
+func splitMessage(text string) []string {
+	var parts []string
+
+	for len([]rune(text)) > MAX_MESSAGE_LENGTH {
+		runes := []rune(text)
+		head := string(runes[:MAX_MESSAGE_LENGTH])
+
+		cut := -1
+		for _, sep := range []string{"\n\n", "\n", " "} {
+			if i := strings.LastIndex(head, sep); i > 0 {
+				cut = i
+				break
+			}
+		}
+
+		if cut < 0 {
+			cut = len(head)
+		}
+
+		parts = append(parts, strings.TrimSpace(text[:cut]))
+		text = strings.TrimSpace(text[cut:])
+	}
+
+	if text != "" {
+		parts = append(parts, text)
+	}
+
+	return parts
+}
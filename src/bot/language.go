@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"strings"
+	"unicode"
+
+	"connect-companion/bot/requests"
+	"connect-companion/database"
+)
+
+const (
+	KEY_LANGUAGE = "lang"
+
+	// Буквы, которые есть в казахском, но не в русском алфавите
+	KAZAKH_LETTERS = "әғқңөұүһі"
+)
+
+// detectLanguage guesses the language of the text among available locales
+func (t *tenant) detectLanguage(text string) string {
+	var cyrillic, latin, kazakh int
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case strings.ContainsRune(KAZAKH_LETTERS, r):
+			kazakh++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+
+	var guess string
+	switch {
+	case kazakh > 0:
+		guess = "kk"
+	case cyrillic > 0 && cyrillic >= latin:
+		guess = "ru"
+	case latin > 0:
+		guess = "en"
+	}
+
+	if _, ok := t.catalogs[guess]; ok {
+		return guess
+	}
+
+	return t.defaultLocale
+}
+
+func languageKeyboard(msg *message) *[][]requests.KeyboardKey {
+	var keyboard [][]requests.KeyboardKey
+
+	t := tenantOf(msg.LineId)
+
+	for _, locale := range t.locales {
+		name := t.catalogs[locale].Render(PHRASE_LANGUAGE_NAME, templateData(msg, nil, nil))
+		keyboard = append(keyboard, []requests.KeyboardKey{{Id: locale, Text: name}})
+	}
+
+	return &keyboard
+}
+
+func chooseLanguage(msg *message, chatState *database.Chat) (database.ChatState, error) {
+	keyboard := withNav(msg, chatState, *languageKeyboard(msg), database.STATE_LANGUAGE)
+
+	_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_LANGUAGE_CHOOSE), &keyboard)
+
+	return checkErrorForSend(msg, err, database.STATE_LANGUAGE)
+}
+
+func processLanguage(msg *message, chatState *database.Chat) (database.ChatState, error) {
+	locale := pick(languageKeyboard(msg), msg.Text)
+	if locale == "" {
+		return chooseLanguage(msg, chatState)
+	}
+
+	chatState.Language = locale
+
+	_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_GREETING), mainKeyboard(msg, chatState))
+
+	return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
+}
@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"connect-companion/bot/access"
+	"connect-companion/bot/forms"
+	"connect-companion/bot/messages"
+	"connect-companion/bot/requests"
+	"connect-companion/database"
+)
+
+// LintFlow looks for mistakes in the dialog which do not stop the start:
+// missing files, ambiguous buttons and lines the bot does not serve.
+// Validate must be called before.
+func LintFlow() []string {
+	var problems []string
+
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, t := range allTenants() {
+		lintTenant(t, func(format string, args ...interface{}) {
+			report(t.name+": "+format, args...)
+		})
+	}
+
+	for _, r := range cnf.Routing.Rules {
+		for _, line := range r.Lines {
+			if !IsKnownLine(line) {
+				report("routing %s: line %s is not in the list of lines", r.Name, line)
+			}
+		}
+	}
+
+	// Свои правила арендатора видят только его линии
+	for _, t := range allTenants() {
+		if t == defaultTenant || t.router == defaultTenant.router {
+			continue
+		}
+
+		for _, r := range t.conf.Routing.Rules {
+			for _, line := range r.Lines {
+				if tenantOf(line) != t {
+					report("%s: routing %s: line %s is not a line of the tenant", t.name, r.Name, line)
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+func lintTenant(t *tenant, report func(string, ...interface{})) {
+	ids := make(map[string]bool)
+	for i := range t.conf.Documents {
+		doc := &t.conf.Documents[i]
+
+		if ids[doc.Id] {
+			report("document %s: duplicate id", doc.Id)
+		}
+		ids[doc.Id] = true
+
+		if _, err := os.Stat(t.documentPath(doc)); err != nil {
+			report("document %s: %v", doc.Id, err)
+		}
+
+		lintAccess(func(format string, args ...interface{}) {
+			report("document "+doc.Id+": "+format, args...)
+		}, doc.Access)
+	}
+
+	for _, f := range t.conf.Forms {
+		lintAccess(func(format string, args ...interface{}) {
+			report("form "+f.Id+": "+format, args...)
+		}, f.Access)
+
+		if f.Action.Type != forms.ACTION_DOCUMENT || !f.Action.Pdf {
+			continue
+		}
+
+		converter := t.conf.Generator.WithDefaults().Converter[0]
+		if _, err := exec.LookPath(converter); err != nil {
+			report("form %s: converter to PDF: %v", f.Id, err)
+		}
+	}
+
+	// Клавиатуры строятся по линии чата
+	msg := newMessage(messages.Message{})
+	if len(t.conf.Line) > 0 {
+		msg.LineId = t.conf.Line[0]
+	}
+
+	// Клавиатуры строим для чата, у которого есть история, чтобы увидеть все кнопки
+	for _, locale := range t.locales {
+		chatState := &database.Chat{
+			Language:     locale,
+			CurrentState: database.STATE_DUMMY,
+			History:      []database.ChatState{database.STATE_MAIN_MENU},
+		}
+
+		// Кнопку специалиста pickMenu узнает и в нерабочее время
+		specialist := *specialistKeyboard(msg, chatState)
+
+		lintKeyboard(report, locale, database.StateName(database.STATE_MAIN_MENU), append(*menuKeyboard(msg, chatState, false), specialist...))
+		lintKeyboard(report, locale, database.StateName(database.STATE_PARTING), append(*partingKeyboard(msg, chatState), specialist...))
+	}
+
+	for _, l := range t.conf.Schedule.Lines {
+		for _, line := range l.Lines {
+			if !IsKnownLine(line) {
+				report("schedule: line %s is not in the list of lines", line)
+			}
+		}
+	}
+}
+
+// lintAccess reports conditions of the rule which never match
+func lintAccess(report func(string, ...interface{}), rule access.Rule) {
+	if len(rule.Departments) > 0 && !cnf.Directory.Enabled() {
+		report("access by departments needs the directory")
+	}
+
+	// Группы из справочника заранее не известны
+	if cnf.Access.ProfileField != "" {
+		return
+	}
+
+	for _, g := range rule.Groups {
+		found := false
+		for name := range cnf.Access.Groups {
+			if strings.EqualFold(name, g) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			report("access group %s is unknown", g)
+		}
+	}
+}
+
+// lintKeyboard reports buttons which pick could confuse with each other
+func lintKeyboard(report func(string, ...interface{}), locale string, menu string, keyboard [][]requests.KeyboardKey) {
+	seen := make(map[string]string)
+
+	for _, row := range keyboard {
+		for _, k := range row {
+			for _, text := range []string{k.Id, strings.TrimSpace(k.Text)} {
+				text = strings.ToLower(text)
+				if text == "" {
+					report("%s %s: empty label of key %s", locale, menu, k.Id)
+					continue
+				}
+
+				if other, ok := seen[text]; ok && other != k.Id {
+					report("%s %s: %q matches keys %s and %s", locale, menu, text, other, k.Id)
+				}
+				seen[text] = k.Id
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"connect-companion/bot/routing"
+	"connect-companion/bot/schedule"
+	"connect-companion/config"
+
+	"github.com/google/uuid"
+)
+
+func TestLintFlow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "price.pdf"), []byte("%PDF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := cnf
+	t.Cleanup(func() { cnf = saved })
+
+	served, other := uuid.New(), uuid.New()
+	spec := uuid.New()
+
+	c := &config.Conf{
+		Line:     []uuid.UUID{served},
+		FilesDir: dir,
+		Documents: []config.Document{
+			{Id: "price", Title: "Прайс", File: "price.pdf"},
+			{Id: "price", Title: "Прайс", File: "old.pdf"},
+		},
+		Routing: routing.Config{Rules: []routing.Rule{
+			{Name: "vip", Lines: []uuid.UUID{other}, Spec: &spec},
+		}},
+		Schedule: schedule.Config{Lines: []schedule.Line{
+			{Lines: []uuid.UUID{other}, Hours: map[string]string{"mon": "09:00-18:00"}},
+		}},
+	}
+	if err = Validate(c); err != nil {
+		t.Fatal(err)
+	}
+
+	problems := LintFlow()
+
+	for _, want := range []string{"duplicate id", "old.pdf", "routing vip: line " + other.String(), "schedule: line " + other.String()} {
+		found := false
+		for _, p := range problems {
+			found = found || strings.Contains(p, want)
+		}
+
+		if !found {
+			t.Errorf("%q is not reported in %q", want, problems)
+		}
+	}
+
+	// Встроенные кнопки не путаются друг с другом
+	if len(problems) != 4 {
+		t.Errorf("problems %q", problems)
+	}
+}
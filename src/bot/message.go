@@ -0,0 +1,26 @@
+package bot
+
+import (
+	"connect-companion/bot/directory"
+	"connect-companion/bot/messages"
+	"connect-companion/bot/templates"
+)
+
+// message is the webhook message while the bot processes it. What the bot looks up on the way
+// is kept here, the webhook struct stays as 1C-Connect sends it.
+type message struct {
+	messages.Message
+
+	// Profile of the employee is looked up once while the message is processed
+	profile       *directory.Profile
+	profileLooked bool
+	// Data of phrases which does not change while the message is processed
+	data *templates.Data
+
+	// Steps with side effects which are done, the parked message keeps them for its replay
+	done map[string]string
+}
+
+func newMessage(m messages.Message) *message {
+	return &message{Message: m}
+}
@@ -0,0 +1,177 @@
+package bot
+
+import (
+	"net/http"
+
+	"connect-companion/bot/messages"
+	"connect-companion/bot/requests"
+	"connect-companion/database"
+	"connect-companion/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+const (
+	KEY_BACK = "back"
+	KEY_HOME = "home"
+
+	DEFAULT_MAX_DEPTH = 10
+)
+
+// Меню, в которые можно вернуться кнопкой «Назад»
+var navigable = map[database.ChatState]bool{
+	database.STATE_MAIN_MENU: true,
+	database.STATE_QUESTION:  true,
+	database.STATE_PARTING:   true,
+	database.STATE_LANGUAGE:  true,
+	database.STATE_ARTICLES:  true,
+}
+
+func maxDepth() int {
+	if cnf.Navigation.MaxDepth > 0 {
+		return cnf.Navigation.MaxDepth
+	}
+
+	return DEFAULT_MAX_DEPTH
+}
+
+// track pushes the left menu into the history of the chat
+func track(chatState *database.Chat, toState database.ChatState) {
+	switch {
+	case toState == database.STATE_GREETINGS || toState == database.STATE_DUMMY:
+		chatState.History = nil
+	case toState != chatState.CurrentState && navigable[chatState.CurrentState]:
+		chatState.History = append(chatState.History, chatState.CurrentState)
+
+		if over := len(chatState.History) - maxDepth(); over > 0 {
+			chatState.History = chatState.History[over:]
+		}
+	}
+}
+
+// canGoBack tells whether the history will be non-empty after moving to the state
+func canGoBack(chatState *database.Chat, toState database.ChatState) bool {
+	return len(chatState.History) > 0 || (navigable[chatState.CurrentState] && chatState.CurrentState != toState)
+}
+
+// withNav appends «Назад» and, outside of the main menu, «В начало» to the keyboard of the state
+func withNav(msg *message, chatState *database.Chat, keyboard [][]requests.KeyboardKey, toState database.ChatState) [][]requests.KeyboardKey {
+	var row []requests.KeyboardKey
+
+	if canGoBack(chatState, toState) {
+		row = append(row, key(msg, chatState, KEY_BACK, LABEL_BACK))
+	}
+
+	if toState != database.STATE_MAIN_MENU {
+		row = append(row, key(msg, chatState, KEY_HOME, LABEL_HOME))
+	}
+
+	if len(row) == 0 {
+		return keyboard
+	}
+
+	return append(keyboard, row)
+}
+
+// navigate handles «Назад» and «В начало» in menus
+func navigate(msg *message, chatState *database.Chat) (database.ChatState, bool, error) {
+	if !navigable[chatState.CurrentState] {
+		return chatState.CurrentState, false, nil
+	}
+
+	nav := [][]requests.KeyboardKey{{
+		key(msg, chatState, KEY_BACK, LABEL_BACK),
+		key(msg, chatState, KEY_HOME, LABEL_HOME),
+	}}
+
+	target := database.STATE_MAIN_MENU
+
+	switch pick(&nav, msg.Text) {
+	case KEY_BACK:
+		if n := len(chatState.History); n > 0 {
+			target = chatState.History[n-1]
+			chatState.History = chatState.History[:n-1]
+		}
+	case KEY_HOME:
+		chatState.History = nil
+	default:
+		return chatState.CurrentState, false, nil
+	}
+
+	// Возврат не должен попасть в историю
+	chatState.CurrentState = target
+
+	state, err := showState(msg, chatState, target)
+
+	return state, true, err
+}
+
+// showState repeats the prompt of the menu
+func showState(msg *message, chatState *database.Chat, state database.ChatState) (database.ChatState, error) {
+	switch state {
+	case database.STATE_PARTING:
+		_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_AGAIN), partingKeyboard(msg, chatState))
+
+		return checkErrorForSend(msg, err, database.STATE_PARTING)
+	case database.STATE_LANGUAGE:
+		return chooseLanguage(msg, chatState)
+	case database.STATE_QUESTION:
+		return askQuestion(msg, chatState)
+	case database.STATE_ARTICLES:
+		return offerArticles(msg, chatState)
+	default:
+		_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_GREETING), mainKeyboard(msg, chatState))
+
+		return checkErrorForSend(msg, err, database.STATE_MAIN_MENU)
+	}
+}
+
+func stateNames(states ...database.ChatState) []string {
+	names := make([]string, 0, len(states))
+
+	for _, state := range states {
+		names = append(names, database.StateName(state))
+	}
+
+	return names
+}
+
+// ChatInfo returns the state and navigation history of the chat for debugging
+func ChatInfo(c *gin.Context) {
+	db := c.MustGet("db").(redis.UniversalClient)
+
+	lineId, err := uuid.Parse(c.Param("line"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid line id"})
+		return
+	}
+
+	userId, err := uuid.Parse(c.Param("user"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	msg := newMessage(messages.Message{LineId: lineId, UserId: userId})
+
+	dbStateKey := stateKey(msg)
+	if n, err := db.Exists(dbStateKey).Result(); err != nil {
+		logger.Warning("Error while reading state from redis", err)
+
+		c.Status(http.StatusInternalServerError)
+		return
+	} else if n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no state"})
+		return
+	}
+
+	chatState := getState(db, msg)
+
+	c.JSON(http.StatusOK, gin.H{
+		"chat":    chatState,
+		"state":   database.StateName(chatState.CurrentState),
+		"history": stateNames(chatState.History...),
+	})
+}
@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"testing"
+
+	"connect-companion/config"
+	"connect-companion/database"
+)
+
+func TestTrack(t *testing.T) {
+	saved := cnf
+	t.Cleanup(func() { cnf = saved })
+
+	cnf = &config.Conf{Navigation: config.Navigation{MaxDepth: 2}}
+
+	chat := &database.Chat{CurrentState: database.STATE_MAIN_MENU}
+
+	steps := []database.ChatState{
+		database.STATE_QUESTION,
+		database.STATE_PARTING,
+		database.STATE_LANGUAGE,
+		database.STATE_MAIN_MENU,
+	}
+	for _, state := range steps {
+		track(chat, state)
+		chat.CurrentState = state
+	}
+
+	// Хранятся только последние max_depth меню
+	want := []database.ChatState{database.STATE_PARTING, database.STATE_LANGUAGE}
+	if len(chat.History) != len(want) || chat.History[0] != want[0] || chat.History[1] != want[1] {
+		t.Fatalf("history %v, want %v", stateNames(chat.History...), stateNames(want...))
+	}
+
+	// Повтор того же меню не попадает в историю
+	track(chat, database.STATE_MAIN_MENU)
+	if len(chat.History) != 2 {
+		t.Errorf("history %v after the same menu", stateNames(chat.History...))
+	}
+
+	// Новый диалог начинается без истории
+	track(chat, database.STATE_GREETINGS)
+	if len(chat.History) != 0 {
+		t.Errorf("history %v after greetings", stateNames(chat.History...))
+	}
+}
+
+func TestCanGoBack(t *testing.T) {
+	tests := []struct {
+		name    string
+		chat    database.Chat
+		toState database.ChatState
+		want    bool
+	}{
+		{"empty history", database.Chat{CurrentState: database.STATE_GREETINGS}, database.STATE_MAIN_MENU, false},
+		{"from menu", database.Chat{CurrentState: database.STATE_MAIN_MENU}, database.STATE_QUESTION, true},
+		{"same menu", database.Chat{CurrentState: database.STATE_MAIN_MENU}, database.STATE_MAIN_MENU, false},
+		{"with history", database.Chat{
+			CurrentState: database.STATE_QUESTION,
+			History:      []database.ChatState{database.STATE_MAIN_MENU},
+		}, database.STATE_QUESTION, true},
+	}
+
+	for _, tt := range tests {
+		if got := canGoBack(&tt.chat, tt.toState); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"connect-companion/bot/throttle"
+	"connect-companion/config"
+	"connect-companion/logger"
+	"connect-companion/metrics"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrUnavailable is returned without a request while 1C-Connect fails or throttles us,
+	// the chat keeps its state then
+	ErrUnavailable = errors.New("1C-Connect is unavailable")
+
+	outbound = throttle.Config{}.WithDefaults()
+
+	// Общий лимит и лимиты линий
+	globalBucket = throttle.NewBucket(0, 0)
+	lineBuckets  = map[uuid.UUID]*throttle.Bucket{}
+	bucketsMu    sync.Mutex
+
+	// Отдельный предохранитель на каждую учетную запись 1C-Connect
+	breakers   = map[string]*throttle.Breaker{}
+	breakersMu sync.Mutex
+
+	requestsTotal = metrics.NewCounter("connect_requests_total",
+		"Requests to 1C-Connect API by method and result: http code, error, throttled or circuit_open",
+		"method", "result")
+	throttledSeconds = metrics.NewCounter("connect_throttle_wait_seconds_total",
+		"Time requests to 1C-Connect waited for their turn or Retry-After")
+)
+
+func init() {
+	metrics.GaugeFunc("connect_requests_in_flight", "Requests to 1C-Connect API in flight", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(atomic.LoadInt64(&inflight))}}
+	})
+
+	metrics.GaugeFunc("connect_circuit_state", "Circuit breaker of the 1C-Connect account: 0 closed, 1 half-open, 2 open", func() []metrics.Sample {
+		breakersMu.Lock()
+		defer breakersMu.Unlock()
+
+		samples := make([]metrics.Sample, 0, len(breakers))
+		for account, b := range breakers {
+			samples = append(samples, metrics.Sample{Labels: []string{account}, Value: float64(b.State())})
+		}
+
+		return samples
+	}, "account")
+}
+
+func configureOutbound() error {
+	c := cnf.Outbound
+	if c.Rate < 0 || c.LineRate < 0 {
+		return errors.New("outbound: rates must not be negative")
+	}
+
+	bucketsMu.Lock()
+	globalBucket = throttle.NewBucket(c.Rate, c.Burst)
+	lineBuckets = map[uuid.UUID]*throttle.Bucket{}
+	bucketsMu.Unlock()
+
+	breakersMu.Lock()
+	breakers = map[string]*throttle.Breaker{}
+	breakersMu.Unlock()
+
+	outbound = c.WithDefaults()
+
+	return nil
+}
+
+func accountOf(connect config.Connect) string {
+	return connect.Login + "@" + connect.Server
+}
+
+func lineBucket(lineId uuid.UUID) *throttle.Bucket {
+	if lineId == uuid.Nil || outbound.LineRate == 0 {
+		return nil
+	}
+
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+
+	b, ok := lineBuckets[lineId]
+	if !ok {
+		b = throttle.NewBucket(outbound.LineRate, outbound.LineBurst)
+		lineBuckets[lineId] = b
+	}
+
+	return b
+}
+
+func breakerOf(connect config.Connect) *throttle.Breaker {
+	account := accountOf(connect)
+
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[account]
+	if !ok {
+		b = throttle.NewBreaker(outbound.BreakerFailures, outbound.BreakerOpenFor)
+		b.OnChange = func(from throttle.State, to throttle.State) {
+			logger.Warning("1C-Connect circuit of", account, "changed from", from, "to", to)
+		}
+		breakers[account] = b
+	}
+
+	return b
+}
+
+// acquire asks the breaker of the account and waits for the turn of the request,
+// tokens are taken only by requests which will be sent
+func acquire(connect config.Connect, lineId uuid.UUID) (*throttle.Breaker, error) {
+	breaker := breakerOf(connect)
+	if err := breaker.Allow(time.Now()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	// Не ждем дольше max_wait, сразу сообщаем о недоступности
+	delay, ok := throttle.Reserve(time.Now(), outbound.MaxWait, globalBucket, lineBucket(lineId))
+	if !ok {
+		breaker.Cancel()
+		return nil, fmt.Errorf("%w: throttled for %s", ErrUnavailable, delay.Round(time.Millisecond))
+	}
+
+	if delay > 0 {
+		throttledSeconds.Add(delay.Seconds())
+		time.Sleep(delay)
+	}
+
+	return breaker, nil
+}
+
+// throttled pauses requests after 429 and tells whether the request may be repeated
+func throttled(resp *http.Response, lineId uuid.UUID) bool {
+	d := throttle.RetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	until := time.Now().Add(d)
+
+	logger.Warning("1C-Connect throttles requests for", d)
+
+	globalBucket.Pause(until)
+	lineBucket(lineId).Pause(until)
+
+	return d <= outbound.MaxWait
+}
+
+func isUnavailable(err error) bool {
+	return errors.Is(err, ErrUnavailable)
+}
+
+// unavailableFor tells when requests of the line may be tried again
+func unavailableFor(lineId uuid.UUID) time.Duration {
+	now := time.Now()
+
+	d := breakerOf(connectOf(lineId)).RetryIn(now)
+	for _, b := range []*throttle.Bucket{globalBucket, lineBucket(lineId)} {
+		if wait := b.Delay(now); wait > d {
+			d = wait
+		}
+	}
+
+	return d
+}
+
+func checkBreakers() error {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	for account, b := range breakers {
+		if b.State() == throttle.STATE_OPEN {
+			return fmt.Errorf("circuit of %s is open", account)
+		}
+	}
+
+	return nil
+}
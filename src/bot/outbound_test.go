@@ -0,0 +1,62 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"connect-companion/bot/throttle"
+	"connect-companion/config"
+
+	"github.com/google/uuid"
+)
+
+func TestAcquireOpenBreaker(t *testing.T) {
+	cnf = &config.Conf{Outbound: throttle.Config{Rate: 1, Burst: 1, BreakerFailures: 1, BreakerOpenFor: time.Minute}}
+	if err := configureOutbound(); err != nil {
+		t.Fatal(err)
+	}
+
+	connect := config.Connect{Server: "https://connect.test", Login: "bot"}
+	b := breakerOf(connect)
+	if err := b.Allow(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	b.Done(time.Now(), false)
+
+	if _, err := acquire(connect, uuid.New()); !isUnavailable(err) {
+		t.Fatalf("acquire() = %v with the open breaker", err)
+	}
+
+	// Отклоненный запрос не расходует лимит
+	if !globalBucket.Full(time.Now()) {
+		t.Error("token is taken by the request which is not sent")
+	}
+}
+
+func TestAcquireThrottled(t *testing.T) {
+	cnf = &config.Conf{Outbound: throttle.Config{
+		Rate: 0.01, Burst: 1, MaxWait: time.Millisecond,
+		BreakerFailures: 1, BreakerOpenFor: time.Millisecond,
+	}}
+	if err := configureOutbound(); err != nil {
+		t.Fatal(err)
+	}
+
+	connect := config.Connect{Server: "https://connect.test", Login: "bot"}
+
+	b, err := acquire(connect, uuid.Nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Done(time.Now(), false)
+	time.Sleep(2 * time.Millisecond)
+
+	if _, err = acquire(connect, uuid.Nil); !isUnavailable(err) {
+		t.Fatalf("acquire() = %v over the limit", err)
+	}
+
+	// Заторможенный запрос не держит пробу предохранителя
+	if err = b.Allow(time.Now()); err != nil {
+		t.Errorf("probe is held by the throttled request: %v", err)
+	}
+}
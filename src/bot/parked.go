@@ -0,0 +1,106 @@
+package bot
+
+import (
+	"encoding/json"
+	"time"
+
+	"connect-companion/bot/messages"
+	"connect-companion/logger"
+	"connect-companion/scheduler"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	JOB_MESSAGE = "message"
+
+	// Через сколько повторить сообщение, которое не удалось обработать,
+	// дальше планировщик увеличивает паузу сам
+	PARK_DELAY = 5 * time.Second
+
+	// Шаги с последствиями, которые повтор сообщения не выполняет еще раз
+	STEP_FILE_SENDING = "file_sending"
+	STEP_DOCUMENT     = "document"
+	STEP_FILE_SENT    = "file_sent"
+	STEP_FORM_ACTION  = "form_action"
+	STEP_FORM_SENT    = "form_sent"
+)
+
+// parkedMessage is the webhook message waiting for its replay with steps done before it was parked
+type parkedMessage struct {
+	Message messages.Message  `json:"message"`
+	Done    map[string]string `json:"done,omitempty"`
+}
+
+// parkMessage schedules the message which could not be processed now, e.g. when the breaker
+// closes. It is dropped if there is no scheduler, e.g. in commands.
+func parkMessage(msg *message, after time.Duration) {
+	if jobs == nil {
+		logger.Warning("Drop message", msg.MessageID, "of user", msg.UserId, "without the scheduler")
+		return
+	}
+
+	data, err := json.Marshal(&parkedMessage{Message: msg.Message, Done: msg.done})
+	if err != nil {
+		logger.Warning("Error while park message", msg.MessageID, err)
+		return
+	}
+
+	if after < PARK_DELAY {
+		after = PARK_DELAY
+	}
+
+	err = jobs.Schedule(&scheduler.Job{
+		Id:     JOB_MESSAGE + ":" + msg.MessageID.String(),
+		Kind:   JOB_MESSAGE,
+		At:     time.Now().Add(after),
+		LineId: msg.LineId,
+		UserId: msg.UserId,
+		Data:   data,
+	})
+	if err != nil {
+		logger.Warning("Error while park message", msg.MessageID, err)
+	}
+}
+
+// replayMessage processes the parked message, the scheduler retries it with growing pauses
+// while the chat is busy or 1C-Connect is unavailable
+func replayMessage(db redis.UniversalClient, job *scheduler.Job) error {
+	var parked parkedMessage
+	if err := json.Unmarshal(job.Data, &parked); err != nil {
+		logger.Warning("Error while decoding parked message", job.Id, err)
+		return nil
+	}
+
+	msg := newMessage(parked.Message)
+	msg.done = parked.Done
+
+	logger.Info("Replay message", msg.MessageID, "of user", msg.UserId, "on line", msg.LineId)
+
+	err := handleLocked(db, msg)
+	if err != nil {
+		// Следующий повтор пропустит шаги, сделанные в этот раз
+		parked.Done = msg.done
+		if data, mErr := json.Marshal(&parked); mErr == nil {
+			job.Data = data
+		}
+	}
+
+	return err
+}
+
+// done tells whether the step was done before the message was parked and returns its result
+func done(msg *message, step string) (string, bool) {
+	result, ok := msg.done[step]
+
+	return result, ok
+}
+
+// markDone remembers the step, the replayed message skips it
+func markDone(msg *message, step string, result string) {
+	if msg.done == nil {
+		msg.done = make(map[string]string)
+	}
+
+	msg.done[step] = result
+}
@@ -0,0 +1,326 @@
+package bot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"connect-companion/bot/docgen"
+	"connect-companion/bot/forms"
+	"connect-companion/bot/messages"
+	"connect-companion/bot/throttle"
+	"connect-companion/config"
+	"connect-companion/database"
+	"connect-companion/scheduler"
+
+	"github.com/google/uuid"
+)
+
+// connectStub answers requests of the bot and counts them, failing ones return 500
+type connectStub struct {
+	mu     sync.Mutex
+	fail   bool
+	sent   int
+	failed int
+}
+
+func (s *connectStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail {
+		s.failed++
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s.sent++
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *connectStub) set(fail bool) {
+	s.mu.Lock()
+	s.fail = fail
+	s.mu.Unlock()
+}
+
+func (s *connectStub) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sent, s.failed
+}
+
+func TestReplayParkedForm(t *testing.T) {
+	db := testRedis(t)
+
+	connect := &connectStub{}
+	connectSrv := httptest.NewServer(connect)
+	t.Cleanup(connectSrv.Close)
+
+	var (
+		hooksMu sync.Mutex
+		hooks   int
+	)
+	hookSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hooksMu.Lock()
+		hooks++
+		hooksMu.Unlock()
+	}))
+	t.Cleanup(hookSrv.Close)
+
+	line := uuid.New()
+	c := &config.Conf{
+		Line:     []uuid.UUID{line},
+		Connect:  config.Connect{Server: connectSrv.URL, Login: "bot", Password: "password"},
+		Outbound: throttle.Config{BreakerFailures: 1, BreakerOpenFor: time.Hour},
+		Forms: []forms.Form{{
+			Id:     "leave",
+			Title:  "Отпуск",
+			Fields: []forms.Field{{Name: "days", Type: forms.FIELD_NUMBER}},
+			Action: forms.Action{Type: forms.ACTION_WEBHOOK, Url: hookSrv.URL},
+		}},
+	}
+	if err := Validate(c); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := messages.Message{
+		LineId:      line,
+		UserId:      uuid.New(),
+		MessageID:   uuid.New(),
+		MessageType: messages.MESSAGE_TEXT,
+		Text:        KEY_FORM_CONFIRM,
+	}
+
+	chat := database.NewChat()
+	chat.CurrentState = database.STATE_FORM
+	chat.Form = &database.FormState{Id: "leave", Step: 1, Answers: map[string]string{"days": "3"}}
+	if err := changeState(db, newMessage(msg), &chat, database.STATE_FORM); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(&parkedMessage{Message: msg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &scheduler.Job{Id: JOB_MESSAGE + ":" + msg.MessageID.String(), Kind: JOB_MESSAGE, Data: data}
+
+	// Форма отправлена, а 1C-Connect перестал отвечать на полпути
+	connect.set(true)
+	if err = replayMessage(db, job); !isUnavailable(err) {
+		t.Fatalf("replay = %v, want unavailable", err)
+	}
+
+	if hooks != 1 {
+		t.Fatalf("webhook called %d times, want once", hooks)
+	}
+
+	// Пока предохранитель открыт, повтор ничего не делает
+	if err = replayMessage(db, job); !isUnavailable(err) {
+		t.Fatalf("replay = %v with the open circuit", err)
+	}
+	if _, failed := connect.counts(); failed != 1 || hooks != 1 {
+		t.Errorf("replay with the open circuit sent %d requests and %d webhooks", failed, hooks)
+	}
+
+	if getState(db, newMessage(msg)).CurrentState != database.STATE_FORM {
+		t.Fatal("state of the unfinished message is saved")
+	}
+
+	// 1C-Connect вернулся: форма не отправляется второй раз
+	connect.set(false)
+	if err = configureOutbound(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = replayMessage(db, job); err != nil {
+		t.Fatal(err)
+	}
+
+	if hooks != 1 {
+		t.Errorf("webhook called %d times after replay, want once", hooks)
+	}
+
+	if sent, _ := connect.counts(); sent != 2 {
+		t.Errorf("%d messages sent after replay, want the notice and the question", sent)
+	}
+
+	if state := getState(db, newMessage(msg)).CurrentState; state != database.STATE_PARTING {
+		t.Errorf("state %d after replay, want parting", state)
+	}
+}
+
+func TestReplayParkedDocument(t *testing.T) {
+	db := testRedis(t)
+
+	dir, err := ioutil.TempDir("", "parked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	tpl := filepath.Join(dir, "leave.html")
+	if err = ioutil.WriteFile(tpl, []byte("<p>{{.Values.days}}</p>"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	connect := &connectStub{}
+	connectSrv := httptest.NewServer(connect)
+	t.Cleanup(connectSrv.Close)
+
+	line := uuid.New()
+	c := &config.Conf{
+		Line:      []uuid.UUID{line},
+		Connect:   config.Connect{Server: connectSrv.URL, Login: "bot", Password: "password"},
+		Outbound:  throttle.Config{BreakerFailures: 1, BreakerOpenFor: time.Hour},
+		Generator: docgen.Config{Dir: filepath.Join(dir, "generated")},
+		Forms: []forms.Form{{
+			Id:     "leave",
+			Title:  "Отпуск",
+			Fields: []forms.Field{{Name: "days", Type: forms.FIELD_NUMBER}},
+			Action: forms.Action{Type: forms.ACTION_DOCUMENT, Template: tpl, Name: "Заявление"},
+		}},
+	}
+	if err = Validate(c); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := messages.Message{
+		LineId:      line,
+		UserId:      uuid.New(),
+		MessageID:   uuid.New(),
+		MessageType: messages.MESSAGE_TEXT,
+		Text:        KEY_FORM_CONFIRM,
+	}
+
+	chat := database.NewChat()
+	chat.Form = &database.FormState{Id: "leave", Step: 1, Answers: map[string]string{"days": "3"}}
+	if err = changeState(db, newMessage(msg), &chat, database.STATE_FORM); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(&parkedMessage{Message: msg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &scheduler.Job{Id: JOB_MESSAGE + ":" + msg.MessageID.String(), Kind: JOB_MESSAGE, Data: data}
+
+	// Документ выпущен, но не отправлен
+	connect.set(true)
+	if err = replayMessage(db, job); !isUnavailable(err) {
+		t.Fatalf("replay = %v, want unavailable", err)
+	}
+
+	connect.set(false)
+	if err = configureOutbound(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = replayMessage(db, job); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := db.ZRange(database.KEY_GENERATED, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("%d documents issued, want one", len(ids))
+	}
+
+	g, err := readGenerated(db, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.Sent {
+		t.Error("sent document is not marked in the audit")
+	}
+
+	// Уведомление, файл и вопрос после него
+	if sent, _ := connect.counts(); sent != 3 {
+		t.Errorf("%d requests sent after replay, want 3", sent)
+	}
+}
+
+func TestReplayParkedFile(t *testing.T) {
+	db := testRedis(t)
+
+	dir, err := ioutil.TempDir("", "parked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "vacation.txt"), []byte("Отпуск"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	connect := &connectStub{}
+	connectSrv := httptest.NewServer(connect)
+	t.Cleanup(connectSrv.Close)
+
+	// Первый запрос линии проходит, следующий ждет дольше max_wait
+	line := uuid.New()
+	c := &config.Conf{
+		Line:      []uuid.UUID{line},
+		Connect:   config.Connect{Server: connectSrv.URL, Login: "bot", Password: "password"},
+		Outbound:  throttle.Config{LineRate: 0.001, LineBurst: 1, MaxWait: time.Millisecond},
+		FilesDir:  dir,
+		Documents: []config.Document{{Id: "1", Title: "Отпуск", File: "vacation.txt"}},
+	}
+	if err = Validate(c); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := messages.Message{
+		LineId:      line,
+		UserId:      uuid.New(),
+		MessageID:   uuid.New(),
+		MessageType: messages.MESSAGE_TEXT,
+		Text:        "1",
+	}
+
+	chat := database.NewChat()
+	if err = changeState(db, newMessage(msg), &chat, database.STATE_MAIN_MENU); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(&parkedMessage{Message: msg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &scheduler.Job{Id: JOB_MESSAGE + ":" + msg.MessageID.String(), Kind: JOB_MESSAGE, Data: data}
+
+	// Уведомление отправлено, а файл нет
+	if err = replayMessage(db, job); !isUnavailable(err) {
+		t.Fatalf("replay = %v, want unavailable", err)
+	}
+	if sent, _ := connect.counts(); sent != 1 {
+		t.Fatalf("%d messages sent, want the notice", sent)
+	}
+
+	cnf.Outbound = throttle.Config{}
+	if err = configureOutbound(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = replayMessage(db, job); err != nil {
+		t.Fatal(err)
+	}
+
+	// Уведомление не повторяется: файл и вопрос после него
+	if sent, _ := connect.counts(); sent != 3 {
+		t.Errorf("%d requests sent after replay, want 3", sent)
+	}
+
+	if state := getState(db, newMessage(msg)).CurrentState; state != database.STATE_PARTING {
+		t.Errorf("state %d after replay, want parting", state)
+	}
+}
@@ -0,0 +1,391 @@
+package bot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"connect-companion/bot/forms"
+	"connect-companion/bot/requests"
+	"connect-companion/bot/templates"
+	"connect-companion/config"
+	"connect-companion/database"
+	"connect-companion/logger"
+)
+
+const (
+	PHRASE_GREETING     = "greeting"
+	PHRASE_SORRY        = "sorry"
+	PHRASE_FILE_SENDING = "file_sending"
+	PHRASE_FILE_SENDED  = "file_sended"
+	PHRASE_AGAIN        = "again"
+	PHRASE_RETOUTING    = "rerouting"
+	PHRASE_BYE          = "bye"
+
+	PHRASE_OFF_HOURS       = "off_hours"
+	PHRASE_OPENING         = "opening"
+	PHRASE_ASK_QUESTION    = "ask_question"
+	PHRASE_QUESTION_SAVED  = "question_saved"
+	PHRASE_QUESTION_HANDED = "question_handed"
+	PHRASE_QUESTION_TEXT   = "question_text"
+
+	PHRASE_IDLE_REMIND = "idle_remind"
+	PHRASE_IDLE_CLOSE  = "idle_close"
+
+	PHRASE_SURVEY_RATING  = "survey_rating"
+	PHRASE_SURVEY_SORRY   = "survey_sorry"
+	PHRASE_SURVEY_COMMENT = "survey_comment"
+	PHRASE_SURVEY_THANKS  = "survey_thanks"
+
+	PHRASE_FORM_CONFIRM  = "form_confirm"
+	PHRASE_FORM_CHOOSE   = "form_choose"
+	PHRASE_FORM_SENT     = "form_sent"
+	PHRASE_FORM_FAILED   = "form_failed"
+	PHRASE_FORM_CANCELED = "form_canceled"
+
+	PHRASE_TOO_MANY = "too_many_messages"
+
+	PHRASE_KB_FOUND  = "kb_found"
+	PHRASE_DOC_FOUND = "doc_found"
+	PHRASE_DOC_OFFER = "doc_offer"
+
+	PHRASE_FORBIDDEN = "forbidden"
+
+	LABEL_CLOSE      = "key_close"
+	LABEL_SPECIALIST = "key_specialist"
+	LABEL_YES        = "key_yes"
+	LABEL_NO         = "key_no"
+	LABEL_SKIP       = "key_skip"
+	LABEL_BACK       = "key_back"
+	LABEL_CANCEL     = "key_cancel"
+	LABEL_CONFIRM    = "key_confirm"
+	LABEL_LANGUAGE   = "key_language"
+	LABEL_HOME       = "key_home"
+	LABEL_SEND_FOUND = "key_send_found"
+
+	PHRASE_LANGUAGE_NAME   = "language_name"
+	PHRASE_LANGUAGE_CHOOSE = "language_choose"
+
+	// Ключи документов и форм в каталоге фраз
+	PREFIX_DOCUMENT = "document:"
+	PREFIX_FORM     = "form:"
+
+	BUILTIN_LOCALE = "ru"
+)
+
+var (
+	defaultPhrases = map[string]string{
+		PHRASE_GREETING:     "Выберите, какая информация вас интересует:",
+		PHRASE_SORRY:        "Извините, но я вас не понимаю. Выберите, пожалуйста, один из вариантов:",
+		PHRASE_FILE_SENDING: "Сейчас пришлю соотвествующий файл, подождите.",
+		PHRASE_FILE_SENDED:  "Вот, пожалуйста.",
+		PHRASE_AGAIN:        "Могу ли я чем-то помочь еще?",
+		PHRASE_RETOUTING:    "Сейчас переведу, секундочку.",
+		PHRASE_BYE:          "Спасибо за обращение!",
+
+		PHRASE_OFF_HOURS:       "Сейчас специалисты не работают.",
+		PHRASE_OPENING:         "Сейчас специалисты не работают, они будут доступны {{.Extra.opening}}.",
+		PHRASE_ASK_QUESTION:    "Опишите, пожалуйста, ваш вопрос одним сообщением, и мы передадим его специалисту в начале рабочего дня.",
+		PHRASE_QUESTION_SAVED:  "Спасибо! Ваш вопрос будет передан специалисту, как только он появится на линии.",
+		PHRASE_QUESTION_HANDED: "Передаю ваш вопрос специалисту.",
+		PHRASE_QUESTION_TEXT:   "Вопрос, оставленный {{.Extra.time}}:\n{{.Extra.question}}",
+
+		PHRASE_IDLE_REMIND: "Вы еще здесь? Выберите, пожалуйста, один из вариантов:",
+		PHRASE_IDLE_CLOSE:  "Закрываю обращение, так как вы долго не отвечали. Если появятся вопросы, напишите нам снова!",
+
+		PHRASE_SURVEY_RATING:  "Спасибо за обращение! Оцените, пожалуйста, насколько я вам помог, от 1 до 5:",
+		PHRASE_SURVEY_SORRY:   "Выберите, пожалуйста, оценку от 1 до 5:",
+		PHRASE_SURVEY_COMMENT: "Хотите что-нибудь добавить? Напишите комментарий или нажмите «Пропустить».",
+		PHRASE_SURVEY_THANKS:  "Спасибо за отзыв!",
+
+		PHRASE_FORM_CONFIRM:  "Проверьте, пожалуйста, данные:",
+		PHRASE_FORM_CHOOSE:   "Подтвердите отправку или вернитесь назад.",
+		PHRASE_FORM_SENT:     "Готово, заявка отправлена.",
+		PHRASE_FORM_FAILED:   "Не удалось отправить заявку, попробуйте, пожалуйста, позже.",
+		PHRASE_FORM_CANCELED: "Заполнение отменено.",
+
+		forms.ERROR_EMPTY:  "Значение не может быть пустым.",
+		forms.ERROR_DATE:   "Введите дату в формате ДД.ММ.ГГГГ.",
+		forms.ERROR_NUMBER: "Введите число.",
+		forms.ERROR_MIN:    "Число должно быть не меньше {{.Extra.limit}}.",
+		forms.ERROR_MAX:    "Число должно быть не больше {{.Extra.limit}}.",
+		forms.ERROR_FORMAT: "Значение указано в неверном формате.",
+		forms.ERROR_PHONE:  "Введите номер телефона, например +7 900 123-45-67.",
+		forms.ERROR_EMAIL:  "Введите адрес электронной почты, например name@example.org.",
+		forms.ERROR_CHOICE: "Выберите один из вариантов.",
+		forms.ERROR_INN:    "Введите корректный ИНН из 10 или 12 цифр.",
+		forms.ERROR_SNILS:  "Введите корректный СНИЛС из 11 цифр.",
+
+		PHRASE_TOO_MANY: "Слишком много сообщений. Подождите, пожалуйста, немного, я отвечу на последнее.",
+
+		PHRASE_KB_FOUND:  "Возможно, ответ есть в одной из статей:",
+		PHRASE_DOC_FOUND: "Нашел в документе «{{.Extra.document}}», страница {{.Extra.page}}:\n\n{{.Extra.snippet}}",
+		PHRASE_DOC_OFFER: "Могу прислать документ целиком:",
+
+		PHRASE_FORBIDDEN: "К сожалению, этот раздел вам недоступен. Если он нужен для работы, обратитесь к руководителю или выберите другой пункт меню.",
+
+		LABEL_CLOSE:      "Закрыть обращение",
+		LABEL_SPECIALIST: "Перевести на специалиста",
+		LABEL_YES:        "Да",
+		LABEL_NO:         "Нет",
+		LABEL_SKIP:       "Пропустить",
+		LABEL_BACK:       "Назад",
+		LABEL_CANCEL:     "Отмена",
+		LABEL_CONFIRM:    "Подтвердить",
+		LABEL_LANGUAGE:   "Сменить язык",
+		LABEL_HOME:       "В начало",
+		LABEL_SEND_FOUND: "Прислать «{{.Extra.document}}»",
+
+		PHRASE_LANGUAGE_NAME:   "Русский",
+		PHRASE_LANGUAGE_CHOOSE: "Выберите язык:",
+	}
+
+	defaultDocuments = []config.Document{
+		{Id: "1", Title: "Памятка сотрудника", File: "Памятка сотрудника.pdf"},
+		{Id: "2", Title: "Положение о персонале", File: "Положение о персонале.pdf"},
+		{Id: "3", Title: "Регламент о пожеланиях", File: "Регламент.pdf"},
+	}
+)
+
+// configurePhrases parses phrases, titles of documents and forms for every locale,
+// so mistakes in templates stop the start
+func (t *tenant) configurePhrases() error {
+	cnf := t.conf
+
+	if len(cnf.Documents) == 0 {
+		cnf.Documents = defaultDocuments
+	}
+
+	sources := make(map[string]string, len(defaultPhrases))
+	for key, text := range defaultPhrases {
+		sources[key] = text
+	}
+
+	for key, text := range cnf.Phrases {
+		if _, ok := defaultPhrases[key]; !ok {
+			return fmt.Errorf("unknown phrase %q", key)
+		}
+		sources[key] = text
+	}
+
+	for _, d := range cnf.Documents {
+		sources[PREFIX_DOCUMENT+d.Id] = d.Title
+	}
+
+	for i := range cnf.Forms {
+		sources[PREFIX_FORM+cnf.Forms[i].Id] = cnf.Forms[i].Title
+
+		for key, text := range formPhrases(&cnf.Forms[i]) {
+			sources[key] = text
+		}
+	}
+
+	translations := map[string]map[string]string{
+		BUILTIN_LOCALE: nil,
+	}
+
+	if cnf.Locales.Dir != "" {
+		files, err := ioutil.ReadDir(cnf.Locales.Dir)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range files {
+			ext := filepath.Ext(fi.Name())
+			if fi.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".po") {
+				continue
+			}
+
+			phrases, err := templates.LoadLocale(filepath.Join(cnf.Locales.Dir, fi.Name()))
+			if err != nil {
+				return err
+			}
+
+			// Переводы документов и форм, которых нет в меню, не считаем ошибкой
+			for key := range phrases {
+				if _, ok := sources[key]; !ok && (strings.HasPrefix(key, PREFIX_DOCUMENT) || strings.HasPrefix(key, PREFIX_FORM)) {
+					logger.Warning("Skip translation of unknown", key, "in", fi.Name())
+					delete(phrases, key)
+				}
+			}
+
+			translations[strings.TrimSuffix(fi.Name(), ext)] = phrases
+		}
+	}
+
+	t.catalogs = make(map[string]*templates.Catalog, len(translations))
+	t.locales = nil
+
+	for locale, phrases := range translations {
+		c, err := templates.New(sources, phrases)
+		if err != nil {
+			return fmt.Errorf("locale %s: %v", locale, err)
+		}
+
+		t.catalogs[locale] = c
+		t.locales = append(t.locales, locale)
+	}
+
+	sort.Strings(t.locales)
+
+	t.defaultLocale = BUILTIN_LOCALE
+	if cnf.Locales.Default != "" {
+		if _, ok := t.catalogs[cnf.Locales.Default]; !ok {
+			return fmt.Errorf("no phrases for default locale %q", cnf.Locales.Default)
+		}
+
+		t.defaultLocale = cnf.Locales.Default
+	}
+
+	return nil
+}
+
+// catalog returns phrases in the language of the chat
+func (t *tenant) catalog(chatState *database.Chat) *templates.Catalog {
+	if chatState != nil {
+		if c, ok := t.catalogs[chatState.Language]; ok {
+			return c
+		}
+	}
+
+	return t.catalogs[t.defaultLocale]
+}
+
+func catalog(msg *message, chatState *database.Chat) *templates.Catalog {
+	return tenantOf(msg.LineId).catalog(chatState)
+}
+
+// templateData returns data of phrases. The part which does not change while the message
+// is processed is built once, the context and the document are taken from the chat every time.
+func templateData(msg *message, chatState *database.Chat, extra map[string]string) *templates.Data {
+	if msg.data == nil {
+		msg.data = &templates.Data{
+			UserId: msg.UserId.String(),
+			LineId: msg.LineId.String(),
+			Now:    time.Now(),
+		}
+
+		if profile := profileOf(msg); profile != nil {
+			msg.data.Profile = *profile
+		}
+	}
+
+	data := *msg.data
+	data.Context = map[string]string{}
+	data.Extra = extra
+
+	if chatState != nil {
+		if chatState.Context != nil {
+			data.Context = chatState.Context
+		}
+
+		t := tenantOf(msg.LineId)
+		if doc := t.documentByFile(chatState.Document); doc != nil {
+			data.Document = t.documentInfo(doc, t.catalog(chatState))
+		}
+	}
+
+	return &data
+}
+
+func say(msg *message, chatState *database.Chat, key string) string {
+	return catalog(msg, chatState).Render(key, templateData(msg, chatState, nil))
+}
+
+func sayWith(msg *message, chatState *database.Chat, key string, extra map[string]string) string {
+	return catalog(msg, chatState).Render(key, templateData(msg, chatState, extra))
+}
+
+func key(msg *message, chatState *database.Chat, id string, label string) requests.KeyboardKey {
+	return requests.KeyboardKey{Id: id, Text: say(msg, chatState, label)}
+}
+
+// pick returns id of the key matching the user reply by id or by label
+func pick(keyboard *[][]requests.KeyboardKey, text string) string {
+	if keyboard == nil {
+		return ""
+	}
+
+	text = strings.TrimSpace(text)
+
+	for _, row := range *keyboard {
+		for _, k := range row {
+			if strings.EqualFold(k.Id, text) || strings.EqualFold(strings.TrimSpace(k.Text), text) {
+				return k.Id
+			}
+		}
+	}
+
+	return ""
+}
+
+// setContext stores the value in the chat context bag
+func setContext(chatState *database.Chat, name string, value string) {
+	if chatState.Context == nil {
+		chatState.Context = make(map[string]string)
+	}
+
+	chatState.Context[name] = templates.Sanitize(value)
+}
+
+func (t *tenant) documentById(id string) *config.Document {
+	for i := range t.conf.Documents {
+		if t.conf.Documents[i].Id == id {
+			return &t.conf.Documents[i]
+		}
+	}
+
+	return nil
+}
+
+func (t *tenant) documentByFile(file string) *config.Document {
+	if file == "" {
+		return nil
+	}
+
+	for i := range t.conf.Documents {
+		if t.conf.Documents[i].File == file {
+			return &t.conf.Documents[i]
+		}
+	}
+
+	return nil
+}
+
+func (t *tenant) documentPath(doc *config.Document) string {
+	filePath, _ := filepath.Abs(filepath.Join(t.conf.FilesDir, doc.File))
+
+	return filePath
+}
+
+func (t *tenant) documentInfo(doc *config.Document, c *templates.Catalog) templates.Document {
+	info := t.files[doc.File]
+	info.Title = c.Render(PREFIX_DOCUMENT+doc.Id, &templates.Data{Context: map[string]string{}})
+
+	return info
+}
+
+// statDocuments remembers sizes and dates of menu files, they are read when the config is applied
+func (t *tenant) statDocuments() {
+	t.files = make(map[string]templates.Document, len(t.conf.Documents))
+
+	for i := range t.conf.Documents {
+		doc := &t.conf.Documents[i]
+
+		info := templates.Document{
+			Id:   doc.Id,
+			File: doc.File,
+		}
+
+		if fi, err := os.Stat(t.documentPath(doc)); err == nil {
+			info.Size = fi.Size()
+			info.Modified = fi.ModTime()
+		}
+
+		t.files[doc.File] = info
+	}
+}
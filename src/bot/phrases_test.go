@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"connect-companion/bot/messages"
+	"connect-companion/config"
+	"connect-companion/database"
+
+	"github.com/google/uuid"
+)
+
+func TestTemplateData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phrases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	file := filepath.Join(dir, "vacation.txt")
+	if err = ioutil.WriteFile(file, []byte("Отпуск"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	line := uuid.New()
+	c := &config.Conf{
+		Line:      []uuid.UUID{line},
+		FilesDir:  dir,
+		Documents: []config.Document{{Id: "1", Title: "Отпуск", File: "vacation.txt"}},
+	}
+	if err = Validate(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Файл читается при настройке, а не в каждой фразе
+	if err = os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := newMessage(messages.Message{LineId: line, UserId: uuid.New()})
+	chat := database.NewChat()
+	chat.Document = "vacation.txt"
+
+	first := templateData(msg, &chat, nil)
+	if first.Document.Size != int64(len("Отпуск")) || first.Document.Title != "Отпуск" {
+		t.Errorf("document %+v", first.Document)
+	}
+
+	// Контекст меняется по ходу сообщения, остальное собрано один раз
+	setContext(&chat, "city", "Казань")
+	second := templateData(msg, &chat, map[string]string{"n": "1"})
+	if second.Context["city"] != "Казань" || second.Extra["n"] != "1" {
+		t.Errorf("context %v and extra %v", second.Context, second.Extra)
+	}
+	if !second.Now.Equal(first.Now) || first.Extra != nil {
+		t.Error("data of the message is built again")
+	}
+}
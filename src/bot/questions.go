@@ -0,0 +1,172 @@
+package bot
+
+import (
+	"encoding/json"
+	"time"
+
+	"connect-companion/bot/messages"
+	"connect-companion/database"
+	"connect-companion/logger"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+const (
+	HANDOVER_INTERVAL = time.Minute
+
+	// После стольких неудачных передач вопрос попадает только в лог
+	HANDOVER_ATTEMPTS = 10
+)
+
+type (
+	// question left by the user outside of working hours
+	question struct {
+		UserId uuid.UUID `json:"user_id"`
+		Text   string    `json:"text"`
+		Time   time.Time `json:"time"`
+
+		Attempts int `json:"attempts,omitempty"`
+	}
+)
+
+func saveQuestion(db redis.UniversalClient, msg *message) error {
+	data, err := json.Marshal(question{
+		UserId: msg.UserId,
+		Text:   msg.Text,
+		Time:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	dbQuestionsKey := database.PREFIX_QUESTIONS + msg.LineId.String()
+
+	return db.RPush(dbQuestionsKey, data).Err()
+}
+
+// HandoverQuestions periodically hands questions collected outside of working hours
+// over to specialists once their line opens
+func HandoverQuestions(db redis.UniversalClient) {
+	ticker := time.NewTicker(HANDOVER_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !isLeader() {
+			continue
+		}
+
+		for _, lineId := range Lines() {
+			if isLineOpen(lineId) {
+				handoverLine(db, lineId)
+			}
+		}
+	}
+}
+
+// handoverLine reroutes questions of the line in order. The question is removed from the list
+// only after the specialist gets it, so failed and interrupted handovers are repeated.
+func handoverLine(db redis.UniversalClient, lineId uuid.UUID) {
+	dbQuestionsKey := database.PREFIX_QUESTIONS + lineId.String()
+
+	count, err := db.LLen(dbQuestionsKey).Result()
+	if err != nil {
+		logger.Warning("Error while reading questions from redis", err)
+		return
+	}
+
+	// Каждый вопрос пробуем не больше раза за проход
+	for ; count > 0; count-- {
+		raw, err := db.LIndex(dbQuestionsKey, 0).Result()
+		if err == redis.Nil {
+			return
+		} else if err != nil {
+			logger.Warning("Error while reading questions from redis", err)
+			return
+		}
+
+		var q question
+		if err = json.Unmarshal([]byte(raw), &q); err != nil {
+			logger.Warning("Drop question which can not be decoded", err, raw)
+			removeQuestion(db, dbQuestionsKey, raw, nil)
+			continue
+		}
+
+		err = handoverQuestion(lineId, &q)
+		if err == nil {
+			removeQuestion(db, dbQuestionsKey, raw, nil)
+			continue
+		}
+
+		logger.Warning("Get error while handover question on line", lineId, "for user", q.UserId, "with error", err)
+
+		// 1C-Connect недоступен: вопросы ждут следующего прохода в том же порядке
+		if isUnavailable(err) {
+			return
+		}
+
+		q.Attempts++
+		if q.Attempts >= HANDOVER_ATTEMPTS {
+			logger.Warning("Drop question of user", q.UserId, "on line", lineId, "after", q.Attempts, "attempts:", q.Text)
+			removeQuestion(db, dbQuestionsKey, raw, nil)
+			continue
+		}
+
+		// Вопрос, который не удается передать, не задерживает остальные
+		removeQuestion(db, dbQuestionsKey, raw, &q)
+	}
+}
+
+// handoverQuestion reroutes the treatment and sends the question into it for the specialist
+func handoverQuestion(lineId uuid.UUID, q *question) error {
+	logger.Info("Handover question of user", q.UserId, "on line", lineId)
+
+	msg := newMessage(messages.Message{
+		LineId: lineId,
+		UserId: q.UserId,
+		Text:   q.Text,
+	})
+
+	if _, err := rerouteTreatment(msg, "", INTENT_QUESTION); err != nil {
+		return err
+	}
+
+	_, _ = SendMessage(lineId, q.UserId, say(msg, nil, PHRASE_QUESTION_HANDED), nil)
+
+	// Время вопроса по часам линии
+	loc := time.Local
+	if sch := tenantOf(lineId).schedules.ForLine(lineId); sch != nil {
+		loc = sch.Location()
+	}
+
+	text := sayWith(msg, nil, PHRASE_QUESTION_TEXT, map[string]string{
+		"question": q.Text,
+		"time":     q.Time.In(loc).Format("02.01.2006 15:04"),
+	})
+
+	if _, err := SendMessage(lineId, q.UserId, text, nil); err != nil {
+		logger.Warning("Error while send question of user", q.UserId, "on line", lineId, err)
+	}
+
+	return nil
+}
+
+// removeQuestion drops the handled question, the failed one is put at the end of the list
+func removeQuestion(db redis.UniversalClient, key string, raw string, failed *question) {
+	_, err := db.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.LRem(key, 1, raw)
+
+		if failed != nil {
+			data, err := json.Marshal(failed)
+			if err != nil {
+				return err
+			}
+			pipe.RPush(key, data)
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Warning("Error while remove question from redis", err)
+	}
+}
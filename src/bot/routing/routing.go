@@ -0,0 +1,358 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"connect-companion/bot/directory"
+	"connect-companion/database"
+	"connect-companion/logger"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+const (
+	STRATEGY_ROUND_ROBIN  = "round_robin"
+	STRATEGY_LEAST_RECENT = "least_recent"
+)
+
+type (
+	Config struct {
+		Rules []Rule `yaml:"rules"`
+	}
+
+	// Rule matches when every non-empty condition matches the request
+	Rule struct {
+		Name string `yaml:"name"`
+
+		// Topics and intents are set by the bot flow: the topic is where the treatment is escalated
+		// from (main_menu, parting), the intent is what for (specialist, file, question).
+		// The text is not analysed, only keywords look into it. Hours are the clock of the line
+		// schedule timezone.
+		Topics   []string    `yaml:"topics"`
+		Intents  []string    `yaml:"intents"`
+		Keywords []string    `yaml:"keywords"`
+		Hours    string      `yaml:"hours"`
+		Users    []uuid.UUID `yaml:"users"`
+		Lines    []uuid.UUID `yaml:"lines"`
+		// Fields of the employee profile, e.g. department: [Бухгалтерия], any value of a field matches
+		Profile map[string][]string `yaml:"profile"`
+
+		Spec     *uuid.UUID  `yaml:"spec"`
+		Pool     []uuid.UUID `yaml:"pool"`
+		Strategy string      `yaml:"strategy"`
+	}
+
+	Request struct {
+		LineId uuid.UUID
+		UserId uuid.UUID
+		Topic  string
+		Intent string
+		Text   string
+		// Hours of rules are compared with the clock of this time, so it is in the line timezone
+		Time time.Time
+		// nil if the directory is off or the user is unknown
+		Profile *directory.Profile
+	}
+
+	Router struct {
+		rules []*rule
+
+		// Очередь пулов общая для всех экземпляров, без redis она в памяти
+		db redis.UniversalClient
+	}
+
+	rule struct {
+		Rule
+
+		from, to time.Duration
+
+		// Пулы разных арендаторов с одинаковыми именами правил не смешиваются
+		scope string
+
+		mu       sync.Mutex
+		next     int
+		assigned map[uuid.UUID]time.Time
+	}
+)
+
+func NewRouter(c Config) (*Router, error) {
+	r := &Router{}
+
+	for i := range c.Rules {
+		rl := &rule{
+			Rule:     c.Rules[i],
+			assigned: make(map[uuid.UUID]time.Time),
+		}
+
+		if rl.Name == "" {
+			rl.Name = fmt.Sprintf("rule #%d", i+1)
+		}
+
+		for _, other := range r.rules {
+			if other.Name == rl.Name {
+				return nil, fmt.Errorf("routing %s: duplicate name", rl.Name)
+			}
+		}
+
+		if rl.Spec == nil && len(rl.Pool) == 0 {
+			return nil, fmt.Errorf("routing %s: spec or pool is required", rl.Name)
+		}
+
+		switch rl.Strategy {
+		case "":
+			rl.Strategy = STRATEGY_ROUND_ROBIN
+		case STRATEGY_ROUND_ROBIN, STRATEGY_LEAST_RECENT:
+		default:
+			return nil, fmt.Errorf("routing %s: unknown strategy %q", rl.Name, rl.Strategy)
+		}
+
+		if rl.Hours != "" {
+			var err error
+			if rl.from, rl.to, err = parseHours(rl.Hours); err != nil {
+				return nil, fmt.Errorf("routing %s: %v", rl.Name, err)
+			}
+		}
+
+		r.rules = append(r.rules, rl)
+	}
+
+	return r, nil
+}
+
+// Share keeps the state of pools in redis, so all instances take turns in one queue.
+// Routers of different scopes keep separate turns.
+func (r *Router) Share(db redis.UniversalClient, scope string) {
+	r.db = db
+	for _, rl := range r.rules {
+		rl.scope = scope
+	}
+}
+
+// Route returns specialists of the first matching rule in the order to try them:
+// the one picked by the strategy first, then the rest of the pool
+func (r *Router) Route(req Request) (specs []uuid.UUID, name string, ok bool) {
+	for _, rl := range r.rules {
+		if !rl.match(req) {
+			continue
+		}
+
+		return rl.order(r.db), rl.Name, true
+	}
+
+	return nil, "", false
+}
+
+// Assigned remembers the specialist who took the treatment by the rule, least_recent picks by it
+func (r *Router) Assigned(name string, specId uuid.UUID, now time.Time) {
+	for _, rl := range r.rules {
+		if rl.Name != name {
+			continue
+		}
+
+		rl.mu.Lock()
+		rl.assigned[specId] = now
+		rl.mu.Unlock()
+
+		if r.db != nil {
+			err := r.db.HSet(rl.key("assigned"), specId.String(), now.UnixNano()/int64(time.Millisecond)).Err()
+			if err != nil {
+				logger.Warning("Error while save assignment of routing", name, err)
+			}
+		}
+
+		return
+	}
+}
+
+func (rl *rule) match(req Request) bool {
+	if len(rl.Topics) > 0 && !containsString(rl.Topics, req.Topic) {
+		return false
+	}
+
+	if len(rl.Intents) > 0 && !containsString(rl.Intents, req.Intent) {
+		return false
+	}
+
+	if len(rl.Keywords) > 0 {
+		text := strings.ToLower(req.Text)
+
+		found := false
+		for _, k := range rl.Keywords {
+			if strings.Contains(text, strings.ToLower(k)) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if rl.Hours != "" {
+		t := req.Time
+		now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+
+		if rl.from <= rl.to {
+			if now < rl.from || now >= rl.to {
+				return false
+			}
+		} else if now < rl.from && now >= rl.to {
+			// Interval crosses midnight, e.g. 22:00-06:00
+			return false
+		}
+	}
+
+	if len(rl.Users) > 0 && !containsUUID(rl.Users, req.UserId) {
+		return false
+	}
+
+	if len(rl.Lines) > 0 && !containsUUID(rl.Lines, req.LineId) {
+		return false
+	}
+
+	for field, values := range rl.Profile {
+		if !containsString(values, req.Profile.Field(field)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rl *rule) key(name string) string {
+	return database.PREFIX_ROUTING + rl.scope + rl.Name + ":" + name
+}
+
+// order returns the pool starting from the specialist whose turn it is
+func (rl *rule) order(db redis.UniversalClient) []uuid.UUID {
+	if rl.Spec != nil {
+		return []uuid.UUID{*rl.Spec}
+	}
+
+	pool := make([]uuid.UUID, len(rl.Pool))
+	copy(pool, rl.Pool)
+
+	switch rl.Strategy {
+	case STRATEGY_LEAST_RECENT:
+		assigned := rl.lastAssigned(db)
+
+		sort.SliceStable(pool, func(i, j int) bool {
+			return assigned[pool[i]].Before(assigned[pool[j]])
+		})
+	default:
+		start := rl.turn(db) % len(pool)
+		pool = append(pool[start:], pool[:start]...)
+	}
+
+	return pool
+}
+
+// turn moves the round robin cursor and returns its previous position
+func (rl *rule) turn(db redis.UniversalClient) int {
+	if db != nil {
+		n, err := db.Incr(rl.key("next")).Result()
+		if err == nil {
+			return int((n - 1) % int64(len(rl.Pool)))
+		}
+
+		logger.Warning("Error while take turn of routing", rl.Name, "in redis", err)
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	n := rl.next
+	rl.next++
+
+	return n
+}
+
+// lastAssigned returns when specialists of the pool took treatments, zero time if never
+func (rl *rule) lastAssigned(db redis.UniversalClient) map[uuid.UUID]time.Time {
+	assigned := make(map[uuid.UUID]time.Time, len(rl.Pool))
+
+	if db != nil {
+		fields := make([]string, len(rl.Pool))
+		for i, id := range rl.Pool {
+			fields[i] = id.String()
+		}
+
+		values, err := db.HMGet(rl.key("assigned"), fields...).Result()
+		if err == nil {
+			for i, v := range values {
+				if s, ok := v.(string); ok {
+					if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+						assigned[rl.Pool[i]] = time.Unix(0, ms*int64(time.Millisecond))
+					}
+				}
+			}
+
+			return assigned
+		}
+
+		logger.Warning("Error while read assignments of routing", rl.Name, "from redis", err)
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	for id, t := range rl.assigned {
+		assigned[id] = t
+	}
+
+	return assigned
+}
+
+func parseHours(s string) (from time.Duration, to time.Duration, err error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("hours must be in format HH:MM-HH:MM")
+	}
+
+	if from, err = parseClock(parts[0]); err != nil {
+		return 0, 0, err
+	}
+
+	if to, err = parseClock(parts[1]); err != nil {
+		return 0, 0, err
+	}
+
+	return from, to, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func containsString(list []string, s string) bool {
+	for i := range list {
+		if strings.EqualFold(list[i], s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsUUID(list []uuid.UUID, id uuid.UUID) bool {
+	for i := range list {
+		if list[i] == id {
+			return true
+		}
+	}
+
+	return false
+}
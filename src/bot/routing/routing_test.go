@@ -0,0 +1,285 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"connect-companion/bot/directory"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+var (
+	spec  = uuid.MustParse("3f0e2c4a-1b6c-4b7e-9d4f-1d2b3c4d5e6f")
+	user  = uuid.MustParse("5a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d")
+	line  = uuid.MustParse("6b2c3d4e-5f6a-4b7c-9d8e-0f1a2b3c4d5e")
+	other = uuid.MustParse("7c3d4e5f-6a7b-4c8d-9e0f-1a2b3c4d5e6f")
+)
+
+func at(clock string) time.Time {
+	t, _ := time.Parse("2006-01-02 15:04", "2020-03-02 "+clock)
+	return t
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		req  Request
+		want bool
+	}{
+		{"no conditions", Rule{}, Request{}, true},
+
+		{"topic", Rule{Topics: []string{"main_menu"}}, Request{Topic: "main_menu"}, true},
+		{"topic case", Rule{Topics: []string{"Main_Menu"}}, Request{Topic: "main_menu"}, true},
+		{"other topic", Rule{Topics: []string{"main_menu"}}, Request{Topic: "parting"}, false},
+
+		{"intent", Rule{Intents: []string{"file", "question"}}, Request{Intent: "question"}, true},
+		{"other intent", Rule{Intents: []string{"file"}}, Request{Intent: "specialist"}, false},
+
+		{"keyword", Rule{Keywords: []string{"отпуск"}}, Request{Text: "Когда мой ОТПУСК?"}, true},
+		{"one of keywords", Rule{Keywords: []string{"зарплата", "аванс"}}, Request{Text: "нет аванса"}, true},
+		{"no keyword", Rule{Keywords: []string{"отпуск"}}, Request{Text: "справка"}, false},
+		{"keyword without text", Rule{Keywords: []string{"отпуск"}}, Request{}, false},
+
+		{"in hours", Rule{Hours: "09:00-18:00"}, Request{Time: at("09:00")}, true},
+		{"end of hours", Rule{Hours: "09:00-18:00"}, Request{Time: at("18:00")}, false},
+		{"before hours", Rule{Hours: "09:00-18:00"}, Request{Time: at("08:59")}, false},
+		{"night before midnight", Rule{Hours: "22:00-06:00"}, Request{Time: at("23:30")}, true},
+		{"night after midnight", Rule{Hours: "22:00-06:00"}, Request{Time: at("05:59")}, true},
+		{"day out of night", Rule{Hours: "22:00-06:00"}, Request{Time: at("12:00")}, false},
+		{"end of night", Rule{Hours: "22:00-06:00"}, Request{Time: at("06:00")}, false},
+		{"clock of request timezone", Rule{Hours: "09:00-18:00"}, Request{Time: at("07:00").In(time.FixedZone("MSK", 3*60*60))}, true},
+
+		{"user", Rule{Users: []uuid.UUID{user}}, Request{UserId: user}, true},
+		{"other user", Rule{Users: []uuid.UUID{user}}, Request{UserId: other}, false},
+		{"line", Rule{Lines: []uuid.UUID{line}}, Request{LineId: line}, true},
+		{"other line", Rule{Lines: []uuid.UUID{line}}, Request{LineId: other}, false},
+
+		{"department", Rule{Profile: map[string][]string{"department": {"Бухгалтерия"}}},
+			Request{Profile: &directory.Profile{Department: "бухгалтерия"}}, true},
+		{"other department", Rule{Profile: map[string][]string{"department": {"Бухгалтерия"}}},
+			Request{Profile: &directory.Profile{Department: "Склад"}}, false},
+		{"attribute", Rule{Profile: map[string][]string{"grade": {"senior", "lead"}}},
+			Request{Profile: &directory.Profile{Attributes: map[string]string{"grade": "lead"}}}, true},
+		{"all fields", Rule{Profile: map[string][]string{"department": {"IT"}, "location": {"Москва"}}},
+			Request{Profile: &directory.Profile{Department: "IT", Location: "Казань"}}, false},
+		{"unknown user", Rule{Profile: map[string][]string{"department": {"IT"}}}, Request{}, false},
+
+		{"all conditions", Rule{Intents: []string{"file"}, Lines: []uuid.UUID{line}, Hours: "09:00-18:00"},
+			Request{Intent: "file", LineId: line, Time: at("10:00")}, true},
+		{"one condition fails", Rule{Intents: []string{"file"}, Lines: []uuid.UUID{line}, Hours: "09:00-18:00"},
+			Request{Intent: "file", LineId: line, Time: at("20:00")}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Spec = &spec
+
+			r, err := NewRouter(Config{Rules: []Rule{tt.rule}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := r.rules[0].match(tt.req); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRouterErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+	}{
+		{"no spec", []Rule{{Name: "a"}}},
+		{"unknown strategy", []Rule{{Spec: &spec, Strategy: "random"}}},
+		{"invalid hours", []Rule{{Spec: &spec, Hours: "9-18"}}},
+		{"invalid clock", []Rule{{Spec: &spec, Hours: "09:00-25:00"}}},
+		{"duplicate name", []Rule{{Name: "a", Spec: &spec}, {Name: "a", Spec: &spec}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRouter(Config{Rules: tt.rules}); err == nil {
+				t.Error("error expected")
+			}
+		})
+	}
+}
+
+func TestRouteFirstMatch(t *testing.T) {
+	r, err := NewRouter(Config{Rules: []Rule{
+		{Name: "files", Intents: []string{"file"}, Spec: &spec},
+		{Name: "all", Spec: &other},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs, name, ok := r.Route(Request{Intent: "file"})
+	if !ok || name != "files" || len(specs) != 1 || specs[0] != spec {
+		t.Errorf("Route = %v %q %v", specs, name, ok)
+	}
+
+	if _, name, _ = r.Route(Request{Intent: "question"}); name != "all" {
+		t.Errorf("Route = %q, want all", name)
+	}
+
+	empty, _ := NewRouter(Config{})
+	if _, _, ok = empty.Route(Request{}); ok {
+		t.Error("no rules must not match")
+	}
+}
+
+func newPool(n int) []uuid.UUID {
+	pool := make([]uuid.UUID, n)
+	for i := range pool {
+		pool[i] = uuid.New()
+	}
+
+	return pool
+}
+
+func newRedis(t *testing.T) redis.UniversalClient {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	return redis.NewClient(&redis.Options{Addr: s.Addr()})
+}
+
+// newInstances returns routers of two instances sharing redis, or independent without it
+func newInstances(t *testing.T, db redis.UniversalClient, rule Rule) (*Router, *Router) {
+	var routers []*Router
+
+	for i := 0; i < 2; i++ {
+		r, err := NewRouter(Config{Rules: []Rule{rule}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if db != nil {
+			r.Share(db, "")
+		}
+		routers = append(routers, r)
+	}
+
+	return routers[0], routers[1]
+}
+
+func TestRoundRobin(t *testing.T) {
+	pool := newPool(3)
+
+	for _, shared := range []bool{false, true} {
+		var db redis.UniversalClient
+		if shared {
+			db = newRedis(t)
+		}
+
+		a, b := newInstances(t, db, Rule{Name: "pool", Pool: pool})
+
+		var firsts []uuid.UUID
+		for i := 0; i < 4; i++ {
+			r := a
+			if shared && i%2 == 1 {
+				r = b
+			}
+
+			specs, _, _ := r.Route(Request{})
+			if len(specs) != len(pool) {
+				t.Fatalf("shared %v: %d specialists, want the whole pool", shared, len(specs))
+			}
+			firsts = append(firsts, specs[0])
+
+			// Остальные идут по кругу следом за выбранным
+			if specs[1] != pool[(i+1)%3] || specs[2] != pool[(i+2)%3] {
+				t.Errorf("shared %v: turn %d order %v", shared, i, specs)
+			}
+		}
+
+		want := []uuid.UUID{pool[0], pool[1], pool[2], pool[0]}
+		for i := range want {
+			if firsts[i] != want[i] {
+				t.Errorf("shared %v: turn %d is %s, want %s", shared, i, firsts[i], want[i])
+			}
+		}
+	}
+}
+
+func TestScopes(t *testing.T) {
+	pool := newPool(2)
+	db := newRedis(t)
+
+	routers := make([]*Router, 2)
+	for i, scope := range []string{"", "shop:"} {
+		r, err := NewRouter(Config{Rules: []Rule{{Name: "pool", Pool: pool}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Share(db, scope)
+		routers[i] = r
+	}
+
+	// Правила с одним именем у разных арендаторов ведут свои очереди
+	for _, r := range routers {
+		if specs, _, _ := r.Route(Request{}); specs[0] != pool[0] {
+			t.Errorf("first turn is %s, want %s", specs[0], pool[0])
+		}
+	}
+}
+
+func TestLeastRecent(t *testing.T) {
+	pool := newPool(3)
+	db := newRedis(t)
+
+	a, b := newInstances(t, db, Rule{Name: "pool", Pool: pool, Strategy: STRATEGY_LEAST_RECENT})
+
+	now := time.Now()
+
+	// Никто еще не назначался: порядок пула
+	specs, _, _ := a.Route(Request{})
+	if specs[0] != pool[0] {
+		t.Fatalf("first is %s, want %s", specs[0], pool[0])
+	}
+
+	a.Assigned("pool", pool[0], now)
+	b.Assigned("pool", pool[1], now.Add(time.Second))
+
+	// Назначения другого экземпляра учитываются
+	specs, _, _ = b.Route(Request{})
+	want := []uuid.UUID{pool[2], pool[0], pool[1]}
+	for i := range want {
+		if specs[i] != want[i] {
+			t.Fatalf("order %v, want %v", specs, want)
+		}
+	}
+
+	// Выбор без назначения не меняет очередь
+	specs, _, _ = a.Route(Request{})
+	if specs[0] != pool[2] {
+		t.Errorf("first is %s, want %s", specs[0], pool[2])
+	}
+
+	a.Assigned("pool", pool[2], now.Add(2*time.Second))
+	if specs, _, _ = b.Route(Request{}); specs[0] != pool[0] {
+		t.Errorf("first is %s, want %s", specs[0], pool[0])
+	}
+}
+
+func TestLeastRecentInMemory(t *testing.T) {
+	pool := newPool(2)
+
+	r, _ := newInstances(t, nil, Rule{Name: "pool", Pool: pool, Strategy: STRATEGY_LEAST_RECENT})
+
+	r.Assigned("pool", pool[0], time.Now())
+
+	if specs, _, _ := r.Route(Request{}); specs[0] != pool[1] {
+		t.Errorf("first is %s, want %s", specs[0], pool[1])
+	}
+}
@@ -0,0 +1,72 @@
+package schedule
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+type DayType int
+
+const (
+	DAY_REGULAR DayType = 0
+	DAY_HOLIDAY DayType = 1
+	DAY_SHORT   DayType = 2
+	DAY_WORKING DayType = 3
+)
+
+type (
+	// Производственный календарь в формате xmlcalendar.ru:
+	// <calendar year="2020"><days><day d="01.01" t="1" h="1"/>...</days></calendar>
+	xmlCalendar struct {
+		Year int      `xml:"year,attr"`
+		Days []xmlDay `xml:"days>day"`
+	}
+
+	xmlDay struct {
+		Date string  `xml:"d,attr"`
+		Type DayType `xml:"t,attr"`
+	}
+)
+
+// LoadCalendar reads production calendar and returns day types by date in DATE_FORMAT
+func LoadCalendar(path string) (map[string]DayType, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseCalendar(data)
+}
+
+func ParseCalendar(data []byte) (map[string]DayType, error) {
+	var cal xmlCalendar
+	if err := xml.Unmarshal(data, &cal); err != nil {
+		return nil, fmt.Errorf("could not parse calendar: %v", err)
+	}
+
+	if cal.Year == 0 {
+		return nil, fmt.Errorf("calendar has no year")
+	}
+
+	days := make(map[string]DayType, len(cal.Days))
+
+	for _, d := range cal.Days {
+		date, err := time.Parse("01.02.2006", strings.TrimSpace(d.Date)+fmt.Sprintf(".%04d", cal.Year))
+		if err != nil {
+			return nil, fmt.Errorf("invalid calendar day %q", d.Date)
+		}
+
+		switch d.Type {
+		case DAY_HOLIDAY, DAY_SHORT, DAY_WORKING:
+		default:
+			return nil, fmt.Errorf("unknown type %d of calendar day %q", d.Type, d.Date)
+		}
+
+		days[date.Format(DATE_FORMAT)] = d.Type
+	}
+
+	return days, nil
+}
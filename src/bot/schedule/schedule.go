@@ -0,0 +1,241 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DATE_FORMAT = "2006-01-02"
+
+	// How far NextOpening looks ahead before giving up
+	LOOKAHEAD_DAYS = 366
+)
+
+type (
+	Config struct {
+		CollectQuestions bool   `yaml:"collect_questions"`
+		Lines            []Line `yaml:"lines"`
+	}
+
+	// Line describes working hours of one or more lines. Entry without lines is used as default.
+	Line struct {
+		Lines    []uuid.UUID       `yaml:"lines"`
+		Timezone string            `yaml:"timezone"`
+		Hours    map[string]string `yaml:"hours"`
+
+		// Файл производственного календаря в формате xmlcalendar.ru
+		CalendarFile string `yaml:"calendar_file"`
+
+		Holidays []string `yaml:"holidays"`
+		Workdays []string `yaml:"workdays"`
+	}
+
+	Schedule struct {
+		location *time.Location
+		hours    [7]*interval
+		days     map[string]DayType
+	}
+
+	Schedules struct {
+		def    *Schedule
+		byLine map[uuid.UUID]*Schedule
+	}
+
+	interval struct {
+		from, to time.Duration
+	}
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func New(c Config) (*Schedules, error) {
+	s := &Schedules{
+		byLine: make(map[uuid.UUID]*Schedule),
+	}
+
+	for i := range c.Lines {
+		sch, err := newSchedule(c.Lines[i])
+		if err != nil {
+			return nil, fmt.Errorf("schedule #%d: %v", i+1, err)
+		}
+
+		if len(c.Lines[i].Lines) == 0 {
+			s.def = sch
+		}
+
+		for _, lineId := range c.Lines[i].Lines {
+			s.byLine[lineId] = sch
+		}
+	}
+
+	return s, nil
+}
+
+// ForLine returns schedule of the line or nil when the line works around the clock
+func (s *Schedules) ForLine(lineId uuid.UUID) *Schedule {
+	if sch, ok := s.byLine[lineId]; ok {
+		return sch
+	}
+
+	return s.def
+}
+
+func newSchedule(c Line) (*Schedule, error) {
+	var err error
+
+	sch := &Schedule{
+		location: time.Local,
+		days:     make(map[string]DayType),
+	}
+
+	if c.Timezone != "" {
+		if sch.location, err = time.LoadLocation(c.Timezone); err != nil {
+			return nil, err
+		}
+	}
+
+	for day, hours := range c.Hours {
+		wd, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", day)
+		}
+
+		iv, err := parseInterval(hours)
+		if err != nil {
+			return nil, err
+		}
+
+		sch.hours[wd] = iv
+	}
+
+	if c.CalendarFile != "" {
+		days, err := LoadCalendar(c.CalendarFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for d, t := range days {
+			sch.days[d] = t
+		}
+	}
+
+	for _, d := range c.Holidays {
+		if _, err := time.Parse(DATE_FORMAT, d); err != nil {
+			return nil, fmt.Errorf("invalid holiday %q", d)
+		}
+		sch.days[d] = DAY_HOLIDAY
+	}
+
+	for _, d := range c.Workdays {
+		if _, err := time.Parse(DATE_FORMAT, d); err != nil {
+			return nil, fmt.Errorf("invalid workday %q", d)
+		}
+		sch.days[d] = DAY_WORKING
+	}
+
+	return sch, nil
+}
+
+func (s *Schedule) Location() *time.Location {
+	return s.location
+}
+
+// workingHours returns the working interval of the day containing t
+func (s *Schedule) workingHours(t time.Time) *interval {
+	t = t.In(s.location)
+
+	switch s.days[t.Format(DATE_FORMAT)] {
+	case DAY_HOLIDAY:
+		return nil
+	case DAY_SHORT:
+		iv := s.hours[t.Weekday()]
+		if iv == nil {
+			return nil
+		}
+
+		// Предпраздничный день короче на один час
+		return &interval{from: iv.from, to: iv.to - time.Hour}
+	case DAY_WORKING:
+		// Перенесенный рабочий день работает по графику понедельника
+		if iv := s.hours[t.Weekday()]; iv != nil {
+			return iv
+		}
+
+		return s.hours[time.Monday]
+	default:
+		return s.hours[t.Weekday()]
+	}
+}
+
+func (s *Schedule) IsOpen(t time.Time) bool {
+	iv := s.workingHours(t)
+	if iv == nil {
+		return false
+	}
+
+	t = t.In(s.location)
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+
+	return now >= iv.from && now < iv.to
+}
+
+// NextOpening returns the moment when the line opens after t, zero time if never
+func (s *Schedule) NextOpening(t time.Time) time.Time {
+	t = t.In(s.location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.location)
+
+	for i := 0; i < LOOKAHEAD_DAYS; i++ {
+		iv := s.workingHours(day)
+		if iv != nil {
+			// Время по часам пояса, в день перевода часов сутки короче или длиннее
+			opening := time.Date(day.Year(), day.Month(), day.Day(), int(iv.from/time.Hour), int(iv.from%time.Hour/time.Minute), 0, 0, s.location)
+			if opening.After(t) {
+				return opening
+			}
+		}
+
+		day = day.AddDate(0, 0, 1)
+	}
+
+	return time.Time{}
+}
+
+func parseInterval(s string) (*interval, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("hours %q must be in format HH:MM-HH:MM", s)
+	}
+
+	from, err := time.Parse("15:04", strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q", parts[0])
+	}
+
+	to, err := time.Parse("15:04", strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q", parts[1])
+	}
+
+	iv := &interval{
+		from: time.Duration(from.Hour())*time.Hour + time.Duration(from.Minute())*time.Minute,
+		to:   time.Duration(to.Hour())*time.Hour + time.Duration(to.Minute())*time.Minute,
+	}
+
+	if iv.to <= iv.from {
+		return nil, fmt.Errorf("hours %q must end after start", s)
+	}
+
+	return iv, nil
+}
@@ -0,0 +1,300 @@
+package schedule
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Март 2020: 2 — понедельник, 6 — предпраздничная пятница, 7 — рабочая суббота, 9 — выходной
+const testCalendar = `<?xml version="1.0" encoding="UTF-8"?>
+<calendar year="2020" lang="ru" date="2020.01.01">
+	<holidays>
+		<holiday id="1" title="Международный женский день"/>
+	</holidays>
+	<days>
+		<day d="03.06" t="2"/>
+		<day d="03.07" t="3"/>
+		<day d="03.09" t="1" h="1"/>
+	</days>
+</calendar>`
+
+var workweek = map[string]string{
+	"mon": "09:00-18:00",
+	"tue": "09:00-18:00",
+	"wed": "09:00-18:00",
+	"thu": "09:00-18:00",
+	"fri": "09:00-17:00",
+}
+
+func newTestSchedule(t *testing.T, c Line) *Schedule {
+	t.Helper()
+
+	if c.CalendarFile == "" {
+		dir, err := ioutil.TempDir("", "calendar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+
+		c.CalendarFile = filepath.Join(dir, "calendar.xml")
+		if err := ioutil.WriteFile(c.CalendarFile, []byte(testCalendar), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.Hours == nil {
+		c.Hours = workweek
+	}
+
+	sch, err := newSchedule(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sch
+}
+
+func utc(value string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", value)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func in(location string, value string) time.Time {
+	loc, err := time.LoadLocation(location)
+	if err != nil {
+		panic(err)
+	}
+
+	t, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func TestIsOpen(t *testing.T) {
+	moscow := newTestSchedule(t, Line{Timezone: "Europe/Moscow"})
+	vladivostok := newTestSchedule(t, Line{Timezone: "Asia/Vladivostok"})
+
+	tests := []struct {
+		name string
+		sch  *Schedule
+		t    time.Time
+		want bool
+	}{
+		{"opening", moscow, utc("2020-03-02 06:00"), true},
+		{"before opening", moscow, utc("2020-03-02 05:59"), false},
+		{"before closing", moscow, utc("2020-03-02 14:59"), true},
+		{"closing", moscow, utc("2020-03-02 15:00"), false},
+		{"night of the next day", moscow, utc("2020-03-02 22:00"), false},
+		{"sunday", moscow, in("Europe/Moscow", "2020-03-01 12:00"), false},
+
+		// В UTC еще воскресенье, во Владивостоке уже понедельник
+		{"weekday of the zone", vladivostok, utc("2020-03-01 23:30"), true},
+		{"monday in utc is closed", vladivostok, utc("2020-03-02 08:00"), false},
+
+		{"short day", moscow, in("Europe/Moscow", "2020-03-06 15:59"), true},
+		{"short day is shorter", moscow, in("Europe/Moscow", "2020-03-06 16:00"), false},
+		{"usual friday", moscow, in("Europe/Moscow", "2020-03-13 16:30"), true},
+
+		{"working saturday", moscow, in("Europe/Moscow", "2020-03-07 10:00"), true},
+		{"working saturday by monday hours", moscow, in("Europe/Moscow", "2020-03-07 17:30"), true},
+		{"usual saturday", moscow, in("Europe/Moscow", "2020-03-14 10:00"), false},
+		{"holiday", moscow, in("Europe/Moscow", "2020-03-09 10:00"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sch.IsOpen(tt.t); got != tt.want {
+				t.Errorf("IsOpen(%s) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShortDayWithoutHours(t *testing.T) {
+	sch := newTestSchedule(t, Line{Timezone: "Europe/Moscow"})
+	sch.days["2020-03-08"] = DAY_SHORT
+
+	if sch.IsOpen(in("Europe/Moscow", "2020-03-08 10:00")) {
+		t.Error("short sunday without hours must be closed")
+	}
+}
+
+func TestConfigOverridesCalendar(t *testing.T) {
+	sch := newTestSchedule(t, Line{
+		Timezone: "Europe/Moscow",
+		Holidays: []string{"2020-03-03", "2020-03-07"},
+		Workdays: []string{"2020-03-09"},
+	})
+
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"2020-03-03 10:00", false},
+		{"2020-03-07 10:00", false},
+		{"2020-03-09 10:00", true},
+	}
+
+	for _, tt := range tests {
+		if got := sch.IsOpen(in("Europe/Moscow", tt.value)); got != tt.want {
+			t.Errorf("IsOpen(%s) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNextOpening(t *testing.T) {
+	moscow := newTestSchedule(t, Line{Timezone: "Europe/Moscow"})
+	newYork := newTestSchedule(t, Line{Timezone: "America/New_York", Hours: map[string]string{"sun": "09:00-17:00"}})
+
+	tests := []struct {
+		name string
+		sch  *Schedule
+		t    time.Time
+		want time.Time
+	}{
+		{"later today", moscow, in("Europe/Moscow", "2020-03-02 07:00"), in("Europe/Moscow", "2020-03-02 09:00")},
+		{"tomorrow while open", moscow, in("Europe/Moscow", "2020-03-02 10:00"), in("Europe/Moscow", "2020-03-03 09:00")},
+		{"after short day to working saturday", moscow, in("Europe/Moscow", "2020-03-06 16:30"), in("Europe/Moscow", "2020-03-07 09:00")},
+		{"over sunday and holiday", moscow, in("Europe/Moscow", "2020-03-07 18:30"), in("Europe/Moscow", "2020-03-10 09:00")},
+		{"in the zone of the line", moscow, utc("2020-03-02 05:00"), in("Europe/Moscow", "2020-03-02 09:00")},
+
+		// 8 марта 2020 в Нью-Йорке часы переводят вперед в 2:00
+		{"day of clock change", newYork, in("America/New_York", "2020-03-08 00:30"), in("America/New_York", "2020-03-08 09:00")},
+		{"week after clock change", newYork, in("America/New_York", "2020-03-08 17:30"), in("America/New_York", "2020-03-15 09:00")},
+		{"day of clock change back", newYork, in("America/New_York", "2020-11-01 00:30"), in("America/New_York", "2020-11-01 09:00")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sch.NextOpening(tt.t); !got.Equal(tt.want) {
+				t.Errorf("NextOpening(%s) = %s, want %s", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextOpeningLookahead(t *testing.T) {
+	sch := newTestSchedule(t, Line{Timezone: "Europe/Moscow", Hours: map[string]string{"mon": "09:00-18:00"}})
+
+	// Все понедельники года — выходные, рабочих суббот нет
+	sch.days = make(map[string]DayType)
+
+	from := in("Europe/Moscow", "2020-01-01 00:00")
+	for d := from; d.Before(from.AddDate(1, 0, 0)); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() == time.Monday {
+			sch.days[d.Format(DATE_FORMAT)] = DAY_HOLIDAY
+		}
+	}
+
+	if got := sch.NextOpening(from); !got.IsZero() {
+		t.Errorf("NextOpening = %s, want zero time beyond %d days", got, LOOKAHEAD_DAYS)
+	}
+
+	never := newTestSchedule(t, Line{Hours: map[string]string{}})
+	if got := never.NextOpening(from); !got.IsZero() {
+		t.Errorf("NextOpening without hours = %s, want zero time", got)
+	}
+}
+
+func TestForLine(t *testing.T) {
+	line, other := uuid.New(), uuid.New()
+
+	s, err := New(Config{Lines: []Line{
+		{Lines: []uuid.UUID{line}, Hours: map[string]string{"mon": "10:00-11:00"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.ForLine(line) == nil {
+		t.Error("schedule of the line is missing")
+	}
+
+	if s.ForLine(other) != nil {
+		t.Error("line without schedule must work around the clock")
+	}
+
+	s, err = New(Config{Lines: []Line{
+		{Lines: []uuid.UUID{line}, Hours: map[string]string{"mon": "10:00-11:00"}},
+		{Hours: map[string]string{"tue": "10:00-11:00"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.ForLine(other) == nil || s.ForLine(other) == s.ForLine(line) {
+		t.Error("default schedule is not used")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		line Line
+	}{
+		{"unknown weekday", Line{Hours: map[string]string{"monday": "09:00-18:00"}}},
+		{"invalid hours", Line{Hours: map[string]string{"mon": "9-18"}}},
+		{"invalid time", Line{Hours: map[string]string{"mon": "09:00-24:30"}}},
+		{"end before start", Line{Hours: map[string]string{"mon": "18:00-09:00"}}},
+		{"unknown timezone", Line{Timezone: "Europe/Nowhere"}},
+		{"invalid holiday", Line{Holidays: []string{"09.03.2020"}}},
+		{"invalid workday", Line{Workdays: []string{"2020-02-30"}}},
+		{"missing calendar", Line{CalendarFile: "/nonexistent/calendar.xml"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(Config{Lines: []Line{tt.line}}); err == nil {
+				t.Error("error expected")
+			}
+		})
+	}
+}
+
+func TestParseCalendar(t *testing.T) {
+	days, err := ParseCalendar([]byte(testCalendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]DayType{
+		"2020-03-06": DAY_SHORT,
+		"2020-03-07": DAY_WORKING,
+		"2020-03-09": DAY_HOLIDAY,
+	}
+
+	if len(days) != len(want) {
+		t.Errorf("%d days, want %d", len(days), len(want))
+	}
+
+	for d, typ := range want {
+		if days[d] != typ {
+			t.Errorf("day %s is %d, want %d", d, days[d], typ)
+		}
+	}
+
+	errors := map[string]string{
+		"no year":      `<calendar><days><day d="01.01" t="1"/></days></calendar>`,
+		"invalid day":  `<calendar year="2020"><days><day d="31.01" t="1"/></days></calendar>`,
+		"unknown type": `<calendar year="2020"><days><day d="01.01" t="5"/></days></calendar>`,
+		"not xml":      `calendar`,
+	}
+
+	for name, data := range errors {
+		if _, err := ParseCalendar([]byte(data)); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package bot
+
+import (
+	"connect-companion/bot/messages"
+	"connect-companion/database"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+// GetState returns the stored state of the user on the line
+func GetState(db redis.UniversalClient, lineId uuid.UUID, userId uuid.UUID) database.Chat {
+	return getState(db, newMessage(messages.Message{LineId: lineId, UserId: userId}))
+}
+
+// SetState moves the user to the state without sending anything
+func SetState(db redis.UniversalClient, lineId uuid.UUID, userId uuid.UUID, state database.ChatState) error {
+	msg := newMessage(messages.Message{LineId: lineId, UserId: userId})
+
+	unlock, err := lockChat(db, msg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	chatState := getState(db, msg)
+
+	if err := changeState(db, msg, &chatState, state); err != nil {
+		return err
+	}
+
+	scheduleIdle(msg, state)
+
+	return nil
+}
+
+// ResetState forgets the user so the next message starts with greeting
+func ResetState(db redis.UniversalClient, lineId uuid.UUID, userId uuid.UUID) error {
+	msg := newMessage(messages.Message{LineId: lineId, UserId: userId})
+
+	// Сообщение, которое сейчас обрабатывается, иначе сохранит свое состояние после сброса
+	unlock, err := lockChat(db, msg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	scheduleIdle(msg, database.STATE_GREETINGS)
+
+	return db.Del(stateKey(msg)).Err()
+}
+
+func stateKey(msg *message) string {
+	return database.PREFIX_STATE + msg.UserId.String() + ":" + msg.LineId.String()
+}
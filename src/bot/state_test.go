@@ -0,0 +1,75 @@
+package bot
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"connect-companion/bot/messages"
+	"connect-companion/database"
+
+	"github.com/google/uuid"
+)
+
+// Состояние старой версии переписывается при чтении, а не при следующем изменении
+func TestGetStateSavesMigrated(t *testing.T) {
+	db := testRedis(t)
+
+	msg := newMessage(messages.Message{LineId: uuid.New(), UserId: uuid.New()})
+	if err := db.Set(stateKey(msg), `{"prev_state":0,"curr_state":300}`, time.Hour).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := getState(db, msg).CurrentState; state != database.STATE_MAIN_MENU {
+		t.Fatalf("state %d, want main menu", state)
+	}
+
+	var stored database.Chat
+	if err := json.Unmarshal([]byte(db.Get(stateKey(msg)).Val()), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version != database.CHAT_VERSION {
+		t.Errorf("stored version %d, want %d", stored.Version, database.CHAT_VERSION)
+	}
+
+	if ttl := db.TTL(stateKey(msg)).Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("ttl of the migrated chat %s", ttl)
+	}
+}
+
+// Сброс ждет сообщение, которое обрабатывается, и не затирается его состоянием
+func TestResetStateWaitsForChat(t *testing.T) {
+	db := testRedis(t)
+
+	msg := newMessage(messages.Message{LineId: uuid.New(), UserId: uuid.New()})
+
+	unlock, err := lockChat(db, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reset := make(chan error, 1)
+	go func() {
+		reset <- ResetState(db, msg.LineId, msg.UserId)
+	}()
+
+	select {
+	case err = <-reset:
+		t.Fatalf("reset %v while the chat is locked", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	chat := database.NewChat()
+	if err = changeState(db, msg, &chat, database.STATE_MAIN_MENU); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	if err = <-reset; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := db.Exists(stateKey(msg)).Val(); n != 0 {
+		t.Error("state saved by the message is kept after the reset")
+	}
+}
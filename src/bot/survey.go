@@ -0,0 +1,316 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"connect-companion/bot/requests"
+	"connect-companion/database"
+	"connect-companion/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+const (
+	SURVEY_EVENT_BOT_CLOSE       = "bot_close"
+	SURVEY_EVENT_TREATMENT_CLOSE = "treatment_close"
+
+	STEP_CLOSE      = "close"
+	STEP_AGAIN      = "again"
+	STEP_SPECIALIST = "specialist"
+
+	CSAT_DIMENSION_LINE     = "line"
+	CSAT_DIMENSION_DOCUMENT = "document"
+	CSAT_DIMENSION_DAY      = "day"
+
+	// Оценки 4 и 5 считаются удовлетворенными
+	CSAT_SATISFIED = 4
+
+	DEFAULT_MAX_RESPONSES      = 10000
+	DEFAULT_SURVEY_CLOSE_AFTER = 10 * time.Minute
+
+	KEY_SKIP = "skip"
+)
+
+type (
+	CsatResponse struct {
+		LineId  uuid.UUID `json:"line_id"`
+		UserId  uuid.UUID `json:"user_id"`
+		Event   string    `json:"event"`
+		Rating  int       `json:"rating"`
+		Comment string    `json:"comment,omitempty"`
+		Path    []string  `json:"path"`
+		// Последний документ диалога, статистика считается по всем из Documents
+		Document  string    `json:"document,omitempty"`
+		Documents []string  `json:"documents,omitempty"`
+		Time      time.Time `json:"time"`
+	}
+
+	CsatStat struct {
+		Key       string  `json:"key"`
+		Responses int64   `json:"responses"`
+		Average   float64 `json:"average"`
+		Csat      float64 `json:"csat"`
+	}
+)
+
+var csatDimensions = []string{CSAT_DIMENSION_LINE, CSAT_DIMENSION_DOCUMENT, CSAT_DIMENSION_DAY}
+
+func isSurveyEvent(msg *message, event string) bool {
+	for _, e := range tenantOf(msg.LineId).conf.Survey.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+func maxResponses() int {
+	if cnf.Survey.MaxResponses > 0 {
+		return cnf.Survey.MaxResponses
+	}
+
+	return DEFAULT_MAX_RESPONSES
+}
+
+// visit remembers the step of the user for survey statistics
+func visit(chatState *database.Chat, step string) {
+	chatState.Path = append(chatState.Path, step)
+}
+
+// received remembers the document sent in the dialog, the survey counts all of them
+func received(chatState *database.Chat, file string) {
+	chatState.Document = file
+
+	for _, d := range chatState.Documents {
+		if d == file {
+			return
+		}
+	}
+	chatState.Documents = append(chatState.Documents, file)
+}
+
+// documentsOf returns documents of the dialog, chats saved before the list have the last one only
+func documentsOf(chatState *database.Chat) []string {
+	if len(chatState.Documents) == 0 && chatState.Document != "" {
+		return []string{chatState.Document}
+	}
+
+	return chatState.Documents
+}
+
+func surveyRatingKeyboard(msg *message, chatState *database.Chat) *[][]requests.KeyboardKey {
+	return &[][]requests.KeyboardKey{
+		{{Id: "1", Text: "1"}, {Id: "2", Text: "2"}, {Id: "3", Text: "3"}, {Id: "4", Text: "4"}, {Id: "5", Text: "5"}},
+		{key(msg, chatState, KEY_SKIP, LABEL_SKIP)},
+	}
+}
+
+func surveyCommentKeyboard(msg *message, chatState *database.Chat) *[][]requests.KeyboardKey {
+	return &[][]requests.KeyboardKey{
+		{key(msg, chatState, KEY_SKIP, LABEL_SKIP)},
+	}
+}
+
+// startSurvey asks for the rating. After treatment_close the treatment of the specialist is closed already,
+// so the question opens a new session of the bot which the survey closes at the end. The treatment
+// of the abandoned survey is closed by the idle job which changeState plans for survey states.
+func startSurvey(msg *message, chatState *database.Chat, event string) (database.ChatState, error) {
+	chatState.Survey = &database.Survey{
+		Event: event,
+	}
+
+	_, err := SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_SURVEY_RATING), surveyRatingKeyboard(msg, chatState))
+
+	return checkErrorForSend(msg, err, database.STATE_SURVEY_RATING)
+}
+
+func processSurvey(db redis.UniversalClient, msg *message, chatState *database.Chat) (database.ChatState, error) {
+	if chatState.Survey == nil {
+		chatState.Survey = &database.Survey{Event: SURVEY_EVENT_BOT_CLOSE}
+	}
+
+	switch chatState.CurrentState {
+	case database.STATE_SURVEY_RATING:
+		choice := pick(surveyRatingKeyboard(msg, chatState), msg.Text)
+		if choice == KEY_SKIP {
+			return finishSurvey(msg, chatState, say(msg, chatState, PHRASE_BYE))
+		}
+
+		rating, err := strconv.Atoi(choice)
+		if err != nil {
+			_, err = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_SURVEY_SORRY), surveyRatingKeyboard(msg, chatState))
+
+			return checkErrorForSend(msg, err, database.STATE_SURVEY_RATING)
+		}
+
+		chatState.Survey.Rating = rating
+
+		_, err = SendMessage(msg.LineId, msg.UserId, say(msg, chatState, PHRASE_SURVEY_COMMENT), surveyCommentKeyboard(msg, chatState))
+
+		return checkErrorForSend(msg, err, database.STATE_SURVEY_COMMENT)
+	default:
+		comment := strings.TrimSpace(msg.Text)
+		if pick(surveyCommentKeyboard(msg, chatState), msg.Text) == KEY_SKIP {
+			comment = ""
+		}
+
+		err := saveSurvey(db, &CsatResponse{
+			LineId:    msg.LineId,
+			UserId:    msg.UserId,
+			Event:     chatState.Survey.Event,
+			Rating:    chatState.Survey.Rating,
+			Comment:   comment,
+			Path:      chatState.Path,
+			Document:  chatState.Document,
+			Documents: documentsOf(chatState),
+			Time:      time.Now(),
+		})
+		if err != nil {
+			logger.Warning("Error while save survey", err)
+		}
+
+		return finishSurvey(msg, chatState, say(msg, chatState, PHRASE_SURVEY_THANKS))
+	}
+}
+
+func finishSurvey(msg *message, chatState *database.Chat, text string) (database.ChatState, error) {
+	event := chatState.Survey.Event
+	chatState.Survey = nil
+
+	_, _ = SendMessage(msg.LineId, msg.UserId, text, nil)
+
+	// Опрос после закрытия специалистом идет в своей сессии, ее тоже закрываем
+	logger.Debug("Close treatment after survey of", event)
+
+	_, err := CloseTreatment(msg.LineId, msg.UserId)
+
+	return checkErrorForSend(msg, err, database.STATE_GREETINGS)
+}
+
+// csatKeys returns keys of the response in every dimension, the response counts for each document of the dialog
+func csatKeys(r *CsatResponse) map[string][]string {
+	documents := r.Documents
+	if len(documents) == 0 && r.Document != "" {
+		documents = []string{r.Document}
+	}
+	if len(documents) == 0 {
+		documents = []string{"-"}
+	}
+
+	return map[string][]string{
+		CSAT_DIMENSION_LINE:     {r.LineId.String()},
+		CSAT_DIMENSION_DOCUMENT: documents,
+		CSAT_DIMENSION_DAY:      {r.Time.Format("2006-01-02")},
+	}
+}
+
+func saveSurvey(db redis.UniversalClient, r *CsatResponse) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	satisfied := 0
+	if r.Rating >= CSAT_SATISFIED {
+		satisfied = 1
+	}
+
+	_, err = db.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.RPush(database.PREFIX_CSAT+"responses", data)
+		pipe.LTrim(database.PREFIX_CSAT+"responses", -int64(maxResponses()), -1)
+
+		for dimension, keys := range csatKeys(r) {
+			dbStatsKey := database.PREFIX_CSAT + "stats:" + dimension
+
+			for _, key := range keys {
+				pipe.HIncrBy(dbStatsKey, key+":count", 1)
+				pipe.HIncrBy(dbStatsKey, key+":sum", int64(r.Rating))
+				pipe.HIncrBy(dbStatsKey, key+":satisfied", int64(satisfied))
+			}
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func csatStats(db redis.UniversalClient, dimension string) ([]CsatStat, error) {
+	raw, err := db.HGetAll(database.PREFIX_CSAT + "stats:" + dimension).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make(map[string]map[string]int64)
+	for field, value := range raw {
+		i := strings.LastIndex(field, ":")
+		if i < 0 {
+			continue
+		}
+
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		key := field[:i]
+		if counters[key] == nil {
+			counters[key] = make(map[string]int64)
+		}
+		counters[key][field[i+1:]] = n
+	}
+
+	stats := make([]CsatStat, 0, len(counters))
+	for key, c := range counters {
+		if c["count"] == 0 {
+			continue
+		}
+
+		stats = append(stats, CsatStat{
+			Key:       key,
+			Responses: c["count"],
+			Average:   float64(c["sum"]) / float64(c["count"]),
+			Csat:      100 * float64(c["satisfied"]) / float64(c["count"]),
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Key < stats[j].Key
+	})
+
+	return stats, nil
+}
+
+// CsatStats returns aggregated satisfaction by line, document or day
+func CsatStats(c *gin.Context) {
+	db := c.MustGet("db").(redis.UniversalClient)
+
+	dimension := c.Param("dimension")
+
+	found := false
+	for _, d := range csatDimensions {
+		found = found || d == dimension
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown dimension " + dimension})
+		return
+	}
+
+	stats, err := csatStats(db, dimension)
+	if err != nil {
+		logger.Warning("Error while reading csat stats", err)
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
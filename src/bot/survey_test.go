@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"connect-companion/config"
+	"connect-companion/database"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+func testRedis(t *testing.T) redis.UniversalClient {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+
+	db := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+func TestReceived(t *testing.T) {
+	chat := &database.Chat{}
+
+	received(chat, "a.pdf")
+	received(chat, "b.pdf")
+	received(chat, "a.pdf")
+
+	if chat.Document != "a.pdf" || len(chat.Documents) != 2 {
+		t.Errorf("document %s of %v", chat.Document, chat.Documents)
+	}
+
+	// Чат, сохраненный до списка документов
+	if docs := documentsOf(&database.Chat{Document: "old.pdf"}); len(docs) != 1 || docs[0] != "old.pdf" {
+		t.Errorf("documents of the old chat %v", docs)
+	}
+}
+
+func TestSurveyStats(t *testing.T) {
+	db := testRedis(t)
+
+	saved := cnf
+	cnf = &config.Conf{}
+	t.Cleanup(func() { cnf = saved })
+
+	line := uuid.New()
+	day := time.Date(2020, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	responses := []*CsatResponse{
+		{LineId: line, Rating: 5, Document: "b.pdf", Documents: []string{"a.pdf", "b.pdf"}, Time: day},
+		{LineId: line, Rating: 2, Document: "a.pdf", Time: day},
+		{LineId: line, Rating: 4, Time: day},
+	}
+	for _, r := range responses {
+		if err := saveSurvey(db, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := csatStats(db, CSAT_DIMENSION_DOCUMENT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]CsatStat{
+		"-":     {Key: "-", Responses: 1, Average: 4, Csat: 100},
+		"a.pdf": {Key: "a.pdf", Responses: 2, Average: 3.5, Csat: 50},
+		"b.pdf": {Key: "b.pdf", Responses: 1, Average: 5, Csat: 100},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("stats %+v", stats)
+	}
+	for _, s := range stats {
+		if s != want[s.Key] {
+			t.Errorf("stat %+v, want %+v", s, want[s.Key])
+		}
+	}
+
+	stats, err = csatStats(db, CSAT_DIMENSION_LINE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Responses != 3 {
+		t.Errorf("line stats %+v", stats)
+	}
+
+	// Хранятся только последние ответы
+	cnf.Survey.MaxResponses = 2
+	if err := saveSurvey(db, &CsatResponse{LineId: line, Rating: 1, Time: day}); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.LLen(database.PREFIX_CSAT + "responses").Val(); n != 2 {
+		t.Errorf("%d responses are kept, want 2", n)
+	}
+}
@@ -0,0 +1,102 @@
+package templates
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LoadLocale reads phrases of the locale from YAML (key: text) or PO (msgid is the key) file
+func LoadLocale(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		phrases := make(map[string]string)
+		if err = yaml.Unmarshal(data, &phrases); err != nil {
+			return nil, fmt.Errorf("could not parse %q: %v", path, err)
+		}
+
+		return phrases, nil
+	case ".po":
+		phrases, err := parsePO(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %q: %v", path, err)
+		}
+
+		return phrases, nil
+	default:
+		return nil, fmt.Errorf("unknown format of locale file %q", path)
+	}
+}
+
+func parsePO(data []byte) (map[string]string, error) {
+	phrases := make(map[string]string)
+
+	var msgid, msgstr *string
+	var current **string
+
+	flush := func() {
+		if msgid != nil && msgstr != nil && *msgid != "" && *msgstr != "" {
+			phrases[*msgid] = *msgstr
+		}
+		msgid, msgstr, current = nil, nil, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "msgid "):
+			flush()
+
+			s, err := strconv.Unquote(strings.TrimSpace(line[len("msgid "):]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			msgid, current = &s, &msgid
+		case strings.HasPrefix(line, "msgstr "):
+			if msgid == nil {
+				return nil, fmt.Errorf("line %d: msgstr without msgid", n)
+			}
+
+			s, err := strconv.Unquote(strings.TrimSpace(line[len("msgstr "):]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			msgstr, current = &s, &msgstr
+		case strings.HasPrefix(line, `"`):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: unexpected string", n)
+			}
+
+			s, err := strconv.Unquote(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			**current += s
+		default:
+			return nil, fmt.Errorf("line %d: unknown statement", n)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	flush()
+
+	return phrases, nil
+}
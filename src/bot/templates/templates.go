@@ -0,0 +1,266 @@
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"connect-companion/bot/directory"
+	"connect-companion/logger"
+)
+
+const (
+	// Максимальная длина значения переменной чата
+	MAX_VALUE_LENGTH = 1000
+
+	// Функция, которую Add дописывает в конец каждой подстановки
+	ESCAPE_FUNC = "_plain"
+)
+
+type (
+	// Data is available in every phrase and keyboard label
+	Data struct {
+		UserId   string
+		LineId   string
+		Now      time.Time
+		Context  map[string]string
+		Document Document
+		Extra    map[string]string
+		// Employee of the directory, empty if the user is unknown
+		Profile directory.Profile
+	}
+
+	Document struct {
+		Id       string
+		Title    string
+		File     string
+		Size     int64
+		Modified time.Time
+	}
+
+	// Catalog holds parsed templates by key
+	Catalog struct {
+		tpl  *template.Template
+		keys map[string]bool
+
+		escaped map[*parse.Tree]bool
+	}
+)
+
+var funcs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"title": func(s string) string {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError {
+			return s
+		}
+
+		return string(unicode.ToUpper(r)) + s[size:]
+	},
+	"default": func(def string, value string) string {
+		if strings.TrimSpace(value) == "" {
+			return def
+		}
+
+		return value
+	},
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"size": func(n int64) string {
+		switch {
+		case n >= 1<<20:
+			return fmt.Sprintf("%.1f МБ", float64(n)/(1<<20))
+		case n >= 1<<10:
+			return fmt.Sprintf("%.0f КБ", float64(n)/(1<<10))
+		default:
+			return fmt.Sprintf("%d Б", n)
+		}
+	},
+}
+
+// Funcs returns functions of phrases, templates of documents use them too
+func Funcs() template.FuncMap {
+	return funcs
+}
+
+// New parses default templates and overrides from the configuration. Unknown keys of overrides are errors.
+func New(defaults map[string]string, overrides map[string]string) (*Catalog, error) {
+	c := &Catalog{
+		tpl:  template.New("").Funcs(funcs).Funcs(template.FuncMap{ESCAPE_FUNC: Plain}).Option("missingkey=zero"),
+		keys: make(map[string]bool),
+
+		escaped: make(map[*parse.Tree]bool),
+	}
+
+	for key, text := range defaults {
+		if err := c.Add(key, text); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, text := range overrides {
+		if !c.keys[key] {
+			return nil, fmt.Errorf("unknown phrase %q", key)
+		}
+
+		if err := c.Add(key, text); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Add parses the template and checks it renders with sample data. Output of every {{...}}
+// of the template goes through Plain, so values of users are substituted as plain text.
+func (c *Catalog) Add(key string, text string) error {
+	t, err := c.tpl.New(key).Parse(text)
+	if err != nil {
+		return fmt.Errorf("phrase %q: %v", key, err)
+	}
+
+	// Шаблоны из {{define}} тоже, каждое дерево только раз
+	for _, tt := range t.Templates() {
+		if tt.Tree != nil && !c.escaped[tt.Tree] {
+			escapeList(tt.Tree, tt.Tree.Root)
+			c.escaped[tt.Tree] = true
+		}
+	}
+
+	if err = t.Execute(new(bytes.Buffer), sample()); err != nil {
+		return fmt.Errorf("phrase %q: %v", key, err)
+	}
+
+	c.keys[key] = true
+
+	return nil
+}
+
+func (c *Catalog) Has(key string) bool {
+	return c.keys[key]
+}
+
+func (c *Catalog) Keys() []string {
+	keys := make([]string, 0, len(c.keys))
+	for key := range c.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (c *Catalog) Render(key string, data *Data) string {
+	buf := new(bytes.Buffer)
+
+	if err := c.tpl.ExecuteTemplate(buf, key, data); err != nil {
+		logger.Warning("Error while render phrase", key, err)
+
+		return key
+	}
+
+	return buf.String()
+}
+
+// Plain prints the value as plain text of 1C-Connect messages, which have no markup: control
+// characters and invisible format characters, e.g. U+202E turning the text around, are removed,
+// line breaks and tabs are kept. Values are never parsed as templates again.
+func Plain(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		s = v
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+
+		return r
+	}, s)
+}
+
+// escapeList appends Plain to the pipelines of actions printing something
+func escapeList(tree *parse.Tree, list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			// Присваивание переменной ничего не выводит
+			if len(n.Pipe.Decl) > 0 {
+				continue
+			}
+
+			ident := parse.NewIdentifier(ESCAPE_FUNC).SetTree(tree).SetPos(n.Position())
+			n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Pos:      n.Position(),
+				Args:     []parse.Node{ident},
+			})
+		case *parse.IfNode:
+			escapeList(tree, n.List)
+			escapeList(tree, n.ElseList)
+		case *parse.RangeNode:
+			escapeList(tree, n.List)
+			escapeList(tree, n.ElseList)
+		case *parse.WithNode:
+			escapeList(tree, n.List)
+			escapeList(tree, n.ElseList)
+		}
+	}
+}
+
+// Sanitize removes control characters from the value stored in the chat context and limits its length
+func Sanitize(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			return r
+		}
+
+		return -1
+	}, strings.TrimSpace(value))
+
+	if utf8.RuneCountInString(value) > MAX_VALUE_LENGTH {
+		value = string([]rune(value)[:MAX_VALUE_LENGTH])
+	}
+
+	return value
+}
+
+func sample() *Data {
+	return &Data{
+		UserId:  "00000000-0000-0000-0000-000000000000",
+		LineId:  "00000000-0000-0000-0000-000000000000",
+		Now:     time.Now(),
+		Context: map[string]string{},
+		Document: Document{
+			Id:       "1",
+			Title:    "Документ",
+			File:     "document.pdf",
+			Size:     1024,
+			Modified: time.Now(),
+		},
+		Extra: map[string]string{},
+	}
+}
@@ -0,0 +1,141 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	c, err := New(map[string]string{
+		"greeting": "{{with .Context.name}}{{.}}, з{{else}}З{{end}}дравствуйте!",
+		"document": "«{{.Document.Title}}» ({{size .Document.Size}}) от {{date \"02.01.2006\" .Document.Modified}}",
+		"profile":  "{{$name := .Profile.Name}}{{title (default \"коллега\" $name)}}",
+		"defined":  `{{define "who"}}{{.Context.name}}{{end}}Это {{template "who" .}}`,
+	}, map[string]string{
+		"greeting": "Привет, {{default \"гость\" .Context.name}}!",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := &Data{
+		Context: map[string]string{"name": "Иван"},
+		Document: Document{
+			Title:    "Регламент",
+			Size:     2048,
+			Modified: time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	tests := map[string]string{
+		"greeting": "Привет, Иван!",
+		"document": "«Регламент» (2 КБ) от 02.03.2020",
+		"profile":  "Коллега",
+		"defined":  "Это Иван",
+		"unknown":  "unknown",
+	}
+
+	for key, want := range tests {
+		if got := c.Render(key, data); got != want {
+			t.Errorf("%s: %q, want %q", key, got, want)
+		}
+	}
+
+	if !c.Has("profile") || c.Has("unknown") || len(c.Keys()) != 4 {
+		t.Errorf("keys %v", c.Keys())
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	c, err := New(map[string]string{
+		"greeting": "{{.Context.name}}, вот ваш документ",
+		"range":    "{{range $k, $v := .Context}}{{$k}}={{$v}};{{end}}",
+		"if":       "{{if .Context.name}}[{{.Context.name | upper}}]{{end}}",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		// Значения не разбираются как шаблоны
+		{"{{.UserId}}", "{{.UserId}}"},
+		// Управляющие и невидимые символы, в том числе разворот текста, удаляются
+		{"Иван\x1b[31m\u202eнавИ\u200b", "Иван[31mнавИ"},
+		// Переводы строк и табуляции остаются
+		{"Иван\nПетров\tмл.", "Иван\nПетров\tмл."},
+		{"<b>Иван</b> & *Ко*", "<b>Иван</b> & *Ко*"},
+	}
+
+	for _, tt := range tests {
+		data := &Data{Context: map[string]string{"name": tt.value}}
+
+		if got := c.Render("greeting", data); got != tt.want+", вот ваш документ" {
+			t.Errorf("%q rendered as %q", tt.value, got)
+		}
+
+		if got := c.Render("range", data); got != "name="+tt.want+";" {
+			t.Errorf("%q in range rendered as %q", tt.value, got)
+		}
+
+		if got := c.Render("if", data); got != "["+strings.ToUpper(tt.want)+"]" {
+			t.Errorf("%q in if rendered as %q", tt.value, got)
+		}
+	}
+
+	// Фразы из настроек тоже экранируются
+	if err := c.Add("greeting", "{{.Context.name}}!"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Render("greeting", &Data{Context: map[string]string{"name": "И\u202eван"}}); got != "Иван!" {
+		t.Errorf("override rendered as %q", got)
+	}
+}
+
+func TestPlain(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, ""},
+		{"a\x00b\r\nc", "ab\nc"},
+		{int64(42), "42"},
+		{"\ufeffтекст", "текст"},
+	}
+
+	for _, tt := range tests {
+		if got := Plain(tt.value); got != tt.want {
+			t.Errorf("Plain(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	defaults := map[string]string{"greeting": "Здравствуйте"}
+
+	tests := map[string]map[string]string{
+		"unknown phrase": {"greting": "Привет"},
+		"syntax":         {"greeting": "{{.Context.name"},
+		"unknown field":  {"greeting": "{{.Name}}"},
+		"unknown func":   {"greeting": "{{shout .UserId}}"},
+	}
+
+	for name, overrides := range tests {
+		if _, err := New(defaults, overrides); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	if got := Sanitize("  Иван\x07\n "); got != "Иван" {
+		t.Errorf("Sanitize = %q", got)
+	}
+
+	if got := Sanitize(strings.Repeat("я", MAX_VALUE_LENGTH+10)); len([]rune(got)) != MAX_VALUE_LENGTH {
+		t.Errorf("value of %d runes is kept", len([]rune(got)))
+	}
+}
@@ -0,0 +1,228 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"connect-companion/bot/forms"
+	"connect-companion/bot/fulltext"
+	"connect-companion/bot/kb"
+	"connect-companion/bot/routing"
+	"connect-companion/bot/schedule"
+	"connect-companion/bot/templates"
+	"connect-companion/config"
+	"connect-companion/database"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DEFAULT_TENANT = "default"
+)
+
+type (
+	// tenant is the group of lines sharing the Connect account, menu, phrases and working hours
+	tenant struct {
+		name string
+		conf *config.Conf
+
+		// Каталоги фраз по языкам, встроенные фразы на русском
+		catalogs      map[string]*templates.Catalog
+		locales       []string
+		defaultLocale string
+		// Размер и дата файлов меню для фраз по имени файла, без названий на разных языках
+		files map[string]templates.Document
+
+		schedules *schedule.Schedules
+
+		// Без своих правил арендатор делит очереди пулов с общими линиями
+		router *routing.Router
+		idle   map[database.ChatState]idlePolicy
+		pacing map[string]time.Duration
+
+		// База знаний, nil если статей нет
+		kb *kb.Index
+		// Страницы PDF и DOCX, nil если поиск по документам выключен
+		pages *fulltext.Index
+	}
+)
+
+var (
+	tenants = map[uuid.UUID]*tenant{}
+
+	// Линии без арендатора обслуживаются по общим настройкам
+	defaultTenant = &tenant{
+		name:          DEFAULT_TENANT,
+		conf:          &config.Conf{},
+		catalogs:      map[string]*templates.Catalog{},
+		defaultLocale: BUILTIN_LOCALE,
+		schedules:     &schedule.Schedules{},
+		router:        &routing.Router{},
+	}
+)
+
+// configureTenants maps lines to their tenants, full configuration also loads phrases,
+// schedules, forms, routing and reminders of every tenant
+func configureTenants(full bool) error {
+	var errs config.Errors
+
+	base := &tenant{name: DEFAULT_TENANT, conf: cnf, router: &routing.Router{}}
+	if full {
+		collect(&errs, base.configure())
+	}
+
+	byLine := make(map[uuid.UUID]*tenant)
+	for _, line := range cnf.Line {
+		byLine[line] = base
+	}
+
+	for i := range cnf.Tenants {
+		tc := &cnf.Tenants[i]
+
+		if tc.Name == "" || tc.Name == DEFAULT_TENANT {
+			errs = append(errs, fmt.Errorf("tenant %d: name is required and must not be %q", i+1, DEFAULT_TENANT))
+			continue
+		}
+
+		if len(tc.Lines) == 0 {
+			errs = append(errs, fmt.Errorf("tenant %s: lines are required", tc.Name))
+			continue
+		}
+
+		t := &tenant{name: tc.Name, conf: cnf.ForTenant(tc), router: base.router}
+		if full {
+			if err := t.configure(); err != nil {
+				errs = append(errs, fmt.Errorf("tenant %s: %v", tc.Name, err))
+			}
+
+			if tc.Routing == nil {
+				t.router = base.router
+			}
+		}
+
+		for _, line := range tc.Lines {
+			if other, ok := byLine[line]; ok {
+				errs = append(errs, fmt.Errorf("line %s belongs to tenants %s and %s", line, other.name, t.name))
+				continue
+			}
+			byLine[line] = t
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	defaultTenant = base
+	tenants = byLine
+
+	for _, t := range allTenants() {
+		t.shareRouting()
+	}
+
+	return nil
+}
+
+func (t *tenant) configure() error {
+	var err error
+	if t.schedules, err = schedule.New(t.conf.Schedule); err != nil {
+		return err
+	}
+
+	if t.router, err = routing.NewRouter(t.conf.Routing); err != nil {
+		return err
+	}
+
+	if err = t.configureInactivity(); err != nil {
+		return err
+	}
+
+	if err = t.configurePacing(); err != nil {
+		return err
+	}
+
+	if err = forms.Validate(t.conf.Forms); err != nil {
+		return err
+	}
+
+	if err = t.configurePhrases(); err != nil {
+		return err
+	}
+
+	t.statDocuments()
+
+	if err = t.configureKnowledge(); err != nil {
+		return err
+	}
+
+	return t.configureDocuments()
+}
+
+// shareRouting keeps turns of the tenant pools in redis apart from other tenants
+func (t *tenant) shareRouting() {
+	if routingDb == nil {
+		return
+	}
+
+	scope := ""
+	if t.name != DEFAULT_TENANT {
+		scope = t.name + ":"
+	}
+
+	// Общий маршрутизатор остается в области общих линий
+	if t != defaultTenant && t.router == defaultTenant.router {
+		return
+	}
+
+	t.router.Share(routingDb, scope)
+}
+
+// tenantOf returns the tenant serving the line, the default one for unknown lines
+func tenantOf(lineId uuid.UUID) *tenant {
+	if t, ok := tenants[lineId]; ok {
+		return t
+	}
+
+	return defaultTenant
+}
+
+// IsKnownLine tells whether the bot serves the line
+func IsKnownLine(lineId uuid.UUID) bool {
+	_, ok := tenants[lineId]
+
+	return ok
+}
+
+// Lines returns all lines served by the bot
+func Lines() []uuid.UUID {
+	lines := make([]uuid.UUID, 0, len(tenants))
+	for line := range tenants {
+		lines = append(lines, line)
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].String() < lines[j].String()
+	})
+
+	return lines
+}
+
+// allTenants returns every configured tenant once, the default one first
+func allTenants() []*tenant {
+	list := []*tenant{defaultTenant}
+	seen := map[*tenant]bool{defaultTenant: true}
+
+	for _, line := range Lines() {
+		if t := tenants[line]; !seen[t] {
+			seen[t] = true
+			list = append(list, t)
+		}
+	}
+
+	return list
+}
+
+func connectOf(lineId uuid.UUID) config.Connect {
+	return tenantOf(lineId).conf.Connect
+}
@@ -0,0 +1,141 @@
+package throttle
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	STATE_CLOSED State = iota
+	STATE_HALF_OPEN
+	STATE_OPEN
+)
+
+var (
+	ErrOpen = errors.New("circuit breaker is open")
+)
+
+type (
+	State int
+
+	// Breaker stops requests after failures in a row. When open_for passes one probe request
+	// is let through: its success closes the breaker, its failure opens it again.
+	Breaker struct {
+		mu sync.Mutex
+
+		failures int
+		openFor  time.Duration
+
+		state    State
+		count    int
+		openedAt time.Time
+		probing  bool
+
+		// Вызывается при смене состояния, например для записи в лог
+		OnChange func(from State, to State)
+	}
+)
+
+func (s State) String() string {
+	switch s {
+	case STATE_CLOSED:
+		return "closed"
+	case STATE_HALF_OPEN:
+		return "half-open"
+	case STATE_OPEN:
+		return "open"
+	}
+
+	return "unknown"
+}
+
+func NewBreaker(failures int, openFor time.Duration) *Breaker {
+	return &Breaker{failures: failures, openFor: openFor}
+}
+
+// Allow returns ErrOpen while the breaker is open or the probe request is in flight
+func (b *Breaker) Allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case STATE_OPEN:
+		if now.Sub(b.openedAt) < b.openFor {
+			return ErrOpen
+		}
+		b.set(STATE_HALF_OPEN)
+		fallthrough
+	case STATE_HALF_OPEN:
+		if b.probing {
+			return ErrOpen
+		}
+		b.probing = true
+	}
+
+	return nil
+}
+
+// Done records the result of the allowed request
+func (b *Breaker) Done(now time.Time, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if ok {
+		b.count = 0
+		b.set(STATE_CLOSED)
+		return
+	}
+
+	b.count++
+	if b.state == STATE_HALF_OPEN || b.count >= b.failures {
+		b.openedAt = now
+		b.set(STATE_OPEN)
+	}
+}
+
+// Cancel releases the allowed request which is not sent, e.g. throttled, its result is not counted
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// RetryIn tells how long the breaker stays open, 0 if requests may be tried
+func (b *Breaker) RetryIn(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != STATE_OPEN {
+		return 0
+	}
+
+	if left := b.openFor - now.Sub(b.openedAt); left > 0 {
+		return left
+	}
+
+	return 0
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *Breaker) set(state State) {
+	if b.state == state {
+		return
+	}
+
+	from := b.state
+	b.state = state
+
+	if b.OnChange != nil {
+		b.OnChange(from, state)
+	}
+}
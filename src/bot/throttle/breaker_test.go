@@ -0,0 +1,139 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(3, 30*time.Second)
+
+	var changes []string
+	b.OnChange = func(from State, to State) {
+		changes = append(changes, from.String()+">"+to.String())
+	}
+
+	now := start
+
+	// Успех сбрасывает счетчик ошибок подряд
+	for _, ok := range []bool{false, false, true, false, false} {
+		if err := b.Allow(now); err != nil {
+			t.Fatal(err)
+		}
+		b.Done(now, ok)
+	}
+
+	if b.State() != STATE_CLOSED {
+		t.Fatalf("state %s after failures with success between, want closed", b.State())
+	}
+
+	if err := b.Allow(now); err != nil {
+		t.Fatal(err)
+	}
+	b.Done(now, false)
+
+	if b.State() != STATE_OPEN {
+		t.Fatalf("state %s after 3 failures in a row, want open", b.State())
+	}
+
+	if err := b.Allow(now.Add(29 * time.Second)); err != ErrOpen {
+		t.Errorf("open breaker allows the request: %v", err)
+	}
+
+	if d := b.RetryIn(now.Add(20 * time.Second)); d != 10*time.Second {
+		t.Errorf("RetryIn = %s, want 10s", d)
+	}
+
+	// Через open_for пропускается одна пробная заявка
+	now = now.Add(30 * time.Second)
+
+	if d := b.RetryIn(now); d != 0 {
+		t.Errorf("RetryIn = %s after open_for", d)
+	}
+
+	if err := b.Allow(now); err != nil {
+		t.Fatalf("probe is not allowed: %v", err)
+	}
+
+	if b.State() != STATE_HALF_OPEN {
+		t.Errorf("state %s while probing, want half-open", b.State())
+	}
+
+	if err := b.Allow(now); err != ErrOpen {
+		t.Errorf("second request during the probe: %v", err)
+	}
+
+	// Неудачная проба снова открывает предохранитель
+	b.Done(now, false)
+
+	if b.State() != STATE_OPEN {
+		t.Fatalf("state %s after failed probe, want open", b.State())
+	}
+
+	if err := b.Allow(now.Add(time.Second)); err != ErrOpen {
+		t.Errorf("breaker is not open again: %v", err)
+	}
+
+	// Удачная проба закрывает
+	now = now.Add(30 * time.Second)
+
+	if err := b.Allow(now); err != nil {
+		t.Fatal(err)
+	}
+	b.Done(now, true)
+
+	if b.State() != STATE_CLOSED {
+		t.Errorf("state %s after successful probe, want closed", b.State())
+	}
+
+	want := []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}
+	if len(changes) != len(want) {
+		t.Fatalf("changes %v, want %v", changes, want)
+	}
+
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d is %s, want %s", i, changes[i], want[i])
+		}
+	}
+}
+
+func TestBreakerCancel(t *testing.T) {
+	b := NewBreaker(1, 30*time.Second)
+
+	now := start
+	if err := b.Allow(now); err != nil {
+		t.Fatal(err)
+	}
+	b.Done(now, false)
+
+	now = now.Add(30 * time.Second)
+	if err := b.Allow(now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Неотправленная проба не занимает место следующей
+	b.Cancel()
+
+	if b.State() != STATE_HALF_OPEN {
+		t.Errorf("state %s after cancelled probe, want half-open", b.State())
+	}
+
+	if err := b.Allow(now); err != nil {
+		t.Errorf("probe is not allowed after the cancelled one: %v", err)
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	c := Config{}.WithDefaults()
+
+	if c.MaxWait != DEFAULT_MAX_WAIT || c.BreakerFailures != DEFAULT_BREAKER_FAILURES || c.BreakerOpenFor != DEFAULT_BREAKER_OPEN_FOR {
+		t.Errorf("defaults %+v", c)
+	}
+
+	c = Config{MaxWait: time.Second, BreakerFailures: 2, BreakerOpenFor: time.Minute}.WithDefaults()
+
+	if c.MaxWait != time.Second || c.BreakerFailures != 2 || c.BreakerOpenFor != time.Minute {
+		t.Errorf("settings are overridden: %+v", c)
+	}
+}
@@ -0,0 +1,165 @@
+package throttle
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Bucket is the token bucket: rate tokens per second up to burst. Requests over the limit
+	// wait in line, so tokens may go negative.
+	Bucket struct {
+		mu sync.Mutex
+
+		rate  float64
+		burst float64
+
+		tokens float64
+		last   time.Time
+
+		// До этого момента запросы не отправляются, см. Retry-After
+		pausedUntil time.Time
+	}
+)
+
+// NewBucket creates the bucket, zero rate is unlimited but may still be paused
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+
+	b.last = now
+}
+
+// Delay tells how long the request would wait for its token
+func (b *Bucket) Delay(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.delay(now)
+}
+
+func (b *Bucket) delay(now time.Time) time.Duration {
+	var d time.Duration
+	if now.Before(b.pausedUntil) {
+		d = b.pausedUntil.Sub(now)
+	}
+
+	if b.rate > 0 {
+		b.refill(now)
+
+		if b.tokens < 1 {
+			if wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second)); wait > d {
+				d = wait
+			}
+		}
+	}
+
+	return d
+}
+
+// Take consumes the token and returns how long the request must wait before sending
+func (b *Bucket) Take(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d := b.delay(now)
+	if b.rate > 0 {
+		b.tokens--
+	}
+
+	return d
+}
+
+// Full tells whether the bucket is back to its initial state and may be dropped
+func (b *Bucket) Full(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+
+	return b.tokens >= b.burst && !now.Before(b.pausedUntil)
+}
+
+// Pause holds all requests until the moment, e.g. after 429 with Retry-After
+func (b *Bucket) Pause(until time.Time) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// Reserve takes tokens of all buckets and returns how long the request must wait, nil buckets
+// are unlimited. Nothing is taken and false is returned if the wait would exceed maxWait.
+func Reserve(now time.Time, maxWait time.Duration, buckets ...*Bucket) (time.Duration, bool) {
+	var delay time.Duration
+	for _, b := range buckets {
+		if d := b.Delay(now); d > delay {
+			delay = d
+		}
+	}
+
+	if delay > maxWait {
+		return delay, false
+	}
+
+	delay = 0
+	for _, b := range buckets {
+		if d := b.Take(now); d > delay {
+			delay = d
+		}
+	}
+
+	return delay, true
+}
+
+// RetryAfter parses Retry-After in seconds or as http date, 1s if missing
+func RetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if at.After(now) {
+			return at.Sub(now)
+		}
+		return 0
+	}
+
+	return time.Second
+}
@@ -0,0 +1,146 @@
+package throttle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+
+func TestBucketWait(t *testing.T) {
+	// 2 запроса в секунду, 3 сразу
+	b := NewBucket(2, 3)
+
+	for i := 0; i < 3; i++ {
+		if d := b.Take(start); d != 0 {
+			t.Fatalf("request %d of the burst waits %s", i+1, d)
+		}
+	}
+
+	tests := []struct {
+		at   time.Duration
+		want time.Duration
+	}{
+		// Запросы сверх лимита встают в очередь
+		{0, 500 * time.Millisecond},
+		{0, time.Second},
+		{250 * time.Millisecond, 1250 * time.Millisecond},
+	}
+
+	for i, tt := range tests {
+		if d := b.Take(start.Add(tt.at)); d != tt.want {
+			t.Errorf("request %d waits %s, want %s", i+4, d, tt.want)
+		}
+	}
+
+	if b.Full(start.Add(2 * time.Second)) {
+		t.Error("bucket is full before the queue is served")
+	}
+
+	if !b.Full(start.Add(5 * time.Second)) {
+		t.Error("bucket is not refilled")
+	}
+
+	// Больше burst не накапливается
+	if d := b.Delay(start.Add(time.Hour)); d != 0 {
+		t.Errorf("delay %s after an hour", d)
+	}
+}
+
+func TestUnlimitedBucket(t *testing.T) {
+	b := NewBucket(0, 0)
+
+	for i := 0; i < 100; i++ {
+		if d := b.Take(start); d != 0 {
+			t.Fatalf("unlimited bucket waits %s", d)
+		}
+	}
+
+	var none *Bucket
+	if none.Take(start) != 0 || none.Delay(start) != 0 || !none.Full(start) {
+		t.Error("nil bucket must be unlimited")
+	}
+	none.Pause(start.Add(time.Minute))
+}
+
+func TestReserve(t *testing.T) {
+	global := NewBucket(10, 1)
+	line := NewBucket(1, 1)
+
+	if d, ok := Reserve(start, time.Second, global, line, nil); !ok || d != 0 {
+		t.Fatalf("first request: %s %v", d, ok)
+	}
+
+	// Ждем линию, а не общий лимит
+	d, ok := Reserve(start, time.Second, global, line)
+	if !ok || d != time.Second {
+		t.Errorf("second request: %s %v, want 1s", d, ok)
+	}
+
+	// Очередь длиннее max_wait: запрос не ставится в очередь и токены не тратятся
+	d, ok = Reserve(start, time.Second, global, line)
+	if ok || d != 2*time.Second {
+		t.Errorf("third request: %s %v, want 2s over max wait", d, ok)
+	}
+
+	if d = line.Delay(start); d != 2*time.Second {
+		t.Errorf("rejected request took the token, delay %s", d)
+	}
+
+	if d = global.Delay(start.Add(200 * time.Millisecond)); d != 0 {
+		t.Errorf("rejected request took the global token, delay %s", d)
+	}
+}
+
+func TestPause(t *testing.T) {
+	b := NewBucket(0, 0)
+
+	b.Pause(start.Add(30 * time.Second))
+	// Более ранняя пауза не сокращает текущую
+	b.Pause(start.Add(10 * time.Second))
+
+	if d := b.Take(start.Add(5 * time.Second)); d != 25*time.Second {
+		t.Errorf("paused request waits %s, want 25s", d)
+	}
+
+	if b.Full(start.Add(29 * time.Second)) {
+		t.Error("paused bucket is full")
+	}
+
+	if d := b.Delay(start.Add(30 * time.Second)); d != 0 {
+		t.Errorf("request waits %s after the pause", d)
+	}
+
+	if _, ok := Reserve(start, 10*time.Second, NewBucket(0, 0), pausedUntil(start.Add(time.Minute))); ok {
+		t.Error("request is reserved beyond max wait of the pause")
+	}
+}
+
+func pausedUntil(until time.Time) *Bucket {
+	b := NewBucket(0, 0)
+	b.Pause(until)
+
+	return b
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{"", time.Second},
+		{"soon", time.Second},
+		{"-5", time.Second},
+		{start.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{start.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+
+	for _, tt := range tests {
+		if got := RetryAfter(tt.value, start); got != tt.want {
+			t.Errorf("RetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package throttle
+
+import (
+	"time"
+)
+
+const (
+	DEFAULT_MAX_WAIT         = 10 * time.Second
+	DEFAULT_BREAKER_FAILURES = 5
+	DEFAULT_BREAKER_OPEN_FOR = 30 * time.Second
+)
+
+type (
+	// Config limits requests of the instance to 1C-Connect API
+	Config struct {
+		// Requests per second of all lines, unlimited if 0
+		Rate  float64 `yaml:"rate"`
+		Burst int     `yaml:"burst"`
+
+		// Requests per second of every line, unlimited if 0
+		LineRate  float64 `yaml:"line_rate"`
+		LineBurst int     `yaml:"line_burst"`
+
+		// How long a request waits for its turn or Retry-After before it fails, 10s by default
+		MaxWait time.Duration `yaml:"max_wait"`
+
+		// The breaker opens after so many failures in a row and lets a probe through after open_for,
+		// 5 and 30s by default
+		BreakerFailures int           `yaml:"breaker_failures"`
+		BreakerOpenFor  time.Duration `yaml:"breaker_open_for"`
+	}
+)
+
+// WithDefaults fills omitted settings
+func (c Config) WithDefaults() Config {
+	if c.MaxWait <= 0 {
+		c.MaxWait = DEFAULT_MAX_WAIT
+	}
+
+	if c.BreakerFailures <= 0 {
+		c.BreakerFailures = DEFAULT_BREAKER_FAILURES
+	}
+
+	if c.BreakerOpenFor <= 0 {
+		c.BreakerOpenFor = DEFAULT_BREAKER_OPEN_FOR
+	}
+
+	return c
+}
@@ -0,0 +1,229 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"connect-companion/bot/messages"
+	"connect-companion/bot/requests"
+	"connect-companion/database"
+	"connect-companion/logger"
+	"connect-companion/scheduler"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	JOB_TIMELINE = "timeline"
+
+	// Действия отложенных шагов
+	ACTION_MESSAGE = "message"
+	ACTION_CLOSE   = "close"
+	ACTION_REROUTE = "reroute"
+
+	// Паузы между сообщениями бота, настраиваются в pacing. Шаги диалога заданы в коде,
+	// поэтому других пауз нет.
+	PACE_AFTER_FILE     = "after_file"
+	PACE_BEFORE_CLOSE   = "before_close"
+	PACE_BEFORE_REROUTE = "before_reroute"
+)
+
+var (
+	defaultPacing = map[string]time.Duration{
+		PACE_AFTER_FILE:     3 * time.Second,
+		PACE_BEFORE_CLOSE:   500 * time.Millisecond,
+		PACE_BEFORE_REROUTE: 500 * time.Millisecond,
+	}
+)
+
+type (
+	// step is the outbound step of the bot sent after the pause
+	step struct {
+		After  time.Duration `json:"after"`
+		Action string        `json:"action"`
+
+		Text     string                    `json:"text,omitempty"`
+		Keyboard *[][]requests.KeyboardKey `json:"keyboard,omitempty"`
+
+		// Маршрутизация при переводе на специалиста
+		Topic  string `json:"topic,omitempty"`
+		Intent string `json:"intent,omitempty"`
+	}
+
+	timelineJobData struct {
+		// Сообщения отправляются, только если чат остался в этом состоянии
+		State database.ChatState `json:"state"`
+		Steps []step             `json:"steps"`
+	}
+)
+
+func (t *tenant) configurePacing() error {
+	t.pacing = make(map[string]time.Duration, len(defaultPacing))
+	for name, d := range defaultPacing {
+		t.pacing[name] = d
+	}
+
+	for name, d := range t.conf.Pacing {
+		if _, ok := defaultPacing[name]; !ok {
+			return fmt.Errorf("pacing: unknown pause %q, only %s, %s and %s can be set",
+				name, PACE_AFTER_FILE, PACE_BEFORE_CLOSE, PACE_BEFORE_REROUTE)
+		}
+
+		if d < 0 {
+			return fmt.Errorf("pacing %s: pause must not be negative", name)
+		}
+
+		t.pacing[name] = d
+	}
+
+	return nil
+}
+
+// pause returns the pause of the tenant serving the line of the message
+func pause(msg *message, name string) time.Duration {
+	return tenantOf(msg.LineId).pacing[name]
+}
+
+func sendLater(after time.Duration, text string, keyboard *[][]requests.KeyboardKey) step {
+	return step{After: after, Action: ACTION_MESSAGE, Text: text, Keyboard: keyboard}
+}
+
+func closeLater(after time.Duration) step {
+	return step{After: after, Action: ACTION_CLOSE}
+}
+
+// rerouteLater keeps the text of the user for routing rules
+func rerouteLater(after time.Duration, msg *message, topic string, intent string) step {
+	return step{After: after, Action: ACTION_REROUTE, Text: msg.Text, Topic: topic, Intent: intent}
+}
+
+func timelineJobId(msg *message) string {
+	return "timeline:" + msg.LineId.String() + ":" + msg.UserId.String()
+}
+
+// later runs steps after their pauses by the scheduler, so the processing goroutine is not blocked
+// and steps survive restarts. Steps without pause at the start are done at once.
+func later(msg *message, state database.ChatState, steps ...step) error {
+	for len(steps) > 0 && (steps[0].After <= 0 || jobs == nil) {
+		if err := runStep(msg, steps[0]); err != nil {
+			return err
+		}
+		steps = steps[1:]
+	}
+
+	if len(steps) == 0 {
+		return nil
+	}
+
+	return scheduleTimeline(msg, state, steps)
+}
+
+func scheduleTimeline(msg *message, state database.ChatState, steps []step) error {
+	data, err := json.Marshal(timelineJobData{State: state, Steps: steps})
+	if err != nil {
+		return err
+	}
+
+	return jobs.Schedule(&scheduler.Job{
+		Id:     timelineJobId(msg),
+		Kind:   JOB_TIMELINE,
+		At:     time.Now().Add(steps[0].After),
+		LineId: msg.LineId,
+		UserId: msg.UserId,
+		Data:   data,
+	})
+}
+
+func runStep(msg *message, s step) error {
+	var err error
+
+	switch s.Action {
+	case ACTION_MESSAGE:
+		_, err = SendMessage(msg.LineId, msg.UserId, s.Text, s.Keyboard)
+	case ACTION_CLOSE:
+		_, err = CloseTreatment(msg.LineId, msg.UserId)
+	case ACTION_REROUTE:
+		m := *msg
+		m.Text = s.Text
+		_, err = rerouteTreatment(&m, s.Topic, s.Intent)
+	default:
+		err = fmt.Errorf("unknown action %q", s.Action)
+	}
+
+	return err
+}
+
+// cancelTimeline drops pending messages when the user replies first,
+// pending actions are not lost and are done at once
+func cancelTimeline(msg *message) {
+	if jobs == nil {
+		return
+	}
+
+	job, err := jobs.Get(timelineJobId(msg))
+	if err != nil || job == nil {
+		if err != nil {
+			logger.Warning("Error while read timeline", err)
+		}
+		return
+	}
+
+	if err = jobs.Cancel(job.Id); err != nil {
+		logger.Warning("Error while cancel timeline", err)
+		return
+	}
+
+	var data timelineJobData
+	if err = json.Unmarshal(job.Data, &data); err != nil {
+		logger.Warning("Error while decoding timeline", job.Id, err)
+		return
+	}
+
+	for _, s := range data.Steps {
+		if s.Action == ACTION_MESSAGE {
+			continue
+		}
+
+		if err = runStep(msg, s); err != nil {
+			logger.Warning("Error while run pending step", s.Action, "of", job.Id, err)
+		}
+	}
+}
+
+// runTimeline does the due step and schedules the rest
+func runTimeline(db redis.UniversalClient, job *scheduler.Job) error {
+	msg := newMessage(messages.Message{LineId: job.LineId, UserId: job.UserId})
+
+	unlock, err := lockChat(db, msg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var data timelineJobData
+	if err := json.Unmarshal(job.Data, &data); err != nil {
+		// Повтор не поможет
+		logger.Warning("Error while decoding timeline", job.Id, err)
+		return nil
+	}
+
+	if len(data.Steps) == 0 {
+		return nil
+	}
+
+	s, rest := data.Steps[0], data.Steps[1:]
+
+	// Пользователь успел ответить, сообщения устарели
+	if s.Action == ACTION_MESSAGE && getState(db, msg).CurrentState != data.State {
+		logger.Debug("Skip timeline message", job.Id, "because chat state changed")
+	} else if err := runStep(msg, s); err != nil {
+		return err
+	}
+
+	if len(rest) == 0 {
+		return nil
+	}
+
+	return scheduleTimeline(msg, data.State, rest)
+}
@@ -0,0 +1,217 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"connect-companion/bot/messages"
+	"connect-companion/config"
+	"connect-companion/database"
+	"connect-companion/scheduler"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+// methodsStub records methods of 1C-Connect called by the bot
+type methodsStub struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (s *methodsStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.methods = append(s.methods, strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/"), "/"))
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *methodsStub) called() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.methods...)
+}
+
+func testTimeline(t *testing.T) (redis.UniversalClient, *methodsStub, *message) {
+	db := testRedis(t)
+
+	connect := &methodsStub{}
+	srv := httptest.NewServer(connect)
+	t.Cleanup(srv.Close)
+
+	savedJobs := jobs
+	t.Cleanup(func() { jobs = savedJobs })
+
+	line := uuid.New()
+	c := &config.Conf{
+		Line:    []uuid.UUID{line},
+		Connect: config.Connect{Server: srv.URL, Login: "bot", Password: "password"},
+	}
+	if err := Validate(c); err != nil {
+		t.Fatal(err)
+	}
+	jobs = scheduler.New(db)
+
+	msg := newMessage(messages.Message{LineId: line, UserId: uuid.New(), Text: "Нужна помощь"})
+
+	return db, connect, msg
+}
+
+func TestLaterPendingMessageDropped(t *testing.T) {
+	_, connect, msg := testTimeline(t)
+
+	// Первое сообщение сразу, второе после паузы
+	err := later(msg, database.STATE_MAIN_MENU,
+		sendLater(0, "Файл", nil),
+		sendLater(time.Minute, "Что-нибудь еще?", nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if called := connect.called(); len(called) != 1 {
+		t.Fatalf("methods %v before the pause, want one message", called)
+	}
+
+	job, err := jobs.Get(timelineJobId(msg))
+	if err != nil || job == nil {
+		t.Fatalf("timeline job %+v, error %v", job, err)
+	}
+
+	// Пользователь ответил раньше: сообщение больше не нужно
+	cancelTimeline(msg)
+
+	if job, err = jobs.Get(timelineJobId(msg)); err != nil || job != nil {
+		t.Errorf("timeline job %+v is kept, error %v", job, err)
+	}
+
+	if called := connect.called(); len(called) != 1 {
+		t.Errorf("methods %v after the reply, want the pending message dropped", called)
+	}
+}
+
+func TestCancelTimelineRunsPendingActions(t *testing.T) {
+	tests := []struct {
+		name   string
+		step   func(msg *message) step
+		method string
+	}{
+		{"close", func(msg *message) step { return closeLater(time.Minute) }, "line/drop/treatment"},
+		{"reroute", func(msg *message) step { return rerouteLater(time.Minute, msg, "", "") }, "line/appoint/start"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, connect, msg := testTimeline(t)
+
+			err := later(msg, database.STATE_PARTING,
+				sendLater(time.Second, "До свидания", nil),
+				tt.step(msg),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if called := connect.called(); len(called) != 0 {
+				t.Fatalf("methods %v before the pause", called)
+			}
+
+			// Сообщение отбрасывается, а действие выполняется сразу
+			cancelTimeline(msg)
+
+			called := connect.called()
+			if len(called) != 1 || called[0] != tt.method {
+				t.Errorf("methods %v after the reply, want %s", called, tt.method)
+			}
+		})
+	}
+}
+
+func TestRunTimeline(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   database.ChatState
+		methods int
+	}{
+		{"same state", database.STATE_MAIN_MENU, 1},
+		{"state changed", database.STATE_FORM, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, connect, msg := testTimeline(t)
+
+			chat := database.NewChat()
+			if err := changeState(db, msg, &chat, tt.state); err != nil {
+				t.Fatal(err)
+			}
+
+			err := later(msg, database.STATE_MAIN_MENU,
+				sendLater(time.Second, "Что-нибудь еще?", nil),
+				closeLater(time.Minute),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			job, err := jobs.Get(timelineJobId(msg))
+			if err != nil || job == nil {
+				t.Fatalf("timeline job %+v, error %v", job, err)
+			}
+
+			if err = runTimeline(db, job); err != nil {
+				t.Fatal(err)
+			}
+
+			if called := connect.called(); len(called) != tt.methods {
+				t.Errorf("methods %v, want %d", called, tt.methods)
+			}
+
+			// Оставшийся шаг ждет своей паузы
+			next, err := jobs.Get(timelineJobId(msg))
+			if err != nil || next == nil {
+				t.Fatalf("rest of timeline %+v, error %v", next, err)
+			}
+
+			if d := time.Until(next.At); d < 59*time.Second || d > time.Minute {
+				t.Errorf("rest of timeline in %s, want a minute", d)
+			}
+
+			if err = runTimeline(db, next); err != nil {
+				t.Fatal(err)
+			}
+
+			called := connect.called()
+			if len(called) != tt.methods+1 || called[len(called)-1] != "line/drop/treatment" {
+				t.Errorf("methods %v, want the close last", called)
+			}
+		})
+	}
+}
+
+func TestConfigurePacing(t *testing.T) {
+	tests := []struct {
+		name   string
+		pacing map[string]time.Duration
+		ok     bool
+	}{
+		{"defaults", nil, true},
+		{"known pause", map[string]time.Duration{PACE_AFTER_FILE: 0}, true},
+		{"unknown pause", map[string]time.Duration{"before_greeting": time.Second}, false},
+		{"negative pause", map[string]time.Duration{PACE_BEFORE_CLOSE: -time.Second}, false},
+	}
+
+	for _, tt := range tests {
+		tn := &tenant{conf: &config.Conf{Pacing: tt.pacing}}
+
+		if err := tn.configurePacing(); (err == nil) != tt.ok {
+			t.Errorf("%s: error %v", tt.name, err)
+		}
+	}
+}
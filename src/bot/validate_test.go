@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"connect-companion/bot/messages"
+	"connect-companion/bot/routing"
+	"connect-companion/config"
+	"connect-companion/database"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateCollectsErrors(t *testing.T) {
+	line := uuid.New()
+
+	c := &config.Conf{
+		Line: []uuid.UUID{line},
+		Tenants: []config.Tenant{
+			{Name: DEFAULT_TENANT, Lines: []uuid.UUID{uuid.New()}},
+			{Name: "shop"},
+			{Name: "branch", Lines: []uuid.UUID{line}},
+		},
+	}
+
+	err := Validate(c)
+	errs, ok := err.(config.Errors)
+	if !ok {
+		t.Fatalf("Validate() = %v, want the list of errors", err)
+	}
+
+	for _, want := range []string{"tenant 1: name", "tenant shop: lines", "belongs to tenants default and branch"} {
+		if !strings.Contains(errs.Error(), want) {
+			t.Errorf("%q is not reported in %v", want, errs)
+		}
+	}
+
+	if IsKnownLine(line) {
+		t.Error("lines are applied from the invalid configuration")
+	}
+}
+
+func TestTenantOverrides(t *testing.T) {
+	shared, own, inherited := uuid.New(), uuid.New(), uuid.New()
+
+	c := &config.Conf{
+		Line:       []uuid.UUID{shared},
+		Routing:    routing.Config{Rules: []routing.Rule{{Name: "all", Pool: []uuid.UUID{uuid.New()}}}},
+		Inactivity: map[string]config.Idle{"main_menu": {CloseAfter: time.Hour}},
+		Survey:     config.Survey{Events: []string{SURVEY_EVENT_BOT_CLOSE}},
+		Tenants: []config.Tenant{
+			{
+				Name:       "shop",
+				Lines:      []uuid.UUID{own},
+				Routing:    &routing.Config{Rules: []routing.Rule{{Name: "all", Pool: []uuid.UUID{uuid.New()}}}},
+				Inactivity: map[string]config.Idle{},
+				Pacing:     map[string]time.Duration{PACE_AFTER_FILE: time.Second},
+				Survey:     &config.Survey{Events: []string{SURVEY_EVENT_TREATMENT_CLOSE}},
+			},
+			{Name: "branch", Lines: []uuid.UUID{inherited}},
+		},
+	}
+
+	if err := Validate(c); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := func(line uuid.UUID) *message {
+		return newMessage(messages.Message{LineId: line})
+	}
+
+	if pause(msg(own), PACE_AFTER_FILE) != time.Second || pause(msg(shared), PACE_AFTER_FILE) != defaultPacing[PACE_AFTER_FILE] {
+		t.Error("pacing is not overridden for the tenant only")
+	}
+
+	if _, ok := tenantOf(own).idle[database.STATE_MAIN_MENU]; ok {
+		t.Error("reminders of the tenant are not turned off")
+	}
+	if _, ok := tenantOf(inherited).idle[database.STATE_MAIN_MENU]; !ok {
+		t.Error("reminders are not inherited")
+	}
+
+	if !isSurveyEvent(msg(own), SURVEY_EVENT_TREATMENT_CLOSE) || isSurveyEvent(msg(own), SURVEY_EVENT_BOT_CLOSE) {
+		t.Error("survey events are not overridden")
+	}
+
+	if tenantOf(own).router == defaultTenant.router || tenantOf(inherited).router != defaultTenant.router {
+		t.Error("only the tenant with own rules has its own router")
+	}
+}
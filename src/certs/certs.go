@@ -0,0 +1,144 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"connect-companion/config"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	MODE_OFF   = "off"
+	MODE_FILES = "files"
+	MODE_ACME  = "acme"
+
+	DEFAULT_CACHE_DIR = "./certs"
+
+	// Как часто проверяем изменение файлов сертификата
+	RELOAD_CHECK = 10 * time.Second
+)
+
+// Enabled tells whether the webhook listener serves TLS
+func Enabled(c config.ServerTLS) bool {
+	return c.Mode != "" && c.Mode != MODE_OFF
+}
+
+// Configure returns the TLS config of the webhook listener and the handler of the plain HTTP listener,
+// both are nil when TLS is off
+func Configure(c config.Server) (*tls.Config, http.Handler, error) {
+	if !Enabled(c.TLS) {
+		return nil, nil, nil
+	}
+
+	redirect := Redirect(c.Host)
+
+	switch c.TLS.Mode {
+	case MODE_FILES:
+		r, err := newReloader(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: r.GetCertificate,
+		}, redirect, nil
+	case MODE_ACME:
+		m, err := manager(c)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tlsConfig := m.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+
+		return tlsConfig, m.HTTPHandler(redirect), nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown tls mode %q", c.TLS.Mode)
+}
+
+func manager(c config.Server) (*autocert.Manager, error) {
+	a := c.TLS.Acme
+
+	domains := a.Domains
+	if len(domains) == 0 {
+		u, err := url.Parse(c.Host)
+		if err != nil {
+			return nil, err
+		}
+		domains = []string{u.Hostname()}
+	}
+
+	cacheDir := a.CacheDir
+	if cacheDir == "" {
+		cacheDir = DEFAULT_CACHE_DIR
+	}
+
+	client := &acme.Client{DirectoryURL: a.DirectoryUrl}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = acme.LetsEncryptURL
+	}
+
+	if a.CaFile != "" {
+		pem, err := ioutil.ReadFile(a.CaFile)
+		if err != nil {
+			return nil, err
+		}
+
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", a.CaFile)
+		}
+
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: roots},
+			},
+		}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      a.Email,
+		Client:     client,
+	}, nil
+}
+
+// Redirect sends plain HTTP requests to the same path on the host
+func Redirect(host string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, err := url.Parse(host)
+		if err != nil || target.Host == "" {
+			// Хост из настроек не разобран, перенаправляем на тот же хост без порта
+			hostname, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				hostname = r.Host
+			}
+			target = &url.URL{Host: hostname}
+		}
+
+		target.Scheme = "https"
+		target.Path = r.URL.Path
+		target.RawQuery = r.URL.RawQuery
+
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+
+		http.Redirect(w, r, target.String(), code)
+	})
+}
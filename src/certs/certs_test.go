@@ -0,0 +1,167 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"connect-companion/config"
+)
+
+// writeCert writes a self-signed certificate of the name and its key
+func writeCert(t *testing.T, dir string, name string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed.Subject.CommonName
+}
+
+func TestConfigure(t *testing.T) {
+	tlsConfig, redirect, err := Configure(config.Server{TLS: config.ServerTLS{Mode: MODE_OFF}})
+	if err != nil || tlsConfig != nil || redirect != nil {
+		t.Errorf("tls is off: %v %v %v", tlsConfig, redirect, err)
+	}
+
+	if _, _, err := Configure(config.Server{TLS: config.ServerTLS{Mode: "self"}}); err == nil {
+		t.Error("unknown mode is accepted")
+	}
+
+	if _, _, err := Configure(config.Server{TLS: config.ServerTLS{Mode: MODE_FILES, CertFile: "missing.pem", KeyFile: "missing.pem"}}); err == nil {
+		t.Error("missing certificate is accepted")
+	}
+
+	tlsConfig, redirect, err = Configure(config.Server{Host: "https://bot.example.com", TLS: config.ServerTLS{Mode: MODE_ACME}})
+	if err != nil || tlsConfig == nil || redirect == nil || tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("acme: %v %v", tlsConfig, err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	certFile, keyFile := writeCert(t, dir, "old.example.com")
+
+	r, err := newReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := r.GetCertificate(nil)
+	if name := commonName(t, cert); name != "old.example.com" {
+		t.Fatalf("certificate of %s", name)
+	}
+
+	writeCert(t, dir, "new.example.com")
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Файлы проверяются не чаще RELOAD_CHECK
+	if cert, _ = r.GetCertificate(nil); commonName(t, cert) != "old.example.com" {
+		t.Error("certificate is reloaded before the check")
+	}
+
+	r.checked = time.Time{}
+	if cert, _ = r.GetCertificate(nil); commonName(t, cert) != "new.example.com" {
+		t.Error("changed certificate is not reloaded")
+	}
+
+	// Битый файл не заменяет рабочий сертификат
+	if err := ioutil.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	_ = os.Chtimes(certFile, later, later)
+
+	r.checked = time.Time{}
+	if cert, err = r.GetCertificate(nil); err != nil || commonName(t, cert) != "new.example.com" {
+		t.Errorf("broken file replaced the certificate: %v", err)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   string
+		method string
+		target string
+		want   string
+		code   int
+	}{
+		{"host", "https://bot.example.com", http.MethodGet, "http://bot.example.com/hook?a=1", "https://bot.example.com/hook?a=1", http.StatusMovedPermanently},
+		{"port of host", "https://bot.example.com:8443", http.MethodGet, "http://bot.example.com/", "https://bot.example.com:8443/", http.StatusMovedPermanently},
+		{"post", "https://bot.example.com", http.MethodPost, "http://bot.example.com/hook", "https://bot.example.com/hook", http.StatusPermanentRedirect},
+		{"no host", "", http.MethodGet, "http://bot.example.com:80/hook", "https://bot.example.com/hook", http.StatusMovedPermanently},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Redirect(tt.host).ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+
+			if w.Code != tt.code || w.Header().Get("Location") != tt.want {
+				t.Errorf("%d %s, want %d %s", w.Code, w.Header().Get("Location"), tt.code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package certs
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"connect-companion/logger"
+)
+
+type (
+	// reloader serves the certificate from files and loads it again after they change
+	reloader struct {
+		certFile string
+		keyFile  string
+
+		mu      sync.Mutex
+		cert    *tls.Certificate
+		modTime time.Time
+		checked time.Time
+	}
+)
+
+func newReloader(certFile string, keyFile string) (*reloader, error) {
+	r := &reloader{certFile: certFile, keyFile: keyFile}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *reloader) load() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+
+	return nil
+}
+
+func (r *reloader) lastModified() (time.Time, error) {
+	var last time.Time
+
+	for _, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return last, err
+		}
+
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+
+	return last, nil
+}
+
+func (r *reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.checked) >= RELOAD_CHECK {
+		r.checked = now
+
+		// При ошибке продолжаем отдавать прежний сертификат
+		if modTime, err := r.lastModified(); err != nil {
+			logger.Warning("Error while check certificate", err)
+		} else if !modTime.Equal(r.modTime) {
+			if err := r.load(); err != nil {
+				logger.Warning("Error while reload certificate", err)
+			} else {
+				logger.Info("Certificate reloaded", r.certFile)
+			}
+		}
+	}
+
+	return r.cert, nil
+}
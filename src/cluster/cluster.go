@@ -0,0 +1,180 @@
+package cluster
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"connect-companion/database"
+	"connect-companion/logger"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+const (
+	DEFAULT_TTL = 15 * time.Second
+)
+
+type (
+	// Node is the instance of the bot among others sharing the same redis.
+	// The leader manages hooks and scheduled jobs, leadership expires after ttl
+	// if the leader dies.
+	Node struct {
+		db  redis.UniversalClient
+		id  string
+		ttl time.Duration
+
+		mu     sync.RWMutex
+		leader bool
+
+		stop chan struct{}
+		done chan struct{}
+	}
+)
+
+// acquire takes free leadership or extends the one held by the node
+var acquire = redis.NewScript(`
+local owner = redis.call('GET', KEYS[1])
+if not owner then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+if owner == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+var release = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func New(db redis.UniversalClient, ttl time.Duration) *Node {
+	if ttl <= 0 {
+		ttl = DEFAULT_TTL
+	}
+
+	return &Node{
+		db:   db,
+		id:   uuid.New().String(),
+		ttl:  ttl,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (n *Node) Id() string {
+	return n.id
+}
+
+func (n *Node) IsLeader() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.leader
+}
+
+// Join registers the node and tries to become the leader at once
+func (n *Node) Join() {
+	n.heartbeat()
+}
+
+// Run keeps the node registered and the leadership taken until Stop
+func (n *Node) Run() {
+	defer close(n.done)
+
+	ticker := time.NewTicker(n.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+			n.heartbeat()
+		}
+	}
+}
+
+// Stop gives up the leadership and unregisters the node
+func (n *Node) Stop() {
+	close(n.stop)
+	<-n.done
+
+	if n.IsLeader() {
+		if err := release.Run(n.db, []string{database.KEY_LEADER}, n.id).Err(); err != nil {
+			logger.Warning("Error while release leadership", err)
+		}
+
+		n.setLeader(false)
+	}
+
+	if err := n.db.ZRem(database.KEY_INSTANCES, n.id).Err(); err != nil {
+		logger.Warning("Error while unregister instance", err)
+	}
+}
+
+// Others returns the number of other live instances
+func (n *Node) Others() (int64, error) {
+	ids, err := n.db.ZRangeByScore(database.KEY_INSTANCES, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(n.now()-n.ttl.Milliseconds(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var others int64
+	for _, id := range ids {
+		if id != n.id {
+			others++
+		}
+	}
+
+	return others, nil
+}
+
+func (n *Node) heartbeat() {
+	now := n.now()
+
+	_, err := n.db.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(database.KEY_INSTANCES, &redis.Z{Score: float64(now), Member: n.id})
+		pipe.ZRemRangeByScore(database.KEY_INSTANCES, "-inf", strconv.FormatInt(now-n.ttl.Milliseconds(), 10))
+
+		return nil
+	})
+	if err != nil {
+		logger.Warning("Error while register instance", err)
+	}
+
+	acquired, err := acquire.Run(n.db, []string{database.KEY_LEADER}, n.id, n.ttl.Milliseconds()).Int()
+	if err != nil {
+		logger.Warning("Error while acquire leadership", err)
+	}
+
+	n.setLeader(err == nil && acquired == 1)
+}
+
+func (n *Node) setLeader(leader bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.leader != leader {
+		if leader {
+			logger.Info("Instance", n.id, "became the leader")
+		} else {
+			logger.Info("Instance", n.id, "is not the leader anymore")
+		}
+	}
+
+	n.leader = leader
+}
+
+func (n *Node) now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
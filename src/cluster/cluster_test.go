@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"connect-companion/database"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+)
+
+func newRedis(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+
+	db := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = db.Close() })
+
+	return mr, db
+}
+
+func TestLeadership(t *testing.T) {
+	mr, db := newRedis(t)
+
+	a, b := New(db, time.Second), New(db, time.Second)
+
+	a.Join()
+	b.Join()
+
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("leaders: a %v, b %v", a.IsLeader(), b.IsLeader())
+	}
+
+	for _, n := range []*Node{a, b} {
+		if others, err := n.Others(); err != nil || others != 1 {
+			t.Errorf("%s sees %d others, %v", n.Id(), others, err)
+		}
+	}
+
+	// Лидер продлевает свое лидерство
+	a.heartbeat()
+	b.heartbeat()
+	if !a.IsLeader() || b.IsLeader() {
+		t.Error("leadership changed while the leader is alive")
+	}
+
+	// Лидерство умершего экземпляра истекает через ttl
+	mr.FastForward(time.Second)
+	b.heartbeat()
+	if !b.IsLeader() {
+		t.Error("leadership of the dead leader is not taken")
+	}
+
+	a.heartbeat()
+	if a.IsLeader() {
+		t.Error("two leaders")
+	}
+}
+
+func TestStop(t *testing.T) {
+	_, db := newRedis(t)
+
+	a, b := New(db, 0), New(db, 0)
+	if a.ttl != DEFAULT_TTL {
+		t.Errorf("ttl %v, want %v", a.ttl, DEFAULT_TTL)
+	}
+
+	a.Join()
+	go a.Run()
+
+	b.Join()
+
+	a.Stop()
+
+	if a.IsLeader() {
+		t.Error("stopped node is the leader")
+	}
+	if owner, _ := db.Get(database.KEY_LEADER).Result(); owner != "" {
+		t.Errorf("leadership is kept by %s", owner)
+	}
+	if others, _ := b.Others(); others != 0 {
+		t.Errorf("stopped node is counted, %d others", others)
+	}
+
+	// Остальные подхватывают лидерство при следующей проверке
+	b.heartbeat()
+	if !b.IsLeader() {
+		t.Error("released leadership is not taken")
+	}
+}
+
+func TestRedisFailure(t *testing.T) {
+	mr, db := newRedis(t)
+
+	n := New(db, time.Second)
+	n.Join()
+	if !n.IsLeader() {
+		t.Fatal("single node is not the leader")
+	}
+
+	// Без redis экземпляр не может подтвердить лидерство и отказывается от него
+	mr.Close()
+	n.heartbeat()
+	if n.IsLeader() {
+		t.Error("node is the leader without redis")
+	}
+}
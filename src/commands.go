@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"connect-companion/bot"
+	"connect-companion/database"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// command runs with arguments after its name, configuration is loaded already
+	command func(args []string) error
+)
+
+var (
+	commands = map[string]command{
+		"serve":     serve,
+		"hooks":     hooksCommand,
+		"send":      sendCommand,
+		"treatment": treatmentCommand,
+		"state":     stateCommand,
+		"config":    configCommand,
+		"flow":      flowCommand,
+	}
+
+	errUsage = errors.New("wrong arguments")
+)
+
+const USAGE = `Usage: %s [flags] [command]
+
+Commands:
+  serve                                  start the bot (default)
+  hooks list                             show hooks registered on 1C-Connect
+  hooks set|delete [line...]             register or remove hooks, all lines of the config by default
+  send message <line> <user> <text>      send the text to the user
+  send file <line> <user> <path> [text]  send the file with optional comment
+  treatment close <line> <user>          close the treatment
+  treatment reroute <line> <user>        pass the treatment to the line specialists
+  treatment assign <line> <user> <spec>  pass the treatment to the specialist
+  state get <line> <user>                print the chat state
+  state set <line> <user> <state>        move the user to the state: ` + "%s" + `
+  state reset <line> <user>              forget the chat state
+  state migrate                          upgrade all stored chat states
+  config validate                        check the config file
+  flow lint                              look for mistakes in the dialog
+
+Flags:
+`
+
+func usage() {
+	var names []string
+	for name := range database.StateByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	_, _ = fmt.Fprintf(flag.CommandLine.Output(), USAGE, filepath.Base(os.Args[0]), strings.Join(names, ", "))
+	flag.PrintDefaults()
+}
+
+// parseIds parses exactly n uuids from the beginning of the arguments
+func parseIds(args []string, n int) ([]uuid.UUID, error) {
+	if len(args) < n {
+		return nil, errUsage
+	}
+
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		id, err := uuid.Parse(args[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q: %v", args[i], err)
+		}
+		ids[i] = id
+	}
+
+	return ids, nil
+}
+
+// subcommand splits arguments into the name of the action and the rest
+func subcommand(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "", nil
+	}
+
+	return args[0], args[1:]
+}
+
+func hooksCommand(args []string) error {
+	if err := bot.ConfigureClient(cnf); err != nil {
+		return err
+	}
+
+	action, args := subcommand(args)
+
+	if action == "list" {
+		hooks, err := bot.ListHooks()
+		if err != nil {
+			return err
+		}
+
+		for account, content := range hooks {
+			fmt.Println(account)
+			fmt.Println(string(content))
+		}
+
+		return nil
+	}
+
+	lines := bot.Lines()
+	if len(args) > 0 {
+		var err error
+		if lines, err = parseIds(args, len(args)); err != nil {
+			return err
+		}
+	}
+
+	var apply func(uuid.UUID) error
+	switch action {
+	case "set":
+		apply = bot.SetHook
+	case "delete":
+		apply = bot.DeleteHook
+	default:
+		return errUsage
+	}
+
+	for _, line := range lines {
+		if err := apply(line); err != nil {
+			return fmt.Errorf("line %s: %v", line, err)
+		}
+
+		fmt.Println(action, line)
+	}
+
+	return nil
+}
+
+func sendCommand(args []string) error {
+	if err := bot.ConfigureClient(cnf); err != nil {
+		return err
+	}
+
+	action, args := subcommand(args)
+
+	ids, err := parseIds(args, 2)
+	if err != nil {
+		return err
+	}
+	args = args[2:]
+
+	switch {
+	case action == "message" && len(args) > 0:
+		_, err = bot.SendMessage(ids[0], ids[1], strings.Join(args, " "), nil)
+	case action == "file" && len(args) > 0:
+		var comment *string
+		if len(args) > 1 {
+			text := strings.Join(args[1:], " ")
+			comment = &text
+		}
+
+		_, err = bot.SendFile(ids[0], ids[1], filepath.Base(args[0]), args[0], comment, nil)
+	default:
+		return errUsage
+	}
+
+	return err
+}
+
+func treatmentCommand(args []string) error {
+	if err := bot.ConfigureClient(cnf); err != nil {
+		return err
+	}
+
+	action, args := subcommand(args)
+
+	n := 2
+	if action == "assign" {
+		n = 3
+	}
+
+	ids, err := parseIds(args, n)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "close":
+		_, err = bot.CloseTreatment(ids[0], ids[1])
+	case "reroute":
+		_, err = bot.RerouteTreatment(ids[0], ids[1])
+	case "assign":
+		_, err = bot.RerouteTreatmentToSpec(ids[0], ids[1], ids[2])
+	default:
+		return errUsage
+	}
+
+	return err
+}
+
+// stateCommand works with redis only, the bot is not configured
+func stateCommand(args []string) error {
+	action, args := subcommand(args)
+
+	db, err := database.Connect(cnf.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if action == "migrate" {
+		migrated, reset, err := database.MigrateAll(db)
+		fmt.Println("migrated:", migrated, "reset:", reset)
+
+		return err
+	}
+
+	ids, err := parseIds(args, 2)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case action == "get":
+		chatState := bot.GetState(db, ids[0], ids[1])
+
+		data, err := json.MarshalIndent(chatState, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(database.StateName(chatState.CurrentState))
+		fmt.Println(string(data))
+
+		return nil
+	case action == "set" && len(args) > 2:
+		state, ok := database.StateByName[args[2]]
+		if !ok {
+			return fmt.Errorf("unknown state %q", args[2])
+		}
+
+		return bot.SetState(db, ids[0], ids[1], state)
+	case action == "reset":
+		return bot.ResetState(db, ids[0], ids[1])
+	}
+
+	return errUsage
+}
+
+func configCommand(args []string) error {
+	if action, _ := subcommand(args); action != "validate" {
+		return errUsage
+	}
+
+	if err := bot.Validate(cnf); err != nil {
+		return err
+	}
+
+	fmt.Println("Config is valid")
+
+	return nil
+}
+
+func flowCommand(args []string) error {
+	if action, _ := subcommand(args); action != "lint" {
+		return errUsage
+	}
+
+	if err := bot.Validate(cnf); err != nil {
+		return err
+	}
+
+	problems := bot.LintFlow()
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems", len(problems))
+	}
+
+	fmt.Println("No problems found")
+
+	return nil
+}
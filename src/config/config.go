@@ -1,6 +1,15 @@
 package config
 
 import (
+	"time"
+
+	"connect-companion/bot/access"
+	"connect-companion/bot/directory"
+	"connect-companion/bot/docgen"
+	"connect-companion/bot/forms"
+	"connect-companion/bot/routing"
+	"connect-companion/bot/schedule"
+	"connect-companion/bot/throttle"
 	"connect-companion/database"
 
 	"github.com/gin-gonic/gin"
@@ -15,21 +24,204 @@ type (
 		Server   Server         `yaml:"server"`
 		Database database.Redis `yaml:"database"`
 
-		Connect Connect `yaml:"connect"`
+		Connect  Connect         `yaml:"connect"`
+		Hooks    Hooks           `yaml:"hooks"`
+		Cluster  Cluster         `yaml:"cluster"`
+		Outbound throttle.Config `yaml:"outbound"`
+		Inbound  Inbound         `yaml:"inbound"`
+		Routing  routing.Config  `yaml:"routing"`
+		Schedule schedule.Config `yaml:"schedule"`
+
+		// Employee profiles for phrases, documents and routing rules
+		Directory directory.Config `yaml:"directory"`
+		// Groups of users for access rules of documents and forms
+		Access access.Config `yaml:"access"`
+
+		// Reminders and auto-close of idle chats by state name
+		Inactivity map[string]Idle `yaml:"inactivity"`
+
+		// Pauses between messages of the bot by name, only after_file, before_close and before_reroute
+		Pacing map[string]time.Duration `yaml:"pacing"`
+
+		Survey     Survey     `yaml:"survey"`
+		Admin      Admin      `yaml:"admin"`
+		Navigation Navigation `yaml:"navigation"`
+
+		Forms []forms.Form `yaml:"forms"`
+		// Storage and PDF converter of documents issued by forms with the document action
+		Generator docgen.Config `yaml:"generator"`
+
+		// Documents of the main menu and overrides of bot phrases (Go templates)
+		Documents []Document        `yaml:"documents"`
+		Phrases   map[string]string `yaml:"phrases"`
+		Locales   Locales           `yaml:"locales"`
+		Knowledge Knowledge         `yaml:"knowledge"`
 
 		FilesDir string      `yaml:"files_dir"`
 		Line     []uuid.UUID `yaml:"line"`
+
+		// Lines with their own Connect account, menu, phrases and working hours
+		Tenants []Tenant `yaml:"tenants"`
+	}
+
+	// Tenant overrides the settings above for its lines, empty fields are inherited
+	Tenant struct {
+		Name  string      `yaml:"name"`
+		Lines []uuid.UUID `yaml:"lines"`
+
+		Connect  *Connect         `yaml:"connect"`
+		Schedule *schedule.Config `yaml:"schedule"`
+		Routing  *routing.Config  `yaml:"routing"`
+
+		// Заданные состояния заменяют общие целиком, inactivity: {} выключает напоминания
+		Inactivity map[string]Idle `yaml:"inactivity"`
+		// Паузы дополняют общие
+		Pacing map[string]time.Duration `yaml:"pacing"`
+		// Events and close_after, responses are kept for all tenants together
+		Survey *Survey `yaml:"survey"`
+
+		Forms     []forms.Form      `yaml:"forms"`
+		Documents []Document        `yaml:"documents"`
+		Phrases   map[string]string `yaml:"phrases"`
+		Locales   *Locales          `yaml:"locales"`
+		Knowledge *Knowledge        `yaml:"knowledge"`
+
+		FilesDir string `yaml:"files_dir"`
+	}
+
+	// Knowledge base of Markdown articles answered right in the chat
+	Knowledge struct {
+		// <files_dir>/kb by default, the knowledge base is off without articles
+		Dir string `yaml:"dir"`
+
+		// How many articles are offered, 3 by default
+		Results int `yaml:"results"`
+		// Articles scored lower are not offered, from 0 to 1
+		MinScore float64 `yaml:"min_score"`
+
+		// Text of PDF and DOCX documents searched too: all (files of files_dir and the menu, default),
+		// menu (documents of the menu only) or off
+		Documents string `yaml:"documents"`
+		// Pages scored lower are not offered, from 0 to 1
+		DocumentMinScore float64 `yaml:"document_min_score"`
 	}
 
 	Server struct {
 		Host   string `yaml:"host"`
 		Listen string `yaml:"listen"`
+
+		TLS ServerTLS `yaml:"tls"`
+	}
+
+	// TLS of the webhook listener, nginx in front is not needed then
+	ServerTLS struct {
+		// off (default), files or acme
+		Mode string `yaml:"mode"`
+
+		// Certificate and key in PEM, reloaded when the files change
+		CertFile string `yaml:"cert_file"`
+		KeyFile  string `yaml:"key_file"`
+
+		Acme Acme `yaml:"acme"`
+
+		// Plain HTTP listener redirecting to server.host and answering ACME challenges
+		RedirectListen string `yaml:"redirect_listen"`
+	}
+
+	Acme struct {
+		// Host of server.host by default
+		Domains []string `yaml:"domains"`
+		Email   string   `yaml:"email"`
+
+		// Let's Encrypt by default, e.g. https://localhost:14000/dir for Pebble
+		DirectoryUrl string `yaml:"directory_url"`
+		// Root certificate of the directory if it is not trusted by the system
+		CaFile string `yaml:"ca_file"`
+
+		// Issued certificates and the account key, ./certs by default
+		CacheDir string `yaml:"cache_dir"`
+	}
+
+	Document struct {
+		Id    string `yaml:"id"`
+		Title string `yaml:"title"`
+		File  string `yaml:"file"`
+
+		// Who sees the document in the menu and in search results, everyone by default
+		Access access.Rule `yaml:"access"`
+	}
+
+	// Locales are phrase catalogs <locale>.yaml or <locale>.po in the directory
+	Locales struct {
+		Default string `yaml:"default"`
+		Dir     string `yaml:"dir"`
+	}
+
+	Survey struct {
+		// Events after which the user is asked to rate the bot: bot_close, treatment_close
+		Events []string `yaml:"events"`
+		// How many last responses are kept, 10000 by default. Statistics count all of them.
+		MaxResponses int `yaml:"max_responses"`
+		// The treatment of the abandoned survey is closed after this, 10m by default
+		CloseAfter time.Duration `yaml:"close_after"`
+	}
+
+	Admin struct {
+		Login        string `yaml:"login"`
+		Password     string `yaml:"password"`
+		PasswordFile string `yaml:"password_file"`
+	}
+
+	Navigation struct {
+		// How many menus «Назад» remembers, 10 by default
+		MaxDepth int `yaml:"max_depth"`
+	}
+
+	Idle struct {
+		RemindAfter time.Duration `yaml:"remind_after"`
+		CloseAfter  time.Duration `yaml:"close_after"`
+	}
+
+	Hooks struct {
+		// How often hooks are verified on 1C-Connect, 1m by default
+		CheckInterval time.Duration `yaml:"check_interval"`
+	}
+
+	// Cluster of bot instances sharing the same redis
+	Cluster struct {
+		// Leadership is lost if the leader does not renew it in time, 15s by default
+		LeaderTtl time.Duration `yaml:"leader_ttl"`
+
+		// When hooks are deleted on shutdown: last (the last instance leaves), always, never
+		DeleteHooks string `yaml:"delete_hooks"`
+	}
+
+	// Limits of messages from users, so one user can not flood the bot
+	Inbound struct {
+		// Messages per second of every user, unlimited if 0
+		Rate  float64 `yaml:"rate"`
+		Burst int     `yaml:"burst"`
+
+		// Messages per second of all users of the line, unlimited if 0
+		LineRate  float64 `yaml:"line_rate"`
+		LineBurst int     `yaml:"line_burst"`
+
+		// What to do with excess messages: drop (default), coalesce (answer the last one later)
+		// or notice (warn the user once)
+		Policy string `yaml:"policy"`
+
+		// So many excess messages within block_window block the user for block_for, never if 0.
+		// 1m and 1h by default.
+		BlockAfter  int           `yaml:"block_after"`
+		BlockWindow time.Duration `yaml:"block_window"`
+		BlockFor    time.Duration `yaml:"block_for"`
 	}
 
 	Connect struct {
-		Server   string `yaml:"server"`
-		Login    string `yaml:"login"`
-		Password string `yaml:"password"`
+		Server       string `yaml:"server"`
+		Login        string `yaml:"login"`
+		Password     string `yaml:"password"`
+		PasswordFile string `yaml:"password_file"`
 	}
 )
 
@@ -38,3 +230,77 @@ func Inject(cnf *Conf) gin.HandlerFunc {
 		c.Set("cnf", cnf)
 	}
 }
+
+// ForTenant returns the configuration of the tenant lines
+func (c *Conf) ForTenant(t *Tenant) *Conf {
+	tc := *c
+	tc.Line = t.Lines
+	tc.Tenants = nil
+
+	if t.Connect != nil {
+		tc.Connect = *t.Connect
+	}
+
+	if t.Schedule != nil {
+		tc.Schedule = *t.Schedule
+	}
+
+	if t.Routing != nil {
+		tc.Routing = *t.Routing
+	}
+
+	if t.Inactivity != nil {
+		tc.Inactivity = t.Inactivity
+	}
+
+	if len(t.Pacing) > 0 {
+		tc.Pacing = make(map[string]time.Duration, len(c.Pacing)+len(t.Pacing))
+		for name, d := range c.Pacing {
+			tc.Pacing[name] = d
+		}
+		for name, d := range t.Pacing {
+			tc.Pacing[name] = d
+		}
+	}
+
+	if t.Survey != nil {
+		if t.Survey.Events != nil {
+			tc.Survey.Events = t.Survey.Events
+		}
+		if t.Survey.CloseAfter > 0 {
+			tc.Survey.CloseAfter = t.Survey.CloseAfter
+		}
+	}
+
+	if t.Forms != nil {
+		tc.Forms = t.Forms
+	}
+
+	if t.Documents != nil {
+		tc.Documents = t.Documents
+	}
+
+	if len(t.Phrases) > 0 {
+		tc.Phrases = make(map[string]string, len(c.Phrases)+len(t.Phrases))
+		for key, text := range c.Phrases {
+			tc.Phrases[key] = text
+		}
+		for key, text := range t.Phrases {
+			tc.Phrases[key] = text
+		}
+	}
+
+	if t.Locales != nil {
+		tc.Locales = *t.Locales
+	}
+
+	if t.Knowledge != nil {
+		tc.Knowledge = *t.Knowledge
+	}
+
+	if t.FilesDir != "" {
+		tc.FilesDir = t.FilesDir
+	}
+
+	return &tc
+}
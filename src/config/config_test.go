@@ -0,0 +1,78 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"connect-companion/bot/routing"
+
+	"github.com/google/uuid"
+)
+
+func TestForTenant(t *testing.T) {
+	base := &Conf{
+		Line:       []uuid.UUID{uuid.New()},
+		Routing:    routing.Config{Rules: []routing.Rule{{Name: "shared"}}},
+		Inactivity: map[string]Idle{"main_menu": {CloseAfter: time.Hour}},
+		Pacing:     map[string]time.Duration{"after_file": time.Second, "before_close": time.Second},
+		Survey:     Survey{Events: []string{"bot_close"}, MaxResponses: 100, CloseAfter: time.Minute},
+		Phrases:    map[string]string{"greeting": "Hello", "again": "Again"},
+	}
+
+	shop := &Tenant{
+		Name:       "shop",
+		Lines:      []uuid.UUID{uuid.New()},
+		Routing:    &routing.Config{Rules: []routing.Rule{{Name: "shop"}}},
+		Inactivity: map[string]Idle{},
+		Pacing:     map[string]time.Duration{"after_file": 0},
+		Survey:     &Survey{Events: []string{"treatment_close"}, CloseAfter: time.Hour},
+		Phrases:    map[string]string{"greeting": "Welcome"},
+	}
+
+	tc := base.ForTenant(shop)
+
+	if len(tc.Line) != 1 || tc.Line[0] != shop.Lines[0] || tc.Tenants != nil {
+		t.Errorf("lines %v of the tenant", tc.Line)
+	}
+
+	if len(tc.Routing.Rules) != 1 || tc.Routing.Rules[0].Name != "shop" {
+		t.Errorf("routing %+v is not overridden", tc.Routing)
+	}
+
+	if len(tc.Inactivity) != 0 {
+		t.Error("empty inactivity does not turn reminders off")
+	}
+
+	if tc.Pacing["after_file"] != 0 || tc.Pacing["before_close"] != time.Second {
+		t.Errorf("pacing %v, want the override on top of the shared one", tc.Pacing)
+	}
+
+	if len(tc.Survey.Events) != 1 || tc.Survey.Events[0] != "treatment_close" || tc.Survey.MaxResponses != 100 {
+		t.Errorf("survey %+v", tc.Survey)
+	}
+	if tc.Survey.CloseAfter != time.Hour {
+		t.Errorf("survey close_after %s of the tenant is ignored", tc.Survey.CloseAfter)
+	}
+
+	// Арендатор может задать только close_after, события остаются общими
+	closing := base.ForTenant(&Tenant{Name: "closing", Survey: &Survey{CloseAfter: time.Hour}})
+	if len(closing.Survey.Events) != 1 || closing.Survey.CloseAfter != time.Hour {
+		t.Errorf("survey %+v with close_after only", closing.Survey)
+	}
+
+	if tc.Phrases["greeting"] != "Welcome" || tc.Phrases["again"] != "Again" {
+		t.Errorf("phrases %v", tc.Phrases)
+	}
+
+	// Общие настройки не меняются
+	if base.Pacing["after_file"] != time.Second || base.Phrases["greeting"] != "Hello" {
+		t.Error("shared config is changed")
+	}
+
+	// Без переопределений арендатор наследует все
+	inherited := base.ForTenant(&Tenant{Name: "branch"})
+	if len(inherited.Routing.Rules) != 1 || len(inherited.Inactivity) != 1 || len(inherited.Survey.Events) != 1 ||
+		inherited.Survey.CloseAfter != time.Minute {
+		t.Errorf("shared settings are not inherited: %+v", inherited)
+	}
+}
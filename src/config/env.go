@@ -0,0 +1,208 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Суффикс ключей, значение соседнего ключа без суффикса читается из файла
+	SUFFIX_FILE = "_file"
+
+	// Переменная с префиксом важнее одноименной без него, если та уже занята чужой программой
+	ENV_PREFIX = "COMPANION"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	uuidType     = reflect.TypeOf(uuid.UUID{})
+)
+
+// yamlName returns the key of the field in the config file, empty if the field is not configurable
+func yamlName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+
+	return name
+}
+
+// readSecret reads the value from the file dropping the trailing line break
+func readSecret(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveFiles reads values of string fields from files given by their <name>_file siblings,
+// so secrets may be kept out of the config file
+func resolveFiles(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return resolveFiles(v.Elem(), path)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveFiles(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+
+		fields := make(map[string]int, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			if name := yamlName(t.Field(i)); name != "" {
+				fields[name] = i
+			}
+		}
+
+		for name, i := range fields {
+			key := name
+			if path != "" {
+				key = path + "." + name
+			}
+
+			if err := resolveFiles(v.Field(i), key); err != nil {
+				return err
+			}
+
+			target, ok := fields[strings.TrimSuffix(name, SUFFIX_FILE)]
+			if !ok || target == i || v.Field(i).Kind() != reflect.String || v.Field(i).String() == "" {
+				continue
+			}
+
+			if v.Field(target).String() != "" {
+				return fmt.Errorf("%s: both value and file are set", key)
+			}
+
+			secret, err := readSecret(v.Field(i).String())
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+
+			v.Field(target).SetString(secret)
+		}
+	}
+
+	return nil
+}
+
+// applyEnv overrides fields with environment variables named by the path of yaml keys,
+// e.g. CONNECT_PASSWORD for connect.password or CONNECT_PASSWORD_FILE for connect.password_file.
+// The same name with the COMPANION_ prefix takes precedence.
+// Lists of lines and strings are separated by commas, lists of structs and maps are not supported.
+func applyEnv(v reflect.Value, path string) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name := yamlName(t.Field(i))
+		if name == "" {
+			continue
+		}
+
+		env := strings.ToUpper(name)
+		if path != "" {
+			env = path + "_" + env
+		}
+
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct && field.Type() != uuidType {
+			if err := applyEnv(field, env); err != nil {
+				return err
+			}
+			continue
+		}
+
+		key, value, ok := lookupEnv(env)
+		if !ok {
+			continue
+		}
+
+		if err := setValue(field, value); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+
+	return nil
+}
+
+// lookupEnv returns the variable with the prefix or, if it is not set, the plain one
+func lookupEnv(name string) (string, string, bool) {
+	for _, key := range []string{ENV_PREFIX + "_" + name, name} {
+		if value, ok := os.LookupEnv(key); ok {
+			return key, value, true
+		}
+	}
+
+	return "", "", false
+}
+
+func setValue(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Type() == uuidType:
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(id))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case field.Kind() == reflect.Slice && (field.Type().Elem() == uuidType || field.Type().Elem().Kind() == reflect.String):
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("%s can not be set from environment", field.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type envTest struct {
+	Name     string        `yaml:"name"`
+	Enabled  bool          `yaml:"enabled"`
+	Count    int           `yaml:"count"`
+	Score    float64       `yaml:"score"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Line     uuid.UUID     `yaml:"line"`
+	Lines    []uuid.UUID   `yaml:"lines"`
+	Tags     []string      `yaml:"tags"`
+	Password string        `yaml:"password"`
+	Nested   struct {
+		PasswordFile string `yaml:"password_file"`
+		Password     string `yaml:"password"`
+	} `yaml:"nested"`
+	Items []struct {
+		Secret     string `yaml:"secret"`
+		SecretFile string `yaml:"secret_file"`
+	} `yaml:"items"`
+	Limits map[string]int `yaml:"limits"`
+}
+
+func setenv(t *testing.T, name string, value string) {
+	t.Helper()
+
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Unsetenv(name) })
+}
+
+func TestSetValue(t *testing.T) {
+	line := uuid.MustParse("4e48509f-6366-4897-9544-46f006e47074")
+	other := uuid.MustParse("5a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d")
+
+	tests := []struct {
+		field string
+		value string
+		want  interface{}
+	}{
+		{"Name", "bot", "bot"},
+		{"Enabled", "true", true},
+		{"Enabled", "0", false},
+		{"Count", "42", 42},
+		{"Score", "0.15", 0.15},
+		{"Timeout", "1m30s", 90 * time.Second},
+		{"Line", line.String(), line},
+		{"Lines", line.String() + ", " + other.String() + ",", []uuid.UUID{line, other}},
+		{"Tags", "a,b , c", []string{"a", "b", "c"}},
+		{"Tags", "", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field+"="+tt.value, func(t *testing.T) {
+			var c envTest
+
+			field := reflect.ValueOf(&c).Elem().FieldByName(tt.field)
+			if err := setValue(field, tt.value); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := field.Interface(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s = %#v, want %#v", tt.field, got, tt.want)
+			}
+		})
+	}
+
+	errors := []struct {
+		field string
+		value string
+	}{
+		{"Enabled", "yes please"},
+		{"Count", "1.5"},
+		{"Score", "high"},
+		{"Timeout", "10"},
+		{"Line", "line"},
+		{"Lines", "line, " + line.String()},
+		{"Limits", "a=1"},
+	}
+
+	for _, tt := range errors {
+		var c envTest
+
+		if err := setValue(reflect.ValueOf(&c).Elem().FieldByName(tt.field), tt.value); err == nil {
+			t.Errorf("%s=%q: error expected", tt.field, tt.value)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	setenv(t, "COMPANION_NAME", "from env")
+	setenv(t, "COMPANION_COUNT", "7")
+	setenv(t, "COMPANION_NESTED_PASSWORD", "secret")
+	// Документированная форма без префикса
+	setenv(t, "ENABLED", "true")
+	setenv(t, "NESTED_PASSWORD_FILE", "/run/secrets/password")
+	// Переменная с префиксом важнее
+	setenv(t, "NAME", "plain")
+
+	c := envTest{Name: "from file", Score: 0.5}
+
+	if err := applyEnv(reflect.ValueOf(&c).Elem(), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Name != "from env" || c.Count != 7 || c.Nested.Password != "secret" {
+		t.Errorf("config is not overridden: %+v", c)
+	}
+
+	if !c.Enabled || c.Nested.PasswordFile != "/run/secrets/password" {
+		t.Errorf("variables without prefix are not applied: %+v", c)
+	}
+
+	if c.Score != 0.5 {
+		t.Error("value of the file is lost")
+	}
+
+	setenv(t, "TIMEOUT", "soon")
+	if err := applyEnv(reflect.ValueOf(&c).Elem(), ""); err == nil {
+		t.Error("error expected for invalid duration")
+	}
+}
+
+func TestResolveFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	secret := filepath.Join(dir, "secret")
+	if err = ioutil.WriteFile(secret, []byte("s3cr3t\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var c envTest
+	c.Nested.PasswordFile = secret
+	c.Items = make([]struct {
+		Secret     string `yaml:"secret"`
+		SecretFile string `yaml:"secret_file"`
+	}, 2)
+	c.Items[1].SecretFile = secret
+
+	if err = resolveFiles(reflect.ValueOf(&c).Elem(), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Nested.Password != "s3cr3t" {
+		t.Errorf("nested password %q, want the file without line break", c.Nested.Password)
+	}
+
+	if c.Items[0].Secret != "" || c.Items[1].Secret != "s3cr3t" {
+		t.Errorf("secrets of items %+v", c.Items)
+	}
+
+	// Значение и файл одновременно
+	c.Nested.Password = "inline"
+	if err = resolveFiles(reflect.ValueOf(&c).Elem(), ""); err == nil {
+		t.Error("error expected when both value and file are set")
+	}
+
+	c.Nested.Password = ""
+	c.Nested.PasswordFile = filepath.Join(dir, "missing")
+	if err = resolveFiles(reflect.ValueOf(&c).Elem(), ""); err == nil {
+		t.Error("error expected for missing file")
+	}
+}
@@ -1,12 +1,13 @@
 package config
 
 import (
-	"io"
+	"fmt"
 	"io/ioutil"
 	"log"
-	"os"
 	"path/filepath"
+	"reflect"
 
+	"connect-companion/database"
 	"connect-companion/logger"
 
 	"gopkg.in/yaml.v2"
@@ -16,41 +17,56 @@ type Parser interface {
 	ParseYAML([]byte) error
 }
 
+// ParseYAML decodes the config strictly: unknown keys are errors
 func (c *Conf) ParseYAML(b []byte) error {
-	return yaml.Unmarshal(b, &c)
+	return yaml.UnmarshalStrict(b, c)
 }
 
-func configLoad(configFile string, p Parser) {
+func configLoad(configFile string, p Parser) error {
 	var err error
 
 	logger.Debug("Load configuration at ")
 
 	if configFile, err = filepath.Abs(configFile); err != nil {
-		log.Fatalln(err)
+		return err
 	}
 
 	log.Printf("%+v", configFile)
 
-	var input = io.ReadCloser(os.Stdin)
-	if input, err = os.Open(configFile); err != nil {
-		log.Fatalln(err)
-	}
-
 	// Read the config file
-	yamlBytes, err := ioutil.ReadAll(input)
-	input.Close()
-
+	yamlBytes, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 
 	// Parse the config
 	if err := p.ParseYAML(yamlBytes); err != nil {
-		//log.Fatalf("Content: %v", yamlBytes)
-		log.Fatalf("Could not parse %q: %v", configFile, err)
+		return fmt.Errorf("could not parse %q: %v", configFile, err)
 	}
+
+	return nil
 }
 
-func GetConfig(configPath string, cnf *Conf) {
-	configLoad(configPath, cnf)
+// GetConfig loads the config file, applies environment overrides, reads secrets from files,
+// validates the result and sets the prefix of redis keys
+func GetConfig(configPath string, cnf *Conf) error {
+	if err := configLoad(configPath, cnf); err != nil {
+		return err
+	}
+
+	if err := applyEnv(reflect.ValueOf(cnf).Elem(), ""); err != nil {
+		return fmt.Errorf("environment: %v", err)
+	}
+
+	if err := resolveFiles(reflect.ValueOf(cnf).Elem(), ""); err != nil {
+		return err
+	}
+
+	if err := cnf.Validate(); err != nil {
+		return err
+	}
+
+	database.UsePrefix(cnf.Database.Prefix)
+
+	return nil
 }
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type (
+	// Errors collects all mistakes of the configuration to report them at once
+	Errors []error
+)
+
+func (e Errors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, "invalid configuration:")
+
+	for _, err := range e {
+		lines = append(lines, " - "+err.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (e *Errors) add(format string, args ...interface{}) {
+	*e = append(*e, fmt.Errorf(format, args...))
+}
+
+// Validate checks values which do not depend on the bot logic
+func (c *Conf) Validate() error {
+	var errs Errors
+
+	if _, _, err := net.SplitHostPort(c.Server.Listen); err != nil {
+		errs.add("server.listen: %v", err)
+	}
+
+	validateUrl(&errs, "server.host", c.Server.Host)
+	validateTLS(&errs, c.Server)
+
+	if err := c.Database.Validate(); err != nil {
+		errs.add("database: %v", err)
+	}
+
+	for _, addr := range c.Database.Addresses() {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs.add("database.addrs: %v", err)
+		}
+	}
+
+	validateConnect(&errs, "connect", c.Connect)
+	validateDir(&errs, "files_dir", c.FilesDir)
+
+	lines := len(c.Line)
+
+	for i, t := range c.Tenants {
+		prefix := fmt.Sprintf("tenants[%d]", i)
+		if t.Name != "" {
+			prefix = "tenants." + t.Name
+		}
+
+		lines += len(t.Lines)
+
+		if t.Connect != nil {
+			validateConnect(&errs, prefix+".connect", *t.Connect)
+		}
+
+		if t.FilesDir != "" {
+			validateDir(&errs, prefix+".files_dir", t.FilesDir)
+		}
+
+		if t.Survey != nil && t.Survey.MaxResponses != 0 {
+			errs.add("%s.survey.max_responses: responses of all tenants are kept together, set it at the top level", prefix)
+		}
+	}
+
+	if lines == 0 {
+		errs.add("line: at least one line is required")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateTLS(errs *Errors, s Server) {
+	switch s.TLS.Mode {
+	case "", "off":
+		return
+	case "files":
+		if s.TLS.CertFile == "" || s.TLS.KeyFile == "" {
+			errs.add("server.tls: cert_file and key_file are required")
+		}
+	case "acme":
+	default:
+		errs.add("server.tls.mode: unknown mode %q", s.TLS.Mode)
+	}
+
+	if !strings.HasPrefix(s.Host, "https://") {
+		errs.add("server.host: %q must be https with tls", s.Host)
+	}
+
+	if s.TLS.RedirectListen != "" {
+		if _, _, err := net.SplitHostPort(s.TLS.RedirectListen); err != nil {
+			errs.add("server.tls.redirect_listen: %v", err)
+		}
+	}
+}
+
+func validateConnect(errs *Errors, prefix string, c Connect) {
+	validateUrl(errs, prefix+".server", c.Server)
+
+	if c.Login == "" {
+		errs.add("%s.login is required", prefix)
+	}
+}
+
+func validateUrl(errs *Errors, name string, value string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		errs.add("%s: %v", name, err)
+		return
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.add("%s: %q is not an http(s) url", name, value)
+	}
+}
+
+func validateDir(errs *Errors, name string, path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		errs.add("%s: %v", name, err)
+		return
+	}
+
+	if !fi.IsDir() {
+		errs.add("%s: %q is not a directory", name, path)
+	}
+}
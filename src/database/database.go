@@ -1,34 +1,282 @@
 package database
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v7"
 )
 
+const (
+	MODE_SINGLE   = "single"
+	MODE_SENTINEL = "sentinel"
+	MODE_CLUSTER  = "cluster"
+
+	DEFAULT_PREFIX         = "demo_bot:"
+	DEFAULT_MIN_IDLE_CONNS = 3
+)
+
 type (
 	Redis struct {
-		Addr     string `yaml:"addr"`
-		Password string `yaml:"password"`
+		// single (default), sentinel or cluster
+		Mode string `yaml:"mode"`
+
+		// Address of the server, or addrs of sentinels or cluster nodes
+		Addr       string   `yaml:"addr"`
+		Addrs      []string `yaml:"addrs"`
+		MasterName string   `yaml:"master_name"`
+
+		Username     string `yaml:"username"`
+		Password     string `yaml:"password"`
+		PasswordFile string `yaml:"password_file"`
+
+		DB           int `yaml:"db"`
+		PoolSize     int `yaml:"pool_size"`
+		MinIdleConns int `yaml:"min_idle_conns"`
+
+		DialTimeout  time.Duration `yaml:"dial_timeout"`
+		ReadTimeout  time.Duration `yaml:"read_timeout"`
+		WriteTimeout time.Duration `yaml:"write_timeout"`
+		PoolTimeout  time.Duration `yaml:"pool_timeout"`
+
+		TLS TLS `yaml:"tls"`
+
+		// Prefix of all keys of the bot, demo_bot: by default
+		Prefix string `yaml:"prefix"`
+	}
+
+	TLS struct {
+		Enabled            bool   `yaml:"enabled"`
+		CaFile             string `yaml:"ca_file"`
+		CertFile           string `yaml:"cert_file"`
+		KeyFile            string `yaml:"key_file"`
+		ServerName         string `yaml:"server_name"`
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
 	}
 )
 
 const (
-	PREFIX_STATE = "demo_bot:chat_state:"
-	EXPIRE       = 30 * 24 * time.Hour
+	EXPIRE = 30 * 24 * time.Hour
 )
 
-func Connect(d Redis) *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:         d.Addr,     // use default Addr
-		Password:     d.Password, // no password set
-		DB:           0,          // use default DB
-		MinIdleConns: 3,
-	})
+// Ключи зависят от префикса из настроек, см. UsePrefix.
+// Redis Cluster runs a transaction only on keys of one slot, so keys written together share
+// a hash tag: {jobs}, {csat}, {generated} and limits of a line under {<line>}.
+// Other keys are written one at a time.
+var (
+	PREFIX_STATE     string
+	PREFIX_QUESTIONS string
+	PREFIX_CSAT      string
+	PREFIX_LOCK      string
+	PREFIX_BLOCKED   string
+	PREFIX_INBOUND   string
+	PREFIX_ROUTING   string
+
+	// Записи о выданных документах, индекс по времени и по пользователям
+	PREFIX_GENERATED      string
+	PREFIX_GENERATED_USER string
+
+	// Hash tag keeps keys of jobs in one slot of redis cluster
+	KEY_JOBS          string
+	KEY_JOBS_DATA     string
+	KEY_JOBS_INFLIGHT string
+
+	KEY_LEADER    string
+	KEY_INSTANCES string
+	KEY_BLOCKLIST string
+	KEY_GENERATED string
+)
+
+func init() {
+	UsePrefix(DEFAULT_PREFIX)
+}
+
+// UsePrefix sets keys of the bot by the prefix of the config, demo_bot: if it is empty.
+// It is called once when the config is loaded, before any client is connected.
+func UsePrefix(prefix string) {
+	if prefix == "" {
+		prefix = DEFAULT_PREFIX
+	}
+
+	PREFIX_STATE = prefix + "chat_state:"
+	PREFIX_QUESTIONS = prefix + "questions:"
+	PREFIX_CSAT = prefix + "{csat}:"
+	PREFIX_LOCK = prefix + "lock:"
+	PREFIX_BLOCKED = prefix + "blocked:"
+	PREFIX_INBOUND = prefix + "inbound:"
+	PREFIX_ROUTING = prefix + "routing:"
+	PREFIX_GENERATED = prefix + "{generated}:"
+	PREFIX_GENERATED_USER = prefix + "{generated}:user:"
+
+	KEY_JOBS = prefix + "{jobs}"
+	KEY_JOBS_DATA = prefix + "{jobs}:data"
+	KEY_JOBS_INFLIGHT = prefix + "{jobs}:inflight"
+
+	KEY_LEADER = prefix + "leader"
+	KEY_INSTANCES = prefix + "instances"
+	KEY_BLOCKLIST = prefix + "blocklist"
+	KEY_GENERATED = prefix + "{generated}:time"
+}
+
+// Addresses returns addresses of the server or nodes
+func (d *Redis) Addresses() []string {
+	if len(d.Addrs) > 0 {
+		return d.Addrs
+	}
+
+	if d.Addr != "" {
+		return []string{d.Addr}
+	}
+
+	return nil
+}
+
+// Validate checks the mode and its required settings
+func (d *Redis) Validate() error {
+	switch d.Mode {
+	case "", MODE_SINGLE:
+		if len(d.Addresses()) != 1 {
+			return errors.New("single mode requires exactly one address")
+		}
+	case MODE_SENTINEL:
+		if d.MasterName == "" {
+			return errors.New("sentinel mode requires master_name")
+		}
+	case MODE_CLUSTER:
+		if d.DB != 0 {
+			return errors.New("cluster mode supports db 0 only")
+		}
+	default:
+		return fmt.Errorf("unknown mode %q", d.Mode)
+	}
+
+	if len(d.Addresses()) == 0 {
+		return errors.New("addr or addrs is required")
+	}
+
+	if d.TLS.CertFile != "" && d.TLS.KeyFile == "" || d.TLS.CertFile == "" && d.TLS.KeyFile != "" {
+		return errors.New("tls requires both cert_file and key_file")
+	}
+
+	// Иначе настройки молча игнорируются и соединение идет без шифрования
+	if !d.TLS.Enabled && d.TLS != (TLS{}) {
+		return errors.New("tls settings are set while tls is not enabled")
+	}
+
+	return nil
+}
+
+func (t *TLS) config() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	c := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CaFile != "" {
+		ca, err := ioutil.ReadFile(t.CaFile)
+		if err != nil {
+			return nil, err
+		}
+
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in %s", t.CaFile)
+		}
+	}
+
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
+
+// Connect creates the client for the configured mode, keys use the prefix set by UsePrefix
+func Connect(d Redis) (redis.UniversalClient, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := d.TLS.config()
+	if err != nil {
+		return nil, fmt.Errorf("tls: %v", err)
+	}
+
+	if d.MinIdleConns == 0 {
+		d.MinIdleConns = DEFAULT_MIN_IDLE_CONNS
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:        d.Addresses(),
+		MasterName:   d.MasterName,
+		Username:     d.Username,
+		Password:     d.Password,
+		DB:           d.DB,
+		PoolSize:     d.PoolSize,
+		MinIdleConns: d.MinIdleConns,
+		DialTimeout:  d.DialTimeout,
+		ReadTimeout:  d.ReadTimeout,
+		WriteTimeout: d.WriteTimeout,
+		PoolTimeout:  d.PoolTimeout,
+		TLSConfig:    tlsConfig,
+	}
+
+	switch d.Mode {
+	case MODE_SENTINEL:
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case MODE_CLUSTER:
+		return redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return redis.NewClient(opts.Simple()), nil
+	}
+}
+
+// ScanKeys calls fn for keys matching the pattern on every master of the cluster
+// or on the single server
+func ScanKeys(db redis.UniversalClient, pattern string, fn func(client redis.Cmdable, keys []string) error) error {
+	scan := func(client redis.Cmdable) error {
+		var cursor uint64
+
+		for {
+			keys, next, err := client.Scan(cursor, pattern, 100).Result()
+			if err != nil {
+				return err
+			}
+
+			if err = fn(client, keys); err != nil {
+				return err
+			}
+
+			if cursor = next; cursor == 0 {
+				return nil
+			}
+		}
+	}
+
+	if cluster, ok := db.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(func(client *redis.Client) error {
+			return scan(client)
+		})
+	}
+
+	return scan(db)
 }
 
-func Inject(key string, redis *redis.Client) gin.HandlerFunc {
+func Inject(key string, redis redis.UniversalClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Set(key, redis)
 	}
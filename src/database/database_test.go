@@ -0,0 +1,43 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+// hashTag returns the part of the key redis cluster hashes, like the cluster does
+func hashTag(key string) string {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+1+e]
+		}
+	}
+
+	return key
+}
+
+func TestKeysOfOneSlot(t *testing.T) {
+	defer UsePrefix(DEFAULT_PREFIX)
+
+	for _, prefix := range []string{"", "bot:", "hr:{bot}:"} {
+		UsePrefix(prefix)
+
+		groups := map[string][]string{
+			"jobs":      {KEY_JOBS, KEY_JOBS_DATA, KEY_JOBS_INFLIGHT},
+			"csat":      {PREFIX_CSAT + "responses", PREFIX_CSAT + "stats:line", PREFIX_CSAT + "stats:day"},
+			"generated": {KEY_GENERATED, PREFIX_GENERATED + "id", PREFIX_GENERATED_USER + "user"},
+		}
+
+		for name, keys := range groups {
+			for _, key := range keys[1:] {
+				if hashTag(key) != hashTag(keys[0]) {
+					t.Errorf("prefix %q: %s and %s of %s are in different slots", prefix, key, keys[0], name)
+				}
+			}
+
+			if !strings.HasPrefix(keys[0], prefix) {
+				t.Errorf("prefix %q: key %s", prefix, keys[0])
+			}
+		}
+	}
+}
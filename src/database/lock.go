@@ -0,0 +1,92 @@
+package database
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"connect-companion/logger"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+const (
+	LOCK_RETRY = 50 * time.Millisecond
+)
+
+var ErrLocked = errors.New("lock is held by another owner")
+
+// unlock deletes the lock only if it is still held by the owner
+var unlock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// refresh prolongs the lock only if it is still held by the owner
+var refresh = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Lock takes the lock shared by all instances waiting up to wait for it.
+// The lock is refreshed until it is released and expires after ttl if the owner dies.
+func Lock(db redis.UniversalClient, key string, ttl time.Duration, wait time.Duration) (func(), error) {
+	token := uuid.New().String()
+	deadline := time.Now().Add(wait)
+
+	for {
+		ok, err := db.SetNX(key, token, ttl).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return hold(db, key, token, ttl), nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrLocked
+		}
+
+		time.Sleep(LOCK_RETRY)
+	}
+}
+
+// hold refreshes the taken lock and returns the function releasing it
+func hold(db redis.UniversalClient, key string, token string, ttl time.Duration) func() {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				held, err := refresh.Run(db, []string{key}, token, int64(ttl/time.Millisecond)).Int()
+				if err != nil {
+					logger.Warning("Error while refresh lock", key, err)
+				} else if held == 0 {
+					logger.Warning("Lock", key, "is lost")
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			close(stop)
+			_ = unlock.Run(db, []string{key}, token).Err()
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+)
+
+func TestLock(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	db := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	const ttl = 300 * time.Millisecond
+
+	unlock, err := Lock(db, "lock", ttl, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Lock(db, "lock", ttl, 2*LOCK_RETRY); err != ErrLocked {
+		t.Fatalf("second lock: %v, want ErrLocked", err)
+	}
+
+	// Без обновления ключ истек бы
+	s.FastForward(ttl - 50*time.Millisecond)
+	time.Sleep(ttl/3 + 50*time.Millisecond)
+
+	if left := s.TTL("lock"); left < ttl/2 {
+		t.Errorf("lock is not refreshed, %s left", left)
+	}
+
+	unlock()
+	unlock()
+
+	if s.Exists("lock") {
+		t.Error("lock is not released")
+	}
+
+	again, err := Lock(db, "lock", ttl, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again()
+
+	// Чужую блокировку старый владелец не снимает
+	unlock()
+	if !s.Exists("lock") {
+		t.Error("lock of another owner is released")
+	}
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"connect-companion/logger"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// CHAT_VERSION is the current schema version of the stored chat state
+const CHAT_VERSION = 1
+
+type (
+	// Migration upgrades decoded JSON of the chat from version N to N+1
+	Migration func(raw map[string]interface{}) error
+)
+
+// replaceChat writes the migrated state only if the chat was not changed since it was read,
+// the TTL of the chat is kept
+var replaceChat = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl <= 0 then
+	ttl = tonumber(ARGV[3])
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ttl)
+return 1
+`)
+
+// Миграции по исходной версии: migrations[N] переводит состояние из версии N в N+1
+var migrations = map[int]Migration{
+	// Состояния без версии записаны до появления схемы, формат совпадает с первой версией
+	0: func(raw map[string]interface{}) error {
+		return nil
+	},
+}
+
+// RegisterMigration adds the upgrade of the chat state from version from to from+1. It is called
+// from init of the package which changes the chat, together with the increase of CHAT_VERSION.
+func RegisterMigration(from int, m Migration) {
+	if _, ok := migrations[from]; ok {
+		panic(fmt.Sprintf("migration from version %d is registered twice", from))
+	}
+
+	migrations[from] = m
+}
+
+// SaveMigrated writes the upgraded chat back unless it was changed since data was read,
+// so the migration is not repeated on every read
+func SaveMigrated(db redis.Cmdable, key string, data []byte, chat Chat) (bool, error) {
+	encoded, err := json.Marshal(chat)
+	if err != nil {
+		return false, err
+	}
+
+	replaced, err := replaceChat.Run(db, []string{key}, data, encoded, EXPIRE.Milliseconds()).Int()
+
+	return replaced == 1, err
+}
+
+// DecodeChat decodes the stored chat state upgrading it to CHAT_VERSION.
+// Error means the state is corrupt or written by a newer version of the bot.
+func DecodeChat(data []byte) (Chat, bool, error) {
+	var chat Chat
+
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return chat, false, err
+	}
+
+	version := 0
+	if v, ok := raw["v"]; ok {
+		f, ok := v.(float64)
+		if !ok || f != float64(int(f)) {
+			return chat, false, fmt.Errorf("invalid version %v", v)
+		}
+		version = int(f)
+	}
+
+	if version > CHAT_VERSION {
+		return chat, false, fmt.Errorf("unknown version %d", version)
+	}
+
+	migrated := version < CHAT_VERSION
+
+	for ; version < CHAT_VERSION; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return chat, false, fmt.Errorf("no migration from version %d", version)
+		}
+
+		if err := migrate(raw); err != nil {
+			return chat, false, fmt.Errorf("migration from version %d: %v", version, err)
+		}
+	}
+
+	raw["v"] = CHAT_VERSION
+
+	upgraded, err := json.Marshal(raw)
+	if err != nil {
+		return chat, false, err
+	}
+
+	if err = json.Unmarshal(upgraded, &chat); err != nil {
+		return chat, false, err
+	}
+
+	if !IsKnownState(chat.CurrentState) {
+		return chat, false, fmt.Errorf("unknown state %d", chat.CurrentState)
+	}
+
+	if !IsKnownState(chat.PreviousState) {
+		chat.PreviousState = STATE_GREETINGS
+	}
+
+	history := chat.History[:0]
+	for _, state := range chat.History {
+		if IsKnownState(state) {
+			history = append(history, state)
+		}
+	}
+	chat.History = history
+
+	return chat, migrated, nil
+}
+
+// NewChat returns the state of the chat which has just started
+func NewChat() Chat {
+	return Chat{
+		Version:       CHAT_VERSION,
+		PreviousState: STATE_GREETINGS,
+		CurrentState:  STATE_GREETINGS,
+	}
+}
+
+// MigrateAll upgrades all stored chat states at once, corrupt ones are reset to greeting.
+// It may run beside the bot: a chat changed meanwhile is skipped, the bot writes the current version.
+func MigrateAll(db redis.UniversalClient) (migrated int, reset int, err error) {
+	err = ScanKeys(db, PREFIX_STATE+"*", func(client redis.Cmdable, keys []string) error {
+		for _, key := range keys {
+			data, err := client.Get(key).Bytes()
+			if err == redis.Nil {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			chat, ok, decodeErr := DecodeChat(data)
+			if decodeErr == nil && !ok {
+				continue
+			} else if decodeErr != nil {
+				chat = NewChat()
+			}
+
+			replaced, err := SaveMigrated(client, key, data, chat)
+			if err != nil {
+				return err
+			}
+
+			if !replaced {
+				logger.Debug("Skip state", key, "changed while migrating")
+				continue
+			}
+
+			if decodeErr != nil {
+				logger.Warning("Reset corrupt state", key, decodeErr, string(data))
+				reset++
+			} else {
+				migrated++
+			}
+		}
+
+		return nil
+	})
+
+	return
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+)
+
+func TestDecodeChat(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		state    ChatState
+		migrated bool
+		err      bool
+	}{
+		{"without version", `{"prev_state":0,"curr_state":300}`, STATE_MAIN_MENU, true, false},
+		{"current", `{"v":1,"prev_state":0,"curr_state":300}`, STATE_MAIN_MENU, false, false},
+		{"newer", `{"v":2,"prev_state":0,"curr_state":300}`, 0, false, true},
+		{"invalid version", `{"v":"1","curr_state":300}`, 0, false, true},
+		{"unknown state", `{"v":1,"curr_state":12345}`, 0, false, true},
+		{"corrupt", `{"v":1,`, 0, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat, migrated, err := DecodeChat([]byte(tt.data))
+			if (err != nil) != tt.err {
+				t.Fatalf("error %v", err)
+			}
+			if err != nil {
+				return
+			}
+
+			if chat.CurrentState != tt.state || migrated != tt.migrated || chat.Version != CHAT_VERSION {
+				t.Errorf("chat %+v, migrated %v", chat, migrated)
+			}
+		})
+	}
+
+	// Неизвестные состояния истории и предыдущее состояние отбрасываются
+	chat, _, err := DecodeChat([]byte(`{"v":1,"prev_state":999,"curr_state":300,"history":[300,999]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.PreviousState != STATE_GREETINGS || len(chat.History) != 1 {
+		t.Errorf("chat %+v", chat)
+	}
+}
+
+func TestMigrateAll(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	db := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	_ = db.Set(PREFIX_STATE+"old", `{"prev_state":0,"curr_state":300}`, time.Hour)
+	_ = db.Set(PREFIX_STATE+"current", `{"v":1,"prev_state":0,"curr_state":300}`, 0)
+	_ = db.Set(PREFIX_STATE+"corrupt", `{"v":`, 0)
+
+	migrated, reset, err := MigrateAll(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 1 || reset != 1 {
+		t.Errorf("migrated %d, reset %d", migrated, reset)
+	}
+
+	var chat Chat
+	if err := json.Unmarshal([]byte(mustGet(t, s, PREFIX_STATE+"old")), &chat); err != nil || chat.Version != CHAT_VERSION {
+		t.Errorf("old chat %+v, %v", chat, err)
+	}
+	if ttl := s.TTL(PREFIX_STATE + "old"); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("ttl of the migrated chat %s", ttl)
+	}
+
+	if err := json.Unmarshal([]byte(mustGet(t, s, PREFIX_STATE+"corrupt")), &chat); err != nil || chat.CurrentState != STATE_GREETINGS {
+		t.Errorf("corrupt chat %+v, %v", chat, err)
+	}
+	if ttl := s.TTL(PREFIX_STATE + "corrupt"); ttl != EXPIRE {
+		t.Errorf("ttl of the reset chat %s", ttl)
+	}
+}
+
+func TestRegisterMigration(t *testing.T) {
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+
+	migrations = map[int]Migration{}
+	RegisterMigration(0, func(raw map[string]interface{}) error {
+		raw["lang"] = "ru"
+		return nil
+	})
+
+	chat, migrated, err := DecodeChat([]byte(`{"prev_state":0,"curr_state":300}`))
+	if err != nil || !migrated || chat.Language != "ru" {
+		t.Errorf("chat %+v, migrated %v, %v", chat, migrated, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("second migration from the same version is registered")
+		}
+	}()
+	RegisterMigration(0, func(raw map[string]interface{}) error { return nil })
+}
+
+// Состояние, записанное ботом во время миграции, не затирается
+func TestReplaceChat(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	db := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	key := PREFIX_STATE + "chat"
+	_ = db.Set(key, "written by the bot", 0)
+
+	replaced, err := replaceChat.Run(db, []string{key}, "read before", "migrated", EXPIRE.Milliseconds()).Int()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if replaced != 0 || mustGet(t, s, key) != "written by the bot" {
+		t.Errorf("changed chat is replaced by the migrated copy")
+	}
+}
+
+func mustGet(t *testing.T, s *miniredis.Miniredis, key string) string {
+	t.Helper()
+
+	value, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return value
+}
@@ -1,17 +1,106 @@
 package database
 
+import "strconv"
+
 type (
 	ChatState int
 
 	Chat struct {
+		// Версия схемы, см. CHAT_VERSION
+		Version int `json:"v" example:"1"`
+
 		PreviousState ChatState `json:"prev_state" binding:"required" example:"100"`
 		CurrentState  ChatState `json:"curr_state" binding:"required" example:"300"`
+
+		// Шаги пользователя в текущем диалоге, последний отправленный документ и все документы диалога
+		Path      []string `json:"path,omitempty" example:"Регламент.pdf"`
+		Document  string   `json:"document,omitempty" example:"Регламент.pdf"`
+		Documents []string `json:"documents,omitempty" example:"Регламент.pdf"`
+
+		// Язык пользователя
+		Language string `json:"lang,omitempty" example:"ru"`
+
+		// Переменные чата, доступные в шаблонах фраз
+		Context map[string]string `json:"context,omitempty"`
+
+		// Статьи базы знаний, предложенные на последний вопрос
+		Articles []string `json:"articles,omitempty" example:"vacation"`
+		// Документы, в тексте которых нашелся ответ
+		Found []string `json:"found,omitempty" example:"Положение о персонале.pdf"`
+
+		// Стек пройденных меню для кнопки «Назад»
+		History []ChatState `json:"history,omitempty" example:"300"`
+
+		Survey *Survey    `json:"survey,omitempty"`
+		Form   *FormState `json:"form,omitempty"`
+	}
+
+	// FormState holds answers of the form being filled
+	FormState struct {
+		Id      string            `json:"id" example:"vacation"`
+		Step    int               `json:"step" example:"1"`
+		Answers map[string]string `json:"answers"`
+	}
+
+	Survey struct {
+		Event  string `json:"event" example:"bot_close"`
+		Rating int    `json:"rating,omitempty" example:"5"`
 	}
 )
 
 const (
-	STATE_DUMMY     = 0
-	STATE_GREETINGS = 100
-	STATE_MAIN_MENU = 300
-	STATE_PARTING   = 500
+	STATE_DUMMY     ChatState = 0
+	STATE_GREETINGS ChatState = 100
+	STATE_MAIN_MENU ChatState = 300
+	STATE_QUESTION  ChatState = 400
+	STATE_PARTING   ChatState = 500
+
+	STATE_SURVEY_RATING  ChatState = 600
+	STATE_SURVEY_COMMENT ChatState = 610
+
+	STATE_FORM ChatState = 700
+
+	STATE_LANGUAGE ChatState = 800
+
+	STATE_ARTICLES ChatState = 900
 )
+
+var StateByName = map[string]ChatState{
+	"greetings": STATE_GREETINGS,
+	"main_menu": STATE_MAIN_MENU,
+	"question":  STATE_QUESTION,
+	"parting":   STATE_PARTING,
+
+	"survey_rating":  STATE_SURVEY_RATING,
+	"survey_comment": STATE_SURVEY_COMMENT,
+
+	"form":     STATE_FORM,
+	"language": STATE_LANGUAGE,
+	"articles": STATE_ARTICLES,
+}
+
+// StateName returns the name of the state used in the configuration
+func StateName(state ChatState) string {
+	for name, s := range StateByName {
+		if s == state {
+			return name
+		}
+	}
+
+	return strconv.Itoa(int(state))
+}
+
+// IsKnownState tells whether the state exists in the current version of the bot
+func IsKnownState(state ChatState) bool {
+	if state == STATE_DUMMY {
+		return true
+	}
+
+	for _, s := range StateByName {
+		if s == state {
+			return true
+		}
+	}
+
+	return false
+}
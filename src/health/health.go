@@ -0,0 +1,95 @@
+package health
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// Check returns nil when the dependency is fine
+	Check func() error
+
+	Result struct {
+		Ok       bool   `json:"ok"`
+		Error    string `json:"error,omitempty"`
+		Duration string `json:"duration"`
+	}
+)
+
+var (
+	mu     sync.Mutex
+	checks = map[string]Check{}
+
+	started = time.Now()
+)
+
+// Add registers the readiness check, the same name replaces the previous one
+func Add(name string, check Check) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	checks[name] = check
+}
+
+func Init(app *gin.Engine) {
+	app.GET("/healthz", Healthz)
+	app.GET("/readyz", Readyz)
+}
+
+// Healthz answers while the process is alive
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"ok":     true,
+		"uptime": time.Since(started).Round(time.Second).String(),
+	})
+}
+
+// Readyz runs all checks and fails if any of them fails
+func Readyz(c *gin.Context) {
+	ok, results := Ready()
+
+	code := http.StatusOK
+	if !ok {
+		code = http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, gin.H{
+		"ok":     ok,
+		"checks": results,
+	})
+}
+
+func Ready() (bool, map[string]Result) {
+	mu.Lock()
+	names := make([]string, 0, len(checks))
+	current := make(map[string]Check, len(checks))
+	for name, check := range checks {
+		names = append(names, name)
+		current[name] = check
+	}
+	mu.Unlock()
+
+	sort.Strings(names)
+
+	ok := true
+	results := make(map[string]Result, len(names))
+
+	for _, name := range names {
+		start := time.Now()
+		err := current[name]()
+
+		r := Result{Ok: err == nil, Duration: time.Since(start).String()}
+		if err != nil {
+			r.Error = err.Error()
+			ok = false
+		}
+
+		results[name] = r
+	}
+
+	return ok, results
+}
@@ -0,0 +1,149 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func resetChecks(t *testing.T) {
+	mu.Lock()
+	checks = map[string]Check{}
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		mu.Lock()
+		checks = map[string]Check{}
+		mu.Unlock()
+	})
+}
+
+func setenv(t *testing.T, name string, value string) {
+	t.Helper()
+
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Unsetenv(name) })
+}
+
+func TestReady(t *testing.T) {
+	resetChecks(t)
+
+	Add("redis", func() error { return nil })
+	Add("connect", func() error { return errors.New("down") })
+
+	ok, results := Ready()
+	if ok {
+		t.Error("ready with the failed check")
+	}
+
+	if !results["redis"].Ok || results["connect"].Ok || results["connect"].Error != "down" {
+		t.Errorf("results %+v", results)
+	}
+
+	// То же имя заменяет проверку
+	Add("connect", func() error { return nil })
+
+	if ok, _ = Ready(); !ok {
+		t.Error("not ready after the check is replaced")
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	resetChecks(t)
+	gin.SetMode(gin.TestMode)
+
+	app := gin.New()
+	Init(app)
+
+	readyz := func() (int, map[string]Result) {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		var body struct {
+			Checks map[string]Result `json:"checks"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		return w.Code, body.Checks
+	}
+
+	if code, _ := readyz(); code != http.StatusOK {
+		t.Errorf("code %d without checks, want 200", code)
+	}
+
+	Add("hooks", func() error { return errors.New("no working hook") })
+
+	code, results := readyz()
+	if code != http.StatusServiceUnavailable || results["hooks"].Error != "no working hook" {
+		t.Errorf("code %d, checks %+v", code, results)
+	}
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("healthz code %d of the unready process", w.Code)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	if WatchdogInterval() != 0 {
+		t.Error("watchdog is on without systemd")
+	}
+
+	setenv(t, "WATCHDOG_USEC", "30000000")
+	if got := WatchdogInterval(); got != 30*time.Second {
+		t.Errorf("interval %s, want 30s", got)
+	}
+
+	// Сторожевой таймер другого процесса
+	setenv(t, "WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if WatchdogInterval() != 0 {
+		t.Error("watchdog of another process is used")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	if err := Notify("READY=1"); err != nil {
+		t.Errorf("notify outside of systemd: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	setenv(t, "NOTIFY_SOCKET", socket)
+
+	if err = Notify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("systemd got %q, %v", buf[:n], err)
+	}
+}
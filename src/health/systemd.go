@@ -0,0 +1,64 @@
+package health
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"connect-companion/logger"
+)
+
+// Notify sends the state to systemd, e.g. READY=1. Outside of systemd does nothing.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// Абстрактный сокет задается с @ в начале
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+
+	return err
+}
+
+// WatchdogInterval returns how often systemd expects WATCHDOG=1, zero when watchdog is off
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Watchdog keeps systemd watchdog satisfied while the process is alive
+func Watchdog() {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := Notify("WATCHDOG=1"); err != nil {
+			logger.Warning("Error while notify systemd watchdog", err)
+		}
+	}
+}
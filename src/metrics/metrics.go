@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	TYPE_COUNTER = "counter"
+	TYPE_GAUGE   = "gauge"
+
+	CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+type (
+	// Sample is the value with label values in the order of the metric labels
+	Sample struct {
+		Labels []string
+		Value  float64
+	}
+
+	metric struct {
+		name    string
+		help    string
+		kind    string
+		labels  []string
+		collect func() []Sample
+	}
+
+	// Counter only grows, every combination of label values is counted separately
+	Counter struct {
+		mu     sync.Mutex
+		values map[string]*Sample
+	}
+)
+
+var (
+	mu       sync.Mutex
+	registry = map[string]*metric{}
+)
+
+func register(m *metric) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	registry[m.name] = m
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{values: map[string]*Sample{}}
+
+	register(&metric{name: name, help: help, kind: TYPE_COUNTER, labels: labels, collect: c.collect})
+
+	return c
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(v float64, values ...string) {
+	key := strings.Join(values, "\x00")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.values[key]
+	if !ok {
+		s = &Sample{Labels: values}
+		c.values[key] = s
+	}
+	s.Value += v
+}
+
+func (c *Counter) collect() []Sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	samples := make([]Sample, 0, len(c.values))
+	for _, s := range c.values {
+		samples = append(samples, *s)
+	}
+
+	return samples
+}
+
+// GaugeFunc registers the gauge computed on every scrape
+func GaugeFunc(name string, help string, collect func() []Sample, labels ...string) {
+	register(&metric{name: name, help: help, kind: TYPE_GAUGE, labels: labels, collect: collect})
+}
+
+func Init(app *gin.Engine) {
+	app.GET("/metrics", Handler)
+}
+
+// Handler writes all metrics in the Prometheus text format
+func Handler(c *gin.Context) {
+	mu.Lock()
+	list := make([]*metric, 0, len(registry))
+	for _, m := range registry {
+		list = append(list, m)
+	}
+	mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].name < list[j].name
+	})
+
+	var buf bytes.Buffer
+	for _, m := range list {
+		m.write(&buf)
+	}
+
+	c.Data(http.StatusOK, CONTENT_TYPE, buf.Bytes())
+}
+
+func (m *metric) write(buf *bytes.Buffer) {
+	samples := m.collect()
+
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Labels, "\x00") < strings.Join(samples[j].Labels, "\x00")
+	})
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", m.name, m.kind)
+
+	for _, s := range samples {
+		buf.WriteString(m.name)
+
+		if len(m.labels) > 0 {
+			pairs := make([]string, 0, len(m.labels))
+			for i, label := range m.labels {
+				value := ""
+				if i < len(s.Labels) {
+					value = s.Labels[i]
+				}
+				pairs = append(pairs, label+"="+strconv.Quote(value))
+			}
+
+			buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+		}
+
+		buf.WriteString(" " + strconv.FormatFloat(s.Value, 'g', -1, 64) + "\n")
+	}
+}
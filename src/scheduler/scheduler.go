@@ -0,0 +1,412 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"connect-companion/database"
+	"connect-companion/logger"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+)
+
+const (
+	// Задания проверяются четыре раза в секунду, более короткие паузы округляются вверх
+	POLL_INTERVAL = 250 * time.Millisecond
+	BATCH_SIZE    = 100
+	// Handlers run concurrently, e.g. a slow file upload does not hold other chats
+	WORKERS = 8
+
+	// The job is returned to the queue if its handler has not finished during the lease,
+	// e.g. the instance crashed
+	LEASE = 2 * time.Minute
+
+	// Failed jobs are retried after 5s, 10s, 20s and so on up to 10m
+	RETRY_DELAY     = 5 * time.Second
+	MAX_RETRY_DELAY = 10 * time.Minute
+	MAX_ATTEMPTS    = 10
+)
+
+type (
+	Job struct {
+		Id     string          `json:"id"`
+		Kind   string          `json:"kind"`
+		At     time.Time       `json:"at"`
+		LineId uuid.UUID       `json:"line_id"`
+		UserId uuid.UUID       `json:"user_id"`
+		Data   json.RawMessage `json:"data,omitempty"`
+		// Failed runs of the job
+		Attempt int `json:"attempt,omitempty"`
+	}
+
+	// Handler runs the job. The failed job is retried with Data as the handler left it,
+	// so steps which are already done may be marked there.
+	Handler func(job *Job) error
+
+	// Scheduler keeps jobs in redis so they survive restarts. A due job is claimed
+	// atomically with a lease, so only one of several instances fires it, and is removed
+	// only after its handler succeeds.
+	Scheduler struct {
+		db redis.UniversalClient
+
+		mu       sync.RWMutex
+		handlers map[string]Handler
+		active   func() bool
+		// Задания, обработчики которых выполняются на этом экземпляре
+		running map[string]bool
+
+		workers chan struct{}
+		wg      sync.WaitGroup
+
+		stop chan struct{}
+		done chan struct{}
+	}
+)
+
+var (
+	// claim moves the due job to in-flight jobs with the lease deadline and returns its payload
+	claim = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[2]) then
+	return false
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+local data = redis.call('HGET', KEYS[3], ARGV[1])
+if not data then
+	return false
+end
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+return data
+`)
+
+	// complete removes the finished job unless the handler has scheduled it again
+	complete = redis.NewScript(`
+redis.call('ZREM', KEYS[2], ARGV[1])
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	redis.call('HDEL', KEYS[3], ARGV[1])
+end
+return 1
+`)
+
+	// retry returns the failed job to the queue unless it was scheduled again or cancelled
+	retry = redis.NewScript(`
+redis.call('ZREM', KEYS[2], ARGV[1])
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) or redis.call('HEXISTS', KEYS[3], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[3])
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+return 1
+`)
+
+	// requeue returns the job with the expired lease to the queue
+	requeue = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[2], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) or redis.call('HEXISTS', KEYS[3], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+return 1
+`)
+)
+
+func New(db redis.UniversalClient) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		handlers: make(map[string]Handler),
+		running:  make(map[string]bool),
+		workers:  make(chan struct{}, WORKERS),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *Scheduler) Handle(kind string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[kind] = h
+}
+
+// OnlyWhen makes the scheduler fire jobs only while active returns true, e.g. on the leader
+func (s *Scheduler) OnlyWhen(active func() bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active = active
+}
+
+// Schedule adds the job or moves it to the new time if the job with the same id exists
+func (s *Scheduler) Schedule(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(database.KEY_JOBS_DATA, job.Id, data)
+		pipe.ZAdd(database.KEY_JOBS, &redis.Z{
+			Score:  float64(millis(job.At)),
+			Member: job.Id,
+		})
+
+		return nil
+	})
+
+	return err
+}
+
+func (s *Scheduler) Cancel(id string) error {
+	_, err := s.db.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZRem(database.KEY_JOBS, id)
+		pipe.ZRem(database.KEY_JOBS_INFLIGHT, id)
+		pipe.HDel(database.KEY_JOBS_DATA, id)
+
+		return nil
+	})
+
+	return err
+}
+
+// Get returns the pending job, nil if it is not scheduled, already fired or running
+func (s *Scheduler) Get(id string) (*Job, error) {
+	var (
+		score *redis.FloatCmd
+		data  *redis.StringCmd
+	)
+
+	_, err := s.db.TxPipelined(func(pipe redis.Pipeliner) error {
+		score = pipe.ZScore(database.KEY_JOBS, id)
+		data = pipe.HGet(database.KEY_JOBS_DATA, id)
+
+		return nil
+	})
+	if err == redis.Nil || score.Err() == redis.Nil || data.Err() == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err = json.Unmarshal([]byte(data.Val()), &job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (s *Scheduler) Run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.poll()
+		}
+	}
+}
+
+// Stop waits for running handlers
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	<-s.done
+	s.wg.Wait()
+}
+
+func (s *Scheduler) poll() {
+	s.mu.RLock()
+	active := s.active
+	s.mu.RUnlock()
+
+	if active != nil && !active() {
+		return
+	}
+
+	now := millis(time.Now())
+
+	s.recoverExpired(now)
+
+	ids, err := s.db.ZRangeByScore(database.KEY_JOBS, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now, 10),
+		Count: BATCH_SIZE,
+	}).Result()
+	if err != nil {
+		logger.Warning("Error while reading jobs from redis", err)
+		return
+	}
+
+	for _, id := range ids {
+		select {
+		case s.workers <- struct{}{}:
+		default:
+			// Все обработчики заняты, остальные задания возьмем в следующий раз
+			return
+		}
+
+		job, ok := s.claim(id, now)
+		if !ok {
+			<-s.workers
+			continue
+		}
+
+		s.wg.Add(1)
+		go s.run(job)
+	}
+}
+
+// claim takes the job unless its previous run is still in progress on this instance
+func (s *Scheduler) claim(id string, now int64) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running[id] {
+		return nil, false
+	}
+
+	deadline := now + int64(LEASE/time.Millisecond)
+
+	raw, err := claim.Run(s.db, jobKeys(), id, now, deadline).Text()
+	if err == redis.Nil {
+		// Claimed by another instance or rescheduled
+		return nil, false
+	} else if err != nil {
+		logger.Warning("Error while claim job", id, err)
+		return nil, false
+	}
+
+	var job Job
+	if err = json.Unmarshal([]byte(raw), &job); err != nil {
+		logger.Warning("Error while decoding job", id, err, raw)
+		s.complete(id)
+		return nil, false
+	}
+
+	s.running[id] = true
+
+	return &job, true
+}
+
+func (s *Scheduler) run(job *Job) {
+	defer s.wg.Done()
+	defer func() { <-s.workers }()
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, job.Id)
+		s.mu.Unlock()
+	}()
+
+	s.mu.RLock()
+	h, ok := s.handlers[job.Kind]
+	s.mu.RUnlock()
+
+	if !ok {
+		logger.Warning("No handler for job", job.Id, "of kind", job.Kind)
+		s.complete(job.Id)
+		return
+	}
+
+	logger.Debug("Run job", job.Id)
+
+	if err := h(job); err != nil {
+		s.retry(job, err)
+		return
+	}
+
+	s.complete(job.Id)
+}
+
+func (s *Scheduler) complete(id string) {
+	if err := complete.Run(s.db, jobKeys(), id).Err(); err != nil {
+		logger.Warning("Error while complete job", id, err)
+	}
+}
+
+func (s *Scheduler) retry(job *Job, jobErr error) {
+	job.Attempt++
+	if job.Attempt >= MAX_ATTEMPTS {
+		logger.Warning("Drop job", job.Id, "after", job.Attempt, "attempts with error", jobErr)
+		s.complete(job.Id)
+		return
+	}
+
+	delay := retryDelay(job.Attempt)
+	logger.Warning("Error while run job", job.Id, jobErr, "retry in", delay)
+
+	job.At = time.Now().Add(delay)
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		logger.Warning("Error while encoding job", job.Id, err)
+		s.complete(job.Id)
+		return
+	}
+
+	if err = retry.Run(s.db, jobKeys(), job.Id, millis(job.At), data).Err(); err != nil {
+		logger.Warning("Error while retry job", job.Id, err)
+	}
+}
+
+// recoverExpired returns to the queue jobs of instances which did not finish them during the lease
+func (s *Scheduler) recoverExpired(now int64) {
+	ids, err := s.db.ZRangeByScore(database.KEY_JOBS_INFLIGHT, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now, 10),
+		Count: BATCH_SIZE,
+	}).Result()
+	if err != nil {
+		logger.Warning("Error while reading jobs in flight from redis", err)
+		return
+	}
+
+	for _, id := range ids {
+		s.mu.RLock()
+		running := s.running[id]
+		s.mu.RUnlock()
+
+		if running {
+			continue
+		}
+
+		recovered, err := requeue.Run(s.db, jobKeys(), id, now).Int()
+		if err != nil {
+			logger.Warning("Error while recover job", id, err)
+		} else if recovered == 1 {
+			logger.Warning("Job", id, "was not finished during the lease and is queued again")
+		}
+	}
+}
+
+func retryDelay(attempt int) time.Duration {
+	delay := RETRY_DELAY
+	for i := 1; i < attempt && delay < MAX_RETRY_DELAY; i++ {
+		delay *= 2
+	}
+
+	if delay > MAX_RETRY_DELAY {
+		delay = MAX_RETRY_DELAY
+	}
+
+	return delay
+}
+
+// Hash tag keeps all keys in one slot of redis cluster
+func jobKeys() []string {
+	return []string{database.KEY_JOBS, database.KEY_JOBS_INFLIGHT, database.KEY_JOBS_DATA}
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
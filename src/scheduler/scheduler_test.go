@@ -0,0 +1,322 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"connect-companion/database"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+)
+
+func newScheduler(t *testing.T) (*Scheduler, redis.UniversalClient) {
+	t.Helper()
+
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	db := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	return New(db), db
+}
+
+// pollOnce fires due jobs and waits for their handlers
+func pollOnce(s *Scheduler) {
+	s.poll()
+	s.wg.Wait()
+}
+
+func due(id string) *Job {
+	return &Job{Id: id, Kind: "test", At: time.Now().Add(-time.Second)}
+}
+
+func TestRunAndComplete(t *testing.T) {
+	s, db := newScheduler(t)
+
+	var fired []string
+	s.Handle("test", func(job *Job) error {
+		fired = append(fired, job.Id)
+		return nil
+	})
+
+	if err := s.Schedule(due("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Schedule(&Job{Id: "later", Kind: "test", At: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	pollOnce(s)
+	pollOnce(s)
+
+	if len(fired) != 1 || fired[0] != "a" {
+		t.Fatalf("fired %v, want [a]", fired)
+	}
+
+	if db.HExists(database.KEY_JOBS_DATA, "a").Val() {
+		t.Error("data of the finished job is kept")
+	}
+
+	if db.ZCard(database.KEY_JOBS_INFLIGHT).Val() != 0 {
+		t.Error("finished job is still in flight")
+	}
+
+	if job, _ := s.Get("later"); job == nil {
+		t.Error("pending job is lost")
+	}
+}
+
+func TestRescheduleFromHandler(t *testing.T) {
+	s, _ := newScheduler(t)
+
+	s.Handle("test", func(job *Job) error {
+		job.At = time.Now().Add(time.Hour)
+		job.Data = []byte(`"next"`)
+		return s.Schedule(job)
+	})
+
+	if err := s.Schedule(due("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	pollOnce(s)
+
+	job, err := s.Get("a")
+	if err != nil || job == nil {
+		t.Fatalf("rescheduled job is lost: %v", err)
+	}
+
+	if string(job.Data) != `"next"` {
+		t.Errorf("data %s, want the new one", job.Data)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	s, db := newScheduler(t)
+
+	s.Handle("test", func(job *Job) error {
+		job.Data = json.RawMessage(`{"noticed":true}`)
+		return errors.New("connect is down")
+	})
+
+	if err := s.Schedule(due("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	pollOnce(s)
+
+	job, err := s.Get("a")
+	if err != nil || job == nil {
+		t.Fatalf("failed job is lost: %v", err)
+	}
+
+	if job.Attempt != 1 {
+		t.Errorf("attempt %d, want 1", job.Attempt)
+	}
+
+	// Отметки обработчика сохраняются до повтора
+	if string(job.Data) != `{"noticed":true}` {
+		t.Errorf("data %s of the retried job", job.Data)
+	}
+
+	at := time.Unix(0, int64(db.ZScore(database.KEY_JOBS, "a").Val())*int64(time.Millisecond))
+	if at.Before(start.Add(RETRY_DELAY-time.Second)) || at.After(time.Now().Add(RETRY_DELAY)) {
+		t.Errorf("retry at %s, want in %s", at, RETRY_DELAY)
+	}
+
+	// Последняя попытка удаляет задание
+	job.Attempt = MAX_ATTEMPTS - 1
+	job.At = time.Now().Add(-time.Second)
+	if err = s.Schedule(job); err != nil {
+		t.Fatal(err)
+	}
+
+	pollOnce(s)
+
+	if db.HExists(database.KEY_JOBS_DATA, "a").Val() || db.ZCard(database.KEY_JOBS).Val() != 0 {
+		t.Error("job is not dropped after the last attempt")
+	}
+}
+
+func TestRetryOfCancelledJob(t *testing.T) {
+	s, _ := newScheduler(t)
+
+	s.Handle("test", func(job *Job) error {
+		_ = s.Cancel(job.Id)
+		return errors.New("failed")
+	})
+
+	if err := s.Schedule(due("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	pollOnce(s)
+
+	if job, _ := s.Get("a"); job != nil {
+		t.Error("cancelled job is retried")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{7, 320 * time.Second},
+		{8, MAX_RETRY_DELAY},
+		{100, MAX_RETRY_DELAY},
+	}
+
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempt); got != tt.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestExpiredLease(t *testing.T) {
+	s, db := newScheduler(t)
+
+	// Экземпляр взял задание и упал
+	if err := s.Schedule(due("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	now := millis(time.Now())
+	if err := claim.Run(db, jobKeys(), "a", now, now-1).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, _ := s.Get("a"); job != nil {
+		t.Error("job in flight is reported as pending")
+	}
+
+	var fired int
+	s.Handle("test", func(job *Job) error {
+		fired++
+		return nil
+	})
+
+	pollOnce(s)
+	pollOnce(s)
+
+	if fired != 1 {
+		t.Errorf("job fired %d times, want once", fired)
+	}
+}
+
+func TestConcurrentHandlers(t *testing.T) {
+	s, _ := newScheduler(t)
+
+	var (
+		started = make(chan string, WORKERS+1)
+		release = make(chan struct{})
+	)
+
+	s.Handle("test", func(job *Job) error {
+		started <- job.Id
+		<-release
+		return nil
+	})
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.Schedule(due(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.poll()
+
+	// Все три обработчика работают одновременно
+	timeout := time.After(time.Second)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-timeout:
+			t.Fatalf("only %d handlers started", i)
+		}
+	}
+
+	// Задание, обработчик которого еще работает, не берется повторно
+	if err := s.Schedule(due("a")); err != nil {
+		t.Fatal(err)
+	}
+	s.poll()
+
+	close(release)
+	s.wg.Wait()
+
+	if len(started) != 0 {
+		t.Error("running job is claimed again")
+	}
+}
+
+func TestWorkersLimit(t *testing.T) {
+	s, db := newScheduler(t)
+
+	var (
+		mu      sync.Mutex
+		running int
+		release = make(chan struct{})
+	)
+
+	s.Handle("test", func(job *Job) error {
+		mu.Lock()
+		running++
+		mu.Unlock()
+		<-release
+		return nil
+	})
+
+	for i := 0; i < WORKERS+2; i++ {
+		if err := s.Schedule(due(string(rune('a' + i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.poll()
+
+	if n := db.ZCard(database.KEY_JOBS).Val(); n != 2 {
+		t.Errorf("%d jobs are left in the queue, want 2", n)
+	}
+
+	close(release)
+	s.wg.Wait()
+	pollOnce(s)
+
+	if running != WORKERS+2 {
+		t.Errorf("%d jobs fired, want %d", running, WORKERS+2)
+	}
+}
+
+func TestOnlyWhen(t *testing.T) {
+	s, _ := newScheduler(t)
+
+	var fired int
+	s.Handle("test", func(job *Job) error {
+		fired++
+		return nil
+	})
+	s.OnlyWhen(func() bool { return false })
+
+	if err := s.Schedule(due("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	pollOnce(s)
+
+	if fired != 0 {
+		t.Error("inactive scheduler fired the job")
+	}
+}